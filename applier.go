@@ -0,0 +1,73 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"runtime"
+)
+
+// Applier applies patches to CBOR documents with its own copy of Options,
+// reusing an internal buffer between calls. It is not safe for concurrent use,
+// use ApplierPool to share Appliers between goroutines.
+type Applier struct {
+	options Options
+	buf     RawMessage
+}
+
+// NewApplier returns an Applier with a copy of the options, NewOptions() is used if it is nil.
+func NewApplier(opts *Options) *Applier {
+	if opts == nil {
+		opts = NewOptions()
+	}
+	return &Applier{options: *opts}
+}
+
+// Apply applies the patch to the CBOR document, and returns the new document.
+// The document is not modified.
+func (a *Applier) Apply(doc []byte, p Patch) ([]byte, error) {
+	if len(doc) == 0 {
+		doc = rawCBORNull
+	}
+
+	a.buf = append(a.buf[:0], doc...)
+	node := &Node{raw: &a.buf, ty: CBORTypePrimitives}
+	if err := node.Patch(p, &a.options); err != nil {
+		return nil, err
+	}
+	return node.MarshalCBOR()
+}
+
+// ApplierPool manages a fixed number of Appliers, and limits the number of concurrent applies.
+// It is safe for concurrent use.
+type ApplierPool struct {
+	appliers chan *Applier
+}
+
+// NewApplierPool returns an ApplierPool with n Appliers, runtime.GOMAXPROCS(0) is used if n <= 0.
+// The options is copied to each Applier, NewOptions() is used if it is nil.
+func NewApplierPool(n int, opts *Options) *ApplierPool {
+	if n <= 0 {
+		n = runtime.GOMAXPROCS(0)
+	}
+
+	p := &ApplierPool{appliers: make(chan *Applier, n)}
+	for i := 0; i < n; i++ {
+		p.appliers <- NewApplier(opts)
+	}
+	return p
+}
+
+// Size returns the number of Appliers in the pool.
+func (p *ApplierPool) Size() int {
+	return cap(p.appliers)
+}
+
+// Apply applies the patch to the CBOR document with an idle Applier, and returns the new document.
+// It blocks until an Applier is available.
+func (p *ApplierPool) Apply(doc []byte, patch Patch) ([]byte, error) {
+	a := <-p.appliers
+	defer func() { p.appliers <- a }()
+
+	return a.Apply(doc, patch)
+}
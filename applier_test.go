@@ -0,0 +1,62 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplier(t *testing.T) {
+	assert := assert.New(t)
+
+	options := NewOptions()
+	options.EnsurePathExistsOnAdd = true
+	a := NewApplier(options)
+	options.EnsurePathExistsOnAdd = false
+
+	doc := MustFromJSON(`{"a": 1}`)
+	p := Patch{{Op: OpAdd, Path: PathMustFrom("b", "c"), Value: MustMarshal(2)}}
+	res, err := a.Apply(doc, p)
+	assert.NoError(err)
+	assert.Equal(`{"a":1,"b":{"c":2}}`, MustToJSON(res))
+	assert.Equal(`{"a":1}`, MustToJSON(doc))
+
+	res2, err := a.Apply(MustFromJSON(`{"x": [1, 2, 3]}`), p)
+	assert.NoError(err)
+	assert.Equal(`{"a":1,"b":{"c":2}}`, MustToJSON(res))
+	assert.Equal(`{"b":{"c":2},"x":[1,2,3]}`, MustToJSON(res2))
+
+	_, err = a.Apply(nil, p)
+	assert.Error(err)
+}
+
+func TestApplierPool(t *testing.T) {
+	assert := assert.New(t)
+
+	pool := NewApplierPool(0, nil)
+	assert.True(pool.Size() > 0)
+
+	pool = NewApplierPool(4, nil)
+	assert.Equal(4, pool.Size())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				doc := MustFromJSON(fmt.Sprintf(`{"i": %d, "list": [%d]}`, i, j))
+				p := Patch{{Op: OpAdd, Path: PathMustFrom("list", "-"), Value: MustMarshal(i)}}
+				res, err := pool.Apply(doc, p)
+				assert.NoError(err)
+				assert.Equal(fmt.Sprintf(`{"i":%d,"list":[%d,%d]}`, i, j, i), MustToJSON(res))
+			}
+		}(i)
+	}
+	wg.Wait()
+}
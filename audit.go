@@ -0,0 +1,125 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// AuditEntry records the change made by an applied operation, see Options.AuditWriter.
+type AuditEntry struct {
+	Op   Op   `cbor:"1,keyasint"`
+	From Path `cbor:"2,keyasint,omitempty"`
+	// Path is the target path of the operation, "-" is resolved to the index appended to.
+	Path Path `cbor:"3,keyasint"`
+	// Old is the raw value at Path before the operation, or nil if it was missing.
+	Old RawMessage `cbor:"4,keyasint,omitempty"`
+	// New is the raw value at Path after the operation, or nil if it is removed.
+	New RawMessage `cbor:"5,keyasint,omitempty"`
+}
+
+// ReadAuditEntries decodes the CBOR sequence of AuditEntry written to Options.AuditWriter.
+func ReadAuditEntries(data []byte) ([]*AuditEntry, error) {
+	var entries []*AuditEntry
+	dec := decMode.NewDecoder(bytes.NewReader(data))
+	for {
+		entry := &AuditEntry{}
+		if err := dec.Decode(entry); err != nil {
+			if errors.Is(err, io.EOF) {
+				return entries, nil
+			}
+			return nil, fmt.Errorf("invalid audit entry %d, %w", len(entries), err)
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// auditValue returns the raw value at path in the container, or nil if it is missing.
+// "-" refers to the position after the last element of an array, so there is no value.
+func auditValue(pd *container, path Path, options *Options) RawMessage {
+	if l := len(path); l > 0 && path[l-1].isMinus() {
+		return nil
+	}
+
+	var node *Node
+	if len(path) == 0 {
+		switch c := (*pd).(type) {
+		case *partialDoc:
+			node = &Node{doc: c, ty: CBORTypeMap, which: eDoc, codec: options.codec}
+		case *partialArray:
+			node = &Node{ary: *c, ty: CBORTypeArray, which: eAry, codec: options.codec}
+		}
+	} else if con, key := findObject(pd, path, options); con != nil {
+		node, _ = con.get(key, options)
+	}
+
+	if node == nil {
+		return nil
+	}
+	data, err := node.MarshalCBOR()
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// writeAudit writes the AuditEntry of the applied operation to options.AuditWriter,
+// old is the value at op.Path before the operation, see auditValue.
+func writeAudit(pd *container, op *Operation, old RawMessage, options *Options) error {
+	entry := &AuditEntry{Op: op.Op, From: op.From, Path: op.Path, Old: old}
+	if l := len(op.Path); l > 0 && op.Path[l-1].isMinus() {
+		if con, _ := findObject(pd, op.Path, options); con != nil {
+			if _, ok := con.(*partialArray); ok {
+				entry.Path = op.Path[:l-1].withIndex(con.len() - 1)
+			}
+		}
+	}
+	entry.New = auditValue(pd, entry.Path, options)
+	return writeAuditEntry(entry, options)
+}
+
+// treeAuditValue is like auditValue, but for ApplyToTree.
+func treeAuditValue(tree any, path Path, options *Options) RawMessage {
+	if l := len(path); l > 0 && path[l-1].isMinus() {
+		return nil
+	}
+
+	val, err := treeGetPath(tree, path, options)
+	if err != nil {
+		return nil
+	}
+	data, err := options.codec.Marshal(val)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// writeTreeAudit is like writeAudit, but for ApplyToTree.
+func writeTreeAudit(tree any, op *Operation, old RawMessage, options *Options) error {
+	entry := &AuditEntry{Op: op.Op, From: op.From, Path: op.Path, Old: old}
+	if l := len(op.Path); l > 0 && op.Path[l-1].isMinus() {
+		if parent, err := treeGetPath(tree, op.Path[:l-1], options); err == nil {
+			if ary, ok := parent.([]any); ok {
+				entry.Path = op.Path[:l-1].withIndex(len(ary) - 1)
+			}
+		}
+	}
+	entry.New = treeAuditValue(tree, entry.Path, options)
+	return writeAuditEntry(entry, options)
+}
+
+func writeAuditEntry(entry *AuditEntry, options *Options) error {
+	data, err := options.codec.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err = options.AuditWriter.Write(data); err != nil {
+		return fmt.Errorf("unable to write audit entry, %w", err)
+	}
+	return nil
+}
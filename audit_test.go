@@ -0,0 +1,67 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type failWriter struct{}
+
+func (failWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestAuditWriter(t *testing.T) {
+	assert := assert.New(t)
+
+	p, err := PatchFromJSON(`[
+		{ "op": "replace", "path": "/a", "value": 2 },
+		{ "op": "test", "path": "/a", "value": 2 },
+		{ "op": "add", "path": "/b/-", "value": "x" },
+		{ "op": "remove", "path": "/c" },
+		{ "op": "move", "from": "/b/0", "path": "/d" }
+	]`)
+	assert.NoError(err)
+	doc := MustFromJSON(`{ "a": 1, "b": ["y"], "c": { "e": true } }`)
+
+	check := func(buf *bytes.Buffer) {
+		entries, err := ReadAuditEntries(buf.Bytes())
+		assert.NoError(err)
+		assert.Equal([]*AuditEntry{
+			{Op: OpReplace, Path: PathMustFromJSON("/a"), Old: MustMarshal(1), New: MustMarshal(2)},
+			{Op: OpAdd, Path: PathMustFromJSON("/b/1"), New: MustMarshal("x")},
+			{Op: OpRemove, Path: PathMustFromJSON("/c"), Old: MustFromJSON(`{ "e": true }`)},
+			{Op: OpMove, From: PathMustFromJSON("/b/0"), Path: PathMustFromJSON("/d"), New: MustMarshal("y")},
+		}, entries)
+	}
+
+	buf := &bytes.Buffer{}
+	options := NewOptions()
+	options.AuditWriter = buf
+	_, err = p.ApplyWithOptions(doc, options)
+	assert.NoError(err)
+	check(buf)
+
+	buf.Reset()
+	var tree any
+	assert.NoError(cborUnmarshal(doc, &tree))
+	_, err = ApplyToTree(tree, p, options)
+	assert.NoError(err)
+	check(buf)
+
+	options.AuditWriter = failWriter{}
+	_, err = p.ApplyWithOptions(doc, options)
+	assert.ErrorContains(err, "disk full")
+	var pe *PatchError
+	assert.True(errors.As(err, &pe))
+	assert.Equal(0, pe.OpIndex)
+
+	_, err = ReadAuditEntries([]byte{0xa1})
+	assert.Error(err)
+}
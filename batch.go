@@ -0,0 +1,80 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"sync"
+)
+
+// ApplyBatch applies the patch to each of the documents like ApplyWithOptions, and returns
+// the new documents and the errors in the same order. The patch is validated once for all
+// the documents, rather than for each of them.
+// If the patch is invalid, the same error is returned for every document.
+// The errors are nil if all the documents are patched.
+//
+// The documents are applied by options.BatchWorkers goroutines in parallel, so the hooks
+// and the AuditWriter of the options must be safe for concurrent use in that case.
+func (p Patch) ApplyBatch(docs [][]byte, options *Options) ([][]byte, []error) {
+	if options == nil {
+		options = NewOptions()
+	}
+
+	res := make([][]byte, len(docs))
+	errs := make([]error, len(docs))
+	err := checkPatchOps(p, options)
+	for i, op := range p {
+		if err != nil {
+			break
+		}
+		if err = validOp(op, options); err != nil {
+			err = newPatchError(i, op, err)
+		}
+	}
+	if err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return res, errs
+	}
+
+	opts := *options
+	opts.validated = true
+	apply := func(i int) {
+		res[i], errs[i] = p.ApplyWithOptions(docs[i], &opts)
+	}
+
+	workers := options.BatchWorkers
+	if workers > len(docs) {
+		workers = len(docs)
+	}
+	if workers < 2 {
+		for i := range docs {
+			apply(i)
+		}
+	} else {
+		ch := make(chan int)
+		wg := sync.WaitGroup{}
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range ch {
+					apply(i)
+				}
+			}()
+		}
+		for i := range docs {
+			ch <- i
+		}
+		close(ch)
+		wg.Wait()
+	}
+
+	for _, err := range errs {
+		if err != nil {
+			return res, errs
+		}
+	}
+	return res, nil
+}
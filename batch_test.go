@@ -0,0 +1,57 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyBatch(t *testing.T) {
+	assert := assert.New(t)
+
+	p, err := PatchFromJSON(`[
+		{ "op": "add", "path": "/version", "value": 2 },
+		{ "op": "move", "from": "/name", "path": "/title" }
+	]`)
+	assert.NoError(err)
+
+	docs := make([][]byte, 100)
+	for i := range docs {
+		docs[i] = MustFromJSON(fmt.Sprintf(`{ "id": %d, "name": "doc %d" }`, i, i))
+	}
+	docs[42] = MustFromJSON(`{ "id": 42 }`)
+
+	for _, workers := range []int{0, 1, 8} {
+		options := NewOptions()
+		options.BatchWorkers = workers
+		res, errs := p.ApplyBatch(docs, options)
+		assert.Equal(len(docs), len(res))
+		assert.Equal(len(docs), len(errs))
+		for i := range docs {
+			if i == 42 {
+				assert.Nil(res[i])
+				assert.ErrorIs(errs[i], ErrMissing)
+				continue
+			}
+			assert.NoError(errs[i])
+			assert.Equal(fmt.Sprintf(`{"id":%d,"title":"doc %d","version":2}`, i, i), MustToJSON(res[i]))
+		}
+	}
+
+	res, errs := p.ApplyBatch(docs[:2], nil)
+	assert.Nil(errs)
+	assert.Equal(`{"id":1,"title":"doc 1","version":2}`, MustToJSON(res[1]))
+
+	invalid := Patch{{Op: OpAdd}}
+	res, errs = invalid.ApplyBatch(docs[:3], nil)
+	assert.Equal(3, len(errs))
+	for i := range errs {
+		assert.Nil(res[i])
+		assert.Error(errs[i])
+		assert.Equal(errs[0], errs[i])
+	}
+}
@@ -0,0 +1,98 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"fmt"
+)
+
+// binaryDiffBlock is the size of the blocks that BinaryDiff matches.
+const binaryDiffBlock = 16
+
+// BinaryDiff returns a delta that changes the byte string from into to, it is the value
+// of a "binary-diff" operation. The delta is an array of instructions that build the new
+// bytes in order:
+//
+//	an array [offset, length] copies length bytes from the old bytes at offset,
+//	a byte string is inserted.
+//
+// BinaryDiff finds the copies by matching the aligned 16 bytes blocks of from in to,
+// and extending the matches in both directions.
+func BinaryDiff(from, to []byte) []any {
+	delta := make([]any, 0, 4)
+	insert := 0
+	flush := func(end int) {
+		if end > insert {
+			delta = append(delta, ByteString(to[insert:end]))
+		}
+	}
+
+	if len(from) >= binaryDiffBlock {
+		blocks := make(map[string]int, len(from)/binaryDiffBlock)
+		for o := 0; o+binaryDiffBlock <= len(from); o += binaryDiffBlock {
+			if _, ok := blocks[string(from[o:o+binaryDiffBlock])]; !ok {
+				blocks[string(from[o:o+binaryDiffBlock])] = o
+			}
+		}
+
+		for i := 0; i+binaryDiffBlock <= len(to); {
+			o, ok := blocks[string(to[i:i+binaryDiffBlock])]
+			if !ok {
+				i++
+				continue
+			}
+
+			for o > 0 && i > insert && from[o-1] == to[i-1] {
+				o--
+				i--
+			}
+			n := 0
+			for o+n < len(from) && i+n < len(to) && from[o+n] == to[i+n] {
+				n++
+			}
+			flush(i)
+			delta = append(delta, []int{o, n})
+			i += n
+			insert = i
+		}
+	}
+
+	flush(len(to))
+	return delta
+}
+
+// NewBinaryDiff returns a "binary-diff" operation that changes the byte string at path from from into to,
+// see BinaryDiff.
+func NewBinaryDiff(path Path, from, to []byte) (*Operation, error) {
+	return newOperation(OpBinaryDiff, nil, path, BinaryDiff(from, to))
+}
+
+// applyBinaryDiff applies the delta to the data, see BinaryDiff.
+func applyBinaryDiff(data []byte, delta []any) ([]byte, error) {
+	res := make([]byte, 0, len(data))
+	for _, step := range delta {
+		switch s := step.(type) {
+		case []byte:
+			res = append(res, s...)
+			continue
+
+		case []any:
+			if len(s) != 2 {
+				break
+			}
+			o, ok1 := s[0].(uint64)
+			n, ok2 := s[1].(uint64)
+			if !ok1 || !ok2 {
+				break
+			}
+			if o > uint64(len(data)) || n > uint64(len(data))-o {
+				return nil, fmt.Errorf("unable to copy %d bytes at %d of %d bytes, %w", n, o, len(data), ErrInvalidIndex)
+			}
+			res = append(res, data[o:o+n]...)
+			continue
+		}
+		return nil, fmt.Errorf("invalid binary-diff instruction %v, %w", step, ErrInvalid)
+	}
+	return res, nil
+}
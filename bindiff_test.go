@@ -0,0 +1,89 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBinaryDiff(t *testing.T) {
+	assert := assert.New(t)
+
+	blob := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(blob)
+
+	patched := append([]byte{}, blob[:1000]...)
+	patched = append(patched, "inserted"...)
+	patched = append(patched, blob[1000:3000]...)
+	patched = append(patched, blob[3500:]...)
+	copy(patched[200:], "xyz")
+
+	for _, c := range []struct {
+		from, to []byte
+		maxSize  int
+	}{
+		{nil, nil, 1},
+		{nil, []byte("hello"), 7},
+		{[]byte("hello"), nil, 1},
+		{[]byte("hello"), []byte("hello world"), 13},
+		{blob, blob, 8},
+		{blob, patched, 64},
+		{patched, blob, 600},
+		{blob, append(blob[2048:], blob[:2048]...), 16},
+	} {
+		delta := BinaryDiff(c.from, c.to)
+		data := MustMarshal(delta)
+		assert.LessOrEqual(len(data), c.maxSize)
+
+		var decoded []any
+		assert.NoError(cborUnmarshal(data, &decoded))
+		res, err := applyBinaryDiff(c.from, decoded)
+		assert.NoError(err)
+		assert.True(bytes.Equal(c.to, res))
+	}
+
+	assert.Equal([]any{[]int{0, 16}, ByteString("!")}, BinaryDiff([]byte("0123456789abcdef"), []byte("0123456789abcdef!")))
+
+	_, err := applyBinaryDiff([]byte("abc"), []any{[]any{uint64(1), uint64(3)}})
+	assert.ErrorContains(err, ErrInvalidIndex.Error())
+	_, err = applyBinaryDiff([]byte("abc"), []any{"abc"})
+	assert.ErrorContains(err, ErrInvalid.Error())
+	_, err = applyBinaryDiff([]byte("abc"), []any{[]any{uint64(1)}})
+	assert.ErrorContains(err, ErrInvalid.Error())
+}
+
+func TestNewBinaryDiff(t *testing.T) {
+	assert := assert.New(t)
+
+	from := make([]byte, 64)
+	rand.New(rand.NewSource(2)).Read(from)
+	to := append(append([]byte{}, from[:20]...), from[40:]...)
+	op, err := NewBinaryDiff(PathMustFrom("blob"), from, to)
+	assert.NoError(err)
+	assert.Equal(`[[0, 20], [40, 24]]`, Diagify(op.Value))
+
+	doc := MustMarshal(map[string]any{"blob": from})
+	res, err := Patch{op}.Apply(doc)
+	assert.NoError(err)
+	assert.True(Equal(MustMarshal(map[string]any{"blob": to}), res))
+
+	tree, err := ApplyToTree(map[string]any{"blob": from}, Patch{op}, nil)
+	assert.NoError(err)
+	assert.Equal(map[string]any{"blob": to}, tree)
+
+	inv, err := Patch{op}.Invert(doc)
+	assert.NoError(err)
+	restored, err := inv.Apply(res)
+	assert.NoError(err)
+	assert.True(Equal(doc, restored))
+
+	_, err = Patch{op}.Apply(MustMarshal(map[string]any{"blob": "text"}))
+	assert.ErrorContains(err, ErrInvalid.Error())
+	_, err = Patch{op}.Apply(MustMarshal(map[string]any{"blob": from[:8]}))
+	assert.ErrorContains(err, ErrInvalidIndex.Error())
+}
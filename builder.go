@@ -0,0 +1,199 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"fmt"
+)
+
+// Builder builds a Patch with chained methods, such as:
+//
+//	p, err := NewBuilder().
+//		Test(PathMustFrom("rev"), 1).
+//		Replace(PathMustFrom("rev"), 2).
+//		Add(PathMustFrom("tags", "-"), "new").
+//		Build()
+//
+// The values are encoded to CBOR, a nil value is encoded as CBOR null.
+// The first error is kept and returned by Build, the following operations are ignored.
+type Builder struct {
+	patch Patch
+	err   error
+}
+
+// NewBuilder returns a new Builder.
+func NewBuilder() *Builder {
+	return &Builder{patch: make(Patch, 0, 8)}
+}
+
+// Add appends an "add" operation.
+func (b *Builder) Add(path Path, value any) *Builder {
+	return b.append(OpAdd, nil, path, value)
+}
+
+// Remove appends a "remove" operation.
+func (b *Builder) Remove(path Path) *Builder {
+	return b.append(OpRemove, nil, path, nil)
+}
+
+// Replace appends a "replace" operation.
+func (b *Builder) Replace(path Path, value any) *Builder {
+	return b.append(OpReplace, nil, path, value)
+}
+
+// Move appends a "move" operation.
+func (b *Builder) Move(from, path Path) *Builder {
+	return b.append(OpMove, from, path, nil)
+}
+
+// Copy appends a "copy" operation.
+func (b *Builder) Copy(from, path Path) *Builder {
+	return b.append(OpCopy, from, path, nil)
+}
+
+// Test appends a "test" operation.
+func (b *Builder) Test(path Path, value any) *Builder {
+	return b.append(OpTest, nil, path, value)
+}
+
+// TestContains appends a "test-contains" operation.
+func (b *Builder) TestContains(path Path, value any) *Builder {
+	return b.append(OpTestContains, nil, path, value)
+}
+
+// TestType appends a "test-type" operation, see NewTestType.
+func (b *Builder) TestType(path Path, typ any) *Builder {
+	return b.append(OpTestType, nil, path, typ)
+}
+
+// TestMatch appends a "test-match" operation, see NewTestMatch.
+func (b *Builder) TestMatch(path Path, pattern string) *Builder {
+	return b.append(OpTestMatch, nil, path, pattern)
+}
+
+// TestLess appends a "test-less" operation.
+func (b *Builder) TestLess(path Path, value any) *Builder {
+	return b.append(OpTestLess, nil, path, value)
+}
+
+// TestLessEqual appends a "test-less-equal" operation.
+func (b *Builder) TestLessEqual(path Path, value any) *Builder {
+	return b.append(OpTestLessEqual, nil, path, value)
+}
+
+// TestGreater appends a "test-greater" operation.
+func (b *Builder) TestGreater(path Path, value any) *Builder {
+	return b.append(OpTestGreater, nil, path, value)
+}
+
+// TestGreaterEqual appends a "test-greater-equal" operation.
+func (b *Builder) TestGreaterEqual(path Path, value any) *Builder {
+	return b.append(OpTestGreaterEqual, nil, path, value)
+}
+
+// TestRange appends a "test-greater-equal" and a "test-less-equal" operation,
+// that assert the number at path is in the range [min, max].
+func (b *Builder) TestRange(path Path, min, max any) *Builder {
+	return b.TestGreaterEqual(path, min).TestLessEqual(path, max)
+}
+
+// TestLength appends a "test-length" operation, see NewTestLength.
+func (b *Builder) TestLength(path Path, n int) *Builder {
+	return b.append(OpTestLength, nil, path, n)
+}
+
+// TestLengthRange appends a "test-length" operation, see NewTestLengthRange.
+func (b *Builder) TestLengthRange(path Path, min, max int) *Builder {
+	return b.append(OpTestLength, nil, path, []int{min, max})
+}
+
+// TestDefined appends a "test-defined" operation.
+func (b *Builder) TestDefined(path Path) *Builder {
+	return b.append(OpTestDefined, nil, path, nil)
+}
+
+// TestUndefined appends a "test-undefined" operation.
+func (b *Builder) TestUndefined(path Path) *Builder {
+	return b.append(OpTestUndefined, nil, path, nil)
+}
+
+// TestSubset appends a "test-subset" operation, see NewTestSubset.
+func (b *Builder) TestSubset(path Path, value any) *Builder {
+	return b.append(OpTestSubset, nil, path, value)
+}
+
+// Merge appends a "merge" operation.
+func (b *Builder) Merge(path Path, value any) *Builder {
+	return b.append(OpMerge, nil, path, value)
+}
+
+// Append appends an "append" operation.
+func (b *Builder) Append(path Path, value any) *Builder {
+	return b.append(OpAppend, nil, path, value)
+}
+
+// Incr appends an "incr" operation.
+func (b *Builder) Incr(path Path, delta any) *Builder {
+	return b.append(OpIncr, nil, path, delta)
+}
+
+// Decr appends a "decr" operation.
+func (b *Builder) Decr(path Path, delta any) *Builder {
+	return b.append(OpDecr, nil, path, delta)
+}
+
+// Toggle appends a "toggle" operation.
+func (b *Builder) Toggle(path Path) *Builder {
+	return b.append(OpToggle, nil, path, nil)
+}
+
+// TextDiff appends a "text-diff" operation that changes the text string at path from from into to.
+func (b *Builder) TextDiff(path Path, from, to string) *Builder {
+	return b.append(OpTextDiff, nil, path, TextDiff(from, to))
+}
+
+// BinaryDiff appends a "binary-diff" operation that changes the byte string at path from from into to.
+func (b *Builder) BinaryDiff(path Path, from, to []byte) *Builder {
+	return b.append(OpBinaryDiff, nil, path, BinaryDiff(from, to))
+}
+
+// Splice appends a "splice" operation, see NewSplice.
+func (b *Builder) Splice(path Path, index, removeCount int, values ...any) *Builder {
+	if b.err != nil {
+		return b
+	}
+
+	o, err := NewSplice(path, index, removeCount, values...)
+	if err != nil {
+		b.err = fmt.Errorf("invalid operation %d, %w", len(b.patch), err)
+		return b
+	}
+	b.patch = append(b.patch, o)
+	return b
+}
+
+// Build validates and returns the patch, or the first error occurred.
+func (b *Builder) Build() (Patch, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	if err := b.patch.Valid(); err != nil {
+		return nil, err
+	}
+	return append(make(Patch, 0, len(b.patch)), b.patch...), nil
+}
+
+func (b *Builder) append(op Op, from, path Path, value any) *Builder {
+	if b.err != nil {
+		return b
+	}
+
+	o, err := newOperation(op, from, path, value)
+	if err != nil {
+		b.err = fmt.Errorf("invalid operation %d, %w", len(b.patch), err)
+		return b
+	}
+	b.patch = append(b.patch, o)
+	return b
+}
@@ -0,0 +1,42 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuilder(t *testing.T) {
+	assert := assert.New(t)
+
+	b := NewBuilder().
+		Test(PathMustFrom("rev"), 1).
+		Replace(PathMustFrom("rev"), 2).
+		Add(PathMustFrom("tags", "-"), "new").
+		Add(PathMustFrom("x"), nil).
+		Copy(PathMustFrom("x"), PathMustFrom("y")).
+		Move(PathMustFrom("y"), PathMustFrom("z")).
+		Remove(PathMustFrom("x"))
+	p, err := b.Build()
+	assert.NoError(err)
+
+	data, err := PatchToJSON(p)
+	assert.NoError(err)
+	assert.Equal(`[{"op":"test","path":"/rev","value":1},{"op":"replace","path":"/rev","value":2},{"op":"add","path":"/tags/-","value":"new"},{"op":"add","path":"/x","value":null},{"op":"copy","from":"/x","path":"/y"},{"op":"move","from":"/y","path":"/z"},{"op":"remove","path":"/x"}]`, string(data))
+
+	doc, err := p.Apply(MustFromJSON(`{"rev": 1, "tags": []}`))
+	assert.NoError(err)
+	assert.Equal(`{"rev":2,"tags":["new"],"z":null}`, MustToJSON(doc))
+
+	b.Remove(PathMustFrom("z"))
+	assert.Equal(7, len(p))
+
+	_, err = NewBuilder().Move(nil, PathMustFrom("a")).Remove(PathMustFrom("b")).Build()
+	assert.ErrorContains(err, `invalid operation 0, "from" must be non-nil for "move" operation`)
+
+	_, err = NewBuilder().Remove(PathMustFrom("b")).Add(PathMustFrom("a"), make(chan int)).Build()
+	assert.ErrorContains(err, "invalid operation 1, invalid value for add operation")
+}
@@ -34,8 +34,13 @@
 package cborpatch
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"math"
+	"sort"
 	"strconv"
+	"sync/atomic"
 
 	"github.com/fxamacker/cbor/v2"
 )
@@ -54,6 +59,8 @@ const (
 )
 
 var (
+	rawCBORFalse = []byte{0xf4}
+	rawCBORTrue  = []byte{0xf5}
 	rawCBORNull  = []byte{0xf6}
 	rawCBORArray = []byte{0x80}
 	rawCBORMap   = []byte{0xa0}
@@ -70,12 +77,114 @@ var (
 		IndefLength: cbor.IndefLengthForbidden,
 	}.EncMode()
 
-	cborUnmarshal = decMode.Unmarshal
-	cborValid     = decMode.Valid
-	cborMarshal   = encMode.Marshal
+	cborValid = decMode.Valid
+
+	// codec holds the cborCodec used by cborMarshal and cborUnmarshal.
+	codec = newCodecValue(encMode.Marshal, decMode.Unmarshal)
 )
 
+type cborCodec struct {
+	marshal   func(v any) ([]byte, error)
+	unmarshal func(data []byte, v any) error
+	// keepKeyOrder indicates that the decoded maps keep the order of their keys, see Options.PreserveKeyOrder.
+	keepKeyOrder bool
+	// anyMapKey indicates that the decoded maps accept keys of any type, see Options.AllowAnyMapKey.
+	anyMapKey bool
+	// lastWins indicates that the value of the last duplicate map key wins, instead of an error.
+	lastWins bool
+	// maxDepth limits the nesting depth of the decoded containers, see Options.MaxNestingDepth.
+	maxDepth int
+}
+
+func newCodecValue(
+	marshal func(v any) ([]byte, error),
+	unmarshal func(data []byte, v any) error,
+) *atomic.Value {
+	v := &atomic.Value{}
+	v.Store(cborCodec{marshal: marshal, unmarshal: unmarshal})
+	return v
+}
+
+func cborMarshal(v any) ([]byte, error) {
+	return codec.Load().(cborCodec).marshal(v)
+}
+
+func cborUnmarshal(data []byte, v any) error {
+	return codec.Load().(cborCodec).unmarshal(data, v)
+}
+
+// newCodec returns the cborCodec of the EncMode, DecMode, PreserveKeyOrder, DupMapKey, AllowAnyMapKey
+// and MaxNestingDepth options, or nil if none is set, a nil cborCodec uses the global functions set by SetCBOR.
+func newCodec(options *Options) *cborCodec {
+	if options == nil || options.EncMode == nil && options.DecMode == nil && !options.PreserveKeyOrder &&
+		options.DupMapKey != DupMapKeyLastWins && !options.AllowAnyMapKey && options.MaxNestingDepth <= 0 {
+		return nil
+	}
+
+	c := codec.Load().(cborCodec)
+	c.keepKeyOrder = options.PreserveKeyOrder
+	c.anyMapKey = options.AllowAnyMapKey
+	c.maxDepth = options.MaxNestingDepth
+	if options.DupMapKey == DupMapKeyLastWins {
+		c.unmarshal = lastWinsDecMode.Unmarshal
+		c.lastWins = true
+	}
+	if options.EncMode != nil {
+		c.marshal = options.EncMode.Marshal
+	}
+	if options.DecMode != nil {
+		c.unmarshal = options.DecMode.Unmarshal
+		c.lastWins = options.DecMode.DecOptions().DupMapKey == cbor.DupMapKeyQuiet
+	}
+	return &c
+}
+
+// Marshal encodes v with the codec, or the global function if the codec is nil.
+func (c *cborCodec) Marshal(v any) ([]byte, error) {
+	if c == nil {
+		return cborMarshal(v)
+	}
+	return c.marshal(v)
+}
+
+// preserveKeyOrder reports whether the decoded maps should keep the order of their keys.
+func (c *cborCodec) preserveKeyOrder() bool {
+	return c != nil && c.keepKeyOrder
+}
+
+// allowAnyMapKey reports whether the decoded maps accept keys of any type.
+func (c *cborCodec) allowAnyMapKey() bool {
+	return c != nil && c.anyMapKey
+}
+
+// checkDepth returns an error if the raw encoded container is nested deeper than the limit of the codec.
+func (c *cborCodec) checkDepth(data []byte) error {
+	if c == nil || c.maxDepth <= 0 {
+		return nil
+	}
+	if depth, err := rawDepth(data); err == nil && depth > c.maxDepth {
+		return fmt.Errorf("document is nested deeper than %d, %w", c.maxDepth, ErrTooDeep)
+	}
+	return nil
+}
+
+// dupMapKeyLastWins reports whether the value of the last duplicate map key wins when decoding.
+func (c *cborCodec) dupMapKeyLastWins() bool {
+	return c != nil && c.lastWins
+}
+
+// Unmarshal decodes data into v with the codec, or the global function if the codec is nil.
+func (c *cborCodec) Unmarshal(data []byte, v any) error {
+	if c == nil {
+		return cborUnmarshal(data, v)
+	}
+	return c.unmarshal(data, v)
+}
+
 // SetCBOR set the underlying global CBOR Marshal and Unmarshal functions.
+// It is safe to call SetCBOR concurrently with other functions of the package,
+// but a call in progress may use either the previous or the new functions,
+// so it should be called during initialization.
 //
 //	func init() {
 //		var EncMode, _ = cbor.CanonicalEncOptions().EncMode()
@@ -86,12 +195,14 @@ var (
 //
 //		cborpatch.SetCBOR(EncMode.Marshal, DecMode.Unmarshal)
 //	}
+//
+// Deprecated: SetCBOR affects all the users of the package in the process,
+// use Options.EncMode and Options.DecMode instead.
 func SetCBOR(
 	marshal func(v any) ([]byte, error),
 	unmarshal func(data []byte, v any) error,
 ) {
-	cborMarshal = marshal
-	cborUnmarshal = unmarshal
+	codec.Store(cborCodec{marshal: marshal, unmarshal: unmarshal})
 }
 
 // RawMessage is a raw encoded CBOR value.
@@ -99,6 +210,9 @@ type RawMessage = cbor.RawMessage
 
 type ByteString = cbor.ByteString
 
+// SimpleValue is a CBOR simple value, such as simple(16), see NewSimpleValue.
+type SimpleValue = cbor.SimpleValue
+
 // CBORType is the type of a raw encoded CBOR value.
 type CBORType uint8
 
@@ -146,6 +260,7 @@ func MustMarshal(val any) []byte {
 
 // Diagify returns the doc as CBOR diagnostic notation.
 // If the doc is a invalid CBOR bytes, it returns the doc with base16 encoding like a byte string.
+// See DiagifyWithOptions for indentation and other rendering options.
 func Diagify(doc []byte) string {
 	if data, err := cbor.Diag(doc, nil); err == nil {
 		return string(data)
@@ -153,3 +268,573 @@ func Diagify(doc []byte) string {
 
 	return fmt.Sprintf("h'%x'", doc)
 }
+
+// IsNull reports whether the raw encoded CBOR value is null, an empty value is null.
+// Unlike Equal, it does not treat undefined as null, see IsUndefined.
+func IsNull(data []byte) bool {
+	return len(data) == 0 || len(data) == 1 && data[0] == 0xf6
+}
+
+// IsUndefined reports whether the raw encoded CBOR value is undefined.
+func IsUndefined(data []byte) bool {
+	return len(data) == 1 && data[0] == 0xf7
+}
+
+// NewSimpleValue returns the raw encoded CBOR simple value v, such as h'f0' for simple(16).
+// The simple values 20 to 23 are false, true, null and undefined, 24 to 31 are reserved and not well-formed.
+func NewSimpleValue(v SimpleValue) (RawMessage, error) {
+	switch {
+	case v < 24:
+		return RawMessage{0xe0 | byte(v)}, nil
+	case v < 32:
+		return nil, fmt.Errorf("reserved simple value %d, %w", v, ErrInvalid)
+	default:
+		return RawMessage{0xf8, byte(v)}, nil
+	}
+}
+
+// ReadSimpleValue returns the simple value of the raw encoded CBOR value,
+// false, true, null and undefined are the simple values 20 to 23.
+// It reports false if the value is not a simple value.
+func ReadSimpleValue(data []byte) (SimpleValue, bool) {
+	switch {
+	case len(data) == 1 && data[0] >= 0xe0 && data[0] < 0xf8:
+		return SimpleValue(data[0] & 0x1f), true
+	case len(data) == 2 && data[0] == 0xf8 && data[1] >= 32:
+		return SimpleValue(data[1]), true
+	default:
+		return 0, false
+	}
+}
+
+// isCBORNumber reports whether the raw encoded CBOR value is an integer, a bignum or a float.
+func isCBORNumber(data []byte) bool {
+	switch ReadCBORType(data) {
+	case CBORTypePositiveInt, CBORTypeNegativeInt:
+		return true
+	case CBORTypeTag:
+		// positive and negative bignums.
+		return data[0] == 0xc2 || data[0] == 0xc3
+	case CBORTypePrimitives:
+		// half, single and double precision floats.
+		return data[0] >= 0xf9 && data[0] <= 0xfb
+	}
+	return false
+}
+
+// cborTypeNames maps the type names of a "test-type" operation to the matchers of raw encoded CBOR values.
+var cborTypeNames = map[string]func(data []byte) bool{
+	"int": func(data []byte) bool {
+		ty := ReadCBORType(data)
+		return ty == CBORTypePositiveInt || ty == CBORTypeNegativeInt
+	},
+	"float":     func(data []byte) bool { return isCBORNumber(data) && ReadCBORType(data) == CBORTypePrimitives },
+	"bytes":     func(data []byte) bool { return ReadCBORType(data) == CBORTypeByteString },
+	"text":      func(data []byte) bool { return ReadCBORType(data) == CBORTypeTextString },
+	"array":     func(data []byte) bool { return ReadCBORType(data) == CBORTypeArray },
+	"map":       func(data []byte) bool { return ReadCBORType(data) == CBORTypeMap },
+	"tag":       func(data []byte) bool { return ReadCBORType(data) == CBORTypeTag },
+	"bool":      func(data []byte) bool { return len(data) == 1 && (data[0] == 0xf4 || data[0] == 0xf5) },
+	"null":      func(data []byte) bool { return len(data) == 1 && data[0] == 0xf6 },
+	"undefined": func(data []byte) bool { return len(data) == 1 && data[0] == 0xf7 },
+	"simple": func(data []byte) bool {
+		v, ok := ReadSimpleValue(data)
+		return ok && (v < 20 || v > 23)
+	},
+}
+
+// readTestType decodes the value of a "test-type" operation, which is a type name in cborTypeNames
+// or a CBOR major type from 0 to 7, and returns the matcher of it.
+func readTestType(value []byte) (func(data []byte) bool, error) {
+	var typ any
+	if err := cborUnmarshal(value, &typ); err != nil {
+		return nil, err
+	}
+
+	switch t := typ.(type) {
+	case string:
+		if match, ok := cborTypeNames[t]; ok {
+			return match, nil
+		}
+	case uint64:
+		if t <= 7 {
+			return func(data []byte) bool { return ReadCBORType(data) == CBORType(t<<5) }, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid type %v", typ)
+}
+
+// rawFloat returns the value of the raw encoded CBOR float.
+func rawFloat(data []byte) (float64, bool) {
+	if !isCBORNumber(data) || ReadCBORType(data) != CBORTypePrimitives {
+		return 0, false
+	}
+	var f float64
+	if err := cborUnmarshal(data, &f); err != nil {
+		return 0, false
+	}
+	return f, true
+}
+
+// cborHead decodes the head of the CBOR data item at off of the well-formed data,
+// it returns the major type, the argument and the offset after the head.
+func cborHead(data []byte, off int) (byte, uint64, int, error) {
+	if off >= len(data) {
+		return 0, 0, 0, errors.New("unexpected end of CBOR data")
+	}
+
+	major, ai := data[off]>>5, data[off]&0x1f
+	off++
+	n := 0
+	switch {
+	case ai < 24:
+		return major, uint64(ai), off, nil
+	case ai == 24:
+		n = 1
+	case ai == 25:
+		n = 2
+	case ai == 26:
+		n = 4
+	case ai == 27:
+		n = 8
+	default:
+		return 0, 0, 0, fmt.Errorf("unsupported additional information %d", ai)
+	}
+	if off+n > len(data) {
+		return 0, 0, 0, errors.New("unexpected end of CBOR data")
+	}
+
+	var arg uint64
+	for _, b := range data[off : off+n] {
+		arg = arg<<8 | uint64(b)
+	}
+	return major, arg, off + n, nil
+}
+
+// rawDepth returns the nesting depth of arrays and maps of the well-formed raw encoded CBOR value,
+// a value that is not an array or a map has depth 0.
+func rawDepth(data []byte) (int, error) {
+	var walk func(off int) (int, int, error)
+	walk = func(off int) (int, int, error) {
+		major, arg, next, err := cborHead(data, off)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		switch major {
+		case 2, 3:
+			return next + int(arg), 0, nil
+
+		case 4, 5:
+			if major == 5 {
+				arg *= 2
+			}
+			depth := 0
+			for i := uint64(0); i < arg; i++ {
+				var d int
+				if next, d, err = walk(next); err != nil {
+					return 0, 0, err
+				}
+				if d > depth {
+					depth = d
+				}
+			}
+			return next, depth + 1, nil
+
+		case 6:
+			return walk(next)
+		}
+		return next, 0, nil
+	}
+
+	_, depth, err := walk(0)
+	return depth, err
+}
+
+// rawMapKeys returns the distinct keys of the well-formed raw encoded CBOR map in their encoded order.
+func rawMapKeys(data []byte) ([]RawKey, error) {
+	major, arg, next, err := cborHead(data, 0)
+	if err != nil {
+		return nil, err
+	}
+	if major != 5 {
+		return nil, fmt.Errorf("unexpected CBOR major type %d, expected a map", major)
+	}
+
+	// each entry takes at least 2 bytes, so do not trust arg for preallocation.
+	size := uint64(len(data)-next) / 2
+	if arg < size {
+		size = arg
+	}
+	keys := make([]RawKey, 0, size)
+	seen := make(map[RawKey]bool)
+	for i := uint64(0); i < arg; i++ {
+		end, err := cborItemEnd(data, next)
+		if err != nil {
+			return nil, err
+		}
+		if key := RawKey(data[next:end]); !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+		if next, err = cborItemEnd(data, end); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// checkDupMapKeys returns a DuplicateKeyError of the first duplicate map key in the well-formed
+// raw encoded CBOR value, the paths of the maps are relative to base.
+func checkDupMapKeys(data []byte, base Path) error {
+	// the path is built only when a duplicate key is found.
+	inPath := func(err error, key RawKey) error {
+		if e, ok := err.(*DuplicateKeyError); ok {
+			e.Path = append(Path{key}, e.Path...)
+		}
+		return err
+	}
+
+	var walk func(off int) (int, error)
+	walk = func(off int) (int, error) {
+		major, arg, next, err := cborHead(data, off)
+		if err != nil {
+			return 0, err
+		}
+
+		switch major {
+		case 4:
+			for i := uint64(0); i < arg; i++ {
+				if next, err = walk(next); err != nil {
+					return 0, inPath(err, encodeArrayIdx(int(i)))
+				}
+			}
+			return next, nil
+
+		case 5:
+			seen := make(map[RawKey]bool)
+			for i := uint64(0); i < arg; i++ {
+				end, err := cborItemEnd(data, next)
+				if err != nil {
+					return 0, err
+				}
+				key := RawKey(data[next:end])
+				if seen[key] {
+					return 0, &DuplicateKeyError{Path: Path{}, Key: key}
+				}
+				seen[key] = true
+				if next, err = walk(end); err != nil {
+					return 0, inPath(err, key)
+				}
+			}
+			return next, nil
+
+		case 6:
+			return walk(next)
+		}
+		return cborItemEnd(data, off)
+	}
+
+	_, err := walk(0)
+	if e, ok := err.(*DuplicateKeyError); ok {
+		e.Path = append(append(Path{}, base...), e.Path...)
+	}
+	return err
+}
+
+// cborItemEnd returns the offset after the well-formed raw encoded CBOR value at off.
+func cborItemEnd(data []byte, off int) (int, error) {
+	major, arg, next, err := cborHead(data, off)
+	if err != nil {
+		return 0, err
+	}
+
+	switch major {
+	case 2, 3:
+		if arg > uint64(len(data)-next) {
+			return 0, errors.New("unexpected end of CBOR data")
+		}
+		return next + int(arg), nil
+
+	case 4, 5:
+		if major == 5 {
+			arg *= 2
+		}
+		for i := uint64(0); i < arg; i++ {
+			if next, err = cborItemEnd(data, next); err != nil {
+				return 0, err
+			}
+		}
+		return next, nil
+
+	case 6:
+		return cborItemEnd(data, next)
+	}
+	return next, nil
+}
+
+var (
+	// indefDecMode accepts the indefinite-length items that Canonicalize and definiteLength
+	// encode with definite lengths.
+	indefDecMode, _ = cbor.DecOptions{
+		DupMapKey:   cbor.DupMapKeyEnforcedAPF,
+		IndefLength: cbor.IndefLengthAllowed,
+	}.DecMode()
+
+	// lastWinsDecMode accepts duplicate map keys, see DupMapKeyLastWins.
+	lastWinsDecMode, _ = cbor.DecOptions{
+		DupMapKey:   cbor.DupMapKeyQuiet,
+		IndefLength: cbor.IndefLengthForbidden,
+	}.DecMode()
+
+	// canonicalEncMode encodes floats in the preferred serialization of RFC 8949.
+	canonicalEncMode, _ = cbor.CoreDetEncOptions().EncMode()
+)
+
+// Canonicalize re-encodes the CBOR document in the Core Deterministic Encoding of RFC 8949, section 4.2.1:
+// the arguments are encoded in the shortest form, floats in the shortest form that preserves their values,
+// indefinite-length items are encoded with definite lengths,
+// and the map entries are sorted by the bytewise lexicographic order of their encoded keys.
+// Tags are kept as they are, and their contents are canonicalized.
+func Canonicalize(doc []byte) ([]byte, error) {
+	if err := indefDecMode.Valid(doc); err != nil {
+		return nil, err
+	}
+	return canonicalize(doc)
+}
+
+// canonicalize is like Canonicalize, but the raw encoded CBOR value should be well-formed.
+func canonicalize(data []byte) ([]byte, error) {
+	return rewriteCBOR(data, true)
+}
+
+// definiteLength re-encodes the indefinite-length items in the CBOR document with definite lengths,
+// other items keep their encoding. It returns the document itself if there is no indefinite-length item.
+func definiteLength(doc []byte) ([]byte, error) {
+	if err := indefDecMode.Valid(doc); err != nil {
+		return nil, err
+	}
+	if !bytes.Contains(doc, []byte{0xff}) {
+		// the "break" stop code must end an indefinite-length item.
+		return doc, nil
+	}
+	return rewriteCBOR(doc, false)
+}
+
+// rewriteCBOR re-encodes the well-formed raw encoded CBOR value with definite lengths,
+// and in the Core Deterministic Encoding if canonical is true.
+func rewriteCBOR(data []byte, canonical bool) ([]byte, error) {
+	w := cborRewriter{data: data, canonical: canonical}
+	res, next, err := w.append(make([]byte, 0, len(data)), 0)
+	if err != nil {
+		return nil, err
+	}
+	if next != len(data) {
+		return nil, fmt.Errorf("unexpected %d bytes after the CBOR value", len(data)-next)
+	}
+	return res, nil
+}
+
+type cborRewriter struct {
+	data      []byte
+	canonical bool
+}
+
+// append appends the re-encoded item at off to dst, and returns the offset after the item.
+func (w cborRewriter) append(dst []byte, off int) ([]byte, int, error) {
+	data := w.data
+	if off < len(data) && data[off]&0x1f == 31 {
+		return w.appendIndefinite(dst, off)
+	}
+
+	major, arg, next, err := cborHead(data, off)
+	if err != nil {
+		return nil, 0, err
+	}
+	appendHead := func(dst []byte) []byte {
+		if w.canonical {
+			return appendCBORHead(dst, major, arg)
+		}
+		return append(dst, data[off:next]...)
+	}
+
+	switch major {
+	case 0, 1:
+		return appendHead(dst), next, nil
+
+	case 2, 3:
+		if arg > uint64(len(data)-next) {
+			return nil, 0, errors.New("unexpected end of CBOR data")
+		}
+		end := next + int(arg)
+		return append(appendHead(dst), data[next:end]...), end, nil
+
+	case 4:
+		dst = appendHead(dst)
+		for i := uint64(0); i < arg; i++ {
+			if dst, next, err = w.append(dst, next); err != nil {
+				return nil, 0, err
+			}
+		}
+		return dst, next, nil
+
+	case 5:
+		if !w.canonical {
+			dst = appendHead(dst)
+			for i := uint64(0); i < arg*2; i++ {
+				if dst, next, err = w.append(dst, next); err != nil {
+					return nil, 0, err
+				}
+			}
+			return dst, next, nil
+		}
+
+		// each entry takes at least 2 bytes, so do not trust arg for preallocation.
+		size := uint64(len(data)-next) / 2
+		if arg < size {
+			size = arg
+		}
+		entries := make([]canonicalEntry, 0, size)
+		for i := uint64(0); i < arg; i++ {
+			var e canonicalEntry
+			if e, next, err = w.readEntry(next); err != nil {
+				return nil, 0, err
+			}
+			entries = append(entries, e)
+		}
+		dst, err = appendCanonicalEntries(dst, entries)
+		return dst, next, err
+
+	case 6:
+		return w.append(appendHead(dst), next)
+	}
+
+	if ai := data[off] & 0x1f; ai >= 25 && w.canonical {
+		var f float64
+		if err = indefDecMode.Unmarshal(data[off:next], &f); err != nil {
+			return nil, 0, err
+		}
+		b, err := canonicalEncMode.Marshal(f)
+		if err != nil {
+			return nil, 0, err
+		}
+		return append(dst, b...), next, nil
+	}
+	return append(dst, data[off:next]...), next, nil
+}
+
+// appendIndefinite appends the indefinite-length item at off with definite length.
+func (w cborRewriter) appendIndefinite(dst []byte, off int) ([]byte, int, error) {
+	data := w.data
+	major := data[off] >> 5
+	if major < 2 || major > 5 {
+		return nil, 0, fmt.Errorf("unsupported additional information 31 for major type %d", major)
+	}
+
+	var (
+		buf     []byte
+		entries []canonicalEntry
+		count   uint64
+		err     error
+	)
+	next := off + 1
+	for {
+		if next >= len(data) {
+			return nil, 0, errors.New("unexpected end of CBOR data")
+		}
+		if data[next] == 0xff {
+			next++
+			break
+		}
+
+		switch major {
+		case 2, 3:
+			if data[next]>>5 != major || data[next]&0x1f == 31 {
+				return nil, 0, errors.New("invalid chunk of indefinite-length string")
+			}
+			_, arg, start, err := cborHead(data, next)
+			if err != nil {
+				return nil, 0, err
+			}
+			if arg > uint64(len(data)-start) {
+				return nil, 0, errors.New("unexpected end of CBOR data")
+			}
+			next = start + int(arg)
+			buf = append(buf, data[start:next]...)
+			count = uint64(len(buf))
+
+		case 4:
+			if buf, next, err = w.append(buf, next); err != nil {
+				return nil, 0, err
+			}
+			count++
+
+		case 5:
+			if w.canonical {
+				var e canonicalEntry
+				if e, next, err = w.readEntry(next); err != nil {
+					return nil, 0, err
+				}
+				entries = append(entries, e)
+				continue
+			}
+			for i := 0; i < 2; i++ {
+				if buf, next, err = w.append(buf, next); err != nil {
+					return nil, 0, err
+				}
+			}
+			count++
+		}
+	}
+
+	if major == 5 && w.canonical {
+		dst, err = appendCanonicalEntries(dst, entries)
+		return dst, next, err
+	}
+	return append(appendCBORHead(dst, major, count), buf...), next, nil
+}
+
+type canonicalEntry struct{ key, value []byte }
+
+func (w cborRewriter) readEntry(off int) (e canonicalEntry, next int, err error) {
+	if e.key, next, err = w.append(nil, off); err != nil {
+		return e, 0, err
+	}
+	if e.value, next, err = w.append(nil, next); err != nil {
+		return e, 0, err
+	}
+	return e, next, nil
+}
+
+// appendCanonicalEntries appends the map of the entries sorted by their encoded keys,
+// the keys should be unique.
+func appendCanonicalEntries(dst []byte, entries []canonicalEntry) ([]byte, error) {
+	sort.Slice(entries, func(i, j int) bool { return bytes.Compare(entries[i].key, entries[j].key) < 0 })
+
+	dst = appendCBORHead(dst, 5, uint64(len(entries)))
+	for i, e := range entries {
+		if i > 0 && bytes.Equal(e.key, entries[i-1].key) {
+			return nil, fmt.Errorf("duplicate map key %s", Diagify(e.key))
+		}
+		dst = append(append(dst, e.key...), e.value...)
+	}
+	return dst, nil
+}
+
+// appendCBORHead appends the head of a CBOR data item with the major type and the argument in the shortest form.
+func appendCBORHead(dst []byte, major byte, arg uint64) []byte {
+	major <<= 5
+	switch {
+	case arg < 24:
+		return append(dst, major|byte(arg))
+	case arg <= math.MaxUint8:
+		return append(dst, major|24, byte(arg))
+	case arg <= math.MaxUint16:
+		return append(dst, major|25, byte(arg>>8), byte(arg))
+	case arg <= math.MaxUint32:
+		return append(dst, major|26, byte(arg>>24), byte(arg>>16), byte(arg>>8), byte(arg))
+	default:
+		return append(dst, major|27, byte(arg>>56), byte(arg>>48), byte(arg>>40), byte(arg>>32),
+			byte(arg>>24), byte(arg>>16), byte(arg>>8), byte(arg))
+	}
+}
@@ -0,0 +1,246 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"encoding/hex"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetCBOR(t *testing.T) {
+	assert := assert.New(t)
+
+	em, err := cbor.CanonicalEncOptions().EncMode()
+	assert.NoError(err)
+	defer SetCBOR(encMode.Marshal, decMode.Unmarshal)
+
+	doc := MustFromJSON(`{"a": [1, 2, 3]}`)
+	p, err := PatchFromJSON(`[{"op": "add", "path": "/a/-", "value": 4}]`)
+	assert.NoError(err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				res, err := p.Apply(doc)
+				assert.NoError(err)
+				assert.Equal(`{"a":[1,2,3,4]}`, MustToJSON(res))
+			}
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for j := 0; j < 100; j++ {
+			SetCBOR(em.Marshal, decMode.Unmarshal)
+			SetCBOR(encMode.Marshal, decMode.Unmarshal)
+		}
+	}()
+	wg.Wait()
+}
+
+func TestOptionsCBORModes(t *testing.T) {
+	assert := assert.New(t)
+
+	em, err := cbor.EncOptions{
+		Sort:          cbor.SortBytewiseLexical,
+		ShortestFloat: cbor.ShortestFloat16,
+	}.EncMode()
+	assert.NoError(err)
+	dm, err := cbor.DecOptions{DupMapKey: cbor.DupMapKeyQuiet}.DecMode()
+	assert.NoError(err)
+
+	options := NewOptions()
+	options.EncMode = em
+	options.DecMode = dm
+
+	p, err := PatchFromJSON(`[{ "op": "incr", "path": "/a", "value": 1.0 }]`)
+	assert.NoError(err)
+	doc := MustFromJSON(`{ "a": 1.5 }`)
+
+	data, err := p.Apply(doc)
+	assert.NoError(err)
+	assert.Equal(MustMarshal(map[string]any{"a": 2.5}), data)
+
+	data, err = p.ApplyWithOptions(doc, options)
+	assert.NoError(err)
+	expected, err := em.Marshal(map[string]any{"a": 2.5})
+	assert.NoError(err)
+	assert.Equal(expected, data)
+	assert.NotEqual(MustMarshal(map[string]any{"a": 2.5}), data)
+
+	var tree any
+	assert.NoError(cborUnmarshal(doc, &tree))
+	tree, err = ApplyToTree(tree, p, options)
+	assert.NoError(err)
+	assert.Equal(map[any]any{"a": 2.5}, tree)
+
+	data, err = FromJSONWithOptions([]byte(`{ "a": 2.5 }`), nil, options)
+	assert.NoError(err)
+	assert.Equal(expected, data)
+
+	// duplicate map keys are rejected by the default decoding mode.
+	dup := []byte{0xa2, 0x61, 0x61, 0x01, 0x61, 0x61, 0x02}
+	p, err = PatchFromJSON(`[{ "op": "add", "path": "/b", "value": 1 }]`)
+	assert.NoError(err)
+	_, err = p.Apply(dup)
+	assert.Error(err)
+	data, err = p.ApplyWithOptions(dup, options)
+	assert.NoError(err)
+	assert.Equal(`{"a":2,"b":1}`, MustToJSON(data))
+
+	_, err = ToJSON(dup, nil)
+	assert.Error(err)
+	js, err := ToJSONWithOptions(dup, nil, options)
+	assert.NoError(err)
+	assert.Equal(`{"a":2}`, string(js))
+
+	nested := append(append(appendCBORHead(nil, 5, 1), MustMarshal("m")...), dup...)
+	_, err = GetNodeValueAs[map[string]int](NewNode(nested), PathMustFrom("m"), nil)
+	assert.Error(err)
+	m, err := GetNodeValueAs[map[string]int](NewNode(nested), PathMustFrom("m"), options)
+	assert.NoError(err)
+	assert.Equal(map[string]int{"a": 2}, m)
+
+	// ApplyToValue encodes the value with the EncMode.
+	type event struct {
+		At time.Time `cbor:"at"`
+	}
+	at := time.Date(2013, 3, 21, 20, 4, 0, 0, time.UTC)
+	p = Patch{{Op: OpTest, Path: PathMustFrom("at"), Value: MustMarshal("2013-03-21T20:04:00Z")}}
+	_, err = ApplyToValue(event{At: at}, p, nil)
+	assert.ErrorIs(err, ErrTestFailed)
+	em, err = cbor.EncOptions{Time: cbor.TimeRFC3339}.EncMode()
+	assert.NoError(err)
+	options = NewOptions()
+	options.EncMode = em
+	ev, err := ApplyToValue(event{At: at}, p, options)
+	assert.NoError(err)
+	assert.True(at.Equal(ev.At))
+
+	// CreatePatchFromWithOptions encodes the values with the EncMode.
+	later := at.Add(time.Hour)
+	p, err = CreatePatchFromWithOptions(event{At: at}, event{At: later}, options)
+	assert.NoError(err)
+	assert.Equal(Patch{{Op: OpReplace, Path: PathMustFrom("at"), Value: MustMarshal("2013-03-21T21:04:00Z")}}, p)
+	p, err = CreatePatchFrom(event{At: at}, event{At: later})
+	assert.NoError(err)
+	assert.NotEqual(MustMarshal("2013-03-21T21:04:00Z"), p[0].Value)
+
+	// the global functions are not affected.
+	assert.Equal(MustFromJSON(`{ "a": 2.5 }`), MustMarshal(map[string]any{"a": 2.5}))
+}
+
+func TestCanonicalize(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, c := range []struct{ doc, want string }{
+		{"00", "00"},
+		{"1817", "17"},
+		{"1b00000000000000ff", "18ff"},
+		{"3900ff", "38ff"},
+		{"7a0000000161", "6161"},
+		{"f93c00", "f93c00"},
+		{"fa3fc00000", "f93e00"},
+		{"fb3ff8000000000000", "f93e00"},
+		{"fb3ff199999999999a", "fb3ff199999999999a"},
+		{"fb4000000020000000", "fa40000001"},
+		{"fb7ff8000000000000", "f97e00"},
+		{"fb7ff0000000000000", "f97c00"},
+		{"f4", "f4"},
+		{"f820", "f820"},
+		{"d900011817", "c117"},
+		// {10: 1, "b": 2, -1: 3, "a": 4, 100: 5}
+		{"a50a016162022003616104186405", "a50a011864052003616104616202"},
+		// [_ 1, [2]]
+		{"9f018102ff", "82018102"},
+		// {_ "a": (_ h'01' h'02')}
+		{"bf61615f41014102ffff", "a16161420102"},
+		// (_ "a" "b")
+		{"7f61616162ff", "626162"},
+	} {
+		doc, err := hex.DecodeString(c.doc)
+		assert.NoError(err, c.doc)
+
+		data, err := Canonicalize(doc)
+		assert.NoError(err, c.doc)
+		assert.Equal(c.want, hex.EncodeToString(data), c.doc)
+	}
+
+	for _, s := range []string{"", "82", "8201", "0000", "5f01ff", "ff", "a2616101616102"} {
+		doc, _ := hex.DecodeString(s)
+		_, err := Canonicalize(doc)
+		assert.Error(err, s)
+	}
+}
+
+func TestSimpleValue(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, c := range []struct {
+		v    SimpleValue
+		want string
+	}{
+		{0, "e0"}, {16, "f0"}, {21, "f5"}, {23, "f7"}, {32, "f820"}, {255, "f8ff"},
+	} {
+		data, err := NewSimpleValue(c.v)
+		assert.NoError(err)
+		assert.Equal(c.want, hex.EncodeToString(data))
+
+		v, ok := ReadSimpleValue(data)
+		assert.True(ok)
+		assert.Equal(c.v, v)
+	}
+	for _, v := range []SimpleValue{24, 31} {
+		_, err := NewSimpleValue(v)
+		assert.ErrorIs(err, ErrInvalid)
+	}
+	for _, s := range []string{"", "10", "f818", "f93c00", "81f0"} {
+		data, _ := hex.DecodeString(s)
+		_, ok := ReadSimpleValue(data)
+		assert.False(ok, s)
+	}
+
+	s16, _ := NewSimpleValue(16)
+	s32, _ := NewSimpleValue(32)
+	doc := MustMarshal(map[string]any{"a": s16, "b": []any{s32, true}, "c": 16})
+	assert.Equal(`{"a": simple(16), "b": [simple(32), true], "c": 16}`, Diagify(doc))
+	assert.Equal(`{"a":null,"b":[null,true],"c":16}`, MustToJSON(doc))
+
+	node := NewNode(doc)
+	v, err := node.GetSimple(PathMustFrom("b", 0))
+	assert.NoError(err)
+	assert.Equal(SimpleValue(32), v)
+	v, err = node.GetSimple(PathMustFrom("b", 1))
+	assert.NoError(err)
+	assert.Equal(SimpleValue(21), v)
+	_, err = node.GetSimple(PathMustFrom("c"))
+	var te *ValueTypeError
+	assert.ErrorAs(err, &te)
+
+	p, err := NewBuilder().
+		Test(PathMustFrom("a"), SimpleValue(16)).
+		TestType(PathMustFrom("b", 0), "simple").
+		Replace(PathMustFrom("c"), s32).
+		Build()
+	assert.NoError(err)
+	doc, err = p.Apply(doc)
+	assert.NoError(err)
+	assert.Equal(`{"a": simple(16), "b": [simple(32), true], "c": simple(32)}`, Diagify(doc))
+
+	for _, path := range []Path{PathMustFrom("b", 1), PathMustFrom("c")} {
+		p, err = NewBuilder().TestType(path, "simple").Build()
+		assert.NoError(err)
+		_, err = p.Apply(MustMarshal(map[string]any{"b": []any{1, true}, "c": nil}))
+		assert.ErrorIs(err, ErrTestFailed)
+	}
+}
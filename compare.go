@@ -0,0 +1,213 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DifferenceKind is the kind of a Difference.
+type DifferenceKind uint8
+
+const (
+	// DifferenceAdded is a value that exists only in the second document.
+	DifferenceAdded DifferenceKind = iota + 1
+	// DifferenceRemoved is a value that exists only in the first document.
+	DifferenceRemoved
+	// DifferenceChanged is a value of the same CBOR major type but different in the two documents.
+	DifferenceChanged
+	// DifferenceTypeChanged is a value of different CBOR major types in the two documents.
+	DifferenceTypeChanged
+)
+
+// String returns the name of the DifferenceKind.
+func (k DifferenceKind) String() string {
+	switch k {
+	case DifferenceAdded:
+		return "added"
+	case DifferenceRemoved:
+		return "removed"
+	case DifferenceChanged:
+		return "changed"
+	case DifferenceTypeChanged:
+		return "type-changed"
+	default:
+		return fmt.Sprintf("reserved(%d)", k)
+	}
+}
+
+// Difference is a structural difference of two CBOR documents at the Path, see Compare.
+// A is the value in the first document, nil if it is added,
+// B is the value in the second document, nil if it is removed.
+type Difference struct {
+	Path Path
+	Kind DifferenceKind
+	A    RawMessage
+	B    RawMessage
+}
+
+// String returns the Difference in CBOR diagnostic notation, such as `changed ["a", 0]: 1 => 2`.
+func (d *Difference) String() string {
+	switch d.Kind {
+	case DifferenceAdded:
+		return fmt.Sprintf("%s %s: %s", d.Kind, d.Path, Diagify(d.B))
+	case DifferenceRemoved:
+		return fmt.Sprintf("%s %s: %s", d.Kind, d.Path, Diagify(d.A))
+	default:
+		return fmt.Sprintf("%s %s: %s => %s", d.Kind, d.Path, Diagify(d.A), Diagify(d.B))
+	}
+}
+
+// Compare returns the structural differences of two CBOR documents, which are equal if there is none.
+// Maps are compared key by key in the bytewise order of the keys, and arrays are compared
+// element by element at the same indexes, so the paths of differences refer to both documents.
+// Values are compared by Equal, a nil or empty document is equal to CBOR null.
+// Unlike CreatePatch, the differences explain why the documents are not equal rather than transforming them.
+func Compare(a, b []byte) ([]Difference, error) {
+	for _, doc := range [][]byte{a, b} {
+		if len(doc) > 0 {
+			if err := cborValid(doc); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var ds []Difference
+	if err := compareNodes(&ds, Path{}, NewNode(a), NewNode(b)); err != nil {
+		return nil, err
+	}
+	return ds, nil
+}
+
+func compareNodes(ds *[]Difference, path Path, a, b *Node) error {
+	if a == nil {
+		a = NewNode(nil)
+	}
+	if b == nil {
+		b = NewNode(nil)
+	}
+
+	a.intoContainer()
+	b.intoContainer()
+	switch {
+	case a.which == eDoc && b.which == eDoc:
+		return compareDocs(ds, path, a.doc, b.doc)
+	case a.which == eAry && b.which == eAry:
+		return compareArrays(ds, path, a.ary, b.ary)
+	case a.Equal(b):
+		return nil
+	}
+
+	av, err := a.MarshalCBOR()
+	if err != nil {
+		return err
+	}
+	bv, err := b.MarshalCBOR()
+	if err != nil {
+		return err
+	}
+
+	kind := DifferenceChanged
+	if ReadCBORType(av) != ReadCBORType(bv) {
+		kind = DifferenceTypeChanged
+	}
+	*ds = append(*ds, Difference{Path: path, Kind: kind, A: av, B: bv})
+	return nil
+}
+
+func compareDocs(ds *[]Difference, path Path, a, b *partialDoc) error {
+	keys := make([]RawKey, 0, len(a.obj)+len(b.obj))
+	for k := range a.obj {
+		keys = append(keys, k)
+	}
+	for k := range b.obj {
+		if _, ok := a.obj[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	for _, k := range keys {
+		av, aok := a.obj[k]
+		bv, bok := b.obj[k]
+		if aok && bok {
+			if err := compareNodes(ds, path.WithKey(k), av, bv); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := compareMissing(ds, path.WithKey(k), av, bv, aok); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func compareArrays(ds *[]Difference, path Path, a, b partialArray) error {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var err error
+		switch {
+		case i < len(a) && i < len(b):
+			err = compareNodes(ds, path.withIndex(i), a[i], b[i])
+		case i < len(a):
+			err = compareMissing(ds, path.withIndex(i), a[i], nil, true)
+		default:
+			err = compareMissing(ds, path.withIndex(i), nil, b[i], false)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compareMissing appends the difference of a value removed from a, or added to b if removed is false.
+func compareMissing(ds *[]Difference, path Path, a, b *Node, removed bool) error {
+	if removed {
+		av, err := a.MarshalCBOR()
+		if err != nil {
+			return err
+		}
+		*ds = append(*ds, Difference{Path: path, Kind: DifferenceRemoved, A: av})
+		return nil
+	}
+
+	bv, err := b.MarshalCBOR()
+	if err != nil {
+		return err
+	}
+	*ds = append(*ds, Difference{Path: path, Kind: DifferenceAdded, B: bv})
+	return nil
+}
+
+// DiffDiag renders the differences of two CBOR documents in CBOR diagnostic notation like a unified diff,
+// each difference has a path header line, a "-" line of the value in a and a "+" line of the value in b,
+// such as:
+//
+//	@@ ["name"] @@
+//	- "John"
+//	+ "Jane"
+//
+// It returns an empty string if the documents are equal, see Compare.
+// If either document is invalid, the whole documents are rendered as a difference at the root.
+func DiffDiag(a, b []byte) string {
+	ds, err := Compare(a, b)
+	if err != nil {
+		ds = []Difference{{Path: Path{}, Kind: DifferenceChanged, A: a, B: b}}
+	}
+
+	buf := &strings.Builder{}
+	for _, d := range ds {
+		fmt.Fprintf(buf, "@@ %s @@\n", d.Path)
+		if d.Kind != DifferenceAdded {
+			fmt.Fprintf(buf, "- %s\n", Diagify(d.A))
+		}
+		if d.Kind != DifferenceRemoved {
+			fmt.Fprintf(buf, "+ %s\n", Diagify(d.B))
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,94 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompare(t *testing.T) {
+	assert := assert.New(t)
+
+	a := MustMarshal(map[string]any{
+		"name": "John",
+		"age":  24,
+		"tags": []any{"a", "b", "c"},
+		"addr": map[string]any{"city": "Paris", "zip": 75001},
+		"nick": nil,
+	})
+	b := MustMarshal(map[string]any{
+		"name":  "John",
+		"age":   "24",
+		"tags":  []any{"a", "x"},
+		"addr":  map[string]any{"city": "Lyon", "zip": 75001},
+		"email": "john@example.com",
+	})
+
+	ds, err := Compare(a, b)
+	assert.NoError(err)
+	strs := make([]string, 0, len(ds))
+	for _, d := range ds {
+		strs = append(strs, d.String())
+	}
+	assert.Equal([]string{
+		`type-changed ["age"]: 24 => "24"`,
+		`changed ["addr", "city"]: "Paris" => "Lyon"`,
+		`removed ["nick"]: null`,
+		`changed ["tags", 1]: "b" => "x"`,
+		`removed ["tags", 2]: "c"`,
+		`added ["email"]: "john@example.com"`,
+	}, strs)
+
+	assert.Equal(DifferenceTypeChanged, ds[0].Kind)
+	assert.Equal(PathMustFrom("age"), ds[0].Path)
+	assert.Equal(RawMessage(MustMarshal(24)), ds[0].A)
+	assert.Equal(RawMessage(MustMarshal("24")), ds[0].B)
+	assert.Nil(ds[2].B)
+	assert.Nil(ds[5].A)
+
+	ds, err = Compare(a, a)
+	assert.NoError(err)
+	assert.Len(ds, 0)
+
+	ds, err = Compare(nil, MustMarshal(nil))
+	assert.NoError(err)
+	assert.Len(ds, 0)
+
+	ds, err = Compare(MustMarshal([]int{1}), MustMarshal(map[string]int{"a": 1}))
+	assert.NoError(err)
+	assert.Equal([]Difference{{Path: Path{}, Kind: DifferenceTypeChanged,
+		A: MustMarshal([]int{1}), B: MustMarshal(map[string]int{"a": 1})}}, ds)
+
+	ds, err = Compare(MustMarshal([]int{1}), MustMarshal([]int{1, 2, 3}))
+	assert.NoError(err)
+	assert.Equal([]Difference{
+		{Path: PathMustFrom(1), Kind: DifferenceAdded, B: MustMarshal(2)},
+		{Path: PathMustFrom(2), Kind: DifferenceAdded, B: MustMarshal(3)},
+	}, ds)
+
+	_, err = Compare([]byte{0x82, 0x01}, nil)
+	assert.Error(err)
+	assert.Equal("reserved(0)", DifferenceKind(0).String())
+}
+
+func TestDiffDiag(t *testing.T) {
+	assert := assert.New(t)
+
+	a := MustMarshal(map[string]any{"name": "John", "key": []byte{1, 2}, "tags": []any{"a"}})
+	b := MustMarshal(map[string]any{"name": "Jane", "key": []byte{1, 3}, "tags": []any{"a", 1.5}})
+
+	assert.Equal(`@@ ["key"] @@
+- h'0102'
++ h'0103'
+@@ ["name"] @@
+- "John"
++ "Jane"
+@@ ["tags", 1] @@
++ 1.5
+`, DiffDiag(a, b))
+	assert.Equal("", DiffDiag(a, a))
+	assert.Equal("@@ [] @@\n- h'8201'\n+ 1\n", DiffDiag([]byte{0x82, 0x01}, MustMarshal(1)))
+}
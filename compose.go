@@ -0,0 +1,135 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+// ComposePatches combines the patches into one patch that has the same effect as applying
+// them in order, if they apply successfully. It drops the operations superseded by a later
+// "replace", "remove" or map "add" on the same path or a parent path, and merges an "add"
+// followed by a "replace" of the same path into one "add". An operation is kept if any
+// operation between them reads or writes an overlapping path, see Conflicts.
+// The composed patch may not fail in the same way as the patches, since some checks
+// of the dropped operations are gone.
+func ComposePatches(ps ...Patch) (Patch, error) {
+	n := 0
+	for _, p := range ps {
+		n += len(p)
+	}
+
+	ops := make([]*Operation, 0, n)
+	for _, p := range ps {
+		for _, op := range p {
+			if err := op.Valid(); err != nil {
+				return nil, err
+			}
+			ops = append(ops, op)
+		}
+	}
+
+	for j, op := range ops {
+		if !supersedes(op) {
+			continue
+		}
+
+		a := access{path: op.Path, write: true}
+	scan:
+		for k := j - 1; k >= 0; k-- {
+			prev := ops[k]
+			if prev == nil {
+				continue
+			}
+
+			switch composeOps(prev, op) {
+			case composeDrop:
+				ops[k] = nil
+				continue
+			case composeMerge:
+				ops[k] = &Operation{Op: OpAdd, Path: prev.Path, Value: op.Value}
+				ops[j] = nil
+				break scan
+			}
+
+			for _, b := range accessesOf(prev) {
+				if a.overlaps(b) {
+					break scan
+				}
+			}
+		}
+	}
+
+	res := make(Patch, 0, n)
+	for _, op := range ops {
+		if op != nil {
+			res = append(res, op)
+		}
+	}
+	return res, nil
+}
+
+// supersedes reports whether the operation overwrites everything at its path.
+func supersedes(op *Operation) bool {
+	if len(op.Path) == 0 {
+		return op.Op == OpReplace
+	}
+	for _, k := range op.Path {
+		if k.isMinus() {
+			return false
+		}
+	}
+
+	switch op.Op {
+	case OpReplace, OpRemove:
+		return true
+	case OpAdd:
+		// adding to an array inserts rather than overwrites.
+		return !op.Path[len(op.Path)-1].isIndex()
+	}
+	return false
+}
+
+const (
+	composeKeep = iota
+	composeDrop
+	composeMerge
+)
+
+// composeOps decides how the prev operation composes with a later operation that supersedes its path.
+func composeOps(prev, op *Operation) int {
+	switch prev.Op {
+	case OpAdd, OpReplace, OpRemove, OpCopy, OpMerge, OpAppend, OpSplice, OpIncr, OpDecr, OpToggle, OpTextDiff, OpBinaryDiff:
+	case OpMove:
+		// prev moves a value inside the subtree that is overwritten.
+		if len(prev.From) > len(op.Path) && prev.From.hasPrefix(op.Path) &&
+			len(prev.Path) > len(op.Path) && prev.Path.hasPrefix(op.Path) {
+			return composeDrop
+		}
+		return composeKeep
+	default:
+		return composeKeep
+	}
+
+	if !prev.Path.hasPrefix(op.Path) {
+		return composeKeep
+	}
+	if len(prev.Path) > len(op.Path) {
+		// prev writes into the subtree that is overwritten.
+		return composeDrop
+	}
+
+	switch prev.Op {
+	case OpReplace, OpMerge, OpAppend, OpSplice, OpIncr, OpDecr, OpToggle, OpTextDiff, OpBinaryDiff:
+		return composeDrop
+	case OpAdd, OpCopy:
+		switch op.Op {
+		case OpReplace:
+			return composeMerge
+		case OpAdd:
+			return composeDrop
+		}
+	case OpRemove:
+		if op.Op == OpAdd {
+			return composeDrop
+		}
+	}
+	return composeKeep
+}
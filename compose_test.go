@@ -0,0 +1,79 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComposePatches(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, c := range []struct {
+		doc      string
+		patches  []string
+		composed string
+	}{
+		{`{"a": 1}`, []string{
+			`[{"op":"replace","path":"/a","value":2}]`,
+			`[{"op":"replace","path":"/a","value":3}]`,
+		}, `[{"op":"replace","path":"/a","value":3}]`},
+		{`{"a": 1}`, []string{
+			`[{"op":"add","path":"/b","value":2},{"op":"replace","path":"/b","value":3}]`,
+			`[{"op":"replace","path":"/b","value":4}]`,
+		}, `[{"op":"add","path":"/b","value":4}]`},
+		{`{"a": {"x": 1}}`, []string{
+			`[{"op":"add","path":"/a/y","value":2},{"op":"remove","path":"/a/x"}]`,
+			`[{"op":"replace","path":"/a","value":{}}]`,
+		}, `[{"op":"replace","path":"/a","value":{}}]`},
+		{`{"a": {"x": 1}}`, []string{
+			`[{"op":"replace","path":"/a/x","value":2},{"op":"test","path":"/a/x","value":2}]`,
+			`[{"op":"replace","path":"/a/x","value":3}]`,
+		}, `[{"op":"replace","path":"/a/x","value":2},{"op":"test","path":"/a/x","value":2},{"op":"replace","path":"/a/x","value":3}]`},
+		{`{"a": [1, 2]}`, []string{
+			`[{"op":"replace","path":"/a/1","value":3},{"op":"add","path":"/a/0","value":0}]`,
+			`[{"op":"replace","path":"/a/1","value":4}]`,
+		}, `[{"op":"replace","path":"/a/1","value":3},{"op":"add","path":"/a/0","value":0},{"op":"replace","path":"/a/1","value":4}]`},
+		{`{"a": [1, 2]}`, []string{
+			`[{"op":"add","path":"/a/0","value":0},{"op":"add","path":"/a/0","value":-1}]`,
+		}, `[{"op":"add","path":"/a/0","value":0},{"op":"add","path":"/a/0","value":-1}]`},
+		{`{"a": 1}`, []string{
+			`[{"op":"add","path":"/b","value":2}]`,
+			`[{"op":"remove","path":"/b"}]`,
+		}, `[{"op":"add","path":"/b","value":2},{"op":"remove","path":"/b"}]`},
+		{`{"a": 1}`, []string{
+			`[{"op":"remove","path":"/a"},{"op":"add","path":"/a","value":2}]`,
+			`[{"op":"copy","from":"/a","path":"/b"},{"op":"add","path":"/b","value":3}]`,
+		}, `[{"op":"add","path":"/a","value":2},{"op":"add","path":"/b","value":3}]`},
+		{`{"a": {"x": 1}, "b": 0}`, []string{
+			`[{"op":"move","from":"/a/x","path":"/b"}]`,
+			`[{"op":"replace","path":"/a","value":{}},{"op":"replace","path":"","value":[]}]`,
+		}, `[{"op":"replace","path":"","value":[]}]`},
+	} {
+		ps := make([]Patch, len(c.patches))
+		expected := MustFromJSON(c.doc)
+		for i, s := range c.patches {
+			p, err := PatchFromJSON(s)
+			assert.NoError(err)
+			ps[i] = p
+			expected, err = p.Apply(expected)
+			assert.NoError(err)
+		}
+
+		composed, err := ComposePatches(ps...)
+		assert.NoError(err)
+		data, err := PatchToJSON(composed)
+		assert.NoError(err)
+		assert.Equal(c.composed, string(data))
+
+		result, err := composed.Apply(MustFromJSON(c.doc))
+		assert.NoError(err)
+		assert.True(Equal(expected, result), "got %s, expected %s", MustToJSON(result), MustToJSON(expected))
+	}
+
+	_, err := ComposePatches(Patch{{Op: OpMove, Path: PathMustFrom("a")}})
+	assert.Error(err)
+}
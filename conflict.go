@@ -0,0 +1,99 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"fmt"
+)
+
+// Conflict describes two operations of different patches whose paths overlap,
+// so the result depends on the order they are applied in.
+type Conflict struct {
+	// Index1 and Index2 are the indexes of the operations in the first and the second patch.
+	Index1, Index2 int
+	// Path1 and Path2 are the overlapping paths of the operations.
+	Path1, Path2 Path
+}
+
+// String returns a readable description of the conflict.
+func (c Conflict) String() string {
+	return fmt.Sprintf("operation %d at %s conflicts with operation %d at %s",
+		c.Index1, c.Path1, c.Index2, c.Path2)
+}
+
+// Conflicts statically detects the conflicts between two patches that are meant to be
+// applied concurrently to the same document. Two operations conflict if one of them
+// writes to a path that the other one reads or writes, or to a parent or a child of it.
+// Inserting into or removing from an array conflicts with any operation on the
+// other elements of the array, since their indexes may be shifted, so does a negative index.
+// Operations that only read (the test operations and the "from" path of "copy") never conflict with each other,
+// neither do "incr", "decr" and "toggle" operations, since the deltas commute.
+func Conflicts(p1, p2 Patch) []Conflict {
+	var res []Conflict
+	for i, op1 := range p1 {
+		a1 := accessesOf(op1)
+	next:
+		for j, op2 := range p2 {
+			for _, a := range a1 {
+				for _, b := range accessesOf(op2) {
+					if (a.write || b.write) && !(a.delta && b.delta) && a.overlaps(b) {
+						res = append(res, Conflict{Index1: i, Index2: j, Path1: a.path, Path2: b.path})
+						continue next
+					}
+				}
+			}
+		}
+	}
+	return res
+}
+
+type access struct {
+	path  Path
+	write bool
+	// shift indicates that the operation inserts or removes an array element at path.
+	shift bool
+	// delta indicates that the operation updates the value at path relative to itself,
+	// such as adding to a number or flipping a boolean, which commutes with other deltas.
+	delta bool
+}
+
+func accessesOf(op *Operation) []access {
+	switch op.Op {
+	case OpAdd, OpRemove:
+		return []access{{path: op.Path, write: true, shift: true}}
+	case OpReplace, OpMerge, OpAppend, OpSplice, OpTextDiff, OpBinaryDiff:
+		return []access{{path: op.Path, write: true}}
+	case OpIncr, OpDecr, OpToggle:
+		return []access{{path: op.Path, write: true, delta: true}}
+	case OpMove:
+		return []access{{path: op.From, write: true, shift: true}, {path: op.Path, write: true, shift: true}}
+	case OpCopy:
+		return []access{{path: op.From}, {path: op.Path, write: true, shift: true}}
+	case OpTest, OpTestContains, OpTestType, OpTestMatch, OpTestLength, OpTestDefined, OpTestUndefined, OpTestSubset,
+		OpTestLess, OpTestLessEqual, OpTestGreater, OpTestGreaterEqual:
+		return []access{{path: op.Path}}
+	}
+	return nil
+}
+
+func (a access) overlaps(b access) bool {
+	for i := 0; ; i++ {
+		if i == len(a.path) || i == len(b.path) {
+			// one path is the other one or its parent.
+			return true
+		}
+		if a.path[i].Equal(b.path[i]) {
+			continue
+		}
+
+		// siblings in an array, the indexes of the latter ones are shifted by inserting or removing,
+		// and a negative index may refer to the same element as the other one.
+		if a.path[i].isIndex() && b.path[i].isIndex() {
+			return a.shift && len(a.path) == i+1 || b.shift && len(b.path) == i+1 ||
+				ReadCBORType([]byte(a.path[i])) == CBORTypeNegativeInt ||
+				ReadCBORType([]byte(b.path[i])) == CBORTypeNegativeInt
+		}
+		return false
+	}
+}
@@ -0,0 +1,54 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConflicts(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, c := range []struct {
+		p1, p2    string
+		conflicts []string
+	}{
+		{`[{"op":"replace","path":"/a/b","value":1}]`, `[{"op":"replace","path":"/a/c","value":2}]`, nil},
+		{`[{"op":"replace","path":"/a/b","value":1}]`, `[{"op":"replace","path":"/a/b","value":2}]`,
+			[]string{`operation 0 at ["a", "b"] conflicts with operation 0 at ["a", "b"]`}},
+		{`[{"op":"test","path":"/x","value":1},{"op":"remove","path":"/a"}]`,
+			`[{"op":"add","path":"/y","value":1},{"op":"add","path":"/a/b/c","value":2}]`,
+			[]string{`operation 1 at ["a"] conflicts with operation 1 at ["a", "b", "c"]`}},
+		{`[{"op":"test","path":"/a","value":1}]`, `[{"op":"copy","from":"/a","path":"/b"}]`, nil},
+		{`[{"op":"test","path":"/b/c","value":1}]`, `[{"op":"copy","from":"/a","path":"/b"}]`,
+			[]string{`operation 0 at ["b", "c"] conflicts with operation 0 at ["b"]`}},
+		{`[{"op":"move","from":"/a/x","path":"/b"}]`, `[{"op":"test","path":"/a","value":1}]`,
+			[]string{`operation 0 at ["a", "x"] conflicts with operation 0 at ["a"]`}},
+		{`[{"op":"add","path":"/a/0","value":1}]`, `[{"op":"replace","path":"/a/3/x","value":2}]`,
+			[]string{`operation 0 at ["a", 0] conflicts with operation 0 at ["a", 3, "x"]`}},
+		{`[{"op":"replace","path":"/a/0","value":1}]`, `[{"op":"replace","path":"/a/3/x","value":2}]`, nil},
+		{`[{"op":"replace","path":"/a/-1","value":1}]`, `[{"op":"replace","path":"/a/3/x","value":2}]`,
+			[]string{`operation 0 at ["a", -1] conflicts with operation 0 at ["a", 3, "x"]`}},
+		{`[{"op":"remove","path":"/a/x"}]`, `[{"op":"replace","path":"/a/y","value":2}]`, nil},
+		{`[{"op":"incr","path":"/n","value":1}]`, `[{"op":"decr","path":"/n","value":2}]`, nil},
+		{`[{"op":"toggle","path":"/f"}]`, `[{"op":"toggle","path":"/f"}]`, nil},
+		{`[{"op":"incr","path":"/n","value":1}]`, `[{"op":"replace","path":"/n","value":2}]`,
+			[]string{`operation 0 at ["n"] conflicts with operation 0 at ["n"]`}},
+		{`[{"op":"replace","path":"","value":{}}]`, `[{"op":"test","path":"/a","value":2}]`,
+			[]string{`operation 0 at [] conflicts with operation 0 at ["a"]`}},
+	} {
+		p1, err := PatchFromJSON(c.p1)
+		assert.NoError(err)
+		p2, err := PatchFromJSON(c.p2)
+		assert.NoError(err)
+
+		var res []string
+		for _, conflict := range Conflicts(p1, p2) {
+			res = append(res, conflict.String())
+		}
+		assert.Equal(c.conflicts, res, "%s vs %s", c.p1, c.p2)
+	}
+}
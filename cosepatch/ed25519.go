@@ -0,0 +1,57 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cosepatch
+
+import (
+	"crypto/ed25519"
+	"errors"
+)
+
+// AlgorithmEdDSA is the COSE algorithm identifier of EdDSA.
+const AlgorithmEdDSA = -8
+
+// Ed25519Signer is a Signer with an Ed25519 private key.
+type Ed25519Signer struct {
+	Key ed25519.PrivateKey
+	Kid []byte
+}
+
+// Algorithm implements the Signer interface.
+func (s *Ed25519Signer) Algorithm() int {
+	return AlgorithmEdDSA
+}
+
+// KeyID implements the Signer interface.
+func (s *Ed25519Signer) KeyID() []byte {
+	return s.Kid
+}
+
+// Sign implements the Signer interface.
+func (s *Ed25519Signer) Sign(data []byte) ([]byte, error) {
+	if len(s.Key) != ed25519.PrivateKeySize {
+		return nil, errors.New("invalid ed25519 private key")
+	}
+	return ed25519.Sign(s.Key, data), nil
+}
+
+// Ed25519Verifier is a Verifier with an Ed25519 public key.
+type Ed25519Verifier struct {
+	Key ed25519.PublicKey
+}
+
+// Algorithm implements the Verifier interface.
+func (v *Ed25519Verifier) Algorithm() int {
+	return AlgorithmEdDSA
+}
+
+// Verify implements the Verifier interface.
+func (v *Ed25519Verifier) Verify(data, sig []byte) error {
+	if len(v.Key) != ed25519.PublicKeySize {
+		return errors.New("invalid ed25519 public key")
+	}
+	if !ed25519.Verify(v.Key, data, sig) {
+		return errors.New("ed25519 signature verification failed")
+	}
+	return nil
+}
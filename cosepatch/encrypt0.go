@@ -0,0 +1,182 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cosepatch
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	"github.com/fxamacker/cbor/v2"
+	cborpatch "github.com/ldclabs/cbor-patch"
+)
+
+// COSE algorithm identifiers of AES-GCM.
+const (
+	AlgorithmA128GCM = 1
+	AlgorithmA192GCM = 2
+	AlgorithmA256GCM = 3
+)
+
+// ContentTypeCOSESign1 is the content type of COSE_Sign1 payloads in COSE headers.
+const ContentTypeCOSESign1 = `application/cose; cose-type="cose-sign1"`
+
+type encrypt0Message struct {
+	_           struct{} `cbor:",toarray"`
+	Protected   cbor.ByteString
+	Unprotected map[int]any
+	Ciphertext  cbor.ByteString
+}
+
+// EncryptPatch encodes the patch as the payload of a COSE_Encrypt0 message,
+// and encrypts it with AES-GCM using the shared key.
+// The key must be 16, 24 or 32 bytes to select A128GCM, A192GCM or A256GCM.
+// It returns the tagged COSE_Encrypt0 message.
+func EncryptPatch(patch cborpatch.Patch, key []byte) ([]byte, error) {
+	if err := patch.Valid(); err != nil {
+		return nil, err
+	}
+
+	payload, err := encMode.Marshal(patch)
+	if err != nil {
+		return nil, err
+	}
+	return encrypt0(payload, ContentTypeCBORPatch, key)
+}
+
+// DecryptPatch decrypts a COSE_Encrypt0 message created by EncryptPatch with the shared key,
+// and decodes its payload as a Patch.
+func DecryptPatch(msg []byte, key []byte) (cborpatch.Patch, error) {
+	payload, err := decrypt0(msg, ContentTypeCBORPatch, key)
+	if err != nil {
+		return nil, err
+	}
+	return cborpatch.NewPatch(payload)
+}
+
+// SignAndEncryptPatch signs the patch as a COSE_Sign1 message with the signer,
+// and then encrypts the COSE_Sign1 message as a COSE_Encrypt0 message with the shared key.
+func SignAndEncryptPatch(patch cborpatch.Patch, signer Signer, key []byte) ([]byte, error) {
+	signed, err := SignPatch(patch, signer)
+	if err != nil {
+		return nil, err
+	}
+	return encrypt0(signed, ContentTypeCOSESign1, key)
+}
+
+// DecryptAndVerifyPatch decrypts a COSE_Encrypt0 message created by SignAndEncryptPatch with the shared key,
+// verifies the enclosed COSE_Sign1 message and decodes its payload as a Patch.
+func DecryptAndVerifyPatch(msg []byte, key []byte, verifier Verifier) (cborpatch.Patch, error) {
+	signed, err := decrypt0(msg, ContentTypeCOSESign1, key)
+	if err != nil {
+		return nil, err
+	}
+	return VerifyAndDecodePatch(signed, verifier)
+}
+
+func encrypt0(payload []byte, ct string, key []byte) ([]byte, error) {
+	aead, alg, err := newAESGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	protected, err := encMode.Marshal(map[int]any{
+		HeaderAlgorithm:   alg,
+		HeaderContentType: ct,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	aad, err := encStructure(protected)
+	if err != nil {
+		return nil, err
+	}
+
+	iv := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(iv); err != nil {
+		return nil, err
+	}
+
+	msg := &encrypt0Message{
+		Protected:   cbor.ByteString(protected),
+		Unprotected: map[int]any{HeaderIV: iv},
+		Ciphertext:  cbor.ByteString(aead.Seal(nil, iv, payload, aad)),
+	}
+	return encMode.Marshal(cbor.Tag{Number: TagEncrypt0, Content: msg})
+}
+
+func decrypt0(msg []byte, ct string, key []byte) ([]byte, error) {
+	aead, alg, err := newAESGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := untag(msg, TagEncrypt0)
+	if err != nil {
+		return nil, err
+	}
+
+	var m encrypt0Message
+	if err = decMode.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("%w, %v", ErrInvalidMessage, err)
+	}
+
+	if err = checkProtectedHeader(m.Protected.Bytes(), alg, ct); err != nil {
+		return nil, err
+	}
+
+	var iv []byte
+	if v, ok := m.Unprotected[HeaderIV]; ok {
+		iv, _ = v.([]byte)
+	}
+	if len(iv) != aead.NonceSize() {
+		return nil, fmt.Errorf("%w, invalid IV", ErrInvalidMessage)
+	}
+
+	aad, err := encStructure(m.Protected.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := aead.Open(nil, iv, m.Ciphertext.Bytes(), aad)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt COSE_Encrypt0 message, %v", err)
+	}
+	return payload, nil
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, int, error) {
+	var alg int
+	switch len(key) {
+	case 16:
+		alg = AlgorithmA128GCM
+	case 24:
+		alg = AlgorithmA192GCM
+	case 32:
+		alg = AlgorithmA256GCM
+	default:
+		return nil, 0, errors.New("invalid AES-GCM key size")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, 0, err
+	}
+	return aead, alg, nil
+}
+
+func encStructure(protected []byte) ([]byte, error) {
+	return encMode.Marshal([]any{
+		"Encrypt0",
+		cbor.ByteString(protected),
+		cbor.ByteString(""),
+	})
+}
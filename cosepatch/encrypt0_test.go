@@ -0,0 +1,79 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cosepatch
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	cborpatch "github.com/ldclabs/cbor-patch"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryptPatch(t *testing.T) {
+	assert := assert.New(t)
+
+	patch, err := cborpatch.PatchFromJSON(`[
+		{"op": "replace", "path": "/password", "value": "secret"}
+	]`)
+	assert.NoError(err)
+
+	for _, size := range []int{16, 24, 32} {
+		key := make([]byte, size)
+		rand.Read(key)
+
+		msg, err := EncryptPatch(patch, key)
+		assert.NoError(err)
+		assert.Equal(byte(0xd0), msg[0], "should be tagged with 16")
+		assert.NotContains(string(msg), "secret")
+
+		res, err := DecryptPatch(msg, key)
+		assert.NoError(err)
+		assert.Equal(patch, res)
+
+		other := make([]byte, size)
+		rand.Read(other)
+		_, err = DecryptPatch(msg, other)
+		assert.ErrorContains(err, "unable to decrypt")
+	}
+
+	_, err = EncryptPatch(patch, []byte("short"))
+	assert.ErrorContains(err, "invalid AES-GCM key size")
+
+	key := make([]byte, 16)
+	msg, err := EncryptPatch(patch, key)
+	assert.NoError(err)
+	_, err = DecryptPatch(msg, make([]byte, 32))
+	assert.True(errors.Is(err, ErrInvalidMessage), "algorithm mismatch")
+}
+
+func TestSignAndEncryptPatch(t *testing.T) {
+	assert := assert.New(t)
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(err)
+	key := make([]byte, 32)
+	rand.Read(key)
+
+	patch, err := cborpatch.PatchFromJSON(`[
+		{"op": "add", "path": "/config/level", "value": 3}
+	]`)
+	assert.NoError(err)
+
+	msg, err := SignAndEncryptPatch(patch, &Ed25519Signer{Key: priv}, key)
+	assert.NoError(err)
+
+	res, err := DecryptAndVerifyPatch(msg, key, &Ed25519Verifier{Key: pub})
+	assert.NoError(err)
+	assert.Equal(patch, res)
+
+	_, err = DecryptPatch(msg, key)
+	assert.True(errors.Is(err, ErrInvalidMessage), "content type mismatch")
+
+	pub2, _, _ := ed25519.GenerateKey(rand.Reader)
+	_, err = DecryptAndVerifyPatch(msg, key, &Ed25519Verifier{Key: pub2})
+	assert.ErrorContains(err, "verification failed")
+}
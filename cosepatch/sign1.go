@@ -0,0 +1,182 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+// Package cosepatch wraps CBOR-Patch documents in COSE (RFC 9052) messages,
+// so that patches can be authenticated and encrypted end-to-end.
+package cosepatch
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/fxamacker/cbor/v2"
+	cborpatch "github.com/ldclabs/cbor-patch"
+)
+
+// COSE tags and header labels used by this package.
+// Refer to https://www.iana.org/assignments/cose/cose.xhtml.
+const (
+	TagSign1    uint64 = 18
+	TagEncrypt0 uint64 = 16
+
+	HeaderAlgorithm   = 1
+	HeaderContentType = 3
+	HeaderKeyID       = 4
+	HeaderIV          = 5
+)
+
+// ContentTypeCBORPatch is the content type of CBOR-Patch payloads in COSE headers.
+const ContentTypeCBORPatch = cborpatch.MediaTypeCBORPatch
+
+// Signer signs data for a COSE_Sign1 message.
+type Signer interface {
+	// Algorithm returns the COSE algorithm identifier, such as -8 for EdDSA.
+	Algorithm() int
+	// KeyID returns the key identifier placed in the unprotected header, it can be nil.
+	KeyID() []byte
+	// Sign returns the signature of the data.
+	Sign(data []byte) ([]byte, error)
+}
+
+// Verifier verifies a COSE_Sign1 message.
+type Verifier interface {
+	// Algorithm returns the COSE algorithm identifier, such as -8 for EdDSA.
+	Algorithm() int
+	// Verify checks the signature of the data.
+	Verify(data, sig []byte) error
+}
+
+var (
+	encMode, _ = cbor.CoreDetEncOptions().EncMode()
+	decMode, _ = cbor.DecOptions{
+		DupMapKey:   cbor.DupMapKeyEnforcedAPF,
+		IndefLength: cbor.IndefLengthForbidden,
+	}.DecMode()
+)
+
+// ErrInvalidMessage is returned when a COSE message is malformed or not expected.
+var ErrInvalidMessage = errors.New("invalid COSE message")
+
+type sign1Message struct {
+	_           struct{} `cbor:",toarray"`
+	Protected   cbor.ByteString
+	Unprotected map[int]any
+	Payload     cbor.ByteString
+	Signature   cbor.ByteString
+}
+
+// SignPatch encodes the patch as the payload of a COSE_Sign1 message and signs it.
+// It returns the tagged COSE_Sign1 message.
+func SignPatch(patch cborpatch.Patch, signer Signer) ([]byte, error) {
+	if err := patch.Valid(); err != nil {
+		return nil, err
+	}
+
+	payload, err := encMode.Marshal(patch)
+	if err != nil {
+		return nil, err
+	}
+
+	protected, err := encMode.Marshal(map[int]any{
+		HeaderAlgorithm:   signer.Algorithm(),
+		HeaderContentType: ContentTypeCBORPatch,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tbs, err := sigStructure(protected, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := signer.Sign(tbs)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &sign1Message{
+		Protected:   cbor.ByteString(protected),
+		Unprotected: map[int]any{},
+		Payload:     cbor.ByteString(payload),
+		Signature:   cbor.ByteString(sig),
+	}
+	if kid := signer.KeyID(); kid != nil {
+		msg.Unprotected[HeaderKeyID] = kid
+	}
+	return encMode.Marshal(cbor.Tag{Number: TagSign1, Content: msg})
+}
+
+// VerifyAndDecodePatch verifies a COSE_Sign1 message created by SignPatch,
+// and decodes its payload as a Patch.
+func VerifyAndDecodePatch(msg []byte, verifier Verifier) (cborpatch.Patch, error) {
+	data, err := untag(msg, TagSign1)
+	if err != nil {
+		return nil, err
+	}
+
+	var m sign1Message
+	if err = decMode.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("%w, %v", ErrInvalidMessage, err)
+	}
+
+	if err = checkProtectedHeader(m.Protected.Bytes(), verifier.Algorithm(), ContentTypeCBORPatch); err != nil {
+		return nil, err
+	}
+
+	tbs, err := sigStructure(m.Protected.Bytes(), m.Payload.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	if err = verifier.Verify(tbs, m.Signature.Bytes()); err != nil {
+		return nil, err
+	}
+	return cborpatch.NewPatch(m.Payload.Bytes())
+}
+
+func sigStructure(protected, payload []byte) ([]byte, error) {
+	return encMode.Marshal([]any{
+		"Signature1",
+		cbor.ByteString(protected),
+		cbor.ByteString(""),
+		cbor.ByteString(payload),
+	})
+}
+
+// untag returns the content of a tagged CBOR data item with the given tag number.
+// Untagged messages are returned as is.
+func untag(msg []byte, num uint64) ([]byte, error) {
+	if cborpatch.ReadCBORType(msg) != cborpatch.CBORTypeTag {
+		return msg, nil
+	}
+
+	var tag cbor.RawTag
+	if err := decMode.Unmarshal(msg, &tag); err != nil {
+		return nil, fmt.Errorf("%w, %v", ErrInvalidMessage, err)
+	}
+	if tag.Number != num {
+		return nil, fmt.Errorf("%w, unexpected tag %d", ErrInvalidMessage, tag.Number)
+	}
+	return tag.Content, nil
+}
+
+func checkProtectedHeader(protected []byte, alg int, contentType string) error {
+	var header map[int]cbor.RawMessage
+	if err := decMode.Unmarshal(protected, &header); err != nil {
+		return fmt.Errorf("%w, invalid protected header, %v", ErrInvalidMessage, err)
+	}
+
+	var got int
+	if err := decMode.Unmarshal(header[HeaderAlgorithm], &got); err != nil || got != alg {
+		return fmt.Errorf("%w, unexpected algorithm in protected header", ErrInvalidMessage)
+	}
+
+	if ct, ok := header[HeaderContentType]; ok {
+		var s string
+		if err := decMode.Unmarshal(ct, &s); err != nil || s != contentType {
+			return fmt.Errorf("%w, unexpected content type in protected header", ErrInvalidMessage)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cosepatch
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	cborpatch "github.com/ldclabs/cbor-patch"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignPatch(t *testing.T) {
+	assert := assert.New(t)
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(err)
+	signer := &Ed25519Signer{Key: priv, Kid: []byte("device-key")}
+	verifier := &Ed25519Verifier{Key: pub}
+
+	patch, err := cborpatch.PatchFromJSON(`[
+		{"op": "replace", "path": "/name", "value": "Jane"},
+		{"op": "remove", "path": "/height"}
+	]`)
+	assert.NoError(err)
+
+	msg, err := SignPatch(patch, signer)
+	assert.NoError(err)
+	assert.Equal(byte(0xd2), msg[0], "should be tagged with 18")
+
+	res, err := VerifyAndDecodePatch(msg, verifier)
+	assert.NoError(err)
+	assert.Equal(patch, res)
+
+	// untagged message
+	res, err = VerifyAndDecodePatch(msg[1:], verifier)
+	assert.NoError(err)
+	assert.Equal(patch, res)
+
+	pub2, _, _ := ed25519.GenerateKey(rand.Reader)
+	_, err = VerifyAndDecodePatch(msg, &Ed25519Verifier{Key: pub2})
+	assert.ErrorContains(err, "verification failed")
+
+	tampered := append([]byte{}, msg...)
+	tampered[len(tampered)-70] ^= 0x01
+	_, err = VerifyAndDecodePatch(tampered, verifier)
+	assert.Error(err)
+
+	_, err = VerifyAndDecodePatch(cborpatch.MustMarshal([]int{1, 2}), verifier)
+	assert.True(errors.Is(err, ErrInvalidMessage))
+
+	_, err = SignPatch(cborpatch.Patch{{Op: cborpatch.OpMove}}, signer)
+	assert.Error(err)
+}
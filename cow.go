@@ -0,0 +1,117 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+// cowOwner identifies the nodes that a live document can mutate in place.
+// Nodes owned by another cowOwner are shared with snapshots and are cloned before mutation.
+type cowOwner struct {
+	_ byte // a non-zero size ensures that every cowOwner has a distinct address.
+}
+
+// COWSnapshot returns a copy-on-write snapshot of the node.
+// The snapshot shares unmodified subtrees with the node: patching the node or the snapshot
+// afterwards only copies the containers on the patched paths, and never changes the other.
+//
+// The snapshot is fully decoded, so that it is safe for concurrent reads,
+// also while the node is being patched. Taking a snapshot and patching the node
+// must not happen concurrently.
+func (n *Node) COWSnapshot() *Node {
+	n.seal()
+	return n.cowClone(&cowOwner{})
+}
+
+// Patched returns a new Node with the patch applied, and leaves the node untouched.
+// The new Node shares unmodified subtrees with the node, see COWSnapshot.
+//
+// The returned Node is fully decoded, so that it is safe to read it and to call Patched on it
+// concurrently, as long as it is not patched in place.
+func (n *Node) Patched(p Patch, options *Options) (*Node, error) {
+	node := n.COWSnapshot()
+	if err := node.Patch(p, options); err != nil {
+		return nil, err
+	}
+	node.seal()
+	return node, nil
+}
+
+// seal freezes the descendants that the node can mutate in place, so that they can be shared.
+// It does not write to a node that is sealed and not patched since.
+func (n *Node) seal() {
+	if n.owner == nil {
+		n.owner = &cowOwner{}
+	}
+	if n.freeze(n.owner) {
+		n.owner = &cowOwner{}
+	}
+}
+
+// freeze decodes the nodes that are new or owned by the owner, and marks them owned by the owner.
+// The nodes owned by other owners were frozen before.
+// It returns true if any descendant is marked.
+func (n *Node) freeze(owner *cowOwner) bool {
+	if n.which == eRaw {
+		n.intoContainer()
+	}
+
+	marked := false
+	mark := func(v *Node) {
+		if v != nil && (v.owner == nil || v.owner == owner) {
+			v.owner = owner
+			v.freeze(owner)
+			marked = true
+		}
+	}
+
+	switch n.which {
+	case eDoc:
+		for _, v := range n.doc.obj {
+			mark(v)
+		}
+	case eAry:
+		for _, v := range n.ary {
+			mark(v)
+		}
+	}
+	return marked
+}
+
+// cowClone returns a shallow copy of the node owned by the owner.
+func (n *Node) cowClone(owner *cowOwner) *Node {
+	c := &Node{ty: n.ty, which: n.which, owner: owner, codec: n.codec, dirty: n.dirty}
+	if n.raw != nil {
+		raw := *n.raw
+		c.raw = &raw
+	}
+
+	switch n.which {
+	case eDoc:
+		obj := make(map[RawKey]*Node, len(n.doc.obj))
+		for k, v := range n.doc.obj {
+			obj[k] = v
+		}
+		c.doc = &partialDoc{obj: obj}
+		if n.doc.keys != nil {
+			c.doc.keys = make([]RawKey, len(n.doc.keys))
+			copy(c.doc.keys, n.doc.keys)
+		}
+	case eAry:
+		c.ary = make(partialArray, len(n.ary))
+		copy(c.ary, n.ary)
+	}
+	return c
+}
+
+// cowChild returns the child node at the key of the container that is safe to mutate.
+// If the child is shared with a snapshot, it is replaced by a clone in the container.
+func cowChild(doc container, key RawKey, child *Node, options *Options) (*Node, error) {
+	if options.owner == nil || child.owner == nil || child.owner == options.owner {
+		return child, nil
+	}
+
+	c := child.cowClone(options.owner)
+	if err := doc.set(key, c, options); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
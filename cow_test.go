@@ -0,0 +1,147 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCOWSnapshot(t *testing.T) {
+	assert := assert.New(t)
+
+	doc := MustFromJSON(`{"a": {"b": [1, 2, {"c": 3}]}, "d": {"e": "f"}}`)
+	node := NewNode(doc)
+	s1 := node.COWSnapshot()
+
+	p, err := PatchFromJSON(`[
+		{"op": "replace", "path": "/a/b/2/c", "value": 4},
+		{"op": "add", "path": "/a/b/-", "value": 5},
+		{"op": "add", "path": "/g", "value": {"h": 1}},
+		{"op": "add", "path": "/g/i", "value": 2}
+	]`)
+	assert.NoError(err)
+	assert.NoError(node.Patch(p, nil))
+
+	assert.Equal(`{"a":{"b":[1,2,{"c":3}]},"d":{"e":"f"}}`, MustToJSON(MustMarshal(s1)))
+	assert.Equal(`{"a":{"b":[1,2,{"c":4},5]},"d":{"e":"f"},"g":{"h":1,"i":2}}`, MustToJSON(MustMarshal(node)))
+
+	key := PathMustFrom("d")[0]
+	assert.Same(s1.doc.obj[key], node.doc.obj[key])
+	key = PathMustFrom("a")[0]
+	assert.NotSame(s1.doc.obj[key], node.doc.obj[key])
+
+	s2 := node.COWSnapshot()
+	p, err = PatchFromJSON(`[
+		{"op": "move", "from": "/a/b", "path": "/d/b"},
+		{"op": "copy", "from": "/g", "path": "/a/g"},
+		{"op": "remove", "path": "/g/h"},
+		{"op": "replace", "path": "/d/b/0", "value": 0}
+	]`)
+	assert.NoError(err)
+	options := NewOptions()
+	options.EnsurePathExistsOnAdd = true
+	assert.NoError(node.Patch(p, options))
+	p, err = PatchFromJSON(`[{"op": "add", "path": "/d/b/2/x/y", "value": 1}]`)
+	assert.NoError(err)
+	assert.NoError(node.Patch(p, options))
+
+	assert.Equal(`{"a":{"b":[1,2,{"c":3}]},"d":{"e":"f"}}`, MustToJSON(MustMarshal(s1)))
+	assert.Equal(`{"a":{"b":[1,2,{"c":4},5]},"d":{"e":"f"},"g":{"h":1,"i":2}}`, MustToJSON(MustMarshal(s2)))
+	assert.Equal(`{"a":{"g":{"h":1,"i":2}},"d":{"b":[0,2,{"c":4,"x":{"y":1}},5],"e":"f"},"g":{"i":2}}`,
+		MustToJSON(MustMarshal(node)))
+
+	v, err := s2.GetValue(PathMustFromJSON("/a/b/2/c"), nil)
+	assert.NoError(err)
+	assert.Equal(MustMarshal(4), []byte(v))
+}
+
+func TestCOWSnapshotConcurrently(t *testing.T) {
+	assert := assert.New(t)
+
+	node := NewNode(MustFromJSON(`{"counter": 0, "items": [{"name": "a"}], "meta": {"v": 1}}`))
+	snapshot := node.COWSnapshot()
+	expected := MustMarshal(snapshot)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				v, err := snapshot.GetValue(PathMustFromJSON("/items/0/name"), nil)
+				assert.NoError(err)
+				assert.Equal(`"a"`, MustToJSON(v))
+				assert.Equal(expected, MustMarshal(snapshot))
+			}
+		}()
+	}
+
+	for j := 1; j <= 100; j++ {
+		p := Patch{
+			{Op: OpReplace, Path: PathMustFromJSON("/counter"), Value: MustMarshal(j)},
+			{Op: OpReplace, Path: PathMustFromJSON("/items/0/name"), Value: MustMarshal("b")},
+			{Op: OpAdd, Path: PathMustFromJSON("/meta/v"), Value: MustMarshal(j)},
+		}
+		assert.NoError(node.Patch(p, nil))
+		if j%10 == 0 {
+			node.COWSnapshot()
+		}
+	}
+	wg.Wait()
+	assert.Equal(`{"counter":100,"items":[{"name":"b"}],"meta":{"v":100}}`, MustToJSON(MustMarshal(node)))
+}
+
+func TestPatched(t *testing.T) {
+	assert := assert.New(t)
+
+	doc := MustFromJSON(`{"a": {"b": [1, 2]}, "c": {"d": "e"}}`)
+	node := NewNode(doc)
+
+	p1, err := PatchFromJSON(`[{"op": "add", "path": "/a/b/-", "value": 3}]`)
+	assert.NoError(err)
+	n1, err := node.Patched(p1, nil)
+	assert.NoError(err)
+
+	p2, err := PatchFromJSON(`[{"op": "remove", "path": "/a/b/0"}, {"op": "add", "path": "/c/x", "value": 1}]`)
+	assert.NoError(err)
+	n2, err := n1.Patched(p2, nil)
+	assert.NoError(err)
+
+	_, err = n1.Patched(Patch{{Op: OpRemove, Path: PathMustFromJSON("/x")}}, nil)
+	assert.ErrorContains(err, "unable to remove nonexistent key")
+
+	assert.Equal(`{"a":{"b":[1,2]},"c":{"d":"e"}}`, MustToJSON(MustMarshal(node)))
+	assert.Equal(`{"a":{"b":[1,2,3]},"c":{"d":"e"}}`, MustToJSON(MustMarshal(n1)))
+	assert.Equal(`{"a":{"b":[2,3]},"c":{"d":"e","x":1}}`, MustToJSON(MustMarshal(n2)))
+
+	key := PathMustFrom("c")[0]
+	assert.Same(node.doc.obj[key], n1.doc.obj[key])
+
+	assert.NoError(n1.Patch(p2, nil))
+	assert.Equal(MustMarshal(n2), MustMarshal(n1))
+	assert.NoError(node.Patch(p1, nil))
+	assert.Equal(`{"a":{"b":[1,2,3]},"c":{"d":"e"}}`, MustToJSON(MustMarshal(node)))
+	assert.Equal(`{"a":{"b":[2,3]},"c":{"d":"e","x":1}}`, MustToJSON(MustMarshal(n2)))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				res, err := n2.Patched(p1, nil)
+				assert.NoError(err)
+				assert.Equal(`{"a":{"b":[2,3,3]},"c":{"d":"e","x":1}}`, MustToJSON(MustMarshal(res)))
+
+				v, err := n2.GetValue(PathMustFromJSON("/a/b/0"), nil)
+				assert.NoError(err)
+				assert.Equal(MustMarshal(2), []byte(v))
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,336 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// CRDTOp is an operation type of the commutative patch mode.
+// All CRDT operations are commutative and idempotent, so replicas that apply the same
+// set of operations in any order, any number of times, converge to the same document.
+type CRDTOp int
+
+const (
+	// CRDTReserved is the zero value of CRDTOp.
+	CRDTReserved CRDTOp = iota
+	// CRDTSet sets the value at the path as a last-writer-wins register,
+	// ordered by (Timestamp, Replica, Value). A CBOR null value removes the path.
+	CRDTSet
+	// CRDTSetAdd adds the value to a grow-only set at the path,
+	// the set is rendered as an array sorted by the encoded elements.
+	CRDTSetAdd
+	// CRDTCounter carries the accumulated increments and decrements of a replica
+	// for a counter at the path, the counter is rendered as an integer.
+	CRDTCounter
+)
+
+// String returns a string representation of the CRDTOp.
+func (op CRDTOp) String() string {
+	switch op {
+	case CRDTSet:
+		return "set"
+	case CRDTSetAdd:
+		return "set-add"
+	case CRDTCounter:
+		return "counter"
+	default:
+		return fmt.Sprintf("reserved(%d)", op)
+	}
+}
+
+// CRDTOperation is a single operation of the commutative patch mode.
+type CRDTOperation struct {
+	Op        CRDTOp     `cbor:"1,keyasint"`
+	Path      Path       `cbor:"3,keyasint"`
+	Value     RawMessage `cbor:"4,keyasint,omitempty"`
+	Timestamp uint64     `cbor:"5,keyasint,omitempty"`
+	Replica   string     `cbor:"6,keyasint,omitempty"`
+	Inc       uint64     `cbor:"7,keyasint,omitempty"`
+	Dec       uint64     `cbor:"8,keyasint,omitempty"`
+}
+
+// Valid checks the operation.
+func (o *CRDTOperation) Valid() error {
+	if o == nil {
+		return errors.New("nil CRDT operation")
+	}
+	if len(o.Path) == 0 {
+		return fmt.Errorf("%q operation requires a non-empty path", o.Op)
+	}
+
+	switch o.Op {
+	default:
+		return fmt.Errorf("invalid CRDT operation %q", o.Op)
+
+	case CRDTSet:
+		if o.Value == nil {
+			return errors.New(`"value" must be non-nil for "set" operation`)
+		}
+
+	case CRDTSetAdd:
+		if o.Value == nil {
+			return errors.New(`"value" must be non-nil for "set-add" operation`)
+		}
+
+	case CRDTCounter:
+		if o.Replica == "" {
+			return errors.New(`"replica" must be non-empty for "counter" operation`)
+		}
+		if o.Value != nil {
+			return errors.New(`"value" must be nil for "counter" operation`)
+		}
+	}
+
+	if o.Value != nil {
+		return cborValid(o.Value)
+	}
+	return nil
+}
+
+// CRDTDoc is a document replicated with the commutative patch mode.
+// It keeps the CRDT state on top of a base document that all replicas share,
+// and renders the document on demand. It is safe for concurrent use.
+type CRDTDoc struct {
+	mu       sync.RWMutex
+	base     []byte
+	paths    map[string]Path
+	regs     map[string]*CRDTOperation
+	sets     map[string]map[string]RawMessage
+	counters map[string]map[string]*CRDTOperation
+}
+
+// NewCRDTDoc returns a CRDTDoc with the given base document.
+func NewCRDTDoc(base []byte) *CRDTDoc {
+	return &CRDTDoc{
+		base:     copyBytes(base),
+		paths:    make(map[string]Path),
+		regs:     make(map[string]*CRDTOperation),
+		sets:     make(map[string]map[string]RawMessage),
+		counters: make(map[string]map[string]*CRDTOperation),
+	}
+}
+
+// Apply applies the operations to the CRDT state.
+// It returns an error without changing the state if any operation is invalid.
+func (d *CRDTDoc) Apply(ops ...*CRDTOperation) error {
+	keys := make([]string, len(ops))
+	for i, op := range ops {
+		if err := op.Valid(); err != nil {
+			return err
+		}
+		data, err := cborMarshal(op.Path)
+		if err != nil {
+			return err
+		}
+		keys[i] = string(data)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for i, op := range ops {
+		d.apply(keys[i], op)
+	}
+	return nil
+}
+
+// apply applies the valid operation at the encoded path key, d.mu must be locked.
+func (d *CRDTDoc) apply(key string, op *CRDTOperation) {
+	d.paths[key] = op.Path
+
+	switch op.Op {
+	case CRDTSet:
+		if cur, ok := d.regs[key]; !ok || lwwLess(cur, op) {
+			d.regs[key] = op
+		}
+
+	case CRDTSetAdd:
+		set, ok := d.sets[key]
+		if !ok {
+			set = make(map[string]RawMessage)
+			d.sets[key] = set
+		}
+		set[string(op.Value)] = op.Value
+
+	case CRDTCounter:
+		cs, ok := d.counters[key]
+		if !ok {
+			cs = make(map[string]*CRDTOperation)
+			d.counters[key] = cs
+		}
+		cur, ok := cs[op.Replica]
+		if !ok {
+			cur = &CRDTOperation{Op: CRDTCounter, Path: op.Path, Replica: op.Replica}
+			cs[op.Replica] = cur
+		}
+		if op.Inc > cur.Inc {
+			cur.Inc = op.Inc
+		}
+		if op.Dec > cur.Dec {
+			cur.Dec = op.Dec
+		}
+	}
+}
+
+// Increment returns a counter operation that adds delta to the counter at the path for the replica,
+// and applies it to the CRDT state. The operation should be sent to other replicas.
+func (d *CRDTDoc) Increment(replica string, path Path, delta int64) (*CRDTOperation, error) {
+	data, err := cborMarshal(path)
+	if err != nil {
+		return nil, err
+	}
+
+	op := &CRDTOperation{Op: CRDTCounter, Path: path, Replica: replica}
+	if err = op.Valid(); err != nil {
+		return nil, err
+	}
+
+	// read, add and store the counter at once, concurrent increments of the replica are not lost.
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if cur, ok := d.counters[string(data)][replica]; ok {
+		op.Inc, op.Dec = cur.Inc, cur.Dec
+	}
+	if delta >= 0 {
+		op.Inc += uint64(delta)
+	} else {
+		op.Dec += uint64(-delta)
+	}
+	d.apply(string(data), op)
+	return op, nil
+}
+
+// Operations returns the CRDT state as operations, ordered by path.
+// Applying them to another CRDTDoc merges the state into it.
+func (d *CRDTDoc) Operations() []*CRDTOperation {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	ops := make([]*CRDTOperation, 0, len(d.paths))
+	for _, key := range d.sortedKeys() {
+		if op, ok := d.regs[key]; ok {
+			ops = append(ops, op)
+		}
+		for _, v := range sortedValues(d.sets[key]) {
+			ops = append(ops, &CRDTOperation{Op: CRDTSetAdd, Path: d.paths[key], Value: v})
+		}
+
+		replicas := make([]string, 0, len(d.counters[key]))
+		for r := range d.counters[key] {
+			replicas = append(replicas, r)
+		}
+		sort.Strings(replicas)
+		for _, r := range replicas {
+			c := *d.counters[key][r]
+			ops = append(ops, &c)
+		}
+	}
+	return ops
+}
+
+// Merge merges the CRDT state of other into d.
+func (d *CRDTDoc) Merge(other *CRDTDoc) error {
+	if other == d {
+		return nil
+	}
+	return d.Apply(other.Operations()...)
+}
+
+// Patch returns a patch that renders the CRDT state on the base document.
+// Shorter paths are rendered first, so values in nested paths are applied into the parent values.
+func (d *CRDTDoc) Patch() (Patch, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	p := make(Patch, 0, len(d.paths))
+	for _, key := range d.sortedKeys() {
+		path := d.paths[key]
+		if op, ok := d.regs[key]; ok {
+			if isNull(op.Value) {
+				p = append(p, &Operation{Op: OpRemove, Path: path})
+			} else {
+				p = append(p, &Operation{Op: OpAdd, Path: path, Value: op.Value})
+			}
+		}
+
+		if set, ok := d.sets[key]; ok {
+			vals := sortedValues(set)
+			ary := make([]RawMessage, len(vals))
+			copy(ary, vals)
+			data, err := cborMarshal(ary)
+			if err != nil {
+				return nil, err
+			}
+			p = append(p, &Operation{Op: OpAdd, Path: path, Value: data})
+		}
+
+		if cs, ok := d.counters[key]; ok {
+			var sum int64
+			for _, c := range cs {
+				sum += int64(c.Inc) - int64(c.Dec)
+			}
+			data, err := cborMarshal(sum)
+			if err != nil {
+				return nil, err
+			}
+			p = append(p, &Operation{Op: OpAdd, Path: path, Value: data})
+		}
+	}
+	return p, nil
+}
+
+// Document renders the CRDT state on the base document, and returns the document.
+func (d *CRDTDoc) Document() ([]byte, error) {
+	p, err := d.Patch()
+	if err != nil {
+		return nil, err
+	}
+
+	options := NewOptions()
+	options.EnsurePathExistsOnAdd = true
+	options.AllowMissingPathOnRemove = true
+	return p.ApplyWithOptions(d.base, options)
+}
+
+// sortedKeys returns the encoded paths in bytewise order.
+// The length of a path is encoded in the first byte, so shorter paths come first.
+func (d *CRDTDoc) sortedKeys() []string {
+	keys := make([]string, 0, len(d.paths))
+	for k := range d.paths {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedValues(set map[string]RawMessage) []RawMessage {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	vals := make([]RawMessage, len(keys))
+	for i, k := range keys {
+		vals[i] = set[k]
+	}
+	return vals
+}
+
+func lwwLess(a, b *CRDTOperation) bool {
+	switch {
+	case a.Timestamp != b.Timestamp:
+		return a.Timestamp < b.Timestamp
+	case a.Replica != b.Replica:
+		return a.Replica < b.Replica
+	default:
+		return bytes.Compare(a.Value, b.Value) < 0
+	}
+}
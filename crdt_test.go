@@ -0,0 +1,106 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCRDTDoc(t *testing.T) {
+	assert := assert.New(t)
+
+	base := MustFromJSON(`{"name": "doc", "tags": ["base"], "meta": {"owner": "a"}}`)
+	ops := []*CRDTOperation{
+		{Op: CRDTSet, Path: PathMustFrom("name"), Value: MustMarshal("x"), Timestamp: 1, Replica: "a"},
+		{Op: CRDTSet, Path: PathMustFrom("name"), Value: MustMarshal("y"), Timestamp: 2, Replica: "b"},
+		{Op: CRDTSet, Path: PathMustFrom("name"), Value: MustMarshal("z"), Timestamp: 2, Replica: "a"},
+		{Op: CRDTSet, Path: PathMustFrom("meta", "owner"), Value: MustMarshal(nil), Timestamp: 3, Replica: "a"},
+		{Op: CRDTSet, Path: PathMustFrom("meta", "size"), Value: MustMarshal(42), Timestamp: 1, Replica: "c"},
+		{Op: CRDTSetAdd, Path: PathMustFrom("tags"), Value: MustMarshal("red")},
+		{Op: CRDTSetAdd, Path: PathMustFrom("tags"), Value: MustMarshal("blue")},
+		{Op: CRDTSetAdd, Path: PathMustFrom("tags"), Value: MustMarshal("red")},
+		{Op: CRDTCounter, Path: PathMustFrom("stats", "views"), Replica: "a", Inc: 3},
+		{Op: CRDTCounter, Path: PathMustFrom("stats", "views"), Replica: "a", Inc: 5, Dec: 1},
+		{Op: CRDTCounter, Path: PathMustFrom("stats", "views"), Replica: "b", Inc: 2},
+	}
+	expected := `{"meta":{"size":42},"name":"y","stats":{"views":6},"tags":["red","blue"]}`
+
+	for i := 0; i < 10; i++ {
+		shuffled := append([]*CRDTOperation{}, ops...)
+		rand.Shuffle(len(shuffled), func(i, j int) {
+			shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+		})
+
+		d := NewCRDTDoc(base)
+		for _, op := range shuffled {
+			assert.NoError(d.Apply(op))
+		}
+		// idempotent
+		assert.NoError(d.Apply(shuffled[:5]...))
+
+		doc, err := d.Document()
+		assert.NoError(err)
+		assert.Equal(expected, MustToJSON(doc))
+	}
+
+	a := NewCRDTDoc(base)
+	b := NewCRDTDoc(base)
+	assert.NoError(a.Apply(ops[:6]...))
+	assert.NoError(b.Apply(ops[6:]...))
+	assert.NoError(a.Merge(b))
+	assert.NoError(b.Merge(a))
+	assert.NoError(a.Merge(a))
+
+	da, err := a.Document()
+	assert.NoError(err)
+	db, err := b.Document()
+	assert.NoError(err)
+	assert.Equal(expected, MustToJSON(da))
+	assert.Equal(da, db)
+
+	op, err := a.Increment("b", PathMustFrom("stats", "views"), -2)
+	assert.NoError(err)
+	assert.Equal(uint64(2), op.Inc)
+	assert.Equal(uint64(2), op.Dec)
+	assert.NoError(b.Apply(op))
+	da, _ = a.Document()
+	db, _ = b.Document()
+	assert.Equal(da, db)
+	assert.Equal(`{"meta":{"size":42},"name":"y","stats":{"views":4},"tags":["red","blue"]}`, MustToJSON(da))
+
+	assert.Error(a.Apply(&CRDTOperation{Op: CRDTSet, Path: PathMustFrom("x")}))
+	assert.Error(a.Apply(&CRDTOperation{Op: CRDTCounter, Path: PathMustFrom("x")}))
+	assert.Error(a.Apply(&CRDTOperation{Op: CRDTSetAdd, Path: Path{}, Value: MustMarshal(1)}))
+	assert.Error(a.Apply(&CRDTOperation{Op: CRDTReserved, Path: PathMustFrom("x")}))
+}
+
+func TestCRDTDocConcurrentIncrement(t *testing.T) {
+	assert := assert.New(t)
+
+	d := NewCRDTDoc(MustFromJSON(`{}`))
+	path := PathMustFrom("views")
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_, err := d.Increment("a", path, 1)
+				assert.NoError(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	doc, err := d.Document()
+	assert.NoError(err)
+	assert.Equal(`{"views":800}`, MustToJSON(doc))
+
+	_, err = d.Increment("", path, 1)
+	assert.Error(err)
+}
@@ -0,0 +1,218 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"unicode/utf8"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// Profile is a set of constraints that CBOR documents must conform to.
+type Profile interface {
+	// Valid returns an error if the CBOR document does not conform to the profile.
+	Valid(doc []byte) error
+}
+
+// DAGCBOR is the Profile of dag-cbor, the IPLD codec.
+// Refer to https://ipld.io/specs/codecs/dag-cbor/spec/.
+//
+// A dag-cbor document:
+//
+//	has definite lengths only,
+//	has integers and lengths encoded in the shortest form,
+//	has floats encoded in 64 bits, NaN and Infinity are forbidden,
+//	has no tags except tag 42 for CIDs,
+//	has no simple values except false, true and null,
+//	has maps with text string keys only, sorted length-first without duplicates.
+var DAGCBOR Profile = dagCBORProfile{}
+
+// DAGCBORTag is the only tag allowed by dag-cbor, a CID in the binary format with a 0x00 prefix.
+const DAGCBORTag uint64 = 42
+
+var dagCBOREncMode, _ = cbor.EncOptions{
+	Sort:          cbor.SortLengthFirst,
+	IndefLength:   cbor.IndefLengthForbidden,
+	ShortestFloat: cbor.ShortestFloatNone,
+	NaNConvert:    cbor.NaNConvertNone,
+	InfConvert:    cbor.InfConvertNone,
+}.EncMode()
+
+// MarshalDAGCBOR returns the dag-cbor encoding of v.
+func MarshalDAGCBOR(v any) ([]byte, error) {
+	data, err := dagCBOREncMode.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if err = DAGCBOR.Valid(data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+type dagCBORProfile struct{}
+
+// Valid implements the Profile interface.
+func (dagCBORProfile) Valid(doc []byte) error {
+	rest, err := validDAGCBOR(doc, 0)
+	if err == nil && len(rest) > 0 {
+		err = errors.New("unexpected trailing data")
+	}
+	if err != nil {
+		return fmt.Errorf("invalid dag-cbor document, %w", err)
+	}
+	return nil
+}
+
+// dagCBORMaxDepth limits the nesting depth of dag-cbor documents to validate.
+const dagCBORMaxDepth = 1024
+
+func validDAGCBOR(data []byte, depth int) ([]byte, error) {
+	if depth > dagCBORMaxDepth {
+		return nil, errors.New("exceeded max nesting depth")
+	}
+
+	ty, ai, arg, rest, err := readDAGCBORHead(data)
+	if err != nil {
+		return nil, err
+	}
+
+	switch ty {
+	case CBORTypePositiveInt:
+		return rest, nil
+
+	case CBORTypeNegativeInt:
+		if arg > math.MaxInt64 {
+			return nil, errors.New("negative integer overflows int64")
+		}
+		return rest, nil
+
+	case CBORTypeByteString, CBORTypeTextString:
+		if uint64(len(rest)) < arg {
+			return nil, errors.New("unexpected EOF")
+		}
+		if ty == CBORTypeTextString && !utf8.Valid(rest[:arg]) {
+			return nil, errors.New("invalid UTF-8 text string")
+		}
+		return rest[arg:], nil
+
+	case CBORTypeArray:
+		for i := uint64(0); i < arg; i++ {
+			if rest, err = validDAGCBOR(rest, depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return rest, nil
+
+	case CBORTypeMap:
+		var prev []byte
+		for i := uint64(0); i < arg; i++ {
+			if ReadCBORType(rest) != CBORTypeTextString {
+				return nil, errors.New("map key must be a text string")
+			}
+
+			key := rest
+			if rest, err = validDAGCBOR(rest, depth+1); err != nil {
+				return nil, err
+			}
+			key = key[:len(key)-len(rest)]
+			if prev != nil {
+				switch {
+				case len(prev) > len(key), len(prev) == len(key) && bytes.Compare(prev, key) > 0:
+					return nil, fmt.Errorf("map key %s is not sorted", Diagify(key))
+				case bytes.Equal(prev, key):
+					return nil, fmt.Errorf("duplicate map key %s", Diagify(key))
+				}
+			}
+			prev = key
+
+			if rest, err = validDAGCBOR(rest, depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return rest, nil
+
+	case CBORTypeTag:
+		if arg != DAGCBORTag {
+			return nil, fmt.Errorf("unsupported tag %d", arg)
+		}
+		if ReadCBORType(rest) != CBORTypeByteString {
+			return nil, errors.New("tag 42 content must be a byte string")
+		}
+
+		content := rest
+		if rest, err = validDAGCBOR(rest, depth+1); err != nil {
+			return nil, err
+		}
+		_, _, n, cid, _ := readDAGCBORHead(content[:len(content)-len(rest)])
+		if n == 0 || cid[0] != 0x00 {
+			return nil, errors.New("tag 42 content must be a CID with 0x00 prefix")
+		}
+		return rest, nil
+
+	default: // CBORTypePrimitives
+		switch ai {
+		case 20, 21, 22: // false, true, null
+			return rest, nil
+		case 27:
+			f := math.Float64frombits(arg)
+			if math.IsNaN(f) || math.IsInf(f, 0) {
+				return nil, errors.New("NaN and Infinity are not allowed")
+			}
+			return rest, nil
+		case 25, 26:
+			return nil, errors.New("float must be encoded in 64 bits")
+		default:
+			return nil, fmt.Errorf("unsupported simple value %d", arg)
+		}
+	}
+}
+
+// readDAGCBORHead reads the head of a CBOR data item, it returns an error
+// if the head is not in the shortest form or has an indefinite length.
+func readDAGCBORHead(data []byte) (ty CBORType, ai byte, arg uint64, rest []byte, err error) {
+	if len(data) == 0 {
+		return 0, 0, 0, nil, errors.New("unexpected EOF")
+	}
+
+	ty, ai = ReadCBORType(data), data[0]&0x1f
+	data = data[1:]
+	switch {
+	case ai < 24:
+		return ty, ai, uint64(ai), data, nil
+	case ai > 27:
+		return 0, 0, 0, nil, fmt.Errorf("unsupported additional information %d", ai)
+	}
+
+	size := 1 << (ai - 24)
+	if len(data) < size {
+		return 0, 0, 0, nil, errors.New("unexpected EOF")
+	}
+
+	switch size {
+	case 1:
+		arg = uint64(data[0])
+	case 2:
+		arg = uint64(binary.BigEndian.Uint16(data))
+	case 4:
+		arg = uint64(binary.BigEndian.Uint32(data))
+	default:
+		arg = binary.BigEndian.Uint64(data)
+	}
+
+	if ty != CBORTypePrimitives {
+		min := [4]uint64{24, 1 << 8, 1 << 16, 1 << 32}[ai-24]
+		if arg < min {
+			return 0, 0, 0, nil, fmt.Errorf("%s is not encoded in the shortest form", ty)
+		}
+	} else if ai == 24 {
+		return 0, 0, 0, nil, fmt.Errorf("unsupported simple value %d", arg)
+	}
+	return ty, ai, arg, data[size:], nil
+}
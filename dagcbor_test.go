@@ -0,0 +1,76 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"math"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDAGCBOR(t *testing.T) {
+	assert := assert.New(t)
+
+	cid := cbor.Tag{Number: DAGCBORTag, Content: []byte{0x00, 0x01, 0x71, 0x12}}
+	data, err := MarshalDAGCBOR(map[string]any{
+		"bb":  []any{1, -1, "x", []byte{1}, true, false, nil, 1.5},
+		"a":   map[string]any{},
+		"ccc": cid,
+	})
+	assert.NoError(err)
+	assert.Equal(`{"a": {}, "bb": [1, -1, "x", h'01', true, false, null, 1.5], "ccc": 42(h'00017112')}`, Diagify(data))
+	assert.NoError(DAGCBOR.Valid(data))
+
+	_, err = MarshalDAGCBOR(math.NaN())
+	assert.Error(err)
+	_, err = MarshalDAGCBOR(cbor.Tag{Number: 1, Content: 1})
+	assert.ErrorContains(err, "unsupported tag 1")
+
+	for _, tc := range []struct {
+		name string
+		doc  []byte
+		err  string
+	}{
+		{"indefinite length", []byte{0x9f, 0x01, 0xff}, "unsupported additional information 31"},
+		{"non-shortest int", []byte{0x18, 0x01}, "positive integer is not encoded in the shortest form"},
+		{"non-shortest length", []byte{0x79, 0x00, 0x01, 0x61}, "not encoded in the shortest form"},
+		{"float16", []byte{0xf9, 0x3e, 0x00}, "float must be encoded in 64 bits"},
+		{"float32", []byte{0xfa, 0x3f, 0xc0, 0x00, 0x00}, "float must be encoded in 64 bits"},
+		{"infinity", []byte{0xfb, 0x7f, 0xf0, 0, 0, 0, 0, 0, 0}, "NaN and Infinity are not allowed"},
+		{"undefined", []byte{0xf7}, "unsupported simple value 23"},
+		{"simple value", []byte{0xf8, 0x20}, "unsupported simple value 32"},
+		{"int key", []byte{0xa1, 0x01, 0x01}, "map key must be a text string"},
+		{"unsorted keys", []byte{0xa2, 0x62, 0x61, 0x61, 0x01, 0x61, 0x62, 0x01}, `map key "b" is not sorted`},
+		{"duplicate keys", []byte{0xa2, 0x61, 0x61, 0x01, 0x61, 0x61, 0x01}, `duplicate map key "a"`},
+		{"other tag", MustMarshal(cbor.Tag{Number: 2, Content: []byte{1}}), "unsupported tag 2"},
+		{"invalid cid", MustMarshal(cbor.Tag{Number: 42, Content: []byte{1}}), "0x00 prefix"},
+		{"cid text", MustMarshal(cbor.Tag{Number: 42, Content: "x"}), "must be a byte string"},
+		{"trailing data", []byte{0x01, 0x01}, "unexpected trailing data"},
+		{"EOF", []byte{0x62, 0x61}, "unexpected EOF"},
+		{"big negative", []byte{0x3b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, "overflows int64"},
+	} {
+		assert.ErrorContains(DAGCBOR.Valid(tc.doc), tc.err, tc.name)
+	}
+
+	options := NewOptions()
+	options.Profile = DAGCBOR
+
+	p, err := PatchFromJSON(`[{"op": "add", "path": "/bb/-", "value": 2}]`)
+	assert.NoError(err)
+	res, err := p.ApplyWithOptions(data, options)
+	assert.NoError(err)
+	assert.NoError(DAGCBOR.Valid(res))
+
+	p = Patch{{Op: OpAdd, Path: PathMustFrom(1), Value: MustMarshal(1)}}
+	_, err = p.ApplyWithOptions(data, options)
+	assert.ErrorContains(err, "map key must be a text string")
+	_, err = p.ApplyWithOptions(data, nil)
+	assert.NoError(err)
+
+	p = Patch{{Op: OpReplace, Path: PathMustFrom("a"), Value: []byte{0xf9, 0x3e, 0x00}}}
+	_, err = p.ApplyWithOptions(data, options)
+	assert.ErrorContains(err, "invalid dag-cbor document, float must be encoded in 64 bits")
+}
@@ -0,0 +1,702 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// DiagBytesFormat specifies how byte strings are rendered in CBOR diagnostic notation.
+type DiagBytesFormat int
+
+const (
+	// DiagBytesHex renders byte strings in base16, such as h'0102'.
+	DiagBytesHex DiagBytesFormat = iota
+	// DiagBytesBase64 renders byte strings in base64url, such as b64'AQI'.
+	DiagBytesBase64
+	// DiagBytesEmbeddedCBOR renders byte strings that are valid CBOR as embedded CBOR, such as <<1, 2>>,
+	// the others are rendered in base16.
+	DiagBytesEmbeddedCBOR
+)
+
+// DiagOptions specifies the options of rendering CBOR diagnostic notation, see DiagifyWithOptions.
+// The zero DiagOptions renders the same as Diagify.
+type DiagOptions struct {
+	// Prefix and Indent render maps and arrays in multiple lines like json.Indent, each element
+	// begins on a new line beginning with Prefix followed by one or more copies of Indent
+	// according to the nesting. Empty maps and arrays, and the contents of embedded CBOR
+	// are rendered in a single line.
+	// Default to empty, the notation is rendered in a single line.
+	Prefix string
+	Indent string
+	// MaxStringLength truncates the contents of text strings and byte strings that are longer than it
+	// in the notation, the characters of an escape sequence are counted as one, such as "\n",
+	// and "..." is appended to the truncated strings, such as "abc"... for "abcdef" with 3.
+	// Default to 0, strings are not truncated.
+	MaxStringLength int
+	// BytesFormat specifies how byte strings are rendered.
+	// Default to DiagBytesHex.
+	BytesFormat DiagBytesFormat
+}
+
+// DiagifyWithOptions is like Diagify, but renders the doc with the DiagOptions.
+func DiagifyWithOptions(doc []byte, opts *DiagOptions) string {
+	if opts == nil {
+		return Diagify(doc)
+	}
+
+	do := &cbor.DiagOptions{ByteStringEncoding: "base16"}
+	switch opts.BytesFormat {
+	case DiagBytesBase64:
+		do.ByteStringEncoding = "base64"
+	case DiagBytesEmbeddedCBOR:
+		do.ByteStringEmbeddedCBOR = true
+	}
+
+	s := fmt.Sprintf("h'%x'", doc)
+	if data, err := cbor.Diag(doc, do); err == nil {
+		s = string(data)
+	}
+	if opts.Prefix == "" && opts.Indent == "" && opts.MaxStringLength <= 0 {
+		return s
+	}
+	return formatDiag(s, opts)
+}
+
+// DiagifyIndent is like Diagify, but renders maps and arrays in multiple lines with the prefix and indent,
+// see DiagOptions.
+func DiagifyIndent(doc []byte, prefix, indent string) string {
+	return DiagifyWithOptions(doc, &DiagOptions{Prefix: prefix, Indent: indent})
+}
+
+// formatDiag indents and truncates the single line diagnostic notation s rendered by cbor.Diag.
+func formatDiag(s string, opts *DiagOptions) string {
+	multiline := opts.Prefix != "" || opts.Indent != ""
+	buf := &strings.Builder{}
+	level, embedded := 0, 0
+	// stack records whether the open brackets are rendered in multiple lines.
+	stack := make([]bool, 0, 8)
+	newline := func() {
+		buf.WriteByte('\n')
+		buf.WriteString(opts.Prefix)
+		for i := 0; i < level; i++ {
+			buf.WriteString(opts.Indent)
+		}
+	}
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\'':
+			end := diagStringEnd(s, i)
+			buf.WriteString(truncateDiagString(s[i:end], opts.MaxStringLength))
+			i = end - 1
+
+		case !multiline:
+			buf.WriteByte(c)
+
+		case c == '[' || c == '{':
+			buf.WriteByte(c)
+			if strings.HasPrefix(s[i+1:], "_ ") {
+				buf.WriteByte('_')
+				i += 2
+			}
+			ml := embedded == 0 && i+1 < len(s) && s[i+1] != ']' && s[i+1] != '}'
+			stack = append(stack, ml)
+			if ml {
+				level++
+				newline()
+			}
+
+		case c == '(':
+			buf.WriteByte(c)
+			stack = append(stack, false)
+
+		case c == '<' && strings.HasPrefix(s[i:], "<<"):
+			buf.WriteString("<<")
+			i++
+			embedded++
+			stack = append(stack, false)
+
+		case c == ']' || c == '}' || c == ')' || c == '>' && strings.HasPrefix(s[i:], ">>"):
+			if len(stack) > 0 {
+				if stack[len(stack)-1] {
+					level--
+					newline()
+				}
+				stack = stack[:len(stack)-1]
+			}
+			if c == '>' {
+				buf.WriteString(">>")
+				i++
+				embedded--
+			} else {
+				buf.WriteByte(c)
+			}
+
+		case c == ',' && len(stack) > 0 && stack[len(stack)-1]:
+			buf.WriteByte(',')
+			newline()
+			if i+1 < len(s) && s[i+1] == ' ' {
+				i++
+			}
+
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	return buf.String()
+}
+
+// diagStringEnd returns the end of the quoted string that begins at i in s.
+func diagStringEnd(s string, i int) int {
+	q := s[i]
+	for j := i + 1; j < len(s); j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case q:
+			return j + 1
+		}
+	}
+	return len(s)
+}
+
+// truncateDiagString truncates the contents of the quoted string lit to max characters.
+func truncateDiagString(lit string, max int) string {
+	if max <= 0 || len(lit) < 2 {
+		return lit
+	}
+
+	content := lit[1 : len(lit)-1]
+	for i, n := 0, 0; i < len(content); n++ {
+		if n == max {
+			return lit[:i+1] + lit[len(lit)-1:] + "..."
+		}
+		switch {
+		case content[i] == '\\' && strings.HasPrefix(content[i:], "\\u"):
+			i += 6
+		case content[i] == '\\':
+			i += 2
+		default:
+			_, size := utf8.DecodeRuneInString(content[i:])
+			i += size
+		}
+	}
+	return lit
+}
+
+// FromDiag parses the CBOR diagnostic notation of RFC 8949 section 8 and the extended notation of
+// RFC 8610 appendix G into a CBOR document, it is the reverse of Diagify, such as:
+//
+//	{"name": "John", "key": h'0102', "tags": 23(["a", "b"]), "ext": <<1, 2>>}
+//
+// It supports integers in decimal, hexadecimal, octal and binary, bignums, floats including
+// NaN and Infinity, text strings with JSON escapes, byte strings such as h'0102', b64'AQI',
+// b32'AEBA', h32'0410' or single-quoted text, indefinite-length arrays, maps and strings such as
+// [_ 1] and (_ "a", "b"), tags, simple values, embedded CBOR and the encoding indicators _0 to _3
+// of integers and floats.
+// The comments delimited by slashes, such as / comment /, are ignored.
+// Integers and floats without encoding indicators are encoded by the encoding mode set by SetCBOR.
+func FromDiag(s string) ([]byte, error) {
+	p := &diagParser{s: s}
+	data, err := p.item(nil)
+	if err != nil {
+		return nil, err
+	}
+	if p.space(); p.i < len(p.s) {
+		return nil, p.errorf("unexpected %q", p.s[p.i])
+	}
+	return data, nil
+}
+
+// diagParser parses CBOR diagnostic notation, i is the offset of the next character in s.
+type diagParser struct {
+	s string
+	i int
+}
+
+func (p *diagParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("invalid diagnostic notation at offset %d, %s, %w", p.i, fmt.Sprintf(format, args...), ErrInvalid)
+}
+
+// space skips the white spaces and the comments.
+func (p *diagParser) space() {
+	for p.i < len(p.s) {
+		switch p.s[p.i] {
+		case ' ', '\t', '\n', '\r':
+			p.i++
+		case '/':
+			end := strings.IndexByte(p.s[p.i+1:], '/')
+			if end < 0 {
+				return
+			}
+			p.i += end + 2
+		default:
+			return
+		}
+	}
+}
+
+// consume skips the white spaces and the token if it is next.
+func (p *diagParser) consume(token string) bool {
+	p.space()
+	if strings.HasPrefix(p.s[p.i:], token) {
+		p.i += len(token)
+		return true
+	}
+	return false
+}
+
+func (p *diagParser) expect(token string) error {
+	if !p.consume(token) {
+		if p.i < len(p.s) {
+			return p.errorf("expected %q, got %q", token, p.s[p.i])
+		}
+		return p.errorf("expected %q, got end of input", token)
+	}
+	return nil
+}
+
+// item appends the encoding of the next data item to dst.
+func (p *diagParser) item(dst []byte) ([]byte, error) {
+	p.space()
+	if p.i >= len(p.s) {
+		return nil, p.errorf("unexpected end of input")
+	}
+
+	rest := p.s[p.i:]
+	switch c := rest[0]; {
+	case c == '[':
+		p.i++
+		return p.array(dst)
+	case c == '{':
+		p.i++
+		return p.mapping(dst)
+	case c == '"' || c == '\'':
+		return p.quoted(dst)
+	case strings.HasPrefix(rest, "<<"):
+		p.i += 2
+		return p.embedded(dst)
+	case strings.HasPrefix(rest, "(_"):
+		p.i += 2
+		return p.chunks(dst)
+	case strings.HasPrefix(rest, "h'"), strings.HasPrefix(rest, "b64'"),
+		strings.HasPrefix(rest, "b32'"), strings.HasPrefix(rest, "h32'"):
+		return p.encodedBytes(dst)
+	case c == '-' || c == '+' || c >= '0' && c <= '9':
+		return p.number(dst)
+	}
+
+	for _, w := range []struct {
+		word string
+		data byte
+	}{{"false", 0xf4}, {"true", 0xf5}, {"null", 0xf6}, {"undefined", 0xf7}} {
+		if strings.HasPrefix(rest, w.word) {
+			p.i += len(w.word)
+			return append(dst, w.data), nil
+		}
+	}
+	if strings.HasPrefix(rest, "simple(") {
+		p.i += len("simple(")
+		p.space()
+		start := p.i
+		for p.i < len(p.s) && p.s[p.i] >= '0' && p.s[p.i] <= '9' {
+			p.i++
+		}
+		v, err := strconv.ParseUint(p.s[start:p.i], 10, 8)
+		if err != nil {
+			return nil, p.errorf("invalid simple value %q", p.s[start:p.i])
+		}
+		data, err := NewSimpleValue(SimpleValue(v))
+		if err != nil {
+			return nil, p.errorf("reserved simple value %d", v)
+		}
+		if err = p.expect(")"); err != nil {
+			return nil, err
+		}
+		return append(dst, data...), nil
+	}
+	if strings.HasPrefix(rest, "NaN") || strings.HasPrefix(rest, "Infinity") {
+		return p.number(dst)
+	}
+	return nil, p.errorf("unexpected %q", rest[0])
+}
+
+// items parses the items separated by commas until the closing token,
+// and returns the concatenated encodings of the items and the number of them.
+// The map entries are parsed as two items separated by a colon.
+func (p *diagParser) items(closing string, entries bool) ([]byte, uint64, error) {
+	var data []byte
+	var n uint64
+	if p.consume(closing) {
+		return data, 0, nil
+	}
+	for {
+		var err error
+		if data, err = p.item(data); err != nil {
+			return nil, 0, err
+		}
+		if entries {
+			if err = p.expect(":"); err != nil {
+				return nil, 0, err
+			}
+			if data, err = p.item(data); err != nil {
+				return nil, 0, err
+			}
+		}
+		n++
+		if p.consume(closing) {
+			return data, n, nil
+		}
+		if err = p.expect(","); err != nil {
+			return nil, 0, err
+		}
+	}
+}
+
+func (p *diagParser) array(dst []byte) ([]byte, error) {
+	indefinite := p.consume("_")
+	data, n, err := p.items("]", false)
+	if err != nil {
+		return nil, err
+	}
+	if indefinite {
+		return append(append(append(dst, 0x9f), data...), 0xff), nil
+	}
+	return append(appendCBORHead(dst, 4, n), data...), nil
+}
+
+func (p *diagParser) mapping(dst []byte) ([]byte, error) {
+	indefinite := p.consume("_")
+	data, n, err := p.items("}", true)
+	if err != nil {
+		return nil, err
+	}
+	if indefinite {
+		return append(append(append(dst, 0xbf), data...), 0xff), nil
+	}
+	return append(appendCBORHead(dst, 5, n), data...), nil
+}
+
+func (p *diagParser) embedded(dst []byte) ([]byte, error) {
+	data, _, err := p.items(">>", false)
+	if err != nil {
+		return nil, err
+	}
+	return append(appendCBORHead(dst, 2, uint64(len(data))), data...), nil
+}
+
+// chunks parses the chunks of an indefinite-length string, such as (_ "a", "b").
+func (p *diagParser) chunks(dst []byte) ([]byte, error) {
+	start := p.i
+	data, n, err := p.items(")", false)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		p.i = start
+		return nil, p.errorf("indefinite-length string without chunks")
+	}
+
+	major := data[0] >> 5
+	for off := 0; off < len(data); {
+		_, arg, next, err := cborHead(data, off)
+		if err != nil || data[off]>>5 != major || major != 2 && major != 3 || data[off]&0x1f == 31 {
+			p.i = start
+			return nil, p.errorf("invalid chunks of indefinite-length string")
+		}
+		off = next + int(arg)
+	}
+	return append(append(append(dst, major<<5|31), data...), 0xff), nil
+}
+
+// quoted parses a text string in double quotes, or a byte string in single quotes.
+func (p *diagParser) quoted(dst []byte) ([]byte, error) {
+	q := p.s[p.i]
+	start := p.i
+	p.i++
+
+	buf := make([]byte, 0, 16)
+	for {
+		if p.i >= len(p.s) {
+			p.i = start
+			return nil, p.errorf("unterminated string")
+		}
+
+		c := p.s[p.i]
+		p.i++
+		switch {
+		case c == q:
+			major := byte(3)
+			if q == '\'' {
+				major = 2
+			}
+			return append(appendCBORHead(dst, major, uint64(len(buf))), buf...), nil
+
+		case c != '\\':
+			buf = append(buf, c)
+
+		case p.i >= len(p.s):
+			return nil, p.errorf("unterminated string")
+
+		default:
+			c = p.s[p.i]
+			p.i++
+			switch c {
+			case 'b':
+				buf = append(buf, '\b')
+			case 'f':
+				buf = append(buf, '\f')
+			case 'n':
+				buf = append(buf, '\n')
+			case 'r':
+				buf = append(buf, '\r')
+			case 't':
+				buf = append(buf, '\t')
+			case 'u':
+				r, err := p.unicode()
+				if err != nil {
+					return nil, err
+				}
+				buf = utf8.AppendRune(buf, r)
+			default:
+				// '"', '\'', '\\', '/' and the other escaped characters are themselves.
+				buf = append(buf, c)
+			}
+		}
+	}
+}
+
+// unicode parses the hexadecimal digits of a \u escape, and the low surrogate that follows a high one.
+func (p *diagParser) unicode() (rune, error) {
+	read := func() (rune, error) {
+		if p.i+4 > len(p.s) {
+			return 0, p.errorf("invalid unicode escape")
+		}
+		v, err := strconv.ParseUint(p.s[p.i:p.i+4], 16, 16)
+		if err != nil {
+			return 0, p.errorf("invalid unicode escape %q", p.s[p.i:p.i+4])
+		}
+		p.i += 4
+		return rune(v), nil
+	}
+
+	r, err := read()
+	if err != nil || !utf16.IsSurrogate(r) {
+		return r, err
+	}
+	if !strings.HasPrefix(p.s[p.i:], "\\u") {
+		return utf8.RuneError, nil
+	}
+	p.i += 2
+	r2, err := read()
+	if err != nil {
+		return 0, err
+	}
+	return utf16.DecodeRune(r, r2), nil
+}
+
+// encodedBytes parses a byte string in base16, base64, base32 or base32hex, white spaces are ignored.
+func (p *diagParser) encodedBytes(dst []byte) ([]byte, error) {
+	start := p.i
+	prefix := p.s[p.i : strings.IndexByte(p.s[p.i:], '\'')+p.i]
+	p.i += len(prefix) + 1
+	end := strings.IndexByte(p.s[p.i:], '\'')
+	if end < 0 {
+		p.i = start
+		return nil, p.errorf("unterminated byte string")
+	}
+
+	text := strings.Map(func(r rune) rune {
+		if r == ' ' || r == '\t' || r == '\n' || r == '\r' {
+			return -1
+		}
+		return r
+	}, p.s[p.i:p.i+end])
+	text = strings.TrimRight(text, "=")
+
+	var data []byte
+	var err error
+	switch prefix {
+	case "h":
+		data, err = hex.DecodeString(text)
+	case "b64":
+		if strings.ContainsAny(text, "+/") {
+			data, err = base64.RawStdEncoding.DecodeString(text)
+		} else {
+			data, err = base64.RawURLEncoding.DecodeString(text)
+		}
+	case "b32":
+		data, err = base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(text)
+	case "h32":
+		data, err = base32.HexEncoding.WithPadding(base32.NoPadding).DecodeString(text)
+	}
+	if err != nil {
+		p.i = start
+		return nil, p.errorf("invalid byte string, %v", err)
+	}
+	p.i += end + 1
+	return append(appendCBORHead(dst, 2, uint64(len(data))), data...), nil
+}
+
+// number parses an integer, a float or a tag.
+func (p *diagParser) number(dst []byte) ([]byte, error) {
+	start := p.i
+	for p.i < len(p.s) && strings.IndexByte("+-._0123456789abcdefxoABCDEFINaNinity", p.s[p.i]) >= 0 {
+		p.i++
+	}
+	token := p.s[start:p.i]
+
+	// the encoding indicator, such as 1.5_1.
+	indicator := -1
+	if n := len(token); n > 2 && token[n-2] == '_' && token[n-1] >= '0' && token[n-1] <= '3' {
+		indicator = int(token[n-1] - '0')
+		token = token[:n-2]
+	}
+
+	if p.consume("(") {
+		num, err := strconv.ParseUint(token, 10, 64)
+		if err != nil || indicator >= 0 {
+			p.i = start
+			return nil, p.errorf("invalid tag number %q", p.s[start:p.i])
+		}
+		content, err := p.item(nil)
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expect(")"); err != nil {
+			return nil, err
+		}
+		return append(appendCBORHead(dst, 6, num), content...), nil
+	}
+
+	if i, ok := new(big.Int).SetString(token, 0); ok {
+		return p.integer(dst, start, i, indicator)
+	}
+
+	unsigned := strings.TrimLeft(token, "+-")
+	f, err := strconv.ParseFloat(token, 64)
+	switch {
+	case unsigned == "NaN":
+		f, err = math.NaN(), nil
+	case unsigned == "Infinity":
+		f, err = math.Inf(1), nil
+		if token[0] == '-' {
+			f = math.Inf(-1)
+		}
+	case strings.HasPrefix(unsigned, "0x") || !strings.ContainsAny(unsigned, "0123456789"):
+		// hexadecimal floats, "inf" and "nan" are not in the notation.
+		err = strconv.ErrSyntax
+	}
+	if err != nil {
+		p.i = start
+		return nil, p.errorf("invalid number %q", token)
+	}
+	return p.float(dst, start, f, indicator)
+}
+
+// integer appends an integer, or a bignum if it overflows 64 bits.
+func (p *diagParser) integer(dst []byte, start int, i *big.Int, indicator int) ([]byte, error) {
+	major := byte(0)
+	if i.Sign() < 0 {
+		major = 1
+		i = new(big.Int).Sub(new(big.Int).Neg(i), big.NewInt(1))
+	}
+
+	if !i.IsUint64() {
+		if indicator >= 0 {
+			p.i = start
+			return nil, p.errorf("encoding indicator of bignum")
+		}
+		num := uint64(2) + uint64(major)
+		data := i.Bytes()
+		return append(appendCBORHead(appendCBORHead(dst, 6, num), 2, uint64(len(data))), data...), nil
+	}
+
+	arg := i.Uint64()
+	if indicator < 0 {
+		return appendCBORHead(dst, major, arg), nil
+	}
+	size := 1 << indicator
+	if size < 8 && arg >= 1<<(8*size) {
+		p.i = start
+		return nil, p.errorf("integer %d overflows the encoding indicator _%d", arg, indicator)
+	}
+	dst = append(dst, major<<5|byte(24+indicator))
+	for s := size - 1; s >= 0; s-- {
+		dst = append(dst, byte(arg>>(8*s)))
+	}
+	return dst, nil
+}
+
+// float appends a float, in half, single or double precision for the encoding indicators _1, _2 and _3.
+func (p *diagParser) float(dst []byte, start int, f float64, indicator int) ([]byte, error) {
+	switch indicator {
+	case -1:
+		data, err := cborMarshal(f)
+		if err != nil {
+			return nil, err
+		}
+		return append(dst, data...), nil
+
+	case 1:
+		if h, ok := float16Bits(f); ok {
+			return append(dst, 0xf9, byte(h>>8), byte(h)), nil
+		}
+
+	case 2:
+		if f32 := float32(f); float64(f32) == f || math.IsNaN(f) {
+			b := math.Float32bits(f32)
+			return append(dst, 0xfa, byte(b>>24), byte(b>>16), byte(b>>8), byte(b)), nil
+		}
+
+	case 3:
+		b := math.Float64bits(f)
+		return append(dst, 0xfb, byte(b>>56), byte(b>>48), byte(b>>40), byte(b>>32),
+			byte(b>>24), byte(b>>16), byte(b>>8), byte(b)), nil
+	}
+
+	p.i = start
+	return nil, p.errorf("float %v is not representable with the encoding indicator _%d", f, indicator)
+}
+
+// float16Bits returns the half-precision bits of f if it is exactly representable.
+func float16Bits(f float64) (uint16, bool) {
+	var sign uint16
+	if math.Signbit(f) {
+		sign = 0x8000
+	}
+
+	a := math.Abs(f)
+	switch {
+	case math.IsNaN(f):
+		return 0x7e00, true
+	case math.IsInf(f, 0):
+		return sign | 0x7c00, true
+	case a == 0:
+		return sign, true
+	case a < 0x1p-14:
+		// subnormals are multiples of 2^-24.
+		m := a * 0x1p24
+		return sign | uint16(m), m == math.Trunc(m)
+	case a > 65504:
+		return 0, false
+	}
+
+	frac, exp := math.Frexp(a)
+	m := (frac*2 - 1) * 1024
+	return sign | uint16(exp-1+15)<<10 | uint16(m), m == math.Trunc(m)
+}
@@ -0,0 +1,105 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiagifyWithOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	doc := MustMarshal(map[string]any{
+		"a": []any{1, []any{}, map[string]any{}},
+		"b": RawMessage{0xd8, 0x17, 0xa1, 0x61, 0x63, 0x01},
+		"e": MustMarshal([]int{1, 2}),
+		"s": "hello wörld",
+	})
+
+	assert.Equal(Diagify(doc), DiagifyWithOptions(doc, nil))
+	assert.Equal(Diagify(doc), DiagifyWithOptions(doc, &DiagOptions{}))
+	assert.Equal(`{
+  "a": [
+    1,
+    [],
+    {}
+  ],
+  "b": 23({
+    "c": 1
+  }),
+  "e": h'820102',
+  "s": "hello w\u00f6rld"
+}`, DiagifyIndent(doc, "", "  "))
+
+	assert.Equal(`{
+	"a": [
+		1,
+		[],
+		{}
+	],
+	"b": 23({
+		"c": 1
+	}),
+	"e": <<[1, 2]>>,
+	"s": "hello w\u00f6"...
+}`, DiagifyWithOptions(doc, &DiagOptions{
+		Indent: "\t", MaxStringLength: 8, BytesFormat: DiagBytesEmbeddedCBOR,
+	}))
+
+	assert.Equal(`{"a": [1, [], {}], "b": 23({"c": 1}), "e": b64'gg'..., "s": "he"...}`,
+		DiagifyWithOptions(doc, &DiagOptions{MaxStringLength: 2, BytesFormat: DiagBytesBase64}))
+
+	// [_ 1, (_ "a", "b")]
+	assert.Equal("[_\n>   1,\n>   (_ \"a\", \"b\")\n> ]",
+		DiagifyIndent([]byte{0x9f, 0x01, 0x7f, 0x61, 0x61, 0x61, 0x62, 0xff, 0xff}, "> ", "  "))
+	assert.Equal("h'8201'", DiagifyIndent([]byte{0x82, 0x01}, "", "  "))
+	assert.Equal("h'82'...", DiagifyWithOptions([]byte{0x82, 0x01}, &DiagOptions{MaxStringLength: 2}))
+}
+
+func TestFromDiag(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, c := range []struct {
+		diag string
+		want string
+	}{
+		{`{"name": "John", "key": h'0102', "tags": 23(["a", "b"])}`, "a3646e616d65644a6f686e636b65794201026474616773d78261616162"},
+		{`[1, -1, 0x10, -0b11, 0o17, 18446744073709551616, -18446744073709551617]`, "87012010220fc249010000000000000000c349010000000000000000"},
+		{`[1.5_1, 1.5_2, 1.5_3, NaN_1, -Infinity_1, 0.00006103515625_1, 5.960464477539063e-8_1]`, "87f93e00fa3fc00000fb3ff8000000000000f97e00f9fc00f90400f90001"},
+		{`[1_0, 1_1, 1_2, -1_3]`, "8418011900011a000000013b0000000000000000"},
+		{`[_ (_ "a", "b"), (_ h'01', h'02'), {_ }]`, "9f7f61616162ff5f41014102ffbfffff"},
+		{`['a', b64'AQI', b64'+/8=', b32'AEBA', h32'0410', h'01 02']`, "86416142010242fbff420102420102420102"},
+		{`<<1, "a">> / embedded CBOR /`, "43016161"},
+		{`"é😀\"\n"`, "68c3a9f09f9880220a"},
+		{`[true, false, null, undefined, simple(16), simple(255)]`, "86f5f4f6f7f0f8ff"},
+	} {
+		data, err := FromDiag(c.diag)
+		assert.NoError(err, c.diag)
+		assert.Equal(c.want, hex.EncodeToString(data), c.diag)
+	}
+
+	// the reverse of Diagify.
+	doc := MustMarshal(map[string]any{
+		"a": []any{1, -2, 1.5, "x\nyé", []byte{1, 2}, nil, true},
+		"t": RawMessage{0xd7, 0xa1, 0x61, 0x63, 0x01},
+		"s": RawMessage{0xf0},
+	})
+	data, err := FromDiag(Diagify(doc))
+	assert.NoError(err)
+	assert.Equal(doc, data)
+	data, err = FromDiag(DiagifyIndent(doc, "", "  "))
+	assert.NoError(err)
+	assert.Equal(doc, data)
+
+	for _, s := range []string{
+		"", "[1,]", "[1 2]", "1 2", `{"a" 1}`, `"abc`, "h'0g'", "b64'@'", "inf", "0x1p3", "1.1_1", "256_0",
+		"18446744073709551616_3", "simple(24)", "simple(256)", `(_ )`, `(_ "a", h'01')`, "23_1(1)", "23(1", "foo",
+	} {
+		_, err := FromDiag(s)
+		assert.ErrorIs(err, ErrInvalid, s)
+	}
+}
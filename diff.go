@@ -0,0 +1,226 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"fmt"
+	"sort"
+)
+
+// CreatePatch creates a patch of "add", "remove" and "replace" operations
+// that transforms the original CBOR document to the modified one.
+// Maps are compared key by key, and arrays are compared element by element
+// after trimming their common prefix and suffix.
+// A nil or empty document is equal to CBOR null.
+//
+// A patch can only be applied to a map or an array, so when the documents differ,
+// both of them must be maps or arrays. If their types differ, such as a map and an array,
+// the patch replaces the whole document with a "replace" operation at the empty path.
+// A scalar document that differs from the other one, such as 1 and 2 or null and a map,
+// can not be patched and CreatePatch returns an ErrInvalid error.
+func CreatePatch(original, modified []byte) (Patch, error) {
+	return createPatch(original, modified, nil)
+}
+
+func createPatch(original, modified []byte, c *cborCodec) (Patch, error) {
+	for _, doc := range [][]byte{original, modified} {
+		if len(doc) > 0 {
+			if err := cborValid(doc); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	a, b := NewNode(original), NewNode(modified)
+	a.useCodec(c)
+	b.useCodec(c)
+	p := Patch{}
+	if err := diffNodes(&p, Path{}, a, b); err != nil {
+		return nil, err
+	}
+	if len(p) > 0 {
+		for _, doc := range [][]byte{original, modified} {
+			if ty := ReadCBORType(doc); ty != CBORTypeMap && ty != CBORTypeArray {
+				return nil, fmt.Errorf("unable to create a patch for %s document, %w", ty, ErrInvalid)
+			}
+		}
+	}
+	return p, nil
+}
+
+// CreatePatchFrom encodes the Go values a and b to CBOR with the encoding mode set by SetCBOR,
+// and creates a patch that transforms a to b, see CreatePatch.
+// The struct tags such as "keyasint" and "toarray" are respected,
+// use CreatePatchFromWithOptions to encode the values with Options.EncMode.
+func CreatePatchFrom(a, b any) (Patch, error) {
+	return CreatePatchFromWithOptions(a, b, nil)
+}
+
+// CreatePatchFromWithOptions is like CreatePatchFrom, but encodes the Go values with options.EncMode
+// if it is set, and decodes them with options.DecMode, see ApplyToValue.
+func CreatePatchFromWithOptions(a, b any, options *Options) (Patch, error) {
+	c := newCodec(options)
+	original, err := c.Marshal(a)
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode the original value, %w", err)
+	}
+	modified, err := c.Marshal(b)
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode the modified value, %w", err)
+	}
+	return createPatch(original, modified, c)
+}
+
+func diffNodes(p *Patch, path Path, a, b *Node) error {
+	if a == nil {
+		a = NewNode(nil)
+	}
+	if b == nil {
+		b = NewNode(nil)
+	}
+
+	a.intoContainer()
+	b.intoContainer()
+	switch {
+	case a.which == eDoc && b.which == eDoc:
+		return diffDocs(p, path, a.doc, b.doc)
+	case a.which == eAry && b.which == eAry:
+		return diffArrays(p, path, a.ary, b.ary)
+	case a.Equal(b):
+		return nil
+	}
+
+	value, err := b.MarshalCBOR()
+	if err != nil {
+		return err
+	}
+	*p = append(*p, &Operation{Op: OpReplace, Path: path, Value: value})
+	return nil
+}
+
+func diffDocs(p *Patch, path Path, a, b *partialDoc) error {
+	keys := make([]RawKey, 0, len(a.obj)+len(b.obj))
+	for k := range a.obj {
+		keys = append(keys, k)
+	}
+	for k := range b.obj {
+		if _, ok := a.obj[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	for _, k := range keys {
+		av, aok := a.obj[k]
+		bv, bok := b.obj[k]
+		switch {
+		case !bok:
+			*p = append(*p, &Operation{Op: OpRemove, Path: path.WithKey(k)})
+
+		case !aok:
+			value, err := bv.MarshalCBOR()
+			if err != nil {
+				return err
+			}
+			*p = append(*p, &Operation{Op: OpAdd, Path: path.WithKey(k), Value: value})
+
+		default:
+			if err := diffNodes(p, path.WithKey(k), av, bv); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// diffArrayLCSLimit limits the number of element comparisons to find the longest common subsequence
+// of two arrays, larger arrays are compared element by element.
+const diffArrayLCSLimit = 1 << 18
+
+func diffArrays(p *Patch, path Path, a, b partialArray) error {
+	start := 0
+	for start < len(a) && start < len(b) && a[start].Equal(b[start]) {
+		start++
+	}
+
+	ae, be := len(a), len(b)
+	for ae > start && be > start && a[ae-1].Equal(b[be-1]) {
+		ae--
+		be--
+	}
+
+	am, bm := a[start:ae], b[start:be]
+	n, m := len(am), len(bm)
+	if n == 0 || m == 0 || n*m > diffArrayLCSLimit {
+		return diffArrayRun(p, path, start, am, bm)
+	}
+
+	// dp[i][j] is the length of the longest common subsequence of am[i:] and bm[j:].
+	eq := make([][]bool, n)
+	dp := make([][]int, n+1)
+	dp[n] = make([]int, m+1)
+	for i := n - 1; i >= 0; i-- {
+		eq[i] = make([]bool, m)
+		dp[i] = make([]int, m+1)
+		for j := m - 1; j >= 0; j-- {
+			switch {
+			case am[i].Equal(bm[j]):
+				eq[i][j] = true
+				dp[i][j] = dp[i+1][j+1] + 1
+			case dp[i+1][j] >= dp[i][j+1]:
+				dp[i][j] = dp[i+1][j]
+			default:
+				dp[i][j] = dp[i][j+1]
+			}
+		}
+	}
+
+	pos, i, j := start, 0, 0
+	for i < n || j < m {
+		ri, rj := i, j
+		for (i < n || j < m) && !(i < n && j < m && eq[i][j]) {
+			if j < m && (i == n || dp[i][j+1] >= dp[i+1][j]) {
+				j++
+			} else {
+				i++
+			}
+		}
+
+		if err := diffArrayRun(p, path, pos, am[ri:i], bm[rj:j]); err != nil {
+			return err
+		}
+		pos += j - rj
+		if i < n && j < m {
+			i, j, pos = i+1, j+1, pos+1
+		}
+	}
+	return nil
+}
+
+// diffArrayRun diffs the elements of a replaced by the elements of b at the index pos.
+func diffArrayRun(p *Patch, path Path, pos int, a, b partialArray) error {
+	k := len(a)
+	if len(b) < k {
+		k = len(b)
+	}
+
+	for i := 0; i < k; i++ {
+		if err := diffNodes(p, path.withIndex(pos+i), a[i], b[i]); err != nil {
+			return err
+		}
+	}
+
+	for i := k; i < len(b); i++ {
+		value, err := b[i].MarshalCBOR()
+		if err != nil {
+			return err
+		}
+		*p = append(*p, &Operation{Op: OpAdd, Path: path.withIndex(pos + i), Value: value})
+	}
+
+	for i := len(a) - 1; i >= k; i-- {
+		*p = append(*p, &Operation{Op: OpRemove, Path: path.withIndex(pos + i)})
+	}
+	return nil
+}
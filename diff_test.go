@@ -0,0 +1,145 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreatePatch(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, c := range []struct {
+		original, modified, patch string
+	}{
+		{`{"a": 1}`, `{"a": 1}`, `[]`},
+		{`{"a": 1}`, `{"a": 2}`, `[{"op":"replace","path":"/a","value":2}]`},
+		{`{"a": 1, "b": 2}`, `{"b": 2, "c": 3}`,
+			`[{"op":"remove","path":"/a"},{"op":"add","path":"/c","value":3}]`},
+		{`{"a": {"b": [1, 2, 3]}}`, `{"a": {"b": [1, 3]}}`, `[{"op":"remove","path":"/a/b/1"}]`},
+		{`{"a": [1, 2]}`, `{"a": [0, 1, 2, 3]}`,
+			`[{"op":"add","path":"/a/0","value":0},{"op":"add","path":"/a/3","value":3}]`},
+		{`[1, {"x": 1}, 3, 4]`, `[1, {"x": 2}, 5]`,
+			`[{"op":"replace","path":"/1/x","value":2},{"op":"replace","path":"/2","value":5},{"op":"remove","path":"/3"}]`},
+		{`{"a": [1]}`, `{"a": {"0": 1}}`, `[{"op":"replace","path":"/a","value":{"0":1}}]`},
+		{`{"a": null}`, `{"a": false}`, `[{"op":"replace","path":"/a","value":false}]`},
+		{`{"a": [1, 2, 3, 4, 5]}`, `{"a": []}`,
+			`[{"op":"remove","path":"/a/4"},{"op":"remove","path":"/a/3"},{"op":"remove","path":"/a/2"},{"op":"remove","path":"/a/1"},{"op":"remove","path":"/a/0"}]`},
+		{`{"a": 1}`, `[1]`, `[{"op":"replace","path":"","value":[1]}]`},
+	} {
+		original, modified := MustFromJSON(c.original), MustFromJSON(c.modified)
+		p, err := CreatePatch(original, modified)
+		assert.NoError(err, c.original)
+
+		data, err := marshalJSONPatch(p)
+		assert.NoError(err)
+		assert.Equal(c.patch, string(data), c.original)
+
+		res, err := p.Apply(original)
+		assert.NoError(err, c.original)
+		assert.True(Equal(modified, res), c.original)
+	}
+
+	p, err := CreatePatch(nil, nil)
+	assert.NoError(err)
+	assert.Equal(Patch{}, p)
+
+	_, err = CreatePatch([]byte{0xa1}, nil)
+	assert.Error(err)
+}
+
+func TestCreatePatchRoot(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, c := range []struct {
+		original, modified string
+	}{
+		{`{"a": 1}`, `[1, 2]`},
+		{`[1, 2]`, `{"a": 1}`},
+		{`{}`, `[]`},
+		{`[]`, `{}`},
+	} {
+		original, modified := MustFromJSON(c.original), MustFromJSON(c.modified)
+		p, err := CreatePatch(original, modified)
+		assert.NoError(err, c.original)
+		res, err := p.Apply(original)
+		assert.NoError(err, c.original)
+		assert.True(Equal(modified, res), c.original)
+	}
+
+	for _, c := range []struct {
+		original, modified string
+	}{
+		{`1`, `2`},
+		{`1`, `"1"`},
+		{`null`, `{"a": 1}`},
+		{`{"a": 1}`, `null`},
+		{`[1]`, `true`},
+	} {
+		_, err := CreatePatch(MustFromJSON(c.original), MustFromJSON(c.modified))
+		assert.ErrorIs(err, ErrInvalid, c.original)
+	}
+	_, err := CreatePatch(nil, MustFromJSON(`{"a": 1}`))
+	assert.ErrorIs(err, ErrInvalid)
+
+	p, err := CreatePatch(MustFromJSON(`1`), MustFromJSON(`1`))
+	assert.NoError(err)
+	assert.Equal(0, len(p))
+}
+
+func TestCreatePatchArrays(t *testing.T) {
+	assert := assert.New(t)
+
+	docs := []string{
+		`[]`, `[1]`, `[1, 2, 3]`, `[3, 2, 1]`, `[0, 1, 2, 3, 4]`, `[2, 4, 6, 1]`,
+		`[{"a": 1}, [1], 1, null]`, `[null, {"a": 2}, [1, 2], 1]`, `[1, 1, 1, 2]`,
+	}
+	for _, a := range docs {
+		for _, b := range docs {
+			original, modified := MustFromJSON(a), MustFromJSON(b)
+			p, err := CreatePatch(original, modified)
+			assert.NoError(err)
+			res, err := p.Apply(original)
+			assert.NoError(err, a+" -> "+b)
+			assert.True(Equal(modified, res), a+" -> "+b)
+			if a == b {
+				assert.Equal(0, len(p))
+			}
+		}
+	}
+}
+
+func TestCreatePatchFrom(t *testing.T) {
+	assert := assert.New(t)
+
+	type point struct {
+		_ struct{} `cbor:",toarray"`
+		X int
+		Y int
+	}
+	type shape struct {
+		Name   string  `cbor:"1,keyasint"`
+		Points []point `cbor:"2,keyasint"`
+		Color  string  `cbor:"3,keyasint,omitempty"`
+	}
+
+	a := shape{Name: "line", Points: []point{{X: 0, Y: 0}, {X: 1, Y: 1}}}
+	b := shape{Name: "line", Points: []point{{X: 0, Y: 0}, {X: 1, Y: 2}}, Color: "red"}
+
+	p, err := CreatePatchFrom(a, b)
+	assert.NoError(err)
+	assert.Equal(Patch{
+		{Op: OpReplace, Path: Path{RawKey(MustMarshal(2)), RawKey(MustMarshal(1)), RawKey(MustMarshal(1))}, Value: MustMarshal(2)},
+		{Op: OpAdd, Path: Path{RawKey(MustMarshal(3))}, Value: MustMarshal("red")},
+	}, p)
+
+	data, err := p.Apply(MustMarshal(a))
+	assert.NoError(err)
+	assert.Equal(MustMarshal(b), data)
+
+	_, err = CreatePatchFrom(a, func() {})
+	assert.ErrorContains(err, "unable to encode the modified value")
+}
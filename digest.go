@@ -0,0 +1,37 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"crypto"
+	_ "crypto/sha256" // register crypto.SHA256 for Digest
+	"fmt"
+)
+
+// Digest returns the SHA-256 digest of the CBOR document in the deterministic encoding,
+// so that documents with the same data model value have the same digest,
+// no matter how their map keys are ordered or their arguments are encoded.
+func Digest(doc []byte) ([]byte, error) {
+	return NewNode(doc).Digest(crypto.SHA256)
+}
+
+// Digest returns the digest of the node in the deterministic encoding with the hash function h.
+// The hash function should be linked into the binary, see crypto.Hash.Available.
+func (n *Node) Digest(h crypto.Hash) ([]byte, error) {
+	if !h.Available() {
+		return nil, fmt.Errorf("unavailable hash function %v", h)
+	}
+
+	data, err := n.MarshalCBOR()
+	if err != nil {
+		return nil, err
+	}
+	if data, err = canonicalize(data); err != nil {
+		return nil, err
+	}
+
+	hasher := h.New()
+	hasher.Write(data)
+	return hasher.Sum(nil), nil
+}
@@ -0,0 +1,59 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDigest(t *testing.T) {
+	assert := assert.New(t)
+
+	// {"a": 2, "b": [1, 1.5]}
+	canonical, _ := hex.DecodeString("a261610261628201f93e00")
+	sum := sha256.Sum256(canonical)
+
+	for _, s := range []string{
+		"a261610261628201f93e00",
+		// {"b": [1, 1.5], "a": 2}
+		"a261628201f93e00616102",
+		// non-shortest arguments and floats
+		"b8027801611a0000000279000162980201fb3ff8000000000000",
+	} {
+		doc, err := hex.DecodeString(s)
+		assert.NoError(err)
+
+		data, err := canonicalize(doc)
+		assert.NoError(err, s)
+		assert.Equal(canonical, data, s)
+
+		digest, err := Digest(doc)
+		assert.NoError(err, s)
+		assert.Equal(sum[:], digest, s)
+	}
+
+	digest, err := NewNode(MustMarshal(map[string]any{"a": 2, "b": []any{1, 1.5}})).Digest(crypto.SHA256)
+	assert.NoError(err)
+	assert.Equal(sum[:], digest)
+
+	digest, err = Digest(MustMarshal(map[string]any{"a": 2, "b": []any{1, 2.5}}))
+	assert.NoError(err)
+	assert.NotEqual(sum[:], digest)
+
+	// 1.1 can not be encoded in float16 or float32
+	data, err := canonicalize(MustMarshal(1.1))
+	assert.NoError(err)
+	assert.Equal("fb3ff199999999999a", hex.EncodeToString(data))
+
+	_, err = NewNode(canonical).Digest(crypto.MD4)
+	assert.ErrorContains(err, "unavailable hash function")
+
+	_, err = Digest([]byte{0x82, 0x01})
+	assert.Error(err)
+}
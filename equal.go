@@ -0,0 +1,168 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"math"
+	"math/big"
+)
+
+// EqualOptions are the options of comparing CBOR values, see EqualWithOptions and Options.Equal.
+// The zero EqualOptions compares values by their encoding, except that maps are compared regardless of
+// the order of their keys, and null, undefined and a missing value are equal.
+type EqualOptions struct {
+	// IgnoreTags instructs the comparison to ignore the tags of values, so 23("x") is equal to "x".
+	IgnoreTags bool
+	// CompareTagNumbers instructs the comparison to compare tagged values by their tag numbers and
+	// their contents, so 23({"a": 1, "b": 2}) is equal to 23({"b": 2, "a": 1}), but not to 24({"a": 1, "b": 2}).
+	// It has no effect if IgnoreTags is set.
+	CompareTagNumbers bool
+	// FloatEpsilon is the tolerance of comparing floats, floats are equal if their difference is within it,
+	// regardless of their precisions.
+	FloatEpsilon float64
+	// NumericEquality instructs the comparison to compare numbers by their values,
+	// so integers, bignums and floats of the same value are equal, such as 1, 1.0 and 2(h'01'),
+	// and integers encoded in different lengths are equal.
+	// With FloatEpsilon, numbers are equal if their difference is within it.
+	NumericEquality bool
+	// DistinguishUndefined instructs the comparison to treat undefined as a value distinct from null,
+	// so undefined is only equal to undefined, and a "test" operation of undefined fails on a missing path.
+	DistinguishUndefined bool
+	// IgnoreArrayOrder instructs the comparison to compare arrays as multisets, so [1, 2, 2] is equal to
+	// [2, 1, 2], but not to [1, 1, 2]. Each element is matched to the first equal element not yet matched.
+	IgnoreArrayOrder bool
+}
+
+// EqualWithOptions indicates if two CBOR documents have the same structural equality with the EqualOptions,
+// nil EqualOptions is the comparison of Equal.
+func EqualWithOptions(a, b []byte, opts *EqualOptions) bool {
+	return NewNode(a).EqualWithOptions(NewNode(b), opts)
+}
+
+// floatEpsilon returns the tolerance of comparing floats, nil EqualOptions have no tolerance.
+func (eo *EqualOptions) floatEpsilon() float64 {
+	if eo == nil {
+		return 0
+	}
+	return eo.FloatEpsilon
+}
+
+// equalArrays compares the arrays of the same length element by element with match,
+// or as multisets if the array order is ignored.
+func (eo *EqualOptions) equalArrays(a, b partialArray, match func(x, y *Node) bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	if eo == nil || !eo.IgnoreArrayOrder {
+		for i := range a {
+			if !match(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	matched := make([]bool, len(b))
+	for _, x := range a {
+		found := false
+		for j, y := range b {
+			if !matched[j] && match(x, y) {
+				matched[j], found = true, true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// equalNumbers compares the raw encoded CBOR values by their values if both are numbers and
+// the numeric equality is enabled, ok is false if they should be compared as usual.
+func (eo *EqualOptions) equalNumbers(a, b []byte) (equal, ok bool) {
+	if eo == nil || !eo.NumericEquality || !isCBORNumber(a) || !isCBORNumber(b) {
+		return false, false
+	}
+
+	var x, y any
+	if cborUnmarshal(a, &x) != nil || cborUnmarshal(b, &y) != nil {
+		return false, false
+	}
+	if eo.FloatEpsilon > 0 {
+		f, fok := bigFloatOf(x)
+		g, gok := bigFloatOf(y)
+		if !fok || !gok {
+			return false, false
+		}
+		diff, _ := new(big.Float).Sub(f, g).Float64()
+		return math.Abs(diff) <= eo.FloatEpsilon, true
+	}
+
+	c, ok := compareNumbers(x, y)
+	if !ok {
+		return false, false
+	}
+	return c == 0, true
+}
+
+// equalUndefined compares the nodes if either is CBOR undefined and undefined is distinct from null,
+// ok is false if they should be compared as usual.
+func (eo *EqualOptions) equalUndefined(n, o *Node) (equal, ok bool) {
+	if eo == nil || !eo.DistinguishUndefined {
+		return false, false
+	}
+
+	nu, ou := n.IsUndefined(), o.IsUndefined()
+	if !nu && !ou {
+		return false, false
+	}
+	return nu && ou, true
+}
+
+// equalTags compares the nodes if either is a tagged value and the tags are not compared by encoding,
+// ok is false if the nodes should be compared as usual.
+func (eo *EqualOptions) equalTags(n, o *Node) (equal, ok bool) {
+	if eo == nil || !eo.IgnoreTags && !eo.CompareTagNumbers {
+		return false, false
+	}
+
+	nt, nc, nok := n.untag()
+	ot, oc, ook := o.untag()
+	switch {
+	case !nok && !ook:
+		return false, false
+
+	case eo.IgnoreTags:
+		for nok {
+			n = nc
+			_, nc, nok = n.untag()
+		}
+		for ook {
+			o = oc
+			_, oc, ook = o.untag()
+		}
+		return n.equal(o, eo), true
+
+	case nok && ook && nt == ot:
+		return nc.equal(oc, eo), true
+	}
+	return false, true
+}
+
+// untag returns the tag number and the content node if the node is a tagged value.
+func (n *Node) untag() (uint64, *Node, bool) {
+	if n == nil || n.raw == nil || n.which == eDoc || n.which == eAry || ReadCBORType(*n.raw) != CBORTypeTag {
+		return 0, nil, false
+	}
+
+	_, num, next, err := cborHead(*n.raw, 0)
+	if err != nil {
+		return 0, nil, false
+	}
+	content := NewNode((*n.raw)[next:])
+	content.useCodec(n.codec)
+	return num, content, true
+}
@@ -0,0 +1,202 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEqualOptionsTags(t *testing.T) {
+	assert := assert.New(t)
+
+	// {"a": 1, "b": 2} and {"b": 2, "a": 1}
+	ab := MustMarshal(map[string]int{"a": 1, "b": 2})
+	ba := append(appendCBORHead(nil, 5, 2), MustMarshal("b")...)
+	ba = append(append(ba, 0x02), MustMarshal("a")...)
+	ba = append(ba, 0x01)
+
+	doc := MustMarshal(map[string]any{
+		"x": cbor.Tag{Number: 23, Content: "x"},
+		"m": cbor.RawTag{Number: 23, Content: ab},
+	})
+	test := func(path string, value []byte, eo EqualOptions) error {
+		options := NewOptions()
+		options.Equal = eo
+		p := Patch{{Op: OpTest, Path: PathMustFromJSON(path), Value: value}}
+		_, err := p.ApplyWithOptions(doc, options)
+		return err
+	}
+
+	for _, c := range []struct {
+		path  string
+		value []byte
+		eo    EqualOptions
+		ok    bool
+	}{
+		{"/x", MustMarshal(cbor.Tag{Number: 23, Content: "x"}), EqualOptions{}, true},
+		{"/x", MustMarshal("x"), EqualOptions{}, false},
+		{"/x", MustMarshal("x"), EqualOptions{IgnoreTags: true}, true},
+		{"/x", MustMarshal("x"), EqualOptions{CompareTagNumbers: true}, false},
+		{"/x", MustMarshal(cbor.Tag{Number: 24, Content: "x"}), EqualOptions{IgnoreTags: true}, true},
+		{"/x", MustMarshal(cbor.Tag{Number: 24, Content: "x"}), EqualOptions{CompareTagNumbers: true}, false},
+		{"/m", MustMarshal(cbor.RawTag{Number: 23, Content: ba}), EqualOptions{}, false},
+		{"/m", MustMarshal(cbor.RawTag{Number: 23, Content: ba}), EqualOptions{CompareTagNumbers: true}, true},
+		{"/m", ba, EqualOptions{IgnoreTags: true}, true},
+		{"", MustMarshal(map[string]any{"x": "x", "m": RawMessage(ba)}), EqualOptions{}, false},
+		{"", MustMarshal(map[string]any{"x": "x", "m": RawMessage(ba)}), EqualOptions{IgnoreTags: true}, true},
+	} {
+		err := test(c.path, c.value, c.eo)
+		assert.Equal(c.ok, err == nil, "%s %s %+v, %v", c.path, Diagify(c.value), c.eo, err)
+	}
+
+	options := NewOptions()
+	options.Equal.IgnoreTags = true
+	res, err := NewNode(doc).FindChildren([]*PV{{Path: PathMustFrom("x"), Value: MustMarshal("x")}}, options)
+	assert.NoError(err)
+	assert.Len(res, 1)
+}
+
+func TestNumericEquality(t *testing.T) {
+	assert := assert.New(t)
+
+	doc := MustMarshal(map[string]any{"a": 1, "b": -1, "c": 1.5, "d": []any{1, 2}})
+	test := func(path string, value []byte, numeric bool, epsilon float64) error {
+		options := NewOptions()
+		options.Equal.NumericEquality = numeric
+		options.Equal.FloatEpsilon = epsilon
+		p := Patch{{Op: OpTest, Path: PathMustFromJSON(path), Value: value}}
+		_, err := p.ApplyWithOptions(doc, options)
+		return err
+	}
+
+	for _, c := range []struct {
+		path    string
+		value   []byte
+		epsilon float64
+		ok      bool
+		// plain is the result without NumericEquality.
+		plain bool
+	}{
+		{"/a", MustMarshal(1.0), 0, true, false},
+		{"/a", []byte{0x18, 0x01}, 0, true, false},
+		{"/a", []byte{0xc2, 0x41, 0x01}, 0, true, false},
+		{"/a", MustMarshal(2), 0, false, false},
+		{"/a", MustMarshal("1"), 0, false, false},
+		{"/b", MustMarshal(-1.0), 0, true, false},
+		{"/b", MustMarshal(1), 0, false, false},
+		{"/c", MustMarshal(float32(1.5)), 0, true, false},
+		{"/c", MustMarshal(2), 0, false, false},
+		{"/c", MustMarshal(2), 0.5, true, false},
+		{"/d", MustMarshal([]any{1.0, 2.0}), 0, true, false},
+	} {
+		err := test(c.path, c.value, true, c.epsilon)
+		assert.Equal(c.ok, err == nil, "%s %s, %v", c.path, Diagify(c.value), err)
+		err = test(c.path, c.value, false, c.epsilon)
+		assert.Equal(c.plain, err == nil, "%s %s without NumericEquality, %v", c.path, Diagify(c.value), err)
+	}
+
+	options := NewOptions()
+	options.Equal.NumericEquality = true
+	res, err := NewNode(doc).FindChildren([]*PV{{Path: PathMustFrom("a"), Value: MustMarshal(1.0)}}, options)
+	assert.NoError(err)
+	assert.Len(res, 1)
+}
+
+func TestDistinguishUndefined(t *testing.T) {
+	assert := assert.New(t)
+
+	undefined := []byte{0xf7}
+	doc := MustMarshal(map[string]any{"a": cbor.RawMessage(undefined), "b": nil})
+	test := func(path string, value []byte, distinguish bool) error {
+		options := NewOptions()
+		options.Equal.DistinguishUndefined = distinguish
+		p := Patch{{Op: OpTest, Path: PathMustFromJSON(path), Value: value}}
+		_, err := p.ApplyWithOptions(doc, options)
+		return err
+	}
+
+	for _, c := range []struct {
+		path  string
+		value []byte
+		ok    bool
+		// plain is the result without DistinguishUndefined.
+		plain bool
+	}{
+		{"/a", undefined, true, true},
+		{"/a", rawCBORNull, false, true},
+		{"/b", undefined, false, true},
+		{"/b", rawCBORNull, true, true},
+		{"/c", undefined, false, true},
+		{"/c", rawCBORNull, true, true},
+		{"", MustMarshal(map[string]any{"a": nil, "b": nil}), false, true},
+	} {
+		err := test(c.path, c.value, true)
+		assert.Equal(c.ok, err == nil, "%s %s, %v", c.path, Diagify(c.value), err)
+		err = test(c.path, c.value, false)
+		assert.Equal(c.plain, err == nil, "%s %s without DistinguishUndefined, %v", c.path, Diagify(c.value), err)
+	}
+
+	p := Patch{{Op: OpAdd, Path: PathMustFrom("c"), Value: undefined}}
+	res, err := p.Apply(doc)
+	assert.NoError(err)
+	assert.Equal(`{"a": undefined, "b": null, "c": undefined}`, Diagify(res))
+
+	node, err := NewNode(res).GetChild(PathMustFrom("c"), nil)
+	assert.NoError(err)
+	assert.True(node.IsUndefined())
+	assert.False(node.IsNull())
+	assert.Equal("undefined", node.String())
+
+	assert.True(IsNull(nil))
+	assert.True(IsNull(rawCBORNull))
+	assert.False(IsNull(undefined))
+	assert.True(IsUndefined(undefined))
+	assert.False(IsUndefined(rawCBORNull))
+	assert.True((*Node)(nil).IsNull())
+	assert.False(NewNode(MustMarshal(map[string]int{})).IsNull())
+}
+
+func TestEqualWithOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	a := MustMarshal(map[string]any{"x": []any{1, 2, 2, []any{3, 4}}, "f": 1.0})
+	b := MustMarshal(map[string]any{"x": []any{[]any{4, 3}, 2, 1, 2}, "f": 1})
+	c := MustMarshal(map[string]any{"x": []any{[]any{4, 3}, 1, 1, 2}, "f": 1})
+
+	assert.False(Equal(a, b))
+	assert.False(EqualWithOptions(a, b, nil))
+	assert.False(EqualWithOptions(a, b, &EqualOptions{IgnoreArrayOrder: true}))
+	assert.True(EqualWithOptions(a, b, &EqualOptions{IgnoreArrayOrder: true, NumericEquality: true}))
+	assert.False(EqualWithOptions(a, c, &EqualOptions{IgnoreArrayOrder: true, NumericEquality: true}))
+	assert.True(NewNode(a).EqualWithOptions(NewNode(b), &EqualOptions{IgnoreArrayOrder: true, NumericEquality: true}))
+
+	assert.True(EqualWithOptions(MustMarshal(1.0), MustMarshal(1.05), &EqualOptions{FloatEpsilon: 0.1}))
+	assert.False(EqualWithOptions(MustMarshal(1.0), MustMarshal(1.05), nil))
+	assert.True(EqualWithOptions(RawMessage{0xf7}, RawMessage{0xf6}, nil))
+	assert.False(EqualWithOptions(RawMessage{0xf7}, RawMessage{0xf6}, &EqualOptions{DistinguishUndefined: true}))
+
+	// the options of Options.Equal apply to the operations and FindChildren.
+	options := NewOptions()
+	options.Equal = EqualOptions{IgnoreArrayOrder: true}
+	p := Patch{
+		{Op: OpTest, Path: PathMustFrom("x"), Value: MustMarshal([]any{[]any{4, 3}, 2, 1, 2})},
+		{Op: OpTestSubset, Path: PathMustFrom("x"), Value: MustMarshal([]any{[]any{4, 3}, 2, 1, 2})},
+	}
+	_, err := p.ApplyWithOptions(a, options)
+	assert.NoError(err)
+	_, err = p.Apply(a)
+	assert.ErrorIs(err, ErrTestFailed)
+
+	doc := MustMarshal([]any{map[string]any{"tags": []string{"a", "b"}}, map[string]any{"tags": []string{"c"}}})
+	tests := []*PV{{Path: PathMustFrom("tags"), Value: MustMarshal([]string{"b", "a"})}}
+	nodes, err := NewNode(doc).FindChildren(tests, options)
+	assert.NoError(err)
+	assert.Len(nodes, 1)
+	nodes, err = NewNode(doc).FindChildren(tests, nil)
+	assert.NoError(err)
+	assert.Len(nodes, 0)
+}
@@ -0,0 +1,133 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// DefaultMaxHTTPBodySize is the default limit of a patch request body size for HTTPHandler.
+const DefaultMaxHTTPBodySize int64 = 1 << 20
+
+// HTTPHandler is a http.Handler that applies a CBOR-Patch ("application/cbor-patch"),
+// a JSON-Patch ("application/json-patch+json") or a CBOR merge patch ("application/merge-patch+cbor")
+// in a PATCH request to a CBOR document.
+//
+// It responds with:
+//
+//	200 and the patched document on success,
+//	400 if the patch document is malformed or invalid,
+//	405 if the request method is not PATCH,
+//	409 if a "test" operation failed,
+//	413 if the request body is too large,
+//	415 if the request content type is not supported,
+//	422 if the patch can not be applied to the document,
+//	404 if Load returns an error that matches ErrMissing,
+//	500 if Load or Save returns other errors.
+type HTTPHandler struct {
+	// Load returns the CBOR document that the patch in the request applies to. It is required.
+	Load func(r *http.Request) ([]byte, error)
+	// Save stores the patched CBOR document. It is optional.
+	Save func(r *http.Request, doc []byte) error
+	// Options is used to apply patches and merge patches, NewOptions() is used if it is nil.
+	Options *Options
+	// MaxBodySize limits the request body size, DefaultMaxHTTPBodySize is used if it is zero.
+	MaxBodySize int64
+}
+
+var _ http.Handler = (*HTTPHandler)(nil)
+
+// ServeHTTP implements the http.Handler interface.
+func (h *HTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Accept-Patch", strings.Join([]string{
+		MediaTypeCBORPatch, MediaTypeJSONPatch, MediaTypeCBORMergePatch}, ", "))
+
+	if r.Method != http.MethodPatch {
+		w.Header().Set("Allow", http.MethodPatch)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ct := r.Header.Get("Content-Type")
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		http.Error(w, "invalid content type, "+err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	switch mt {
+	case MediaTypeCBORPatch, MediaTypeJSONPatch, MediaTypeCBORMergePatch:
+	default:
+		http.Error(w, "unsupported content type "+mt, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	limit := h.MaxBodySize
+	if limit <= 0 {
+		limit = DefaultMaxHTTPBodySize
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+	switch {
+	case err != nil:
+		http.Error(w, "unable to read request body, "+err.Error(), http.StatusBadRequest)
+		return
+	case int64(len(body)) > limit:
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	var patch Patch
+	if mt == MediaTypeCBORMergePatch {
+		err = cborValid(body)
+	} else {
+		patch, err = DecodePatchByContentType(ct, body)
+	}
+	if err != nil {
+		http.Error(w, "invalid patch document, "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	doc, err := h.Load(r)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, ErrMissing) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	options := h.Options
+	if options == nil {
+		options = NewOptions()
+	}
+	if mt == MediaTypeCBORMergePatch {
+		doc, err = MergePatchWithOptions(doc, body, options)
+	} else {
+		doc, err = patch.ApplyWithOptions(doc, options)
+	}
+	if err != nil {
+		status := http.StatusUnprocessableEntity
+		if errors.Is(err, ErrTestFailed) {
+			status = http.StatusConflict
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	if h.Save != nil {
+		if err = h.Save(r, doc); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", MediaTypeCBOR)
+	w.WriteHeader(http.StatusOK)
+	w.Write(doc)
+}
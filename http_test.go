@@ -0,0 +1,110 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	var saved []byte
+	h := &HTTPHandler{
+		Load: func(r *http.Request) ([]byte, error) {
+			if r.URL.Path == "/missing" {
+				return nil, ErrMissing
+			}
+			return MustFromJSON(`{"name": "John", "age": 24}`), nil
+		},
+		Save: func(r *http.Request, doc []byte) error {
+			saved = doc
+			return nil
+		},
+		MaxBodySize: 256,
+	}
+
+	do := func(method, path, ct string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		if ct != "" {
+			req.Header.Set("Content-Type", ct)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	cborPatch := MustMarshal(Patch{
+		{Op: OpReplace, Path: PathMustFrom("name"), Value: MustMarshal("Jane")},
+	})
+	rec := do(http.MethodPatch, "/", "application/cbor-patch", cborPatch)
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.Equal("application/cbor", rec.Header().Get("Content-Type"))
+	assert.Equal(`{"age":24,"name":"Jane"}`, MustToJSON(rec.Body.Bytes()))
+	assert.Equal(rec.Body.Bytes(), saved)
+
+	rec = do(http.MethodPatch, "/", "application/json-patch+json; charset=utf-8",
+		[]byte(`[{"op": "remove", "path": "/age"}]`))
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.Equal(`{"name":"John"}`, MustToJSON(rec.Body.Bytes()))
+
+	rec = do(http.MethodPatch, "/", "application/merge-patch+cbor",
+		MustFromJSON(`{"age": null, "tags": {"a": 1}}`))
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.Equal(`{"name":"John","tags":{"a":1}}`, MustToJSON(rec.Body.Bytes()))
+
+	rec = do(http.MethodPost, "/", "application/cbor-patch", cborPatch)
+	assert.Equal(http.StatusMethodNotAllowed, rec.Code)
+
+	rec = do(http.MethodPatch, "/", "application/cbor", cborPatch)
+	assert.Equal(http.StatusUnsupportedMediaType, rec.Code)
+	assert.Contains(rec.Header().Get("Accept-Patch"), "application/cbor-patch")
+
+	rec = do(http.MethodPatch, "/", "application/json-patch+json", []byte(`[{"op": "foo"}]`))
+	assert.Equal(http.StatusBadRequest, rec.Code)
+
+	rec = do(http.MethodPatch, "/", "application/json-patch+json", bytes.Repeat([]byte{' '}, 257))
+	assert.Equal(http.StatusRequestEntityTooLarge, rec.Code)
+
+	rec = do(http.MethodPatch, "/", "application/json-patch+json",
+		[]byte(`[{"op": "test", "path": "/name", "value": "Jane"}]`))
+	assert.Equal(http.StatusConflict, rec.Code)
+
+	rec = do(http.MethodPatch, "/", "application/json-patch+json",
+		[]byte(`[{"op": "remove", "path": "/foo"}]`))
+	assert.Equal(http.StatusUnprocessableEntity, rec.Code)
+
+	rec = do(http.MethodPatch, "/missing", "application/cbor-patch", cborPatch)
+	assert.Equal(http.StatusNotFound, rec.Code)
+
+	// the options apply to both patches and merge patches.
+	h.Options = NewOptions()
+	h.Options.ResultValidator = ResultValidatorFunc(func(doc []byte) error {
+		if NewNode(doc).Exists(PathMustFrom("tags"), nil) {
+			return errors.New("tags are read-only")
+		}
+		return nil
+	})
+	rec = do(http.MethodPatch, "/", "application/merge-patch+cbor", MustFromJSON(`{"tags": {"a": 1}}`))
+	assert.Equal(http.StatusUnprocessableEntity, rec.Code)
+	assert.Contains(rec.Body.String(), "tags are read-only")
+	rec = do(http.MethodPatch, "/", "application/json-patch+json",
+		[]byte(`[{"op": "add", "path": "/tags", "value": {"a": 1}}]`))
+	assert.Equal(http.StatusUnprocessableEntity, rec.Code)
+	rec = do(http.MethodPatch, "/", "application/merge-patch+cbor", MustFromJSON(`{"age": 25}`))
+	assert.Equal(http.StatusOK, rec.Code)
+	h.Options = nil
+
+	h.Save = func(r *http.Request, doc []byte) error {
+		return errors.New("save failed")
+	}
+	rec = do(http.MethodPatch, "/", "application/cbor-patch", cborPatch)
+	assert.Equal(http.StatusInternalServerError, rec.Code)
+}
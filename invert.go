@@ -0,0 +1,438 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"fmt"
+)
+
+// Invert returns the inverse patch of p for the original document doc,
+// applying it to the patched document restores the original document.
+//
+//	"add" and "copy" are inverted to "remove", or "replace" with the old value if a map key is overwritten,
+//	"remove" is inverted to "add" with the old value,
+//	"replace" and "merge" are inverted to "replace" with the old value,
+//	"move" is inverted to "move" back, or "replace" and "add" if a map key is overwritten,
+//	"append" is inverted to "splice" that removes the appended elements, or "replace" with the old string,
+//	"splice" is inverted to "splice" that removes the inserted elements and inserts the removed ones,
+//	"incr" and "decr" on an integer are inverted to each other, or "replace" with the old float,
+//	"toggle" is inverted to itself,
+//	"text-diff" and "binary-diff" are inverted to the same operation that changes the new value back,
+//	"test" and the other test operations are dropped.
+//
+// Array indexes in the inverse patch are resolved, "-" is converted to the index appended to.
+// The patch is applied with the default options, use ApplyWithRevert for other options.
+func (p Patch) Invert(doc []byte) (Patch, error) {
+	_, revert, err := p.ApplyWithRevert(doc, NewOptions())
+	return revert, err
+}
+
+// ApplyWithRevert mutates a CBOR document according to the patch and the passed in Options,
+// like ApplyWithOptions. It returns the new document and the revert patch, which is built
+// from the old values captured while applying, see Invert.
+// The parents created by EnsurePathExistsOnAdd are removed by the revert patch.
+func (p Patch) ApplyWithRevert(doc []byte, options *Options) ([]byte, Patch, error) {
+	node := NewNode(doc)
+	revert := make(Patch, 0, len(p))
+	if err := node.patch(p, options, &revert); err != nil {
+		return nil, nil, err
+	}
+
+	data, err := node.MarshalCBOR()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i, j := 0, len(revert)-1; i < j; i, j = i+1, j-1 {
+		revert[i], revert[j] = revert[j], revert[i]
+	}
+	return data, revert, nil
+}
+
+// invert applies the operation to the document, and returns its inverse operations in reverse order.
+func (p Patch) invert(doc *container, op *Operation, accumulatedCopySize *int64, options *Options) (Patch, error) {
+	var ops Patch
+	var err error
+
+	switch op.Op {
+	case OpAdd, OpCopy:
+		if op.Op == OpAdd && options.EnsurePathExistsOnAdd {
+			ops = invertEnsurePath(*doc, op.Path, options)
+		}
+		if ops == nil {
+			ops, err = invertAdd(*doc, op.Op, op.Path, options)
+		}
+
+	case OpRemove:
+		var o *Operation
+		if o, err = invertRemove(*doc, op.Op, op.Path, options); err == nil {
+			ops = Patch{o}
+		}
+
+	case OpReplace, OpMerge:
+		var o *Operation
+		if o, err = invertReplace(*doc, op.Path, options); err == nil {
+			ops = Patch{o}
+		}
+
+	case OpAppend:
+		ops, err = invertAppend(*doc, op, options)
+
+	case OpSplice:
+		ops, err = invertSplice(*doc, op, options)
+
+	case OpIncr, OpDecr:
+		ops, err = invertIncr(*doc, op, options)
+
+	case OpToggle:
+		ops = Patch{{Op: OpToggle, Path: op.Path}}
+
+	case OpTextDiff:
+		ops, err = invertTextDiff(*doc, op, options)
+
+	case OpBinaryDiff:
+		ops, err = invertBinaryDiff(*doc, op, options)
+
+	case OpMove:
+		return p.invertMove(doc, op, options)
+	}
+
+	if err != nil {
+		// prefer the error of applying the operation.
+		if e := p.apply(doc, op, accumulatedCopySize, options); e != nil {
+			return nil, e
+		}
+		if op.Op == OpRemove && options.AllowMissingPathOnRemove {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if err = p.apply(doc, op, accumulatedCopySize, options); err != nil {
+		return nil, err
+	}
+	return ops, nil
+}
+
+// invertMove inverts a "move" operation as a "remove" followed by an "add" on the intermediate document.
+func (p Patch) invertMove(doc *container, op *Operation, options *Options) (Patch, error) {
+	undoRemove, err := invertRemove(*doc, op.Op, op.From, options)
+	if err != nil {
+		if e := p.move(doc, op, options); e != nil {
+			return nil, e
+		}
+		return nil, err
+	}
+
+	con, key := findObject(doc, op.From, options)
+	val, err := con.get(key, options)
+	if err != nil {
+		return nil, fmt.Errorf("move operation does not apply for from %s, %w", op.From, err)
+	}
+	if err = con.remove(key, options); err != nil {
+		return nil, fmt.Errorf("move operation does not apply for from %s, %w", op.From, err)
+	}
+
+	undoAdd, err := invertAdd(*doc, op.Op, op.Path, options)
+	if err != nil {
+		return nil, fmt.Errorf("move operation does not apply for path %s, %w", op.Path, err)
+	}
+
+	con, key = findObject(doc, op.Path, options)
+	if err = con.add(key, val, options); err != nil {
+		return nil, fmt.Errorf("move operation does not apply for path %s, %w", op.Path, err)
+	}
+
+	if undoAdd[0].Op == OpRemove {
+		return Patch{{Op: OpMove, From: undoAdd[0].Path, Path: undoRemove.Path}}, nil
+	}
+	return Patch{undoRemove, undoAdd[0]}, nil
+}
+
+func invertAdd(doc container, op Op, path Path, options *Options) (Patch, error) {
+	con, key := findObject(&doc, path, options)
+	if con == nil {
+		return nil, fmt.Errorf("unable to invert %s operation for %s, %w", op, path, ErrMissing)
+	}
+
+	rk, err := invertKey(con, key, true)
+	if err != nil {
+		return nil, fmt.Errorf("unable to invert %s operation for %s, %w", op, path, err)
+	}
+	rp := append(path[:len(path)-1:len(path)-1], rk)
+
+	if _, ok := con.(*partialDoc); ok {
+		if old, err := con.get(key, options); err == nil {
+			val, err := old.MarshalCBOR()
+			if err != nil {
+				return nil, err
+			}
+			return Patch{{Op: OpReplace, Path: rp, Value: val}}, nil
+		}
+	}
+	return Patch{{Op: OpRemove, Path: rp}}, nil
+}
+
+func invertRemove(doc container, op Op, path Path, options *Options) (*Operation, error) {
+	con, key := findObject(&doc, path, options)
+	if con == nil {
+		return nil, fmt.Errorf("unable to invert %s operation for %s, %w", op, path, ErrMissing)
+	}
+
+	old, err := con.get(key, options)
+	if err != nil {
+		return nil, fmt.Errorf("unable to invert %s operation for %s, %w", op, path, err)
+	}
+	val, err := old.MarshalCBOR()
+	if err != nil {
+		return nil, err
+	}
+
+	rk, err := invertKey(con, key, false)
+	if err != nil {
+		return nil, fmt.Errorf("unable to invert %s operation for %s, %w", op, path, err)
+	}
+	return &Operation{Op: OpAdd, Path: append(path[:len(path)-1:len(path)-1], rk), Value: val}, nil
+}
+
+func invertReplace(doc container, path Path, options *Options) (*Operation, error) {
+	if len(path) == 0 {
+		val, err := cborMarshal(doc)
+		if err != nil {
+			return nil, err
+		}
+		return &Operation{Op: OpReplace, Path: Path{}, Value: val}, nil
+	}
+
+	o, err := invertRemove(doc, OpReplace, path, options)
+	if err != nil {
+		return nil, err
+	}
+	o.Op = OpReplace
+	return o, nil
+}
+
+func invertAppend(doc container, op *Operation, options *Options) (Patch, error) {
+	cur, err := invertTarget(doc, op, options)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = cur.intoContainer(); err == nil && cur.which == eAry {
+		var elems []RawMessage
+		if err = cborUnmarshal(op.Value, &elems); err != nil {
+			return nil, err
+		}
+		return Patch{{Op: OpSplice, Path: op.Path, Index: len(cur.ary), RemoveCount: len(elems)}}, nil
+	}
+
+	val, err := cur.MarshalCBOR()
+	if err != nil {
+		return nil, err
+	}
+	return Patch{{Op: OpReplace, Path: op.Path, Value: val}}, nil
+}
+
+func invertSplice(doc container, op *Operation, options *Options) (Patch, error) {
+	cur, err := invertTarget(doc, op, options)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = cur.intoContainer(); err != nil || cur.which != eAry {
+		return nil, fmt.Errorf("unable to invert %s operation for %s, %w", op.Op, op.Path, ErrInvalid)
+	}
+	idx, err := spliceIndex(op.Index, op.RemoveCount, len(cur.ary), options)
+	if err != nil {
+		return nil, fmt.Errorf("unable to invert %s operation for %s, %w", op.Op, op.Path, err)
+	}
+
+	var elems []RawMessage
+	if op.Value != nil {
+		if err = cborUnmarshal(op.Value, &elems); err != nil {
+			return nil, err
+		}
+	}
+	removed, err := cborMarshal(cur.ary[idx : idx+op.RemoveCount])
+	if err != nil {
+		return nil, err
+	}
+	return Patch{{Op: OpSplice, Path: op.Path, Index: idx, RemoveCount: len(elems), Value: removed}}, nil
+}
+
+func invertIncr(doc container, op *Operation, options *Options) (Patch, error) {
+	cur, err := invertTarget(doc, op, options)
+	if err != nil {
+		return nil, err
+	}
+
+	val, err := cur.MarshalCBOR()
+	if err != nil {
+		return nil, err
+	}
+	var v, delta any
+	if err = cborUnmarshal(val, &v); err != nil {
+		return nil, err
+	}
+	if err = cborUnmarshal(op.Value, &delta); err != nil {
+		return nil, err
+	}
+
+	_, ok1 := bigIntOf(v)
+	_, ok2 := bigIntOf(delta)
+	if ok1 && ok2 {
+		rop := OpDecr
+		if op.Op == OpDecr {
+			rop = OpIncr
+		}
+		return Patch{{Op: rop, Path: op.Path, Value: op.Value}}, nil
+	}
+	// float arithmetic may lose precision, restores the old value.
+	return Patch{{Op: OpReplace, Path: op.Path, Value: val}}, nil
+}
+
+func invertTextDiff(doc container, op *Operation, options *Options) (Patch, error) {
+	cur, err := invertTarget(doc, op, options)
+	if err != nil {
+		return nil, err
+	}
+
+	val, err := cur.MarshalCBOR()
+	if err != nil {
+		return nil, err
+	}
+	var text string
+	var script []any
+	if err = cborUnmarshal(val, &text); err != nil {
+		return nil, fmt.Errorf("unable to invert %s operation for %s, %w", op.Op, op.Path, err)
+	}
+	if err = cborUnmarshal(op.Value, &script); err != nil {
+		return nil, err
+	}
+	res, err := applyTextDiff(text, script)
+	if err != nil {
+		return nil, fmt.Errorf("unable to invert %s operation for %s, %w", op.Op, op.Path, err)
+	}
+
+	if val, err = cborMarshal(TextDiff(res, text)); err != nil {
+		return nil, err
+	}
+	return Patch{{Op: OpTextDiff, Path: op.Path, Value: val}}, nil
+}
+
+func invertBinaryDiff(doc container, op *Operation, options *Options) (Patch, error) {
+	cur, err := invertTarget(doc, op, options)
+	if err != nil {
+		return nil, err
+	}
+
+	val, err := cur.MarshalCBOR()
+	if err != nil {
+		return nil, err
+	}
+	if ReadCBORType(val) != CBORTypeByteString {
+		return nil, fmt.Errorf("unable to invert %s operation for %s, %w", op.Op, op.Path, ErrInvalid)
+	}
+	var data []byte
+	var delta []any
+	if err = cborUnmarshal(val, &data); err != nil {
+		return nil, err
+	}
+	if err = cborUnmarshal(op.Value, &delta); err != nil {
+		return nil, err
+	}
+	res, err := applyBinaryDiff(data, delta)
+	if err != nil {
+		return nil, fmt.Errorf("unable to invert %s operation for %s, %w", op.Op, op.Path, err)
+	}
+
+	if val, err = cborMarshal(BinaryDiff(res, data)); err != nil {
+		return nil, err
+	}
+	return Patch{{Op: OpBinaryDiff, Path: op.Path, Value: val}}, nil
+}
+
+// invertTarget returns the target node of an "append", "splice", "incr", "decr", "text-diff" or "binary-diff" operation, a root array is wrapped in a node.
+func invertTarget(doc container, op *Operation, options *Options) (*Node, error) {
+	if len(op.Path) == 0 {
+		ary, ok := doc.(*partialArray)
+		if !ok {
+			return nil, fmt.Errorf("unable to invert %s operation for %s, %w", op.Op, op.Path, ErrInvalid)
+		}
+		return &Node{ary: *ary, ty: CBORTypeArray, which: eAry}, nil
+	}
+
+	con, key := findObject(&doc, op.Path, options)
+	if con == nil {
+		return nil, fmt.Errorf("unable to invert %s operation for %s, %w", op.Op, op.Path, ErrMissing)
+	}
+	cur, err := con.get(key, options)
+	if err != nil {
+		return nil, fmt.Errorf("unable to invert %s operation for %s, %w", op.Op, op.Path, err)
+	}
+	return cur, nil
+}
+
+// invertEnsurePath returns the inverse operations of creating the missing parents of path
+// by ensurePathExists, or nil if there is no missing parent.
+func invertEnsurePath(doc container, path Path, options *Options) Patch {
+	if len(path) == 0 {
+		return nil
+	}
+
+	for i, key := range path[:len(path)-1] {
+		next, err := doc.get(key, options)
+		if err == nil && next != nil {
+			if doc, _ = next.intoContainer(); doc == nil {
+				return nil
+			}
+			continue
+		}
+
+		pa, ok := doc.(*partialArray)
+		if !ok {
+			return Patch{{Op: OpRemove, Path: path[: i+1 : i+1]}}
+		}
+
+		// the array is padded with nulls up to the index.
+		idx, err := key.toInt()
+		if err != nil || idx < pa.len() {
+			return nil
+		}
+		rp := append(path[:i:i], encodeArrayIdx(pa.len()))
+		ops := make(Patch, 0, idx-pa.len()+1)
+		for j := pa.len(); j <= idx; j++ {
+			ops = append(ops, &Operation{Op: OpRemove, Path: rp})
+		}
+		return ops
+	}
+	return nil
+}
+
+// invertKey resolves the array index "-" and negative indexes to absolute indexes.
+func invertKey(con container, key RawKey, adding bool) (RawKey, error) {
+	if _, ok := con.(*partialArray); !ok {
+		return key, nil
+	}
+
+	sz := con.len()
+	if adding {
+		if key == minus {
+			return encodeArrayIdx(sz), nil
+		}
+		sz++
+	}
+
+	idx, err := key.toInt()
+	if err != nil {
+		return "", err
+	}
+	if idx < 0 {
+		idx += sz
+	}
+	if idx < 0 || idx >= sz {
+		return "", fmt.Errorf("unable to access invalid index %d, %w", idx, ErrInvalidIndex)
+	}
+	return encodeArrayIdx(idx), nil
+}
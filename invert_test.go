@@ -0,0 +1,249 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPatchInvert(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, c := range []struct {
+		doc, patch, inverse string
+	}{
+		{`{"a": 1}`, `[{"op":"add","path":"/b","value":2}]`, `[{"op":"remove","path":"/b"}]`},
+		{`{"a": 1}`, `[{"op":"add","path":"/a","value":2}]`, `[{"op":"replace","path":"/a","value":1}]`},
+		{`{"a": [1, 2]}`, `[{"op":"add","path":"/a/-","value":3}]`, `[{"op":"remove","path":"/a/2"}]`},
+		{`{"a": [1, 2]}`, `[{"op":"remove","path":"/a/0"}]`, `[{"op":"add","path":"/a/0","value":1}]`},
+		{`{"a": {"b": 1}}`, `[{"op":"replace","path":"/a/b","value":2}]`,
+			`[{"op":"replace","path":"/a/b","value":1}]`},
+		{`{"a": 1}`, `[{"op":"replace","path":"","value":[1]}]`, `[{"op":"replace","path":"","value":{"a":1}}]`},
+		{`{"a": {"b": 1, "c": 2}}`, `[{"op":"merge","path":"/a","value":{"b":null,"d":3}}]`,
+			`[{"op":"replace","path":"/a","value":{"b":1,"c":2}}]`},
+		{`{"a": [1], "b": "x"}`, `[{"op":"append","path":"/a","value":[2, 3]},{"op":"append","path":"/b","value":"yz"}]`,
+			`[{"op":"replace","path":"/b","value":"x"},{"op":"splice","path":"/a","index":1,"remove-count":2}]`},
+		{`[0, 1, 2, 3]`, `[{"op":"splice","path":"","index":1,"remove-count":2,"value":["a"]}]`,
+			`[{"op":"splice","path":"","index":1,"remove-count":1,"value":[1,2]}]`},
+		{`{"a": 1, "b": [0.5]}`, `[{"op":"incr","path":"/a","value":2},{"op":"decr","path":"/b/0","value":0.25}]`,
+			`[{"op":"replace","path":"/b/0","value":0.5},{"op":"decr","path":"/a","value":2}]`},
+		{`{"a": [true]}`, `[{"op":"toggle","path":"/a/0"}]`, `[{"op":"toggle","path":"/a/0"}]`},
+		{`{"a": "hello world"}`, `[{"op":"text-diff","path":"/a","value":[4,-1,", w"]}]`,
+			`[{"op":"text-diff","path":"/a","value":[4,-3,"o"]}]`},
+		{`{"a": [1, 2], "b": {}}`, `[{"op":"move","from":"/a/0","path":"/b/x"}]`,
+			`[{"op":"move","from":"/b/x","path":"/a/0"}]`},
+		{`{"a": 1, "b": 2}`, `[{"op":"move","from":"/a","path":"/b"}]`,
+			`[{"op":"replace","path":"/b","value":2},{"op":"add","path":"/a","value":1}]`},
+		{`{"a": [1]}`, `[{"op":"copy","from":"/a/0","path":"/a/-"},{"op":"test","path":"/a/1","value":1}]`,
+			`[{"op":"remove","path":"/a/1"}]`},
+		{`{"a": [1, 2, 3], "b": {"c": "d"}}`,
+			`[{"op":"remove","path":"/a/1"},{"op":"add","path":"/b/e","value":[]},{"op":"move","from":"/a/0","path":"/b/e/0"},{"op":"replace","path":"/b/c","value":null}]`,
+			`[{"op":"replace","path":"/b/c","value":"d"},{"op":"move","from":"/b/e/0","path":"/a/0"},{"op":"remove","path":"/b/e"},{"op":"add","path":"/a/1","value":2}]`},
+	} {
+		doc := MustFromJSON(c.doc)
+		p, err := PatchFromJSON(c.patch)
+		assert.NoError(err)
+
+		inv, err := p.Invert(doc)
+		if !assert.NoError(err) {
+			continue
+		}
+
+		data, err := PatchToJSON(inv)
+		assert.NoError(err)
+		assert.Equal(c.inverse, string(data))
+
+		patched, err := p.Apply(doc)
+		assert.NoError(err)
+		restored, err := inv.Apply(patched)
+		assert.NoError(err)
+		assert.True(Equal(doc, restored), "restored %s, expected %s", MustToJSON(restored), c.doc)
+	}
+
+	for _, c := range []struct {
+		doc, patch string
+	}{
+		{`{"a": 1}`, `[{"op":"remove","path":"/b"}]`},
+		{`{"a": 1}`, `[{"op":"add","path":"/b/c","value":1}]`},
+		{`{"a": [1]}`, `[{"op":"add","path":"/a/2","value":1}]`},
+		{`{"a": 1}`, `[{"op":"test","path":"/a","value":2}]`},
+	} {
+		p, err := PatchFromJSON(c.patch)
+		assert.NoError(err)
+		_, err = p.Invert(MustFromJSON(c.doc))
+		assert.Error(err)
+	}
+}
+
+func TestPatchApplyWithRevert(t *testing.T) {
+	assert := assert.New(t)
+
+	options := NewOptions()
+	options.EnsurePathExistsOnAdd = true
+	options.AllowMissingPathOnRemove = true
+	options.SupportNegativeIndices = true
+
+	for _, c := range []struct {
+		doc, patch, revert string
+	}{
+		{`{"a": 1}`, `[{"op":"add","path":"/b/c/d","value":2}]`, `[{"op":"remove","path":"/b"}]`},
+		{`{"a": [1]}`, `[{"op":"add","path":"/a/3/x","value":2}]`,
+			`[{"op":"remove","path":"/a/1"},{"op":"remove","path":"/a/1"},{"op":"remove","path":"/a/1"}]`},
+		{`{"a": 1}`, `[{"op":"remove","path":"/b"},{"op":"remove","path":"/a"}]`, `[{"op":"add","path":"/a","value":1}]`},
+		{`{"a": [1, 2, 3]}`, `[{"op":"remove","path":"/a/-1"},{"op":"add","path":"/a/-1","value":4}]`,
+			`[{"op":"remove","path":"/a/2"},{"op":"add","path":"/a/2","value":3}]`},
+		{`{"a": [1, 2, 3]}`, `[{"op":"move","from":"/a/0","path":"/a/-"}]`,
+			`[{"op":"move","from":"/a/2","path":"/a/0"}]`},
+	} {
+		doc := MustFromJSON(c.doc)
+		p, err := PatchFromJSON(c.patch)
+		assert.NoError(err)
+
+		patched, revert, err := p.ApplyWithRevert(doc, options)
+		if !assert.NoError(err) {
+			continue
+		}
+		expected, err := p.ApplyWithOptions(doc, options)
+		assert.NoError(err)
+		assert.Equal(expected, patched)
+
+		data, err := PatchToJSON(revert)
+		assert.NoError(err)
+		assert.Equal(c.revert, string(data))
+
+		restored, err := revert.ApplyWithOptions(patched, options)
+		assert.NoError(err)
+		assert.True(Equal(doc, restored), "restored %s, expected %s", MustToJSON(restored), c.doc)
+	}
+
+	options = NewOptions()
+	options.AccumulatedCopySizeLimit = 6
+	p, err := PatchFromJSON(`[{"op":"copy","from":"/a","path":"/b"},{"op":"copy","from":"/a","path":"/c"}]`)
+	assert.NoError(err)
+	_, _, err = p.ApplyWithRevert(MustFromJSON(`{"a": "abcd"}`), options)
+	assert.ErrorContains(err, "exceeding the limit 6")
+
+	p, err = PatchFromJSON(`[{"op":"move","from":"/x","path":"/b"}]`)
+	assert.NoError(err)
+	_, _, err = p.ApplyWithRevert(MustFromJSON(`{"a": 1}`), nil)
+	assert.ErrorContains(err, "move operation does not apply for from")
+}
+
+func TestRandomMoveRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+
+	rnd := rand.New(rand.NewSource(1))
+	var randValue func(depth int) any
+	randValue = func(depth int) any {
+		if depth == 0 || rnd.Intn(3) == 0 {
+			return rnd.Intn(100)
+		}
+		v := make([]any, rnd.Intn(4))
+		for i := range v {
+			v[i] = randValue(depth - 1)
+		}
+		return v
+	}
+	// randPath returns a random path to an element in v, or to the end of an array if end is true.
+	var randPath func(v any, end bool) []int
+	randPath = func(v any, end bool) []int {
+		ary, ok := v.([]any)
+		if !ok {
+			return nil
+		}
+		n := len(ary)
+		if end {
+			n++
+		}
+		if n == 0 {
+			return nil
+		}
+		i := rnd.Intn(n)
+		if i < len(ary) && rnd.Intn(2) == 0 {
+			if sub := randPath(ary[i], end); sub != nil {
+				return append([]int{i}, sub...)
+			}
+		}
+		return []int{i}
+	}
+	// splice removes the element at path in v if val is nil, or inserts val at path.
+	var splice func(v any, path []int, val any) (any, any)
+	splice = func(v any, path []int, val any) (any, any) {
+		ary := append([]any{}, v.([]any)...)
+		i := path[0]
+		if len(path) > 1 {
+			var removed any
+			ary[i], removed = splice(ary[i], path[1:], val)
+			return ary, removed
+		}
+		if val == nil {
+			removed := ary[i]
+			return append(ary[:i], ary[i+1:]...), removed
+		}
+		return append(ary[:i], append([]any{val}, ary[i:]...)...), nil
+	}
+
+	for n := 0; n < 500; n++ {
+		var doc any = []any{randValue(3), randValue(3), randValue(3)}
+		expected := doc
+		var p Patch
+		for len(p) < 3 {
+			from := randPath(expected, false)
+			if from == nil {
+				continue
+			}
+			rest, val := splice(expected, from, nil)
+			path := randPath(rest, true)
+			if path == nil {
+				continue
+			}
+			expected, _ = splice(rest, path, val)
+
+			op := &Operation{Op: OpMove, From: Path{}, Path: Path{}}
+			for _, i := range from {
+				op.From = op.From.withIndex(i)
+			}
+			for _, i := range path {
+				op.Path = op.Path.withIndex(i)
+			}
+			p = append(p, op)
+		}
+
+		data := MustMarshal(doc)
+		patched, err := p.Apply(data)
+		if !assert.NoError(err) {
+			continue
+		}
+		assert.True(Equal(MustMarshal(expected), patched), "patched %s with %v, expected %s",
+			Diagify(patched), p, Diagify(MustMarshal(expected)))
+
+		inv, err := p.Invert(data)
+		assert.NoError(err)
+		restored, err := inv.Apply(patched)
+		assert.NoError(err)
+		assert.True(Equal(data, restored), "restored %s, expected %s", Diagify(restored), Diagify(data))
+
+		res, revert, err := p.ApplyWithRevert(data, nil)
+		assert.NoError(err)
+		assert.Equal(patched, res)
+		restored, err = revert.Apply(res)
+		assert.NoError(err)
+		assert.True(Equal(data, restored), "reverted %s, expected %s", Diagify(restored), Diagify(data))
+
+		optimized, err := p.Optimize(data)
+		assert.NoError(err)
+		res, err = optimized.Apply(data)
+		assert.NoError(err)
+		assert.True(Equal(patched, res), "optimized %s, expected %s", Diagify(res), Diagify(patched))
+
+		composed, err := ComposePatches(p, inv)
+		assert.NoError(err)
+		res, err = composed.Apply(data)
+		assert.NoError(err)
+		assert.True(Equal(data, res), "composed %s, expected %s", Diagify(res), Diagify(data))
+	}
+}
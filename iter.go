@@ -0,0 +1,57 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+//go:build go1.23
+
+package cborpatch
+
+import (
+	"iter"
+)
+
+// Entries returns an iterator over the keys and values of a map node in the bytewise order of the keys,
+// or the preserved order, see Options.PreserveKeyOrder.
+// It yields nothing if the node is not a map. The node is decoded lazily when iterated.
+func (n *Node) Entries() iter.Seq2[RawKey, *Node] {
+	return func(yield func(RawKey, *Node) bool) {
+		if n == nil {
+			return
+		}
+		if _, err := n.intoContainer(); err != nil || n.which != eDoc {
+			return
+		}
+		for _, k := range n.doc.orderedKeys() {
+			v, ok := n.doc.obj[k]
+			if !ok {
+				continue
+			}
+			if v == nil {
+				v = NewNode(nil)
+			}
+			if !yield(k, v) {
+				return
+			}
+		}
+	}
+}
+
+// Items returns an iterator over the indexes and elements of an array node.
+// It yields nothing if the node is not an array. The node is decoded lazily when iterated.
+func (n *Node) Items() iter.Seq2[int, *Node] {
+	return func(yield func(int, *Node) bool) {
+		if n == nil {
+			return
+		}
+		if _, err := n.intoContainer(); err != nil || n.which != eAry {
+			return
+		}
+		for i, v := range n.ary {
+			if v == nil {
+				v = NewNode(nil)
+			}
+			if !yield(i, v) {
+				return
+			}
+		}
+	}
+}
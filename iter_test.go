@@ -0,0 +1,48 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+//go:build go1.23
+
+package cborpatch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNodeIterators(t *testing.T) {
+	assert := assert.New(t)
+
+	node := NewNode(MustFromJSON(`{ "b": [1, null, "x"], "a": 2 }`))
+	var keys []string
+	node.Entries()(func(k RawKey, v *Node) bool {
+		keys = append(keys, k.String()+"="+v.String())
+		return true
+	})
+	assert.Equal([]string{`"a"=2`, `"b"=[1, null, "x"]`}, keys)
+
+	child, err := node.GetChild(PathMustFromJSON("/b"), nil)
+	assert.NoError(err)
+	var items []string
+	child.Items()(func(i int, v *Node) bool {
+		items = append(items, v.String())
+		return i < 1
+	})
+	assert.Equal([]string{"1", "null"}, items)
+
+	count := 0
+	node.Items()(func(int, *Node) bool {
+		count++
+		return true
+	})
+	child.Entries()(func(RawKey, *Node) bool {
+		count++
+		return true
+	})
+	NewNode(MustMarshal(1)).Entries()(func(RawKey, *Node) bool {
+		count++
+		return true
+	})
+	assert.Equal(0, count)
+}
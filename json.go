@@ -4,7 +4,7 @@
 package cborpatch
 
 import (
-	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -27,26 +27,27 @@ import (
 //	JSON objects decode to map[string]any.
 //	JSON null decode to nil.
 func FromJSON(doc []byte, v any) ([]byte, error) {
+	return FromJSONWithOptions(doc, v, nil)
+}
+
+// FromJSONWithOptions is like FromJSON, but encodes the CBOR-encoded data with options.EncMode if it is set.
+func FromJSONWithOptions(doc []byte, v any, options *Options) ([]byte, error) {
 	if len(doc) == 0 {
 		return doc, nil
 	}
 
-	var err error
+	c := newCodec(options)
 	if v == nil {
 		if !json.Valid(doc) {
 			return nil, fmt.Errorf("invalid JSON document")
 		}
+		return jsonToCBOR(doc, c)
+	}
 
-		dec := json.NewDecoder(bytes.NewReader(doc))
-		dec.UseNumber()
-		if v, err = readJSONValue(dec); err != nil {
-			return nil, err
-		}
-
-	} else if err = json.Unmarshal(doc, v); err != nil {
+	if err := json.Unmarshal(doc, v); err != nil {
 		return nil, err
 	}
-	return cborMarshal(v)
+	return c.Marshal(v)
 }
 
 // MustFromJSON converts a JSON-encoded string to a CBOR-encoded data.
@@ -62,18 +63,26 @@ func MustFromJSON(doc string) []byte {
 // ToJSON converts a CBOR-encoded data to a JSON-encoded data with a optional value as struct container.
 // If v is not nil, it will decode data into v and then encode v to JSON-encoded data.
 func ToJSON(doc []byte, v any) ([]byte, error) {
+	return ToJSONWithOptions(doc, v, nil)
+}
+
+// ToJSONWithOptions is like ToJSON, but decodes the CBOR-encoded data with options.DecMode if it is set.
+func ToJSONWithOptions(doc []byte, v any, options *Options) ([]byte, error) {
 	if len(doc) == 0 {
 		return doc, nil
 	}
 
+	c := newCodec(options)
 	if v != nil {
-		if err := cborUnmarshal(doc, v); err != nil {
+		if err := c.Unmarshal(doc, v); err != nil {
 			return nil, err
 		}
 		return json.Marshal(v)
 	}
 
-	return json.Marshal(NewNode(doc))
+	node := NewNode(doc)
+	node.useCodec(c)
+	return json.Marshal(node)
 }
 
 // MustToJSON converts a CBOR-encoded data to a JSON-encoded string.
@@ -86,6 +95,20 @@ func MustToJSON(doc []byte) string {
 	return string(data)
 }
 
+// PathFromJSON converts a JSON Pointer (RFC 6901) to a Path.
+// A reference token that is an integer is converted to an integer key, and
+// the following extended escapes are supported for keys that are not text strings:
+//
+//	"~u" followed by a non-negative integer is an unsigned integer key, such as "~u18446744073709551615",
+//	"~i" followed by an integer is an integer key, such as "~i-18446744073709551616",
+//	"~s" followed by a reference token is a text string key, such as "~s0" for "0",
+//	"~b" followed by a base64url encoded string without padding is a byte string key,
+//	"~c" followed by a base64url encoded raw CBOR value without padding is a key of any type,
+//	such as "~c9Q" for true, see Options.AllowAnyMapKey.
+//
+// A Relative JSON Pointer, such as "1/name", is invalid. It is only accepted as the "from" of
+// "move" and "copy" operations by PatchFromJSONWithOptions and NewPatchWithOptions
+// with Options.AllowRelativeFrom.
 func PathFromJSON(jsonpath string) (Path, error) {
 	if jsonpath == "" {
 		return Path{}, nil
@@ -98,30 +121,200 @@ func PathFromJSON(jsonpath string) (Path, error) {
 	parts := strings.Split(jsonpath[1:], "/")
 	path := make(Path, len(parts))
 	for i, part := range parts {
-		token := rfc6901Decoder.Replace(part)
-		if len(token) > 0 {
-			switch token[0] {
-			case '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
-				if v, err := strconv.Atoi(token); err == nil {
-					data, err := cborMarshal(v)
-					if err != nil {
-						return nil, err
-					}
-
-					path[i] = RawKey(data)
-					continue
-				}
+		key, err := DecodePointerToken(part)
+		if err != nil {
+			return nil, fmt.Errorf("%w in JSON Pointer %q", err, jsonpath)
+		}
+		path[i] = key
+	}
+	return path, nil
+}
+
+// relativePathFromJSON is like PathFromJSON, but converts a Relative JSON Pointer that begins with
+// the levels to go up, such as "1/name", to a relative path, see RelativePath.
+// The index manipulations and the "#" suffix are not supported.
+func relativePathFromJSON(jsonpath string) (Path, error) {
+	if jsonpath == "" || jsonpath[0] < '0' || jsonpath[0] > '9' {
+		return PathFromJSON(jsonpath)
+	}
+
+	prefix, pointer := jsonpath, ""
+	if i := strings.IndexByte(jsonpath, '/'); i > 0 {
+		prefix, pointer = jsonpath[:i], jsonpath[i:]
+	}
+	up, err := strconv.ParseUint(prefix, 10, 64)
+	if err != nil || len(prefix) > 1 && prefix[0] == '0' {
+		return nil, fmt.Errorf("invalid Relative JSON Pointer %q", jsonpath)
+	}
+	rest, err := PathFromJSON(pointer)
+	if err != nil {
+		return nil, err
+	}
+	return append(Path{relativeKey(up)}, rest...), nil
+}
+
+// DecodePointerToken converts a reference token of a JSON Pointer to a map key or an array index,
+// with the extended escapes of PathFromJSON. It is the reverse of EncodePointerToken, such as:
+//
+//	"name" and "a~1b" are the text string keys "name" and "a/b",
+//	"0" and "-1" are the integer keys or array indexes 0 and -1, and "-" is the text string "-",
+//	"~u18446744073709551615" and "~i18446744073709551615" are the integer key 18446744073709551615,
+//	"~s0" is the text string key "0",
+//	"~bAQI" is the byte string key h'0102',
+//	"~c9Q" is the key true.
+func DecodePointerToken(token string) (RawKey, error) {
+	if len(token) >= 2 && token[0] == '~' {
+		var key any
+		switch token[1] {
+		case 'u':
+			n, err := strconv.ParseUint(token[2:], 10, 64)
+			if err != nil {
+				return "", fmt.Errorf("invalid unsigned integer token %q", token)
+			}
+			key = n
+		case 's':
+			key = rfc6901Decoder.Replace(token[2:])
+		case 'i':
+			n, ok := new(big.Int).SetString(token[2:], 10)
+			if !ok {
+				return "", fmt.Errorf("invalid integer token %q", token)
 			}
+			key = n
+		case 'b':
+			b, err := base64.RawURLEncoding.DecodeString(token[2:])
+			if err != nil {
+				return "", fmt.Errorf("invalid byte string token %q, %w", token, err)
+			}
+			key = ByteString(b)
+		case 'c':
+			data, err := base64.RawURLEncoding.DecodeString(token[2:])
+			if err == nil {
+				err = cborValid(data)
+			}
+			if err != nil {
+				return "", fmt.Errorf("invalid CBOR token %q, %w", token, err)
+			}
+			return RawKey(data), nil
 		}
 
-		data, err := cborMarshal(token)
-		if err != nil {
-			return nil, err
+		if key != nil {
+			data, err := cborMarshal(key)
+			if err != nil {
+				return "", err
+			}
+			rk := RawKey(data)
+			if err = rk.Valid(); err != nil {
+				return "", fmt.Errorf("invalid token %q, %w", token, err)
+			}
+			return rk, nil
 		}
-		path[i] = RawKey(data)
 	}
 
-	return path, nil
+	var key any = rfc6901Decoder.Replace(token)
+	if s := key.(string); len(s) > 0 {
+		switch s[0] {
+		case '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+			if v, err := strconv.Atoi(s); err == nil {
+				key = v
+			}
+		}
+	}
+
+	data, err := cborMarshal(key)
+	if err != nil {
+		return "", err
+	}
+	return RawKey(data), nil
+}
+
+// PathToJSON converts a Path to a JSON Pointer, with the extended escapes of PathFromJSON.
+// A relative path is converted to a Relative JSON Pointer, such as "1/name".
+func PathToJSON(p Path) string {
+	buf := &strings.Builder{}
+	if up, rest, ok := p.relative(); ok {
+		buf.WriteString(strconv.FormatUint(up, 10))
+		p = rest
+	}
+	for _, k := range p {
+		buf.WriteByte('/')
+		buf.WriteString(EncodePointerToken(k))
+	}
+	return buf.String()
+}
+
+// MarshalJSON implements the json.Marshaler interface, the Path is encoded as a JSON Pointer string.
+func (p Path) MarshalJSON() ([]byte, error) {
+	return json.Marshal(PathToJSON(p))
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface, see PathFromJSON.
+func (p *Path) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	path, err := PathFromJSON(s)
+	if err != nil {
+		return err
+	}
+	*p = path
+	return nil
+}
+
+// EncodePointerToken converts a map key or an array index to a reference token of a JSON Pointer,
+// with the extended escapes of PathFromJSON for the keys that are not text strings or integers
+// that fit in int64, and the text strings that look like integers, see DecodePointerToken.
+// So JSON tooling can address the maps with integer keys, such as the structs encoded with "keyasint".
+func EncodePointerToken(k RawKey) string {
+	switch ReadCBORType([]byte(k)) {
+	case CBORTypeTextString:
+		var s string
+		if err := cborUnmarshal([]byte(k), &s); err == nil {
+			if isIntToken(s) {
+				return "~s" + s
+			}
+			return rfc6901Encoder.Replace(s)
+		}
+
+	case CBORTypePositiveInt, CBORTypeNegativeInt:
+		var n big.Int
+		if err := cborUnmarshal([]byte(k), &n); err == nil {
+			s := n.String()
+			switch {
+			case isIntToken(s):
+				return s
+			case n.Sign() > 0:
+				return "~u" + s
+			}
+			return "~i" + s
+		}
+
+	case CBORTypeByteString:
+		var b []byte
+		if err := cborUnmarshal([]byte(k), &b); err == nil {
+			return "~b" + base64.RawURLEncoding.EncodeToString(b)
+		}
+
+	default:
+		if len(k) > 0 {
+			return "~c" + base64.RawURLEncoding.EncodeToString([]byte(k))
+		}
+	}
+	return rfc6901Encoder.Replace(k.Key())
+}
+
+// isIntToken reports whether PathFromJSON converts the reference token to an integer key.
+func isIntToken(s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+	switch s[0] {
+	case '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		_, err := strconv.Atoi(s)
+		return err == nil
+	}
+	return false
 }
 
 func PathMustFromJSON(jsonpath string) Path {
@@ -132,14 +325,51 @@ func PathMustFromJSON(jsonpath string) Path {
 	return path
 }
 
+// PatchToJSON converts a Patch to a JSON Patch (RFC 6902) document.
+// The paths are converted to JSON Pointers with the extended escapes of PathFromJSON,
+// and the values are converted by ToJSON.
+func PatchToJSON(p Patch) ([]byte, error) {
+	return marshalJSONPatch(p)
+}
+
+// MarshalJSON implements the json.Marshaler interface, see PatchToJSON.
+func (p Patch) MarshalJSON() ([]byte, error) {
+	return marshalJSONPatch(p)
+}
+
 type jsonOperation struct {
-	Op    string           `json:"op"`
-	Path  string           `json:"path"`
-	From  *string          `json:"from,omitempty"`
-	Value *json.RawMessage `json:"value,omitempty"`
+	Op          string           `json:"op"`
+	Path        string           `json:"path"`
+	From        *string          `json:"from,omitempty"`
+	Value       *json.RawMessage `json:"value,omitempty"`
+	Index       int              `json:"index,omitempty"`
+	RemoveCount int              `json:"remove-count,omitempty"`
 }
 
+// PatchFromJSON decodes the passed JSON document as an RFC 6902 patch.
+// The keys in the paths must be integers, text strings or byte strings, see Operation.Valid.
 func PatchFromJSON(jsonpatch string) (Patch, error) {
+	return patchFromJSON(jsonpatch, nil)
+}
+
+// PatchFromJSONWithOptions is like PatchFromJSON, but checks the operations with the options,
+// so the paths can have keys of any type if options.AllowAnyMapKey is set, and the "from" of
+// "move" and "copy" operations can be a Relative JSON Pointer if options.AllowRelativeFrom is set.
+func PatchFromJSONWithOptions(jsonpatch string, options *Options) (Patch, error) {
+	if options == nil {
+		options = NewOptions()
+	}
+	return patchFromJSON(jsonpatch, options)
+}
+
+// patchFromJSON decodes the JSON patch, the operations are checked with the options,
+// or by Operation.Valid if options is nil.
+func patchFromJSON(jsonpatch string, options *Options) (Patch, error) {
+	fromJSON := PathFromJSON
+	if options != nil && options.AllowRelativeFrom {
+		fromJSON = relativePathFromJSON
+	}
+
 	var err error
 	jp := make([]jsonOperation, 0)
 	if err = json.Unmarshal([]byte(jsonpatch), &jp); err != nil {
@@ -165,15 +395,53 @@ func PatchFromJSON(jsonpatch string) (Patch, error) {
 			op = OpCopy
 		case "test":
 			op = OpTest
+		case "merge":
+			op = OpMerge
+		case "append":
+			op = OpAppend
+		case "splice":
+			op = OpSplice
+		case "incr":
+			op = OpIncr
+		case "decr":
+			op = OpDecr
+		case "toggle":
+			op = OpToggle
+		case "text-diff":
+			op = OpTextDiff
+		case "binary-diff":
+			op = OpBinaryDiff
+		case "test-contains":
+			op = OpTestContains
+		case "test-type":
+			op = OpTestType
+		case "test-match":
+			op = OpTestMatch
+		case "test-less":
+			op = OpTestLess
+		case "test-less-equal":
+			op = OpTestLessEqual
+		case "test-greater":
+			op = OpTestGreater
+		case "test-greater-equal":
+			op = OpTestGreaterEqual
+		case "test-length":
+			op = OpTestLength
+		case "test-defined":
+			op = OpTestDefined
+		case "test-undefined":
+			op = OpTestUndefined
+		case "test-subset":
+			op = OpTestSubset
 		}
 
-		o := &Operation{Op: op}
+		o := &Operation{Op: op, Index: p.Index, RemoveCount: p.RemoveCount}
 		if o.Path, err = PathFromJSON(p.Path); err != nil {
 			return nil, err
 		}
 
 		if p.From != nil {
-			if o.From, err = PathFromJSON(*p.From); err != nil {
+			if o.From, err = fromJSON(*p.From); err != nil {
 				return nil, err
 			}
 		}
@@ -186,7 +454,12 @@ func PatchFromJSON(jsonpatch string) (Patch, error) {
 			o.Value = data
 		}
 
-		if err = o.Valid(); err != nil {
+		if options == nil {
+			err = o.Valid()
+		} else {
+			err = validOp(o, options)
+		}
+		if err != nil {
 			return nil, err
 		}
 		patch[i] = o
@@ -194,75 +467,6 @@ func PatchFromJSON(jsonpatch string) (Patch, error) {
 	return patch, nil
 }
 
-func readJSONKey(dec *json.Decoder) (string, error) {
-	t, err := dec.Token()
-	if err != nil {
-		return "", err
-	}
-
-	if key, ok := t.(string); ok {
-		return key, nil
-	}
-	return "", fmt.Errorf("expected a string as key, got token %v", t)
-}
-
-func readJSONValue(dec *json.Decoder) (any, error) {
-	t, err := dec.Token()
-	if err != nil {
-		return nil, err
-	}
-
-	switch v := t.(type) {
-	case json.Delim:
-		switch v {
-		case '{':
-			obj := make(map[string]any)
-
-			for dec.More() {
-				key, err := readJSONKey(dec)
-				if err != nil {
-					return nil, err
-				}
-				val, err := readJSONValue(dec)
-				if err != nil {
-					return nil, err
-				}
-				obj[key] = val
-			}
-			// read '}'
-			if _, err = dec.Token(); err != nil {
-				return nil, err
-			}
-			return obj, nil
-
-		case '[':
-			arr := make([]any, 0)
-
-			for dec.More() {
-				val, err := readJSONValue(dec)
-				if err != nil {
-					return nil, err
-				}
-				arr = append(arr, val)
-			}
-			// read ']'
-			if _, err = dec.Token(); err != nil {
-				return nil, err
-			}
-			return arr, nil
-
-		default:
-			return nil, fmt.Errorf("unexpected token %v", v)
-		}
-
-	case json.Number:
-		return convertNumber(v)
-
-	default:
-		return v, nil
-	}
-}
-
 func maybeFloat(s string) (mf, mbf bool) {
 	for _, r := range s {
 		switch r {
@@ -334,4 +538,5 @@ func convertNumber(n json.Number) (any, error) {
 // Refer to http://tools.ietf.org/html/rfc6901#section-4
 var (
 	rfc6901Decoder = strings.NewReplacer("~1", "/", "~0", "~")
+	rfc6901Encoder = strings.NewReplacer("~", "~0", "/", "~1")
 )
@@ -0,0 +1,550 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+//go:build cborpatch_lite || tinygo
+
+// The reflection-light build, used by TinyGo or with the "cborpatch_lite" build tag.
+// JSON conversion transcodes between JSON tokens and raw CBOR directly, without decoding into Go values.
+
+package cborpatch
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// jsonToCBOR converts a valid JSON document to CBOR by transcoding JSON tokens.
+// The result is the same as encoding the decoded Go values with the default encoding mode:
+// map keys are sorted bytewise, and the last one wins on duplicate keys.
+// If the codec is not nil, the result is decoded and encoded again with it, such as Options.EncMode.
+func jsonToCBOR(doc []byte, c *cborCodec) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.UseNumber()
+	buf := &bytes.Buffer{}
+	if err := transcodeJSONValue(dec, buf); err != nil {
+		return nil, err
+	}
+	if c == nil {
+		return buf.Bytes(), nil
+	}
+
+	var v any
+	if err := cborUnmarshal(buf.Bytes(), &v); err != nil {
+		return nil, err
+	}
+	return c.Marshal(v)
+}
+
+func transcodeJSONValue(dec *json.Decoder, buf *bytes.Buffer) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	switch v := t.(type) {
+	case json.Delim:
+		switch v {
+		case '{':
+			entries := make(map[string][]byte)
+			for dec.More() {
+				kt, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				key, ok := kt.(string)
+				if !ok {
+					return fmt.Errorf("expected a string as key, got token %v", kt)
+				}
+
+				kb := &bytes.Buffer{}
+				writeCBORHead(kb, 0x60, uint64(len(key)))
+				kb.WriteString(key)
+
+				vb := &bytes.Buffer{}
+				if err = transcodeJSONValue(dec, vb); err != nil {
+					return err
+				}
+				entries[kb.String()] = vb.Bytes()
+			}
+			// read '}'
+			if _, err = dec.Token(); err != nil {
+				return err
+			}
+
+			keys := make([]string, 0, len(entries))
+			for k := range entries {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+
+			writeCBORHead(buf, 0xa0, uint64(len(keys)))
+			for _, k := range keys {
+				buf.WriteString(k)
+				buf.Write(entries[k])
+			}
+			return nil
+
+		case '[':
+			items := &bytes.Buffer{}
+			n := 0
+			for dec.More() {
+				if err = transcodeJSONValue(dec, items); err != nil {
+					return err
+				}
+				n++
+			}
+			// read ']'
+			if _, err = dec.Token(); err != nil {
+				return err
+			}
+
+			writeCBORHead(buf, 0x80, uint64(n))
+			buf.Write(items.Bytes())
+			return nil
+
+		default:
+			return fmt.Errorf("unexpected token %v", v)
+		}
+
+	case json.Number:
+		num, err := convertNumber(v)
+		if err != nil {
+			return err
+		}
+
+		switch n := num.(type) {
+		case uint64:
+			writeCBORHead(buf, 0x00, n)
+		case int64:
+			if n >= 0 {
+				writeCBORHead(buf, 0x00, uint64(n))
+			} else {
+				writeCBORHead(buf, 0x20, uint64(-(n + 1)))
+			}
+		case float64:
+			buf.WriteByte(0xfb)
+			binary.Write(buf, binary.BigEndian, math.Float64bits(n))
+		case *big.Int:
+			major, tag := byte(0x00), byte(0xc2)
+			if n.Sign() < 0 {
+				major, tag = 0x20, 0xc3
+				n = new(big.Int).Neg(n)
+				n.Sub(n, big.NewInt(1))
+			}
+			if n.IsUint64() {
+				writeCBORHead(buf, major, n.Uint64())
+				return nil
+			}
+			buf.WriteByte(tag)
+			b := n.Bytes()
+			writeCBORHead(buf, 0x40, uint64(len(b)))
+			buf.Write(b)
+		}
+		return nil
+
+	case string:
+		writeCBORHead(buf, 0x60, uint64(len(v)))
+		buf.WriteString(v)
+		return nil
+
+	case bool:
+		if v {
+			buf.WriteByte(0xf5)
+		} else {
+			buf.WriteByte(0xf4)
+		}
+		return nil
+
+	case nil:
+		buf.WriteByte(0xf6)
+		return nil
+
+	default:
+		return fmt.Errorf("unexpected token %v", v)
+	}
+}
+
+func writeCBORHead(buf *bytes.Buffer, major byte, n uint64) {
+	switch {
+	case n < 24:
+		buf.WriteByte(major | byte(n))
+	case n <= math.MaxUint8:
+		buf.WriteByte(major | 24)
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(major | 25)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	case n <= math.MaxUint32:
+		buf.WriteByte(major | 26)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	default:
+		buf.WriteByte(major | 27)
+		binary.Write(buf, binary.BigEndian, n)
+	}
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (n *Node) MarshalJSON() ([]byte, error) {
+	if n == nil {
+		return []byte("null"), nil
+	}
+
+	data, err := n.MarshalCBOR()
+	if err != nil {
+		return nil, err
+	}
+	return cborToJSON(data, n.codec.dupMapKeyLastWins())
+}
+
+func (d *partialDoc) MarshalJSON() ([]byte, error) {
+	data, err := d.MarshalCBOR()
+	if err != nil {
+		return nil, err
+	}
+	return cborToJSON(data, false)
+}
+
+// cborToJSON converts a CBOR document to JSON by transcoding raw CBOR data items.
+// Duplicate map keys are rejected, unless lastWins is true and the value of the last one wins.
+func cborToJSON(data []byte, lastWins bool) ([]byte, error) {
+	if err := cborValid(data); err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	if _, err := transcodeCBORItem(data, buf, lastWins); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+var errTruncatedCBOR = errors.New("unexpected end of CBOR data")
+
+func readCBORHead(data []byte) (major byte, ai byte, val uint64, off int, err error) {
+	if len(data) == 0 {
+		return 0, 0, 0, 0, errTruncatedCBOR
+	}
+
+	major, ai = data[0]&0xe0, data[0]&0x1f
+	switch {
+	case ai < 24:
+		return major, ai, uint64(ai), 1, nil
+	case ai == 24 && len(data) >= 2:
+		return major, ai, uint64(data[1]), 2, nil
+	case ai == 25 && len(data) >= 3:
+		return major, ai, uint64(binary.BigEndian.Uint16(data[1:])), 3, nil
+	case ai == 26 && len(data) >= 5:
+		return major, ai, uint64(binary.BigEndian.Uint32(data[1:])), 5, nil
+	case ai == 27 && len(data) >= 9:
+		return major, ai, binary.BigEndian.Uint64(data[1:]), 9, nil
+	case ai > 27:
+		return 0, 0, 0, 0, fmt.Errorf("unsupported CBOR additional information %d", ai)
+	default:
+		return 0, 0, 0, 0, errTruncatedCBOR
+	}
+}
+
+// transcodeCBORItem writes the first CBOR data item in data as JSON, and returns its length.
+func transcodeCBORItem(data []byte, buf *bytes.Buffer, lastWins bool) (int, error) {
+	major, ai, val, off, err := readCBORHead(data)
+	if err != nil {
+		return 0, err
+	}
+
+	switch major {
+	case 0x00:
+		buf.WriteString(strconv.FormatUint(val, 10))
+		return off, nil
+
+	case 0x20:
+		if val <= math.MaxInt64 {
+			buf.WriteString(strconv.FormatInt(-1-int64(val), 10))
+		} else {
+			i := new(big.Int).SetUint64(val)
+			buf.WriteString(i.Neg(i.Add(i, big.NewInt(1))).String())
+		}
+		return off, nil
+
+	case 0x40, 0x60:
+		end := off + int(val)
+		if val > uint64(len(data)) || end > len(data) {
+			return 0, errTruncatedCBOR
+		}
+		if major == 0x40 {
+			buf.WriteByte('"')
+			buf.WriteString(base64.StdEncoding.EncodeToString(data[off:end]))
+			buf.WriteByte('"')
+		} else {
+			writeJSONString(buf, data[off:end])
+		}
+		return end, nil
+
+	case 0x80:
+		buf.WriteByte('[')
+		for i := uint64(0); i < val; i++ {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			n, err := transcodeCBORItem(data[off:], buf, lastWins)
+			if err != nil {
+				return 0, err
+			}
+			off += n
+		}
+		buf.WriteByte(']')
+		return off, nil
+
+	case 0xa0:
+		type entry struct {
+			key string
+			val []byte
+		}
+		entries := make([]entry, 0, val)
+		seen := make(map[RawKey]int, val)
+		for i := uint64(0); i < val; i++ {
+			kb := &bytes.Buffer{}
+			n, err := transcodeCBORItem(data[off:], kb, lastWins)
+			if err != nil {
+				return 0, err
+			}
+			key := RawKey(data[off : off+n])
+			if err = key.Valid(); err != nil {
+				return 0, err
+			}
+			off += n
+
+			vb := &bytes.Buffer{}
+			if n, err = transcodeCBORItem(data[off:], vb, lastWins); err != nil {
+				return 0, err
+			}
+			off += n
+			if j, ok := seen[key]; ok {
+				if !lastWins {
+					return 0, &cbor.DupMapKeyError{Key: key.Key(), Index: int(i)}
+				}
+				entries[j].val = vb.Bytes()
+				continue
+			}
+			seen[key] = len(entries)
+			entries = append(entries, entry{key.Key(), vb.Bytes()})
+		}
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].key < entries[j].key
+		})
+
+		buf.WriteByte('{')
+		for i, e := range entries {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeJSONString(buf, []byte(e.key))
+			buf.WriteByte(':')
+			buf.Write(e.val)
+		}
+		buf.WriteByte('}')
+		return off, nil
+
+	case 0xc0:
+		return transcodeCBORTag(val, data[off:], off, buf, lastWins)
+
+	default:
+		switch {
+		case ai == 20:
+			buf.WriteString("false")
+		case ai == 21:
+			buf.WriteString("true")
+		case ai <= 24:
+			// null, undefined and the other simple values, RFC 8949 section 6.1.
+			buf.WriteString("null")
+		default:
+			var f float64
+			switch ai {
+			case 25:
+				f = float16ToFloat64(uint16(val))
+			case 26:
+				f = float64(math.Float32frombits(uint32(val)))
+			default:
+				f = math.Float64frombits(val)
+			}
+			if err = writeJSONFloat(buf, f); err != nil {
+				return 0, err
+			}
+		}
+		return off, nil
+	}
+}
+
+func transcodeCBORTag(num uint64, content []byte, off int, buf *bytes.Buffer, lastWins bool) (int, error) {
+	switch num {
+	case 2, 3:
+		major, _, val, hoff, err := readCBORHead(content)
+		if err != nil {
+			return 0, err
+		}
+		if major == 0x40 && val <= uint64(len(content)-hoff) {
+			i := new(big.Int).SetBytes(content[hoff : hoff+int(val)])
+			if num == 3 {
+				i.Neg(i.Add(i, big.NewInt(1)))
+			}
+			buf.WriteString(i.String())
+			return off + hoff + int(val), nil
+		}
+
+	case 1:
+		major, ai, val, hoff, err := readCBORHead(content)
+		if err != nil {
+			return 0, err
+		}
+		var t time.Time
+		switch major {
+		case 0x00:
+			t = time.Unix(int64(val), 0)
+		case 0x20:
+			t = time.Unix(-1-int64(val), 0)
+		case 0xe0:
+			if ai >= 25 && ai <= 27 {
+				f := math.Float64frombits(val)
+				switch ai {
+				case 25:
+					f = float16ToFloat64(uint16(val))
+				case 26:
+					f = float64(math.Float32frombits(uint32(val)))
+				}
+				sec, frac := math.Modf(f)
+				t = time.Unix(int64(sec), int64(frac*1e9))
+			}
+		}
+		if !t.IsZero() {
+			buf.WriteByte('"')
+			buf.WriteString(t.UTC().Format(time.RFC3339Nano))
+			buf.WriteByte('"')
+			return off + hoff, nil
+		}
+
+	case 0:
+		if ReadCBORType(content) == CBORTypeTextString {
+			n, err := transcodeCBORItem(content, buf, lastWins)
+			return off + n, err
+		}
+	}
+
+	buf.WriteString(`{"Number":`)
+	buf.WriteString(strconv.FormatUint(num, 10))
+	buf.WriteString(`,"Content":`)
+	n, err := transcodeCBORItem(content, buf, lastWins)
+	if err != nil {
+		return 0, err
+	}
+	buf.WriteByte('}')
+	return off + n, nil
+}
+
+func float16ToFloat64(h uint16) float64 {
+	sign := float64(1)
+	if h&0x8000 != 0 {
+		sign = -1
+	}
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+	switch exp {
+	case 0:
+		return sign * math.Ldexp(mant, -24)
+	case 0x1f:
+		if mant == 0 {
+			return sign * math.Inf(1)
+		}
+		return math.NaN()
+	default:
+		return sign * math.Ldexp(mant+1024, exp-25)
+	}
+}
+
+// writeJSONFloat writes a float64 as encoding/json does.
+func writeJSONFloat(buf *bytes.Buffer, f float64) error {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return fmt.Errorf("json: unsupported value: %s", strconv.FormatFloat(f, 'g', -1, 64))
+	}
+
+	abs := math.Abs(f)
+	format := byte('f')
+	if abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+	b := strconv.AppendFloat(nil, f, format, -1, 64)
+	if format == 'e' {
+		// clean up e-09 to e-9
+		if n := len(b); n >= 4 && b[n-4] == 'e' && b[n-3] == '-' && b[n-2] == '0' {
+			b[n-2] = b[n-1]
+			b = b[:n-1]
+		}
+	}
+	buf.Write(b)
+	return nil
+}
+
+const hexDigits = "0123456789abcdef"
+
+// writeJSONString writes a JSON string with HTML escaping as encoding/json does.
+func writeJSONString(buf *bytes.Buffer, s []byte) {
+	buf.WriteByte('"')
+	start := 0
+	for i := 0; i < len(s); {
+		if b := s[i]; b < utf8.RuneSelf {
+			if b >= 0x20 && b != '"' && b != '\\' && b != '<' && b != '>' && b != '&' {
+				i++
+				continue
+			}
+			buf.Write(s[start:i])
+			switch b {
+			case '\\', '"':
+				buf.WriteByte('\\')
+				buf.WriteByte(b)
+			case '\n':
+				buf.WriteString(`\n`)
+			case '\r':
+				buf.WriteString(`\r`)
+			case '\t':
+				buf.WriteString(`\t`)
+			default:
+				buf.WriteString(`\u00`)
+				buf.WriteByte(hexDigits[b>>4])
+				buf.WriteByte(hexDigits[b&0xf])
+			}
+			i++
+			start = i
+			continue
+		}
+
+		c, size := utf8.DecodeRune(s[i:])
+		if c == utf8.RuneError && size == 1 {
+			buf.Write(s[start:i])
+			buf.WriteString(`\ufffd`)
+			i += size
+			start = i
+			continue
+		}
+		if c == '\u2028' || c == '\u2029' {
+			buf.Write(s[start:i])
+			buf.WriteString(`\u202`)
+			buf.WriteByte(hexDigits[c&0xf])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	buf.Write(s[start:])
+	buf.WriteByte('"')
+}
@@ -0,0 +1,53 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+//go:build cborpatch_lite || tinygo
+
+package cborpatch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLiteJSONTranscoding(t *testing.T) {
+	assert := assert.New(t)
+
+	doc := MustFromJSON(`{"b": [1, -2, 3.5, 1e-10, -0, 18446744073709551616, -9223372036854775809, "<\u2028>"], "a": {"a": 1, "a": 2}}`)
+	assert.Equal("a26161a16161026162880121fb400c000000000000fb3ddb7cdfd9d7bdbb00c2490100000000000000003b8000000000000000653ce280a83e",
+		toHex(doc))
+	assert.Equal(`{"a":{"a":2},"b":[1,-2,3.5,1e-10,0,18446744073709551616,-9223372036854775809,"\u003c\u2028\u003e"]}`,
+		MustToJSON(doc))
+
+	cases := []struct {
+		cbor []byte
+		json string
+	}{
+		{[]byte{0xf9, 0x3c, 0x00}, `1`},
+		{[]byte{0xfa, 0x3f, 0xc0, 0x00, 0x00}, `1.5`},
+		{[]byte{0xc1, 0x1a, 0x51, 0x4b, 0x67, 0xb0}, `"2013-03-21T20:04:00Z"`},
+		{[]byte{0xd8, 0x20, 0x63, 0x61, 0x62, 0x63}, `{"Number":32,"Content":"abc"}`},
+		{[]byte{0x43, 0x01, 0x02, 0x03}, `"AQID"`},
+		{[]byte{0xf8, 0x20}, `null`},
+		{[]byte{0xa1, 0x01, 0x02}, `{"1":2}`},
+		{[]byte{0x3b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, `-18446744073709551616`},
+	}
+	for _, c := range cases {
+		res, err := ToJSON(c.cbor, nil)
+		assert.NoError(err)
+		assert.Equal(c.json, string(res))
+	}
+
+	_, err := ToJSON([]byte{0xf9, 0x7e, 0x00}, nil)
+	assert.Error(err, "NaN")
+}
+
+func toHex(data []byte) string {
+	const digits = "0123456789abcdef"
+	buf := make([]byte, 0, len(data)*2)
+	for _, b := range data {
+		buf = append(buf, digits[b>>4], digits[b&0xf])
+	}
+	return string(buf)
+}
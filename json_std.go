@@ -0,0 +1,131 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+//go:build !cborpatch_lite && !tinygo
+
+package cborpatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// jsonToCBOR converts a valid JSON document to CBOR by decoding it into Go values
+// and encoding them with the codec.
+func jsonToCBOR(doc []byte, c *cborCodec) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.UseNumber()
+	v, err := readJSONValue(dec)
+	if err != nil {
+		return nil, err
+	}
+	return c.Marshal(v)
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (n *Node) MarshalJSON() ([]byte, error) {
+	if n == nil {
+		return []byte("null"), nil
+	}
+
+	n.intoContainer()
+	switch n.which {
+	case eOther:
+		if n.raw == nil {
+			return json.Marshal(nil)
+		}
+		var val any
+		if err := n.codec.Unmarshal(*n.raw, &val); err != nil {
+			return nil, err
+		}
+		if _, ok := val.(SimpleValue); ok {
+			// JSON has no simple values other than false, true and null, RFC 8949 section 6.1.
+			return json.Marshal(nil)
+		}
+		return json.Marshal(val)
+	case eDoc:
+		return json.Marshal(n.doc)
+	case eAry:
+		return json.Marshal(n.ary)
+	default:
+		return nil, ErrUnknownType
+	}
+}
+
+func (d *partialDoc) MarshalJSON() ([]byte, error) {
+	obj := make(map[string]*Node, len(d.obj))
+	for k := range d.obj {
+		obj[k.Key()] = d.obj[k]
+	}
+	return json.Marshal(obj)
+}
+
+func readJSONKey(dec *json.Decoder) (string, error) {
+	t, err := dec.Token()
+	if err != nil {
+		return "", err
+	}
+
+	if key, ok := t.(string); ok {
+		return key, nil
+	}
+	return "", fmt.Errorf("expected a string as key, got token %v", t)
+}
+
+func readJSONValue(dec *json.Decoder) (any, error) {
+	t, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch v := t.(type) {
+	case json.Delim:
+		switch v {
+		case '{':
+			obj := make(map[string]any)
+
+			for dec.More() {
+				key, err := readJSONKey(dec)
+				if err != nil {
+					return nil, err
+				}
+				val, err := readJSONValue(dec)
+				if err != nil {
+					return nil, err
+				}
+				obj[key] = val
+			}
+			// read '}'
+			if _, err = dec.Token(); err != nil {
+				return nil, err
+			}
+			return obj, nil
+
+		case '[':
+			arr := make([]any, 0)
+
+			for dec.More() {
+				val, err := readJSONValue(dec)
+				if err != nil {
+					return nil, err
+				}
+				arr = append(arr, val)
+			}
+			// read ']'
+			if _, err = dec.Token(); err != nil {
+				return nil, err
+			}
+			return arr, nil
+
+		default:
+			return nil, fmt.Errorf("unexpected token %v", v)
+		}
+
+	case json.Number:
+		return convertNumber(v)
+
+	default:
+		return v, nil
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"math"
 	"math/big"
+	"reflect"
 	"testing"
 )
 
@@ -99,3 +100,215 @@ func TestConvertNumber(t *testing.T) {
 		}
 	}
 }
+
+func TestPathJSON(t *testing.T) {
+	cases := []struct {
+		path Path
+		json string
+	}{
+		{Path{}, ""},
+		{PathMustFrom("a/b", "m~n", 0, -1), "/a~1b/m~0n/0/-1"},
+		{PathMustFrom("0", "-1", "-", "~u"), "/~s0/~s-1/-/~0u"},
+		{PathMustFrom(ByteString("\x01\x02\xff")), "/~bAQL_"},
+		{PathMustFrom(uint64(1 << 63)), "/~u9223372036854775808"},
+		{Path{RawKey(MustMarshal(true)), RawKey(MustMarshal([]int{1, 2}))}, "/~c9Q/~cggEC"},
+	}
+	for _, c := range cases {
+		if got := PathToJSON(c.path); got != c.json {
+			t.Errorf("PathToJSON(%s) = %q, expected %q", c.path, got, c.json)
+		}
+		p, err := PathFromJSON(c.json)
+		if err != nil {
+			t.Fatalf("PathFromJSON(%q) failed, %v", c.json, err)
+		}
+		if p.String() != c.path.String() {
+			t.Errorf("PathFromJSON(%q) = %s, expected %s", c.json, p, c.path)
+		}
+	}
+
+	for _, s := range []string{"/~ix", "/~b!", "/~i18446744073709551616", "/~c!", "/~c_w", "/~cgg", "a", "0", "0/a", "1/name"} {
+		if _, err := PathFromJSON(s); err == nil {
+			t.Errorf("PathFromJSON(%q) should fail", s)
+		}
+	}
+
+	// Relative JSON Pointers are only converted for the "from" of operations, see AllowRelativeFrom.
+	cases = []struct {
+		path Path
+		json string
+	}{
+		{PathMustFrom("a", 0), "/a/0"},
+		{append(Path{relativeKey(1)}, PathMustFrom("name")...), "1/name"},
+		{append(Path{relativeKey(12)}, PathMustFrom("a", 0)...), "12/a/0"},
+		{Path{relativeKey(0)}, "0"},
+	}
+	for _, c := range cases {
+		if got := PathToJSON(c.path); got != c.json {
+			t.Errorf("PathToJSON(%s) = %q, expected %q", c.path, got, c.json)
+		}
+		p, err := relativePathFromJSON(c.json)
+		if err != nil {
+			t.Fatalf("relativePathFromJSON(%q) failed, %v", c.json, err)
+		}
+		if p.String() != c.path.String() {
+			t.Errorf("relativePathFromJSON(%q) = %s, expected %s", c.json, p, c.path)
+		}
+	}
+
+	for _, s := range []string{"01/a", "1+1/a", "1#", "1/~ix", "a"} {
+		if _, err := relativePathFromJSON(s); err == nil {
+			t.Errorf("relativePathFromJSON(%q) should fail", s)
+		}
+	}
+}
+
+func TestPatchToJSON(t *testing.T) {
+	jp := `[{"op":"add","path":"/a/0","value":{"b":[1,"x"]}},{"op":"move","from":"/~s1","path":"/~bAQI"},{"op":"remove","path":"/c"}]`
+	p, err := PatchFromJSON(jp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := PatchToJSON(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != jp {
+		t.Errorf("PatchToJSON = %s, expected %s", data, jp)
+	}
+
+	data, err = json.Marshal(struct {
+		Patch Patch `json:"patch"`
+	}{p})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"patch":`+jp+`}` {
+		t.Errorf("json.Marshal = %s", data)
+	}
+}
+
+func TestPathMarshalJSON(t *testing.T) {
+	v := struct {
+		Path Path `json:"path"`
+		From Path `json:"from"`
+	}{PathMustFrom("a", 1, "2"), Path{}}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"path":"/a/1/~s2","from":""}` {
+		t.Errorf("json.Marshal = %s", data)
+	}
+
+	v.Path, v.From = nil, nil
+	if err = json.Unmarshal(data, &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Path.String() != PathMustFrom("a", 1, "2").String() || v.From == nil || len(v.From) != 0 {
+		t.Errorf("json.Unmarshal = %s, %s", v.Path, v.From)
+	}
+
+	if err = json.Unmarshal([]byte(`{"path":"a"}`), &v); err == nil {
+		t.Errorf("json.Unmarshal should fail with invalid JSON Pointer")
+	}
+}
+
+func TestParsePatch(t *testing.T) {
+	jp := `[{"op":"add","path":"/a","value":1},{"op":"remove","path":"/b"}]`
+	want, err := PatchFromJSON(jp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tagged, err := WrapPatch(want, PatchTag)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, doc := range [][]byte{
+		[]byte(jp),
+		[]byte(" \r\n\t" + jp),
+		append([]byte{0xef, 0xbb, 0xbf}, jp...),
+		MustMarshal(want),
+		tagged,
+	} {
+		p, err := ParsePatch(doc)
+		if err != nil {
+			t.Fatalf("ParsePatch(%q) error: %v", doc, err)
+		}
+		if !reflect.DeepEqual(p, want) {
+			t.Errorf("ParsePatch(%q) = %v, expected %v", doc, p, want)
+		}
+	}
+
+	for _, doc := range [][]byte{nil, []byte(" "), []byte(`{"op":"add"}`), []byte(`[{"op":"foo"}]`), {0x81, 0x01}} {
+		if _, err := ParsePatch(doc); err == nil {
+			t.Errorf("ParsePatch(%q) should fail", doc)
+		}
+	}
+}
+
+func TestPointerToken(t *testing.T) {
+	cases := []struct {
+		key   RawKey
+		token string
+	}{
+		{RawKey(MustMarshal("name")), "name"},
+		{RawKey(MustMarshal("a/b~c")), "a~1b~0c"},
+		{RawKey(MustMarshal("")), ""},
+		{RawKey(MustMarshal("-")), "-"},
+		{RawKey(MustMarshal("0")), "~s0"},
+		{RawKey(MustMarshal("-1")), "~s-1"},
+		{RawKey(MustMarshal(0)), "0"},
+		{RawKey(MustMarshal(-1)), "-1"},
+		{RawKey(MustMarshal(uint64(1<<64 - 1))), "~u18446744073709551615"},
+		{RawKey(MustMarshal(new(big.Int).Sub(big.NewInt(math.MinInt64), big.NewInt(1)))), "~i-9223372036854775809"},
+		{RawKey(MustMarshal([]byte{1, 2})), "~bAQI"},
+		{RawKey(MustMarshal(true)), "~c9Q"},
+	}
+	for _, c := range cases {
+		if got := EncodePointerToken(c.key); got != c.token {
+			t.Errorf("EncodePointerToken(%s) = %q, expected %q", c.key, got, c.token)
+		}
+		key, err := DecodePointerToken(c.token)
+		if err != nil {
+			t.Fatalf("DecodePointerToken(%q) failed, %v", c.token, err)
+		}
+		if key != c.key {
+			t.Errorf("DecodePointerToken(%q) = %s, expected %s", c.token, key, c.key)
+		}
+	}
+
+	// a struct encoded with "keyasint" can be addressed by JSON Pointers.
+	type user struct {
+		Name string `cbor:"1,keyasint"`
+		Tags []int  `cbor:"2,keyasint"`
+	}
+	doc := MustMarshal(user{Name: "John", Tags: []int{1}})
+	p, err := PatchFromJSON(`[{"op":"replace","path":"/1","value":"Jane"},{"op":"add","path":"/2/-","value":2}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc, err = p.Apply(doc); err != nil {
+		t.Fatal(err)
+	}
+	var u user
+	if err = cborUnmarshal(doc, &u); err != nil || u.Name != "Jane" || len(u.Tags) != 2 {
+		t.Errorf("unexpected result %s, %v", Diagify(doc), err)
+	}
+
+	// "~u" and "~i" escapes of the same integer are the same key.
+	if u, err := DecodePointerToken("~u5"); err != nil || u != RawKey(MustMarshal(5)) {
+		t.Errorf("DecodePointerToken(\"~u5\") = %s, %v, expected 5", u, err)
+	}
+	if i, err := DecodePointerToken("~i5"); err != nil || i != RawKey(MustMarshal(5)) {
+		t.Errorf("DecodePointerToken(\"~i5\") = %s, %v, expected 5", i, err)
+	}
+
+	for _, s := range []string{"~ix", "~i1.5", "~u-1", "~ux", "~u18446744073709551616", "~b!", "~c!", "~cgg"} {
+		if _, err := DecodePointerToken(s); err == nil {
+			t.Errorf("DecodePointerToken(%q) should fail", s)
+		}
+	}
+}
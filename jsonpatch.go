@@ -0,0 +1,38 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"encoding/json"
+)
+
+// FromJSONPatch converts a JSON patch in the shape of github.com/evanphx/json-patch's Patch
+// (a slice of map[string]*json.RawMessage operations) to a Patch.
+// It does not import the evanphx/json-patch package:
+//
+//	p, err := cborpatch.FromJSONPatch(jsonpatch.Patch{...})
+func FromJSONPatch[P ~[]O, O ~map[string]*json.RawMessage](jp P) (Patch, error) {
+	data, err := json.Marshal(jp)
+	if err != nil {
+		return nil, err
+	}
+	return PatchFromJSON(string(data))
+}
+
+// ToJSONPatch converts a Patch to a JSON patch in the shape of github.com/evanphx/json-patch's Patch.
+// It does not import the evanphx/json-patch package:
+//
+//	jp, err := cborpatch.ToJSONPatch[jsonpatch.Patch](p)
+func ToJSONPatch[P ~[]O, O ~map[string]*json.RawMessage](p Patch) (P, error) {
+	data, err := marshalJSONPatch(p)
+	if err != nil {
+		return nil, err
+	}
+
+	var jp P
+	if err = json.Unmarshal(data, &jp); err != nil {
+		return nil, err
+	}
+	return jp, nil
+}
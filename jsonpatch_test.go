@@ -0,0 +1,57 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// evanphxOperation and evanphxPatch mirror the types of github.com/evanphx/json-patch.
+type evanphxOperation map[string]*json.RawMessage
+type evanphxPatch []evanphxOperation
+
+func TestJSONPatchInterop(t *testing.T) {
+	assert := assert.New(t)
+
+	src := `[
+		{"op": "add", "path": "/a~1b", "value": {"c": [1, "x", null]}},
+		{"op": "move", "from": "/a~1b/c/0", "path": "/d"},
+		{"op": "test", "path": "/d", "value": 1},
+		{"op": "remove", "path": "/e/-1"}
+	]`
+
+	var jp evanphxPatch
+	assert.NoError(json.Unmarshal([]byte(src), &jp))
+
+	p, err := FromJSONPatch(jp)
+	assert.NoError(err)
+	expected, err := PatchFromJSON(src)
+	assert.NoError(err)
+	assert.Equal(expected, p)
+
+	res, err := ToJSONPatch[evanphxPatch](p)
+	assert.NoError(err)
+	assert.Equal(len(jp), len(res))
+	for i := range jp {
+		assert.Equal(len(jp[i]), len(res[i]))
+		for k, v := range jp[i] {
+			assert.JSONEq(string(*v), string(*res[i][k]), k)
+		}
+	}
+
+	p, err = FromJSONPatch(res)
+	assert.NoError(err)
+	assert.Equal(expected, p)
+
+	var invalid evanphxPatch
+	assert.NoError(json.Unmarshal([]byte(`[{"op": "unknown", "path": "/a"}]`), &invalid))
+	_, err = FromJSONPatch(invalid)
+	assert.ErrorContains(err, "invalid json patch operation")
+
+	_, err = ToJSONPatch[evanphxPatch](Patch{{Op: OpMove, Path: PathMustFromJSON("/a")}})
+	assert.Error(err)
+}
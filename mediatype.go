@@ -0,0 +1,144 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"strconv"
+	"strings"
+)
+
+// Media types for CBOR documents and patches.
+const (
+	MediaTypeCBOR           = "application/cbor"
+	MediaTypeJSON           = "application/json"
+	MediaTypeCBORPatch      = "application/cbor-patch"
+	MediaTypeJSONPatch      = "application/json-patch+json"
+	MediaTypeCBORMergePatch = "application/merge-patch+cbor"
+)
+
+// ErrUnsupportedMediaType is returned when a media type is not supported.
+var ErrUnsupportedMediaType = errors.New("unsupported media type")
+
+// DecodePatchByContentType decodes the body as a Patch according to the content type.
+// The content type can be MediaTypeCBORPatch or MediaTypeJSONPatch, with optional parameters.
+func DecodePatchByContentType(ct string, body []byte) (Patch, error) {
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return nil, fmt.Errorf("invalid content type %q, %w", ct, err)
+	}
+
+	switch mt {
+	case MediaTypeCBORPatch:
+		return NewPatch(body)
+	case MediaTypeJSONPatch:
+		return PatchFromJSON(string(body))
+	default:
+		return nil, fmt.Errorf("unable to decode patch with content type %q, %w", mt, ErrUnsupportedMediaType)
+	}
+}
+
+// EncodePatchForAccept encodes the patch to the media type that best matches the Accept header.
+// It returns the encoded patch and its content type.
+// MediaTypeCBORPatch is preferred if the Accept header is empty or accepts any media type.
+func EncodePatchForAccept(accept string, p Patch) ([]byte, string, error) {
+	var err error
+	var data []byte
+
+	switch ct := negotiatePatchType(accept); ct {
+	case MediaTypeCBORPatch:
+		if err = p.Valid(); err == nil {
+			data, err = cborMarshal(p)
+		}
+		return data, ct, err
+	case MediaTypeJSONPatch:
+		data, err = marshalJSONPatch(p)
+		return data, ct, err
+	default:
+		return nil, "", fmt.Errorf("unable to encode patch for accept %q, %w", accept, ErrUnsupportedMediaType)
+	}
+}
+
+func negotiatePatchType(accept string) string {
+	if strings.TrimSpace(accept) == "" {
+		return MediaTypeCBORPatch
+	}
+
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+
+		switch mt {
+		case "*/*", "application/*":
+			mt = MediaTypeCBORPatch
+		case MediaTypeCBORPatch, MediaTypeJSONPatch:
+		default:
+			continue
+		}
+
+		if q > bestQ {
+			best, bestQ = mt, q
+		}
+	}
+	return best
+}
+
+func marshalJSONPatch(p Patch) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	buf.WriteByte('[')
+	for i, op := range p {
+		if err := op.Valid(); err != nil {
+			return nil, err
+		}
+
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(`{"op":`)
+		writeJSONText(buf, op.Op.String())
+		if op.From != nil {
+			buf.WriteString(`,"from":`)
+			writeJSONText(buf, PathToJSON(op.From))
+		}
+		buf.WriteString(`,"path":`)
+		writeJSONText(buf, PathToJSON(op.Path))
+		if op.Op == OpSplice {
+			buf.WriteString(`,"index":`)
+			buf.WriteString(strconv.Itoa(op.Index))
+			buf.WriteString(`,"remove-count":`)
+			buf.WriteString(strconv.Itoa(op.RemoveCount))
+		}
+		if op.Value != nil {
+			data, err := ToJSON(op.Value, nil)
+			if err != nil {
+				return nil, err
+			}
+			buf.WriteString(`,"value":`)
+			buf.Write(data)
+		}
+		buf.WriteByte('}')
+	}
+	buf.WriteByte(']')
+	return buf.Bytes(), nil
+}
+
+// writeJSONText writes s as a JSON string.
+func writeJSONText(buf *bytes.Buffer, s string) {
+	data, _ := json.Marshal(s)
+	buf.Write(data)
+}
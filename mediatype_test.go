@@ -0,0 +1,64 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodePatchByContentType(t *testing.T) {
+	assert := assert.New(t)
+
+	p := Patch{
+		{Op: OpAdd, Path: PathMustFrom("a", "b/c"), Value: MustMarshal(1)},
+		{Op: OpMove, From: PathMustFrom("a", 0), Path: PathMustFrom("b")},
+	}
+
+	res, err := DecodePatchByContentType(MediaTypeCBORPatch, MustMarshal(p))
+	assert.NoError(err)
+	assert.Equal(p, res)
+
+	res, err = DecodePatchByContentType(MediaTypeJSONPatch+"; charset=utf-8",
+		[]byte(`[{"op":"add","path":"/a/b~1c","value":1},{"op":"move","from":"/a/0","path":"/b"}]`))
+	assert.NoError(err)
+	assert.Equal(p, res)
+
+	_, err = DecodePatchByContentType(MediaTypeCBOR, MustMarshal(p))
+	assert.True(errors.Is(err, ErrUnsupportedMediaType))
+
+	_, err = DecodePatchByContentType("", MustMarshal(p))
+	assert.Error(err)
+}
+
+func TestEncodePatchForAccept(t *testing.T) {
+	assert := assert.New(t)
+
+	p := Patch{
+		{Op: OpAdd, Path: PathMustFrom("a", "b/c"), Value: MustMarshal(1)},
+		{Op: OpMove, From: PathMustFrom("a", 0), Path: PathMustFrom("b")},
+	}
+
+	for _, accept := range []string{"", "*/*", MediaTypeCBORPatch,
+		"application/json-patch+json;q=0.5, application/cbor-patch"} {
+		data, ct, err := EncodePatchForAccept(accept, p)
+		assert.NoError(err)
+		assert.Equal(MediaTypeCBORPatch, ct)
+		assert.Equal(MustMarshal(p), data)
+	}
+
+	data, ct, err := EncodePatchForAccept("text/plain, application/json-patch+json", p)
+	assert.NoError(err)
+	assert.Equal(MediaTypeJSONPatch, ct)
+	assert.Equal(`[{"op":"add","path":"/a/b~1c","value":1},{"op":"move","from":"/a/0","path":"/b"}]`, string(data))
+
+	res, err := DecodePatchByContentType(ct, data)
+	assert.NoError(err)
+	assert.Equal(p, res)
+
+	_, _, err = EncodePatchForAccept("text/plain", p)
+	assert.True(errors.Is(err, ErrUnsupportedMediaType))
+}
@@ -0,0 +1,148 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+// MergePatch applies a RFC 7396 style merge patch to a CBOR document, and returns the new document.
+// If the patch is a map, its keys are merged recursively into the document:
+// a null value removes the key, and other values replace the key's value.
+// Otherwise the patch replaces the whole document.
+// A nil or empty document or patch is equal to CBOR null.
+func MergePatch(doc, patch []byte) ([]byte, error) {
+	for _, data := range [][]byte{doc, patch} {
+		if len(data) > 0 {
+			if err := cborValid(data); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return mergeNode(NewNode(doc), NewNode(patch)).MarshalCBOR()
+}
+
+// MergePatchWithOptions is like MergePatch, but decodes and encodes the documents with the options,
+// such as MaxNestingDepth, DupMapKey, AllowAnyMapKey, AllowIndefiniteLength, EncMode and DecMode,
+// and checks the new document with options.Profile and options.ResultValidator.
+// Unlike MergePatch, the documents are fully decoded, so that their invalid items are reported.
+func MergePatchWithOptions(doc, patch []byte, options *Options) ([]byte, error) {
+	if options == nil {
+		options = NewOptions()
+	}
+
+	c := newCodec(options)
+	nodes := make([]*Node, 2)
+	for i, data := range [][]byte{doc, patch} {
+		if len(data) > 0 {
+			if options.AllowIndefiniteLength {
+				var err error
+				if data, err = definiteLength(data); err != nil {
+					return nil, err
+				}
+			}
+			if err := cborValid(data); err != nil {
+				return nil, err
+			}
+			if options.DupMapKey == DupMapKeyStrict {
+				if err := checkDupMapKeys(data, Path{}); err != nil {
+					return nil, err
+				}
+			}
+		}
+		nodes[i] = NewNode(data)
+		nodes[i].useCodec(c)
+		if err := nodes[i].Materialize(); err != nil {
+			return nil, err
+		}
+	}
+
+	res := mergeNode(nodes[0], nodes[1])
+	if err := res.validateResult(options); err != nil {
+		return nil, err
+	}
+	return res.MarshalCBOR()
+}
+
+func mergeNode(target, patch *Node) *Node {
+	pd, _ := patch.intoContainer()
+	po, ok := pd.(*partialDoc)
+	if !ok {
+		return patch
+	}
+
+	td, _ := target.intoContainer()
+	to, ok := td.(*partialDoc)
+	if !ok {
+		target = NewNode(rawCBORMap)
+		target.useCodec(patch.codec)
+		td, _ = target.intoContainer()
+		to = td.(*partialDoc)
+	}
+	target.dirty = true
+
+	for k, v := range po.obj {
+		if v.isNull() {
+			to.del(k)
+			continue
+		}
+
+		cur, ok := to.obj[k]
+		if !ok || cur == nil {
+			cur = NewNode(nil)
+		}
+		to.put(k, mergeNode(cur, v))
+	}
+	return target
+}
+
+// CreateMergePatch creates a RFC 7396 style merge patch that transforms the original CBOR document
+// to the modified one, MergePatch(original, patch) returns a document equal to the modified one.
+// If either document is not a map, the patch is the modified document.
+// Note that a merge patch can not set a key to null, so null values in the modified maps
+// are treated as removed keys.
+func CreateMergePatch(original, modified []byte) ([]byte, error) {
+	for _, data := range [][]byte{original, modified} {
+		if len(data) > 0 {
+			if err := cborValid(data); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return createMergeNode(NewNode(original), NewNode(modified)).MarshalCBOR()
+}
+
+func createMergeNode(original, modified *Node) *Node {
+	od, _ := original.intoContainer()
+	oo, ok := od.(*partialDoc)
+	if !ok {
+		return modified
+	}
+
+	md, _ := modified.intoContainer()
+	mo, ok := md.(*partialDoc)
+	if !ok {
+		return modified
+	}
+
+	patch := &partialDoc{obj: make(map[RawKey]*Node)}
+	for k, ov := range oo.obj {
+		if mv, ok := mo.obj[k]; !ok || mv.isNull() {
+			if !ov.isNull() {
+				patch.obj[k] = NewNode(nil)
+			}
+		}
+	}
+
+	for k, mv := range mo.obj {
+		if mv.isNull() {
+			continue
+		}
+
+		ov, ok := oo.obj[k]
+		switch {
+		case !ok || ov.isNull():
+			patch.obj[k] = mv
+		case !ov.Equal(mv):
+			patch.obj[k] = createMergeNode(ov, mv)
+		}
+	}
+	return &Node{doc: patch, ty: CBORTypeMap, which: eDoc}
+}
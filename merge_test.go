@@ -0,0 +1,128 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergePatch(t *testing.T) {
+	assert := assert.New(t)
+
+	// Refer to https://www.rfc-editor.org/rfc/rfc7396#appendix-A
+	for _, c := range []struct {
+		doc, patch, result string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	} {
+		res, err := MergePatch(MustFromJSON(c.doc), MustFromJSON(c.patch))
+		assert.NoError(err, c.patch)
+		assert.Equal(c.result, MustToJSON(res), c.patch)
+	}
+
+	res, err := MergePatch(nil, MustFromJSON(`{"a": 1}`))
+	assert.NoError(err)
+	assert.Equal(`{"a":1}`, MustToJSON(res))
+
+	res, err = MergePatch(MustMarshal(map[int]int{1: 1, 2: 2}), MustMarshal(map[int]any{1: nil, 3: 3}))
+	assert.NoError(err)
+	assert.Equal(MustMarshal(map[int]int{2: 2, 3: 3}), res)
+
+	_, err = MergePatch(MustFromJSON(`{}`), []byte{0xa1})
+	assert.Error(err)
+}
+
+func TestMergePatchWithOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	doc := MustFromJSON(`{"a": {"b": 1}, "c": 2}`)
+	res, err := MergePatchWithOptions(doc, MustFromJSON(`{"a": {"b": null, "d": {"e": 3}}}`), nil)
+	assert.NoError(err)
+	assert.Equal(`{"a":{"d":{"e":3}},"c":2}`, MustToJSON(res))
+
+	options := NewOptions()
+	options.MaxNestingDepth = 2
+	_, err = MergePatchWithOptions(doc, MustFromJSON(`{"a": {"d": {"e": 3}}}`), options)
+	assert.ErrorIs(err, ErrTooDeep)
+
+	options = NewOptions()
+	options.ResultValidator = ResultValidatorFunc(func(doc []byte) error {
+		return errors.New("rejected")
+	})
+	_, err = MergePatchWithOptions(doc, MustFromJSON(`{"c": 3}`), options)
+	assert.ErrorContains(err, "invalid patched document, rejected")
+
+	options = NewOptions()
+	options.Profile = DAGCBOR
+	_, err = MergePatchWithOptions(doc, MustMarshal(map[string]any{"c": RawMessage{0x81, 0xf7}}), options)
+	assert.ErrorContains(err, "unsupported simple value 23")
+
+	// {"a": 1, "a": 2}
+	dup := append(appendCBORHead(nil, 5, 2), MustMarshal("a")...)
+	dup = append(append(dup, 0x01), MustMarshal("a")...)
+	dup = append(dup, 0x02)
+	options = NewOptions()
+	options.DupMapKey = DupMapKeyStrict
+	var dupErr *DuplicateKeyError
+	_, err = MergePatchWithOptions(doc, dup, options)
+	assert.ErrorAs(err, &dupErr)
+
+	options = NewOptions()
+	options.PreserveKeyOrder = true
+	ordered, err := FromDiag(`{"z": 1, "a": 2}`)
+	assert.NoError(err)
+	res, err = MergePatchWithOptions(ordered, MustFromJSON(`{"b": 3, "z": 4}`), options)
+	assert.NoError(err)
+	assert.Equal(`{"z": 4, "a": 2, "b": 3}`, Diagify(res))
+}
+
+func TestCreateMergePatch(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, c := range []struct {
+		original, modified, patch string
+	}{
+		{`{"a":"b"}`, `{"a":"b"}`, `{}`},
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"a":"b","b":"c"}`, `{"b":"c"}`},
+		{`{"a":"b","b":"c"}`, `{"b":"c"}`, `{"a":null}`},
+		{`{"a":{"b":"c","d":"e"}}`, `{"a":{"b":"d"}}`, `{"a":{"b":"d","d":null}}`},
+		{`{"a":[1,2]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`["c"]`, `{"a":"b"}`, `{"a":"b"}`},
+		{`{"a":1,"b":null}`, `{"a":null}`, `{"a":null}`},
+		{`{"a":{"x":1}}`, `{"a":"b","c":{"d":{"e":1}}}`, `{"a":"b","c":{"d":{"e":1}}}`},
+	} {
+		original, modified := MustFromJSON(c.original), MustFromJSON(c.modified)
+		patch, err := CreateMergePatch(original, modified)
+		assert.NoError(err, c.modified)
+		assert.Equal(c.patch, MustToJSON(patch), c.modified)
+
+		res, err := MergePatch(original, patch)
+		assert.NoError(err)
+		if c.original != `{"a":1,"b":null}` {
+			assert.True(Equal(modified, res), c.modified)
+		}
+	}
+
+	_, err := CreateMergePatch([]byte{0xa1}, nil)
+	assert.Error(err)
+}
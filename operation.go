@@ -7,6 +7,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"sync/atomic"
 )
 
 type Op int
@@ -19,6 +20,25 @@ const (
 	OpMove
 	OpCopy
 	OpTest
+	OpMerge
+	OpAppend
+	OpSplice
+	OpIncr
+	OpDecr
+	OpToggle
+	OpTextDiff
+	OpBinaryDiff
+	OpTestContains
+	OpTestType
+	OpTestMatch
+	OpTestLess
+	OpTestLessEqual
+	OpTestGreater
+	OpTestGreaterEqual
+	OpTestLength
+	OpTestDefined
+	OpTestUndefined
+	OpTestSubset
 )
 
 // String returns a string representation of the Op.
@@ -38,22 +58,140 @@ func (op Op) String() string {
 		return "copy"
 	case OpTest:
 		return "test"
+	case OpMerge:
+		return "merge"
+	case OpAppend:
+		return "append"
+	case OpSplice:
+		return "splice"
+	case OpIncr:
+		return "incr"
+	case OpDecr:
+		return "decr"
+	case OpToggle:
+		return "toggle"
+	case OpTextDiff:
+		return "text-diff"
+	case OpBinaryDiff:
+		return "binary-diff"
+	case OpTestContains:
+		return "test-contains"
+	case OpTestType:
+		return "test-type"
+	case OpTestMatch:
+		return "test-match"
+	case OpTestLess:
+		return "test-less"
+	case OpTestLessEqual:
+		return "test-less-equal"
+	case OpTestGreater:
+		return "test-greater"
+	case OpTestGreaterEqual:
+		return "test-greater-equal"
+	case OpTestLength:
+		return "test-length"
+	case OpTestDefined:
+		return "test-defined"
+	case OpTestUndefined:
+		return "test-undefined"
+	case OpTestSubset:
+		return "test-subset"
 	}
 }
 
+// IsTest reports whether the Op only asserts the document without changing it,
+// such as "test", "test-contains", "test-type", "test-match", "test-length", "test-defined",
+// "test-undefined", "test-subset" and the numeric comparisons.
+func (op Op) IsTest() bool {
+	switch op {
+	case OpTest, OpTestContains, OpTestType, OpTestMatch, OpTestLength, OpTestDefined, OpTestUndefined, OpTestSubset,
+		OpTestLess, OpTestLessEqual, OpTestGreater, OpTestGreaterEqual:
+		return true
+	}
+	return false
+}
+
 // Operation is a single CBOR-Patch step, such as a single 'add' operation.
 type Operation struct {
 	Op    Op         `cbor:"1,keyasint"`
 	From  Path       `cbor:"2,keyasint,omitempty"`
 	Path  Path       `cbor:"3,keyasint"`
 	Value RawMessage `cbor:"4,keyasint,omitempty"`
+	// Index and RemoveCount are the parameters of a "splice" operation.
+	Index       int `cbor:"5,keyasint,omitempty"`
+	RemoveCount int `cbor:"6,keyasint,omitempty"`
+
+	valueNode atomic.Value // *cachedValueNode
+}
+
+type cachedValueNode struct {
+	value RawMessage
+	node  *Node
+}
+
+// ValueNode returns the Value as a materialized Node, or nil if the Value is nil.
+// The Node is cached until the Value is reassigned, and it is safe for concurrent reads,
+// but it must not be patched.
+func (o *Operation) ValueNode() *Node {
+	if o.Value == nil {
+		return nil
+	}
+
+	if c, ok := o.valueNode.Load().(*cachedValueNode); ok && sameBytes(c.value, o.Value) {
+		return c.node
+	}
+
+	node := NewNode(o.Value)
+	node.Materialize()
+	o.valueNode.Store(&cachedValueNode{value: o.Value, node: node})
+	return node
 }
 
+// clone returns a copy of the operation, the copy does not share the cached ValueNode.
+// Operations are copied by clone rather than by value, which would copy the cache,
+// and race with ValueNode.
+func (o *Operation) clone() *Operation {
+	return &Operation{
+		Op:          o.Op,
+		From:        o.From,
+		Path:        o.Path,
+		Value:       o.Value,
+		Index:       o.Index,
+		RemoveCount: o.RemoveCount,
+	}
+}
+
+// DecodeValue decodes the Value into v.
+func (o *Operation) DecodeValue(v any) error {
+	if o.Value == nil {
+		return fmt.Errorf("%s operation has no value", o.Op)
+	}
+	return cborUnmarshal(o.Value, v)
+}
+
+// Valid checks the operation, the keys in its paths must be integers, text strings or byte strings,
+// except the leading relative key of "from", see Options.AllowAnyMapKey and Options.AllowRelativeFrom.
 func (o *Operation) Valid() error {
+	if err := o.valid(); err != nil {
+		return err
+	}
+	from := o.From
+	if _, rest, ok := from.relative(); ok {
+		from = rest
+	}
+	return validKeyTypes(o.Path, from)
+}
+
+// valid checks the operation like Valid, except the types of the keys in its paths.
+func (o *Operation) valid() error {
 	if o == nil {
 		return errors.New("nil operation")
 	}
 
+	if o.Op != OpSplice && (o.Index != 0 || o.RemoveCount != 0) {
+		return fmt.Errorf(`"index" and "remove-count" must be zero for %q operation`, o.Op)
+	}
+
 	switch o.Op {
 	default:
 		return fmt.Errorf("invalid operation %q", o.Op)
@@ -102,11 +240,297 @@ func (o *Operation) Valid() error {
 		if o.From != nil {
 			return errors.New(`"from" must be nil for "test" operation`)
 		}
+
+	case OpMerge:
+		if o.From != nil {
+			return errors.New(`"from" must be nil for "merge" operation`)
+		}
+		if ReadCBORType(o.Value) != CBORTypeMap {
+			return errors.New(`"value" must be a map for "merge" operation`)
+		}
+
+	case OpAppend:
+		if o.From != nil {
+			return errors.New(`"from" must be nil for "append" operation`)
+		}
+		switch ReadCBORType(o.Value) {
+		case CBORTypeArray, CBORTypeTextString, CBORTypeByteString:
+		default:
+			return errors.New(`"value" must be an array, a text string or a byte string for "append" operation`)
+		}
+
+	case OpSplice:
+		if o.From != nil {
+			return errors.New(`"from" must be nil for "splice" operation`)
+		}
+		if o.RemoveCount < 0 {
+			return errors.New(`"remove-count" must be non-negative for "splice" operation`)
+		}
+		if o.Value != nil && ReadCBORType(o.Value) != CBORTypeArray {
+			return errors.New(`"value" must be an array for "splice" operation`)
+		}
+
+	case OpIncr, OpDecr:
+		if o.From != nil {
+			return fmt.Errorf(`"from" must be nil for %q operation`, o.Op)
+		}
+		if !isCBORNumber(o.Value) {
+			return fmt.Errorf(`"value" must be an integer or a float for %q operation`, o.Op)
+		}
+
+	case OpToggle:
+		if o.From != nil {
+			return errors.New(`"from" must be nil for "toggle" operation`)
+		}
+		if o.Value != nil {
+			return errors.New(`"value" must be nil for "toggle" operation`)
+		}
+
+	case OpTextDiff:
+		if o.From != nil {
+			return errors.New(`"from" must be nil for "text-diff" operation`)
+		}
+		if ReadCBORType(o.Value) != CBORTypeArray {
+			return errors.New(`"value" must be an array for "text-diff" operation`)
+		}
+
+	case OpTestContains:
+		if o.From != nil {
+			return errors.New(`"from" must be nil for "test-contains" operation`)
+		}
+
+	case OpTestType:
+		if o.From != nil {
+			return errors.New(`"from" must be nil for "test-type" operation`)
+		}
+		if _, err := readTestType(o.Value); err != nil {
+			return fmt.Errorf(`"value" must be a type name or a major type for "test-type" operation, %v`, err)
+		}
+
+	case OpTestMatch:
+		if o.From != nil {
+			return errors.New(`"from" must be nil for "test-match" operation`)
+		}
+		if _, err := readTestMatch(o.Value); err != nil {
+			return fmt.Errorf(`"value" must be a regular expression for "test-match" operation, %v`, err)
+		}
+
+	case OpTestLess, OpTestLessEqual, OpTestGreater, OpTestGreaterEqual:
+		if o.From != nil {
+			return fmt.Errorf(`"from" must be nil for %q operation`, o.Op)
+		}
+		if !isCBORNumber(o.Value) {
+			return fmt.Errorf(`"value" must be a number for %q operation`, o.Op)
+		}
+
+	case OpTestLength:
+		if o.From != nil {
+			return errors.New(`"from" must be nil for "test-length" operation`)
+		}
+		if _, _, err := readTestLength(o.Value); err != nil {
+			return fmt.Errorf(`"value" must be a length or an array of min and max lengths for "test-length" operation, %v`, err)
+		}
+
+	case OpTestSubset:
+		if o.From != nil {
+			return errors.New(`"from" must be nil for "test-subset" operation`)
+		}
+
+	case OpTestDefined, OpTestUndefined:
+		if o.From != nil {
+			return fmt.Errorf(`"from" must be nil for %q operation`, o.Op)
+		}
+		if o.Value != nil {
+			return fmt.Errorf(`"value" must be nil for %q operation`, o.Op)
+		}
+
+	case OpBinaryDiff:
+		if o.From != nil {
+			return errors.New(`"from" must be nil for "binary-diff" operation`)
+		}
+		if ReadCBORType(o.Value) != CBORTypeArray {
+			return errors.New(`"value" must be an array for "binary-diff" operation`)
+		}
 	}
 
 	return nil
 }
 
+// NewAdd returns an "add" operation, the value is encoded to CBOR, nil is encoded as CBOR null.
+func NewAdd(path Path, value any) (*Operation, error) {
+	return newOperation(OpAdd, nil, path, value)
+}
+
+// NewRemove returns a "remove" operation.
+func NewRemove(path Path) (*Operation, error) {
+	return newOperation(OpRemove, nil, path, nil)
+}
+
+// NewReplace returns a "replace" operation, the value is encoded to CBOR, nil is encoded as CBOR null.
+func NewReplace(path Path, value any) (*Operation, error) {
+	return newOperation(OpReplace, nil, path, value)
+}
+
+// NewMove returns a "move" operation.
+func NewMove(from, path Path) (*Operation, error) {
+	return newOperation(OpMove, from, path, nil)
+}
+
+// NewCopy returns a "copy" operation.
+func NewCopy(from, path Path) (*Operation, error) {
+	return newOperation(OpCopy, from, path, nil)
+}
+
+// NewTest returns a "test" operation, the value is encoded to CBOR, nil is encoded as CBOR null.
+func NewTest(path Path, value any) (*Operation, error) {
+	return newOperation(OpTest, nil, path, value)
+}
+
+// NewTestContains returns a "test-contains" operation that asserts the array at path contains the value,
+// or the text string or byte string at path contains the value as a substring.
+func NewTestContains(path Path, value any) (*Operation, error) {
+	return newOperation(OpTestContains, nil, path, value)
+}
+
+// NewTestType returns a "test-type" operation that asserts the type of the value at path.
+// The typ is a type name: "int", "float", "bytes", "text", "array", "map", "tag", "bool",
+// "null", "undefined" or "simple" for the other simple values, or a CBOR major type from 0 to 7.
+func NewTestType(path Path, typ any) (*Operation, error) {
+	return newOperation(OpTestType, nil, path, typ)
+}
+
+// NewTestMatch returns a "test-match" operation that asserts the text string at path matches
+// the regular expression in the syntax of the regexp package. The match is not anchored,
+// use "^" and "$" to match the whole text.
+func NewTestMatch(path Path, pattern string) (*Operation, error) {
+	return newOperation(OpTestMatch, nil, path, pattern)
+}
+
+// NewTestLess returns a "test-less" operation that asserts the number at path is less than the value.
+// Integers, bignums and floats are compared by their numeric values.
+func NewTestLess(path Path, value any) (*Operation, error) {
+	return newOperation(OpTestLess, nil, path, value)
+}
+
+// NewTestLessEqual returns a "test-less-equal" operation that asserts the number at path
+// is less than or equal to the value.
+func NewTestLessEqual(path Path, value any) (*Operation, error) {
+	return newOperation(OpTestLessEqual, nil, path, value)
+}
+
+// NewTestGreater returns a "test-greater" operation that asserts the number at path is greater than the value.
+func NewTestGreater(path Path, value any) (*Operation, error) {
+	return newOperation(OpTestGreater, nil, path, value)
+}
+
+// NewTestGreaterEqual returns a "test-greater-equal" operation that asserts the number at path
+// is greater than or equal to the value.
+func NewTestGreaterEqual(path Path, value any) (*Operation, error) {
+	return newOperation(OpTestGreaterEqual, nil, path, value)
+}
+
+// NewTestLength returns a "test-length" operation that asserts the length of the array, map,
+// text string or byte string at path equals n. The length of a text string is the number of
+// its runes (Unicode code points), and the length of a map is the number of its keys.
+func NewTestLength(path Path, n int) (*Operation, error) {
+	return newOperation(OpTestLength, nil, path, n)
+}
+
+// NewTestLengthRange returns a "test-length" operation that asserts the length of the value at path
+// is in the range [min, max], see NewTestLength.
+func NewTestLengthRange(path Path, min, max int) (*Operation, error) {
+	return newOperation(OpTestLength, nil, path, []int{min, max})
+}
+
+// NewTestDefined returns a "test-defined" operation that asserts the path exists, regardless of its value.
+func NewTestDefined(path Path) (*Operation, error) {
+	return newOperation(OpTestDefined, nil, path, nil)
+}
+
+// NewTestUndefined returns a "test-undefined" operation that asserts the path does not exist.
+// Unlike a "test" operation with a null value, it fails if the path exists with a null value.
+func NewTestUndefined(path Path) (*Operation, error) {
+	return newOperation(OpTestUndefined, nil, path, nil)
+}
+
+// NewTestSubset returns a "test-subset" operation that asserts the value at path matches the value
+// as a subset: the keys of a map in the value must exist in the map at path with matching values,
+// other keys are ignored; arrays must have the same length and matching elements; other values must be equal.
+func NewTestSubset(path Path, value any) (*Operation, error) {
+	return newOperation(OpTestSubset, nil, path, value)
+}
+
+// NewMerge returns a "merge" operation, the value is encoded to CBOR and must be a map.
+func NewMerge(path Path, value any) (*Operation, error) {
+	return newOperation(OpMerge, nil, path, value)
+}
+
+// NewAppend returns an "append" operation, the value is encoded to CBOR and must be
+// an array of the elements to append, a text string or a byte string.
+func NewAppend(path Path, value any) (*Operation, error) {
+	return newOperation(OpAppend, nil, path, value)
+}
+
+// NewIncr returns an "incr" operation that adds the delta to the number at path,
+// the delta must be an integer or a float.
+func NewIncr(path Path, delta any) (*Operation, error) {
+	return newOperation(OpIncr, nil, path, delta)
+}
+
+// NewDecr returns a "decr" operation that subtracts the delta from the number at path,
+// the delta must be an integer or a float.
+func NewDecr(path Path, delta any) (*Operation, error) {
+	return newOperation(OpDecr, nil, path, delta)
+}
+
+// NewToggle returns a "toggle" operation that flips the boolean at path.
+func NewToggle(path Path) (*Operation, error) {
+	return newOperation(OpToggle, nil, path, nil)
+}
+
+// NewSplice returns a "splice" operation that removes removeCount elements from the array
+// at path starting at index, and inserts the values there.
+func NewSplice(path Path, index, removeCount int, values ...any) (*Operation, error) {
+	if values == nil {
+		values = []any{}
+	}
+	o, err := newOperation(OpSplice, nil, path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	o.Index, o.RemoveCount = index, removeCount
+	if o.Value, err = cborMarshal(values); err != nil {
+		return nil, fmt.Errorf("invalid value for %s operation, %w", OpSplice, err)
+	}
+	if err = o.Valid(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func newOperation(op Op, from, path Path, value any) (*Operation, error) {
+	o := &Operation{Op: op, From: from, Path: path}
+	if path == nil {
+		o.Path = Path{}
+	}
+
+	var err error
+	switch op {
+	case OpAdd, OpReplace, OpTest, OpMerge, OpAppend, OpIncr, OpDecr, OpTextDiff, OpBinaryDiff,
+		OpTestContains, OpTestType, OpTestMatch, OpTestLength, OpTestSubset,
+		OpTestLess, OpTestLessEqual, OpTestGreater, OpTestGreaterEqual:
+		if o.Value, err = cborMarshal(value); err != nil {
+			return nil, fmt.Errorf("invalid value for %s operation, %w", op, err)
+		}
+	}
+
+	if err = o.Valid(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
 func (op Op) Operation(from, path []any, value any) (*Operation, error) {
 	o := &Operation{Op: op}
 	var err error
@@ -153,6 +577,55 @@ func PathFrom(keys ...any) (Path, error) {
 	return path, nil
 }
 
+// RelativePath returns a relative path that goes up the levels from the path of an operation
+// and then follows the keys, like the Relative JSON Pointer "1/name" for RelativePath(1, "name"),
+// which is the sibling "name" of the path. Its first key is the levels wrapped in RelativePathTag.
+// It can be the From of "move" and "copy" operations with Options.AllowRelativeFrom.
+func RelativePath(up uint, keys ...any) (Path, error) {
+	rest, err := PathFrom(keys...)
+	if err != nil {
+		return nil, err
+	}
+	return append(Path{relativeKey(uint64(up))}, rest...), nil
+}
+
+func relativeKey(up uint64) RawKey {
+	return RawKey(appendCBORHead(appendCBORHead(nil, 6, RelativePathTag), 0, up))
+}
+
+// relative returns the levels to go up and the keys to follow if the path is relative, see RelativePath.
+func (p Path) relative() (uint64, Path, bool) {
+	if len(p) == 0 || ReadCBORType([]byte(p[0])) != CBORTypeTag {
+		return 0, nil, false
+	}
+
+	_, num, next, err := cborHead([]byte(p[0]), 0)
+	if err != nil || num != RelativePathTag {
+		return 0, nil, false
+	}
+	major, up, end, err := cborHead([]byte(p[0]), next)
+	if err != nil || major != 0 || end != len(p[0]) {
+		return 0, nil, false
+	}
+	return up, p[1:], true
+}
+
+// resolve returns the absolute path of the relative path against the base path,
+// or the path itself if it is not relative.
+func (p Path) resolve(base Path) (Path, error) {
+	up, rest, ok := p.relative()
+	if !ok {
+		return p, nil
+	}
+	if up > uint64(len(base)) {
+		return nil, fmt.Errorf("relative path %s goes up beyond path %s, %w", p, base, ErrInvalid)
+	}
+
+	path := make(Path, 0, len(base)-int(up)+len(rest))
+	path = append(path, base[:len(base)-int(up)]...)
+	return append(path, rest...), nil
+}
+
 func PathMustFrom(keys ...any) Path {
 	path, err := PathFrom(keys...)
 	if err != nil {
@@ -179,6 +652,35 @@ func (p Path) String() string {
 	return buf.String()
 }
 
+// UnmarshalCBOR decodes an array of keys into *p, or a text string as a JSON Pointer by PathFromJSON,
+// such as "/a/0", so the operations encoded by other producers with JSON Pointers are accepted.
+// Paths are always encoded as arrays of keys.
+func (p *Path) UnmarshalCBOR(data []byte) error {
+	if p == nil {
+		return errors.New("nil Path")
+	}
+
+	if ReadCBORType(data) == CBORTypeTextString {
+		var jsonpath string
+		if err := cborUnmarshal(data, &jsonpath); err != nil {
+			return err
+		}
+		path, err := PathFromJSON(jsonpath)
+		if err != nil {
+			return err
+		}
+		*p = path
+		return nil
+	}
+
+	var keys []RawKey
+	if err := cborUnmarshal(data, &keys); err != nil {
+		return err
+	}
+	*p = keys
+	return nil
+}
+
 func (p Path) withIndex(i int) Path {
 	return p.WithKey(RawKey(MustMarshal(i)))
 }
@@ -190,6 +692,19 @@ func (p Path) WithKey(key RawKey) Path {
 	return np
 }
 
+// hasPrefix reports whether the path is the prefix path or a descendant of it.
+func (p Path) hasPrefix(prefix Path) bool {
+	if len(p) < len(prefix) {
+		return false
+	}
+	for i, k := range prefix {
+		if p[i] != k {
+			return false
+		}
+	}
+	return true
+}
+
 // rawKey is a raw encoded CBOR value for map key.
 type RawKey string
 
@@ -213,13 +728,34 @@ func (k RawKey) isIndex() bool {
 }
 
 func (k RawKey) Valid() error {
+	if err := k.validType(); err != nil {
+		return err
+	}
+	return cborValid([]byte(k))
+}
+
+// validType returns an error if the key is not an integer, a text string or a byte string,
+// see Options.AllowAnyMapKey.
+func (k RawKey) validType() error {
 	switch t := ReadCBORType([]byte(k)); t {
 	default:
-		return fmt.Errorf("%q can not be used as map key", t)
+		return fmt.Errorf("%q can not be used as map key, %w", t, ErrInvalid)
 
 	case CBORTypePositiveInt, CBORTypeNegativeInt, CBORTypeTextString, CBORTypeByteString:
-		return cborValid([]byte(k))
+		return nil
+	}
+}
+
+// validKeyTypes returns an error if a key in the paths is not an integer, a text string or a byte string.
+func validKeyTypes(paths ...Path) error {
+	for _, path := range paths {
+		for _, k := range path {
+			if err := k.validType(); err != nil {
+				return fmt.Errorf("invalid path %s, %w", path, err)
+			}
+		}
 	}
+	return nil
 }
 
 func (k RawKey) toInt() (int, error) {
@@ -273,11 +809,18 @@ func (k RawKey) MarshalCBOR() ([]byte, error) {
 }
 
 // UnmarshalCBOR creates a copy of data and saves to *k.
+// Keys of any CBOR type are accepted, the types are checked by Operation.Valid and NewPatch,
+// and when the keys are used in documents, see Options.AllowAnyMapKey.
 func (k *RawKey) UnmarshalCBOR(data []byte) error {
 	if k == nil {
 		return errors.New("nil RawKey")
 	}
 
 	*k = RawKey(data)
-	return k.Valid()
+	return nil
+}
+
+// sameBytes reports whether a and b are the same slice of the same underlying array.
+func sameBytes(a, b []byte) bool {
+	return len(a) == len(b) && (len(a) == 0 || &a[0] == &b[0])
 }
@@ -2,3 +2,199 @@
 // See the file LICENSE for licensing terms.
 
 package cborpatch
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOperationValue(t *testing.T) {
+	assert := assert.New(t)
+
+	op := &Operation{Op: OpAdd, Path: PathMustFrom("a"), Value: MustFromJSON(`{"b": [1, 2]}`)}
+	node := op.ValueNode()
+	assert.Same(node, op.ValueNode())
+
+	v, err := node.GetValue(PathMustFrom("b", 1), nil)
+	assert.NoError(err)
+	assert.Equal(MustMarshal(2), []byte(v))
+
+	var val struct {
+		B []int `cbor:"b"`
+	}
+	assert.NoError(op.DecodeValue(&val))
+	assert.Equal([]int{1, 2}, val.B)
+
+	var s string
+	assert.Error(op.DecodeValue(&s))
+
+	op.Value = MustMarshal("x")
+	assert.NotSame(node, op.ValueNode())
+	assert.Equal(`"x"`, op.ValueNode().String())
+	assert.NoError(op.DecodeValue(&s))
+	assert.Equal("x", s)
+
+	op = &Operation{Op: OpRemove, Path: PathMustFrom("a")}
+	assert.Nil(op.ValueNode())
+	assert.ErrorContains(op.DecodeValue(&s), "remove operation has no value")
+}
+
+func TestOperationValueNodeCopy(t *testing.T) {
+	assert := assert.New(t)
+
+	op := &Operation{Op: OpAdd, Path: PathMustFrom("a"), Value: MustMarshal(1)}
+	node := op.ValueNode()
+
+	// copies of the operation do not share the cached node.
+	rp := Patch{op}.Rebase(PathMustFrom("x"))
+	assert.NotSame(node, rp[0].ValueNode())
+	assert.Equal("1", rp[0].ValueNode().String())
+
+	rp[0].Value = MustMarshal(2)
+	assert.Equal("2", rp[0].ValueNode().String())
+	assert.Same(node, op.ValueNode())
+	assert.Equal("1", op.ValueNode().String())
+
+	// copying an operation does not race with ValueNode.
+	op = &Operation{Op: OpAdd, Path: PathMustFrom("a"), Value: MustMarshal(3)}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			op.ValueNode()
+		}()
+		go func() {
+			defer wg.Done()
+			Patch{op}.Rebase(PathMustFrom("x"))
+		}()
+	}
+	wg.Wait()
+}
+
+func TestNewOperations(t *testing.T) {
+	assert := assert.New(t)
+
+	ops := make(Patch, 0, 6)
+	for _, fn := range []func() (*Operation, error){
+		func() (*Operation, error) { return NewTest(PathMustFrom("a"), nil) },
+		func() (*Operation, error) { return NewAdd(PathMustFrom("b"), []int{1}) },
+		func() (*Operation, error) { return NewReplace(PathMustFrom("a"), "x") },
+		func() (*Operation, error) { return NewCopy(PathMustFrom("b"), PathMustFrom("c")) },
+		func() (*Operation, error) { return NewMove(PathMustFrom("c"), PathMustFrom("d")) },
+		func() (*Operation, error) { return NewRemove(PathMustFrom("b", 0)) },
+	} {
+		op, err := fn()
+		assert.NoError(err)
+		ops = append(ops, op)
+	}
+
+	data, err := PatchToJSON(ops)
+	assert.NoError(err)
+	assert.Equal(`[{"op":"test","path":"/a","value":null},{"op":"add","path":"/b","value":[1]},{"op":"replace","path":"/a","value":"x"},{"op":"copy","from":"/b","path":"/c"},{"op":"move","from":"/c","path":"/d"},{"op":"remove","path":"/b/0"}]`, string(data))
+
+	doc, err := ops.Apply(MustFromJSON(`{"a": null}`))
+	assert.NoError(err)
+	assert.Equal(`{"a":"x","b":[],"d":[1]}`, MustToJSON(doc))
+
+	op, err := NewReplace(nil, map[string]int{"a": 1})
+	assert.NoError(err)
+	assert.Equal(Path{}, op.Path)
+
+	_, err = NewMove(nil, PathMustFrom("a"))
+	assert.ErrorContains(err, `"from" must be non-nil`)
+	_, err = NewAdd(PathMustFrom("a"), func() {})
+	assert.ErrorContains(err, "invalid value for add operation")
+}
+
+func TestNewToggle(t *testing.T) {
+	assert := assert.New(t)
+
+	op, err := NewToggle(PathMustFrom("f"))
+	assert.NoError(err)
+	doc, err := Patch{op}.Apply(MustFromJSON(`{"f": false}`))
+	assert.NoError(err)
+	assert.Equal(`{"f":true}`, MustToJSON(doc))
+
+	_, err = Patch{{Op: OpToggle, Path: PathMustFrom("f"), Value: MustMarshal(true)}}.Apply(doc)
+	assert.ErrorContains(err, `"value" must be nil`)
+}
+
+func TestNewIncr(t *testing.T) {
+	assert := assert.New(t)
+
+	incr, err := NewIncr(PathMustFrom("n"), 3)
+	assert.NoError(err)
+	decr, err := NewDecr(PathMustFrom("n"), 0.5)
+	assert.NoError(err)
+
+	doc, err := Patch{incr, decr}.Apply(MustFromJSON(`{"n": 1}`))
+	assert.NoError(err)
+	assert.Equal(`{"n":3.5}`, MustToJSON(doc))
+
+	_, err = NewIncr(PathMustFrom("n"), "1")
+	assert.ErrorContains(err, "must be an integer or a float")
+	_, err = NewDecr(PathMustFrom("n"), nil)
+	assert.ErrorContains(err, "must be an integer or a float")
+}
+
+func TestNewSplice(t *testing.T) {
+	assert := assert.New(t)
+
+	op, err := NewSplice(PathMustFrom("a"), 1, 1, "x", 2)
+	assert.NoError(err)
+	data, err := PatchToJSON(Patch{op})
+	assert.NoError(err)
+	assert.Equal(`[{"op":"splice","path":"/a","index":1,"remove-count":1,"value":["x",2]}]`, string(data))
+
+	p, err := NewPatch(MustMarshal(Patch{op}))
+	assert.NoError(err)
+	assert.Equal(1, p[0].Index)
+	assert.Equal(1, p[0].RemoveCount)
+
+	options := NewOptions()
+	options.SupportNegativeIndices = true
+	doc, err := p.ApplyWithOptions(MustFromJSON(`{"a": [0, 1, 2]}`), options)
+	assert.NoError(err)
+	assert.Equal(`{"a":[0,"x",2,2]}`, MustToJSON(doc))
+
+	op, err = NewSplice(PathMustFrom("a"), -1, 1)
+	assert.NoError(err)
+	doc, err = Patch{op}.ApplyWithOptions(doc, options)
+	assert.NoError(err)
+	assert.Equal(`{"a":[0,"x",2]}`, MustToJSON(doc))
+
+	_, err = NewSplice(PathMustFrom("a"), 0, -1)
+	assert.ErrorContains(err, "must be non-negative")
+}
+
+func TestPathUnmarshalCBOR(t *testing.T) {
+	assert := assert.New(t)
+
+	// a patch encoded by other producers with JSON Pointers.
+	doc := MustMarshal([]map[int]any{
+		{1: OpAdd, 3: "/a/0", 4: "x"},
+		{1: OpMove, 2: "/b/~s1", 3: "/c"},
+		{1: OpReplace, 3: "", 4: MustMarshal(nil)},
+		{1: OpRemove, 3: []string{"d", "~"}},
+	})
+	p, err := NewPatch(doc)
+	assert.NoError(err)
+	assert.Equal(PathMustFrom("a", 0), p[0].Path)
+	assert.Equal(PathMustFrom("b", "1"), p[1].From)
+	assert.Equal(PathMustFrom("c"), p[1].Path)
+	assert.Equal(Path{}, p[2].Path)
+	assert.Equal(PathMustFrom("d", "~"), p[3].Path)
+
+	// paths are encoded as arrays of keys.
+	data, err := cborMarshal(p[0])
+	assert.NoError(err)
+	assert.Equal(MustMarshal(map[int]any{1: OpAdd, 3: []any{"a", 0}, 4: "x"}), data)
+
+	var path Path
+	assert.Error(path.UnmarshalCBOR(MustMarshal("a/b")))
+	assert.Error(path.UnmarshalCBOR(MustMarshal("/~ix")))
+	assert.Error(path.UnmarshalCBOR(MustMarshal(1)))
+}
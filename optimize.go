@@ -0,0 +1,159 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+// Optimize returns a patch that has the same effect as p but with fewer operations.
+// It composes the operations with ComposePatches, and drops the "move" operations
+// that move a value to where it is.
+//
+// If doc is not nil, the patch is applied to it with the default options to learn
+// the document, and the following redundant operations are removed too:
+//
+//	"replace" and "add" operations that do not change the value,
+//	"add" operations whose value is removed later,
+//	"move" chains from A to B and then from B to C are rewritten to a "move" from A to C.
+//
+// The optimized patch is meant to be applied to doc, it returns an error if p does not apply to doc.
+func (p Patch) Optimize(doc []byte) (Patch, error) {
+	ops, err := ComposePatches(p)
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []optimizeInfo
+	if doc != nil {
+		if infos, err = ops.optimizeInfos(doc); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, op := range ops {
+		switch {
+		case op.Op == OpMove && op.From.hasPrefix(op.Path) && op.Path.hasPrefix(op.From):
+			ops[i] = nil
+		case infos != nil && infos[i].noop:
+			ops[i] = nil
+		}
+	}
+
+	if infos != nil {
+		for j, op := range ops {
+			if op == nil {
+				continue
+			}
+
+			switch op.Op {
+			case OpRemove:
+				guard := []access{{path: op.Path, write: true, shift: true}}
+				if i := optimizeFind(ops, j, guard, func(prev *Operation) bool {
+					return (prev.Op == OpAdd || prev.Op == OpCopy) && prev.Path.hasPrefix(op.Path) && op.Path.hasPrefix(prev.Path)
+				}); i >= 0 {
+					// the removed value was added, and the old value is removed if it existed.
+					ops[i] = nil
+					if !infos[i].existed {
+						ops[j] = nil
+					}
+				}
+
+			case OpMove:
+				guard := []access{
+					{path: op.From, write: true, shift: true},
+					{path: op.Path, write: true, shift: true},
+				}
+				if i := optimizeFind(ops, j, guard, func(prev *Operation) bool {
+					return prev.Op == OpMove && prev.Path.hasPrefix(op.From) && op.From.hasPrefix(prev.Path)
+				}); i >= 0 && !infos[i].existed &&
+					optimizeClear(ops[i+1:j], []access{{path: ops[i].From, write: true, shift: true}}) {
+					from := ops[i].From
+					ops[i] = nil
+					if from.hasPrefix(op.Path) && op.Path.hasPrefix(from) {
+						ops[j] = nil
+					} else {
+						ops[j] = &Operation{Op: OpMove, From: from, Path: op.Path}
+					}
+				}
+			}
+		}
+	}
+
+	res := make(Patch, 0, len(ops))
+	for _, op := range ops {
+		if op != nil {
+			res = append(res, op)
+		}
+	}
+	return res, nil
+}
+
+type optimizeInfo struct {
+	// existed indicates that the path of an "add", "copy" or "move" operation
+	// is an existing map key that is overwritten.
+	existed bool
+	// noop indicates that a "replace" or "add" operation does not change the value.
+	noop bool
+}
+
+func (p Patch) optimizeInfos(doc []byte) ([]optimizeInfo, error) {
+	options := NewOptions()
+	node := NewNode(doc)
+	infos := make([]optimizeInfo, len(p))
+	for i, op := range p {
+		pd, err := node.intoContainer()
+		if err != nil {
+			return nil, err
+		}
+
+		switch op.Op {
+		case OpAdd, OpCopy, OpMove, OpReplace:
+			if con, key := findObject(&pd, op.Path, options); con != nil {
+				if old, err := con.get(key, options); err == nil {
+					_, infos[i].existed = con.(*partialDoc)
+					if op.Op == OpReplace || op.Op == OpAdd && infos[i].existed {
+						infos[i].noop = old.Equal(NewNode(op.Value))
+					}
+				}
+			}
+		}
+
+		if err = node.Patch(Patch{op}, options); err != nil {
+			return nil, err
+		}
+	}
+	return infos, nil
+}
+
+// optimizeFind finds the last operation before ops[j] that matches,
+// and no operation between them overlaps the guard paths.
+func optimizeFind(ops []*Operation, j int, guard []access, match func(*Operation) bool) int {
+	for i := j - 1; i >= 0; i-- {
+		prev := ops[i]
+		if prev == nil {
+			continue
+		}
+		if match(prev) {
+			return i
+		}
+		if !optimizeClear(ops[i:i+1], guard) {
+			return -1
+		}
+	}
+	return -1
+}
+
+// optimizeClear reports whether none of the operations overlaps the guard paths.
+func optimizeClear(ops []*Operation, guard []access) bool {
+	for _, op := range ops {
+		if op == nil {
+			continue
+		}
+		for _, a := range guard {
+			for _, b := range accessesOf(op) {
+				if a.overlaps(b) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
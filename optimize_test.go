@@ -0,0 +1,65 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPatchOptimize(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, c := range []struct {
+		doc, patch, optimized string
+	}{
+		{``, `[{"op":"move","from":"/a","path":"/a"},{"op":"replace","path":"/b","value":1},{"op":"replace","path":"/b","value":2}]`,
+			`[{"op":"replace","path":"/b","value":2}]`},
+		{`{"a": 1, "b": [1, 2]}`, `[{"op":"replace","path":"/a","value":1},{"op":"add","path":"/a","value":1},{"op":"replace","path":"/b/0","value":1}]`,
+			`[]`},
+		{`{"a": 1}`, `[{"op":"add","path":"/b","value":2},{"op":"test","path":"/a","value":1},{"op":"remove","path":"/b"}]`,
+			`[{"op":"test","path":"/a","value":1}]`},
+		{`{"a": 1}`, `[{"op":"add","path":"/a","value":2},{"op":"remove","path":"/a"}]`,
+			`[{"op":"remove","path":"/a"}]`},
+		{`{"a": [1]}`, `[{"op":"add","path":"/a/0","value":0},{"op":"test","path":"/a/1","value":1},{"op":"remove","path":"/a/0"}]`,
+			`[{"op":"add","path":"/a/0","value":0},{"op":"test","path":"/a/1","value":1},{"op":"remove","path":"/a/0"}]`},
+		{`{"a": 1}`, `[{"op":"move","from":"/a","path":"/b"},{"op":"move","from":"/b","path":"/c"},{"op":"move","from":"/c","path":"/d"}]`,
+			`[{"op":"move","from":"/a","path":"/d"}]`},
+		{`{"a": 1}`, `[{"op":"move","from":"/a","path":"/b"},{"op":"move","from":"/b","path":"/a"}]`,
+			`[]`},
+		{`{"a": 1, "b": 2}`, `[{"op":"move","from":"/a","path":"/b"},{"op":"move","from":"/b","path":"/c"}]`,
+			`[{"op":"move","from":"/a","path":"/b"},{"op":"move","from":"/b","path":"/c"}]`},
+		{`{"a": 1}`, `[{"op":"move","from":"/a","path":"/b"},{"op":"add","path":"/a","value":2},{"op":"move","from":"/b","path":"/c"}]`,
+			`[{"op":"move","from":"/a","path":"/b"},{"op":"add","path":"/a","value":2},{"op":"move","from":"/b","path":"/c"}]`},
+	} {
+		p, err := PatchFromJSON(c.patch)
+		assert.NoError(err)
+
+		var doc []byte
+		if c.doc != "" {
+			doc = MustFromJSON(c.doc)
+		}
+		optimized, err := p.Optimize(doc)
+		if !assert.NoError(err) {
+			continue
+		}
+		data, err := PatchToJSON(optimized)
+		assert.NoError(err)
+		assert.Equal(c.optimized, string(data), c.patch)
+
+		if doc != nil {
+			expected, err := p.Apply(doc)
+			assert.NoError(err)
+			result, err := optimized.Apply(doc)
+			assert.NoError(err)
+			assert.True(Equal(expected, result), "got %s, expected %s", MustToJSON(result), MustToJSON(expected))
+		}
+	}
+
+	p, err := PatchFromJSON(`[{"op":"remove","path":"/x"}]`)
+	assert.NoError(err)
+	_, err = p.Optimize(MustFromJSON(`{"a": 1}`))
+	assert.ErrorContains(err, "remove operation does not apply")
+}
@@ -0,0 +1,315 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"errors"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// Partition splits the patch into groups that touch non-overlapping top-level keys.
+// Operations in a group keep their relative order, and groups are ordered by their first operation.
+// A "move" or "copy" operation joins the groups of its "from" and "path" keys.
+// If any operation targets the root path, the whole patch is returned as a single group.
+func (p Patch) Partition() []Patch {
+	idxs := p.partition()
+	if idxs == nil {
+		return nil
+	}
+
+	groups := make([]Patch, len(idxs))
+	for g, idx := range idxs {
+		groups[g] = make(Patch, len(idx))
+		for j, i := range idx {
+			groups[g][j] = p[i]
+		}
+	}
+	return groups
+}
+
+// partition returns the indexes of the operations in the groups of Partition.
+func (p Patch) partition() [][]int {
+	if len(p) == 0 {
+		return nil
+	}
+
+	parent := make([]int, len(p))
+	owner := make(map[RawKey]int, len(p))
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	join := func(i int, path Path) {
+		if j, ok := owner[path[0]]; ok {
+			if a, b := find(i), find(j); a != b {
+				if a < b {
+					parent[b] = a
+				} else {
+					parent[a] = b
+				}
+			}
+			return
+		}
+		owner[path[0]] = i
+	}
+
+	for i, op := range p {
+		if len(op.Path) == 0 || (op.From != nil && len(op.From) == 0) {
+			all := make([]int, len(p))
+			for j := range all {
+				all[j] = j
+			}
+			return [][]int{all}
+		}
+
+		parent[i] = i
+		join(i, op.Path)
+		if op.From != nil {
+			join(i, op.From)
+		}
+	}
+
+	idx := make(map[int]int)
+	groups := make([][]int, 0)
+	for i := range p {
+		r := find(i)
+		g, ok := idx[r]
+		if !ok {
+			g = len(groups)
+			idx[r] = g
+			groups = append(groups, nil)
+		}
+		groups[g] = append(groups[g], i)
+	}
+	return groups
+}
+
+// ApplyConcurrently mutates a CBOR document according to the patch and the passed in Options,
+// applying the groups of Partition concurrently. It returns the new document.
+func (p Patch) ApplyConcurrently(doc []byte, options *Options) ([]byte, error) {
+	node := NewNode(doc)
+	if err := node.PatchConcurrently(p, options); err != nil {
+		return nil, err
+	}
+	return node.MarshalCBOR()
+}
+
+// PatchConcurrently applies the patch to the node like Patch, but applies the groups of Partition
+// concurrently, each on an isolated view of the top-level keys it touches.
+// It falls back to Patch if the node is not a map or the patch can not be partitioned.
+//
+// It also falls back to Patch if OnBeforeOp, OnAfterOp or AuditWriter is set,
+// since they observe the operations in the order of the patch.
+//
+// The result is the same as Patch, except that AccumulatedCopySizeLimit applies to each group,
+// and that every group runs to its end even if another group fails. When a group fails,
+// the groups that finished stay applied to the node, and the failed group is applied
+// up to its failed operation like Patch.
+// The PatchError of a failed operation has its index in the patch, the first one if several groups fail.
+func (n *Node) PatchConcurrently(p Patch, options *Options) error {
+	if options == nil {
+		options = NewOptions()
+	}
+
+	n.useCodec(newCodec(options))
+	if _, err := n.intoContainer(); err != nil || n.which != eDoc ||
+		options.OnBeforeOp != nil || options.OnAfterOp != nil || options.AuditWriter != nil {
+		return n.Patch(p, options)
+	}
+	if err := checkPatchOps(p, options); err != nil {
+		return err
+	}
+
+	if options.AllowRelativeFrom {
+		var err error
+		if p, err = absolutePatch(p); err != nil {
+			return err
+		}
+	}
+	if options.AllowWildcard {
+		// a wildcard at the root matches the keys of other groups.
+		for _, op := range p {
+			if len(op.Path) > 0 && op.Path[0] == wildcardKey {
+				return n.Patch(p, options)
+			}
+		}
+	}
+	idxs := p.partition()
+	if len(idxs) < 2 {
+		return n.Patch(p, options)
+	}
+	if options.DupMapKey == DupMapKeyStrict {
+		if err := n.checkDupMapKeys(p); err != nil {
+			return err
+		}
+	}
+
+	for i, op := range p {
+		if err := validOp(op, options); err != nil {
+			return newPatchError(i, op, err)
+		}
+	}
+
+	groups := make([]Patch, len(idxs))
+	for g, idx := range idxs {
+		for _, i := range idx {
+			groups[g] = append(groups[g], p[i])
+		}
+	}
+
+	opts := *options
+	opts.Profile = nil
+	opts.ResultValidator = nil
+
+	views := make([]*Node, len(groups))
+	for i, g := range groups {
+		obj := make(map[RawKey]*Node)
+		for _, op := range g {
+			for _, path := range []Path{op.Path, op.From} {
+				if len(path) > 0 {
+					if v, ok := n.doc.obj[path[0]]; ok {
+						obj[path[0]] = v
+					}
+				}
+			}
+		}
+		views[i] = &Node{doc: &partialDoc{obj: obj}, ty: CBORTypeMap, which: eDoc, owner: n.owner, codec: n.codec}
+	}
+
+	errs := make([]error, len(groups))
+	ch := make(chan int)
+	wg := sync.WaitGroup{}
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(groups) {
+		workers = len(groups)
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range ch {
+				errs[i] = views[i].Patch(groups[i], &opts)
+			}
+		}()
+	}
+	for i := range groups {
+		ch <- i
+	}
+	close(ch)
+	wg.Wait()
+
+	var keys []RawKey
+	if n.doc.keys != nil {
+		keys = n.doc.patchedKeys(p)
+	}
+	for i, g := range groups {
+		for _, op := range g {
+			for _, path := range []Path{op.Path, op.From} {
+				if len(path) > 0 {
+					if v, ok := views[i].doc.obj[path[0]]; ok {
+						n.doc.put(path[0], v)
+					} else {
+						n.doc.del(path[0])
+					}
+				}
+			}
+		}
+	}
+	if keys != nil {
+		n.doc.setKeys(keys)
+	}
+	n.dirty = true
+
+	var first *PatchError
+	for g, err := range errs {
+		if err == nil {
+			continue
+		}
+		var pe *PatchError
+		if !errors.As(err, &pe) {
+			return err
+		}
+		// map the index in the group to the index in the patch.
+		pe.OpIndex = idxs[g][pe.OpIndex]
+		if first == nil || pe.OpIndex < first.OpIndex {
+			first = pe
+		}
+	}
+	if first != nil {
+		return first
+	}
+	return n.validateResult(options)
+}
+
+// patchedKeys returns the order of the keys of the map after the patch is applied to it like Patch,
+// with Options.PreserveKeyOrder: the keys that are added are appended in the order of the operations
+// that add them, and a key that is removed and added again moves to the end.
+func (d *partialDoc) patchedKeys(p Patch) []RawKey {
+	present := make(map[RawKey]bool)
+	has := func(k RawKey) bool {
+		if ok, seen := present[k]; seen {
+			return ok
+		}
+		_, ok := d.obj[k]
+		return ok
+	}
+
+	added := make(map[RawKey]int)
+	for i, op := range p {
+		if op.Op == OpMove && len(op.From) == 1 {
+			present[op.From[0]] = false
+		}
+		if len(op.Path) == 0 || op.Op.IsTest() {
+			continue
+		}
+		k := op.Path[0]
+		switch {
+		case op.Op == OpRemove && len(op.Path) == 1:
+			present[k] = false
+		case !has(k):
+			present[k] = true
+			added[k] = i
+		}
+	}
+
+	keys := make([]RawKey, 0, len(d.keys)+len(added))
+	for _, k := range d.keys {
+		if _, ok := added[k]; !ok && has(k) {
+			keys = append(keys, k)
+		}
+	}
+	tail := make([]RawKey, 0, len(added))
+	for k := range added {
+		if has(k) {
+			tail = append(tail, k)
+		}
+	}
+	sort.Slice(tail, func(i, j int) bool { return added[tail[i]] < added[tail[j]] })
+	return append(keys, tail...)
+}
+
+// setKeys sets the order of the keys of the map to keys, the keys that are not in the map are dropped,
+// and the keys of the map that are not in keys are appended in their current order.
+func (d *partialDoc) setKeys(keys []RawKey) {
+	seen := make(map[RawKey]bool, len(keys))
+	ordered := make([]RawKey, 0, len(d.obj))
+	for _, k := range keys {
+		if _, ok := d.obj[k]; ok && !seen[k] {
+			seen[k] = true
+			ordered = append(ordered, k)
+		}
+	}
+	for _, k := range d.keys {
+		if _, ok := d.obj[k]; ok && !seen[k] {
+			seen[k] = true
+			ordered = append(ordered, k)
+		}
+	}
+	d.keys = ordered
+}
@@ -0,0 +1,222 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPartition(t *testing.T) {
+	assert := assert.New(t)
+
+	p, err := PatchFromJSON(`[
+		{"op": "add", "path": "/a/x", "value": 1},
+		{"op": "remove", "path": "/b/0"},
+		{"op": "replace", "path": "/c", "value": 2},
+		{"op": "move", "from": "/d/y", "path": "/a/y"},
+		{"op": "test", "path": "/b/0", "value": 3},
+		{"op": "copy", "from": "/e", "path": "/f"}
+	]`)
+	assert.NoError(err)
+
+	groups := p.Partition()
+	assert.Equal(4, len(groups))
+	assert.Equal(Patch{p[0], p[3]}, groups[0])
+	assert.Equal(Patch{p[1], p[4]}, groups[1])
+	assert.Equal(Patch{p[2]}, groups[2])
+	assert.Equal(Patch{p[5]}, groups[3])
+
+	p, err = PatchFromJSON(`[
+		{"op": "add", "path": "/a/x", "value": 1},
+		{"op": "replace", "path": "", "value": 2}
+	]`)
+	assert.NoError(err)
+	assert.Equal([]Patch{p}, p.Partition())
+	assert.Nil(Patch{}.Partition())
+}
+
+func TestApplyConcurrently(t *testing.T) {
+	assert := assert.New(t)
+
+	doc := map[string]any{}
+	p := Patch{}
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("k%d", i)
+		doc[key] = map[string]any{"v": i, "list": []int{i}}
+		p = append(p,
+			&Operation{Op: OpReplace, Path: PathMustFrom(key, "v"), Value: MustMarshal(i + 1)},
+			&Operation{Op: OpAdd, Path: PathMustFrom(key, "list", "-"), Value: MustMarshal(i + 1)},
+		)
+		switch i % 10 {
+		case 1:
+			p = append(p, &Operation{Op: OpRemove, Path: PathMustFrom(key)})
+		case 2:
+			p = append(p, &Operation{Op: OpMove, From: PathMustFrom(key), Path: PathMustFrom(fmt.Sprintf("m%d", i))})
+		case 3:
+			p = append(p, &Operation{Op: OpCopy, From: PathMustFrom(fmt.Sprintf("k%d", i-3), "list"), Path: PathMustFrom(key, "copy")})
+		}
+	}
+
+	data := MustMarshal(doc)
+	expected, err := p.Apply(data)
+	assert.NoError(err)
+	res, err := p.ApplyConcurrently(data, nil)
+	assert.NoError(err)
+	assert.True(Equal(expected, res))
+
+	_, err = p.ApplyConcurrently(MustFromJSON(`[]`), nil)
+	assert.Error(err)
+
+	p = append(p, &Operation{Op: OpTest, Path: PathMustFrom("k0", "v"), Value: MustMarshal(0)})
+	_, err = p.ApplyConcurrently(data, nil)
+	assert.ErrorIs(err, ErrTestFailed)
+
+	p = Patch{
+		{Op: OpAdd, Path: PathMustFrom("a"), Value: MustMarshal(1)},
+		{Op: OpAdd, Path: PathMustFrom("b"), Value: []byte{0xf7}},
+	}
+	options := NewOptions()
+	options.Profile = DAGCBOR
+	_, err = p.ApplyConcurrently(MustFromJSON(`{}`), options)
+	assert.ErrorContains(err, "unsupported simple value 23")
+}
+
+func TestApplyConcurrentlyOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	doc := MustFromJSON(`{"a": 1, "b": 2, "c": 3}`)
+	p, err := PatchFromJSON(`[
+		{ "op": "replace", "path": "/a", "value": 10 },
+		{ "op": "replace", "path": "/b", "value": 20 },
+		{ "op": "test", "path": "/c", "value": 0 },
+		{ "op": "remove", "path": "/d" }
+	]`)
+	assert.NoError(err)
+
+	// the index of the failed operation is in the patch rather than in its group.
+	_, err = p.ApplyConcurrently(doc, nil)
+	var pe *PatchError
+	assert.True(errors.As(err, &pe))
+	assert.Equal(2, pe.OpIndex)
+	assert.ErrorIs(err, ErrTestFailed)
+
+	options := NewOptions()
+	options.MaxPatchOps = 2
+	_, err = p.ApplyConcurrently(doc, options)
+	assert.ErrorIs(err, ErrTooManyOps)
+
+	// hooks observe the operations in the order of the patch.
+	p = p[:2]
+	var indexes []int
+	options = NewOptions()
+	options.OnBeforeOp = func(i int, op *Operation) error {
+		indexes = append(indexes, i)
+		return nil
+	}
+	options.OnAfterOp = func(i int, op *Operation, err error) {
+		indexes = append(indexes, i)
+	}
+	res, err := p.ApplyConcurrently(doc, options)
+	assert.NoError(err)
+	assert.Equal(`{"a":10,"b":20,"c":3}`, MustToJSON(res))
+	assert.Equal([]int{0, 0, 1, 1}, indexes)
+
+	// audit entries are written in the order of the patch.
+	buf := &bytes.Buffer{}
+	options = NewOptions()
+	options.AuditWriter = buf
+	_, err = p.ApplyConcurrently(doc, options)
+	assert.NoError(err)
+
+	expected := &bytes.Buffer{}
+	options.AuditWriter = expected
+	_, err = p.ApplyWithOptions(doc, options)
+	assert.NoError(err)
+	assert.Equal(expected.String(), buf.String())
+}
+
+func TestPatchConcurrentlyPartialFailure(t *testing.T) {
+	assert := assert.New(t)
+
+	node := NewNode(MustFromJSON(`{"a": 1, "b": 2, "c": 3}`))
+	p, err := PatchFromJSON(`[
+		{ "op": "replace", "path": "/a", "value": 10 },
+		{ "op": "add", "path": "/x", "value": 1 },
+		{ "op": "replace", "path": "/c", "value": 30 },
+		{ "op": "test", "path": "/c", "value": 0 },
+		{ "op": "remove", "path": "/b" }
+	]`)
+	assert.NoError(err)
+
+	err = node.PatchConcurrently(p, nil)
+	var pe *PatchError
+	assert.True(errors.As(err, &pe))
+	assert.Equal(3, pe.OpIndex)
+	assert.ErrorIs(err, ErrTestFailed)
+
+	// the groups that finished stay applied, and the failed group is applied up to its failed operation.
+	data, err := node.MarshalCBOR()
+	assert.NoError(err)
+	assert.Equal(`{"a":10,"c":30,"x":1}`, MustToJSON(data))
+}
+
+func TestPatchConcurrentlyDupMapKeyStrict(t *testing.T) {
+	assert := assert.New(t)
+
+	// {"a": 1, "b": 2, "c": {"x": 1, "x": 2}}
+	doc := append(appendCBORHead(nil, 5, 3), MustMarshal("a")...)
+	doc = append(append(doc, 0x01), MustMarshal("b")...)
+	doc = append(append(doc, 0x02), MustMarshal("c")...)
+	doc = append(doc, appendCBORHead(nil, 5, 2)...)
+	doc = append(append(doc, MustMarshal("x")...), 0x01)
+	doc = append(append(doc, MustMarshal("x")...), 0x02)
+
+	p, err := PatchFromJSON(`[
+		{ "op": "replace", "path": "/a", "value": 10 },
+		{ "op": "replace", "path": "/b", "value": 20 }
+	]`)
+	assert.NoError(err)
+
+	options := NewOptions()
+	options.DupMapKey = DupMapKeyStrict
+	_, err = p.ApplyConcurrently(doc, options)
+	var dupErr *DuplicateKeyError
+	assert.ErrorAs(err, &dupErr)
+	assert.Equal(`["c"]`, dupErr.Path.String())
+	_, err = p.ApplyWithOptions(doc, options)
+	assert.ErrorAs(err, &dupErr)
+}
+
+func TestPatchConcurrentlyPreserveKeyOrder(t *testing.T) {
+	assert := assert.New(t)
+
+	doc, err := FromDiag(`{"z": 1, "y": 2, "x": 3, "w": 4}`)
+	assert.NoError(err)
+	p, err := PatchFromJSON(`[
+		{ "op": "add", "path": "/n2", "value": 1 },
+		{ "op": "remove", "path": "/y" },
+		{ "op": "add", "path": "/n1", "value": 2 },
+		{ "op": "add", "path": "/y", "value": 3 },
+		{ "op": "add", "path": "/n0", "value": 4 },
+		{ "op": "move", "from": "/z", "path": "/m" },
+		{ "op": "replace", "path": "/x", "value": 5 },
+		{ "op": "add", "path": "/n1", "value": 6 }
+	]`)
+	assert.NoError(err)
+	assert.True(len(p.Partition()) > 1)
+
+	options := NewOptions()
+	options.PreserveKeyOrder = true
+	expected, err := p.ApplyWithOptions(doc, options)
+	assert.NoError(err)
+	res, err := p.ApplyConcurrently(doc, options)
+	assert.NoError(err)
+	assert.Equal(Diagify(expected), Diagify(res))
+	assert.Equal(`{"x": 5, "w": 4, "n2": 1, "n1": 6, "y": 3, "n0": 4, "m": 1}`, Diagify(res))
+}
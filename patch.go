@@ -39,19 +39,35 @@ package cborpatch
 
 import (
 	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"regexp"
 	"strings"
+	"unicode/utf8"
+
+	"github.com/fxamacker/cbor/v2"
 )
 
+// DefaultMaxEnsurePathPadding is the default value of Options.MaxEnsurePathPadding.
+const DefaultMaxEnsurePathPadding = 10000
+
 var (
 	// SupportNegativeIndices decides whether to support non-standard practice of
 	// allowing negative indices to mean indices starting at the end of an array.
 	// Default to true.
+	//
+	// Deprecated: SupportNegativeIndices is only read by NewOptions, and changing it
+	// races with concurrent patches, use DefaultOptions and Options.SupportNegativeIndices instead.
 	SupportNegativeIndices bool = true
 	// AccumulatedCopySizeLimit limits the total size increase in bytes caused by
 	// "copy" operations in a patch.
+	//
+	// Deprecated: AccumulatedCopySizeLimit is only read by NewOptions, and changing it
+	// races with concurrent patches, use DefaultOptions and Options.AccumulatedCopySizeLimit instead.
 	AccumulatedCopySizeLimit int64 = 0
 )
 
@@ -60,6 +76,9 @@ var (
 	ErrUnknownType  = errors.New("unknown object type")
 	ErrInvalid      = errors.New("invalid node detected")
 	ErrInvalidIndex = errors.New("invalid index referenced")
+	ErrTestFailed   = errors.New("test operation failed")
+	ErrTooManyOps   = errors.New("too many operations")
+	ErrTooDeep      = errors.New("nesting depth exceeds the limit")
 )
 
 const (
@@ -93,23 +112,146 @@ type Options struct {
 	// EnsurePathExistsOnAdd instructs cbor-patch to recursively create the missing parts of path on "add" operation.
 	// Default to false.
 	EnsurePathExistsOnAdd bool
+	// MaxEnsurePathPadding limits the number of nulls padded into an array
+	// when EnsurePathExistsOnAdd creates the missing parts of path, a larger index fails the "add" operation.
+	// Default to DefaultMaxEnsurePathPadding, 0 means DefaultMaxEnsurePathPadding too, a negative value means no limit.
+	MaxEnsurePathPadding int
+	// Profile instructs cbor-patch to reject patches whose results do not conform to the profile,
+	// such as DAGCBOR.
+	// Default to nil.
+	Profile Profile
+	// ResultValidator instructs cbor-patch to validate the patched document before it is returned.
+	// Default to nil.
+	ResultValidator ResultValidator
+	// Equal is the options of comparing values in "test", "test-contains" and "test-subset" operations,
+	// and FindChildren, such as EqualOptions.FloatEpsilon, EqualOptions.NumericEquality,
+	// EqualOptions.DistinguishUndefined and EqualOptions.IgnoreArrayOrder.
+	// Default to the zero EqualOptions, values are equal only if they are encoded the same,
+	// and undefined is equal to null, both are equal to a missing value in "test" operations.
+	Equal EqualOptions
+	// FailOnMissingTestPath instructs cbor-patch to fail "test" operations when the target path is missing,
+	// as RFC 6902 requires. Otherwise a missing path is treated as null.
+	// Default to false.
+	FailOnMissingTestPath bool
+	// MaxPatchOps limits the number of operations in a patch, a patch with more operations
+	// is rejected before any of them is applied.
+	// Default to 0, no limit.
+	MaxPatchOps int
+	// MaxNestingDepth limits the nesting depth of arrays and maps in the patched document,
+	// documents nested deeper are rejected when they are decoded, and operations that address
+	// or produce values nested deeper are rejected, so does FindChildren.
+	// Default to 0, only the limit of the CBOR decoder applies.
+	MaxNestingDepth int
+	// EncMode is the CBOR encoding mode of the patched document and the values produced by operations.
+	// Default to nil, the global Marshal function set by SetCBOR is used.
+	EncMode cbor.EncMode
+	// DecMode is the CBOR decoding mode of the patched document and the operation values.
+	// Default to nil, the global Unmarshal function set by SetCBOR is used.
+	DecMode cbor.DecMode
+	// OnBeforeOp is called before applying each valid operation with its index in the patch,
+	// the patch is aborted if it returns an error, which is wrapped in a PatchError.
+	// Default to nil.
+	OnBeforeOp func(i int, op *Operation) error
+	// OnAfterOp is called after applying each operation allowed by OnBeforeOp,
+	// err is the error of applying it, or nil if it is applied.
+	// Default to nil.
+	OnAfterOp func(i int, op *Operation, err error)
+	// AuditWriter instructs cbor-patch to write an AuditEntry for every applied operation except
+	// the test operations, as a CBOR sequence that can be read by ReadAuditEntries.
+	// The entries of the applied operations are written even if a later operation fails.
+	// Default to nil.
+	AuditWriter io.Writer
+	// BatchWorkers is the number of goroutines that ApplyBatch uses to apply the documents in parallel.
+	// Default to 0, the documents are applied sequentially.
+	BatchWorkers int
+	// PreserveKeyOrder instructs cbor-patch to keep the original order of the keys of the maps in the
+	// patched document, keys added by the patch are appended to their maps.
+	// The order is recorded when a map is decoded by a patch with the option, so maps decoded
+	// before, such as by COWSnapshot or Materialize, are ordered by the encoder.
+	// ApplyToTree ignores it, as Go maps are unordered.
+	// Default to false, the keys are ordered by the EncMode, bytewise lexicographic by default.
+	PreserveKeyOrder bool
+	// AllowIndefiniteLength instructs cbor-patch to accept indefinite-length strings, arrays and maps
+	// in the patched document and the operation values, they are encoded with definite lengths.
+	// Default to false, documents with indefinite-length items are rejected.
+	AllowIndefiniteLength bool
+	// DupMapKey is the policy of duplicate keys of the maps in the patched document.
+	// Default to DupMapKeyEnforced.
+	DupMapKey DupMapKeyPolicy
+	// AllowAnyMapKey instructs cbor-patch to accept CBOR values of any type as map keys in the patched
+	// document and the operation paths, such as booleans, floats, tagged values and arrays.
+	// Such keys can be built by converting their raw encoding to RawKey, or by the "~c" escape
+	// of PathFromJSON. The patches with such keys are decoded by NewPatchWithOptions
+	// and PatchFromJSONWithOptions.
+	// Default to false, only integers, text strings and byte strings are accepted.
+	AllowAnyMapKey bool
+	// AllowRelativeFrom instructs cbor-patch to resolve the relative "from" paths of "move" and "copy"
+	// operations against their "path", such as the Relative JSON Pointer "1/name" for the sibling "name"
+	// of the path, see RelativePath. Moves within a subtree are kept valid when the patch is rebased.
+	// The "from" in JSON Pointers is decoded as a Relative JSON Pointer by PatchFromJSONWithOptions
+	// and NewPatchWithOptions.
+	// Default to false, relative paths are invalid.
+	AllowRelativeFrom bool
+	// AllowWildcard instructs cbor-patch to treat the text string key "*" in the paths of "remove", "replace"
+	// and test operations as a wildcard that matches every key of a map and every index of an array,
+	// such as ["users", "*", "password"]. The operation is applied to each existing path it matches when
+	// the operation is applied, and it does nothing if there is none, the hooks are called for each path.
+	// Wildcards are not supported by ApplyToTree.
+	// Default to false, "*" is a map key.
+	AllowWildcard bool
+
+	// owner is the cowOwner of the node being patched, see COWSnapshot.
+	owner *cowOwner
+	// codec is the cborCodec of EncMode and DecMode, see newCodec.
+	codec *cborCodec
+	// ctx is the context of ApplyWithContext and PatchWithContext.
+	ctx context.Context
+	// validated indicates that the operations are validated, see ApplyBatch.
+	validated bool
+}
+
+// ctxErr returns the error of the context of the options, or nil if there is no context.
+func (o *Options) ctxErr() error {
+	if o.ctx == nil {
+		return nil
+	}
+	return o.ctx.Err()
 }
 
 // NewOptions creates a default set of options for calls to ApplyWithOptions.
+// It is DefaultOptions with the deprecated package-level SupportNegativeIndices and AccumulatedCopySizeLimit,
+// they are also used when a nil Options is passed.
 func NewOptions() *Options {
+	options := DefaultOptions()
+	options.SupportNegativeIndices = SupportNegativeIndices
+	options.AccumulatedCopySizeLimit = AccumulatedCopySizeLimit
+	return options
+}
+
+// DefaultOptions creates the default set of options for calls to ApplyWithOptions.
+// Unlike NewOptions, it does not read any package-level variable, all the behavior
+// is carried by the returned Options. The Options is not modified by the package,
+// so it can be shared by concurrent calls once configured.
+func DefaultOptions() *Options {
 	return &Options{
-		SupportNegativeIndices:   SupportNegativeIndices,
-		AccumulatedCopySizeLimit: AccumulatedCopySizeLimit,
+		SupportNegativeIndices:   true,
+		AccumulatedCopySizeLimit: 0,
 		AllowMissingPathOnRemove: false,
 		EnsurePathExistsOnAdd:    false,
+		MaxEnsurePathPadding:     DefaultMaxEnsurePathPadding,
 	}
 }
 
 // NewPatch decodes the passed CBOR document as an RFC 6902 patch.
+// The document can be wrapped in PatchTag or the self-described CBOR tag.
+// The keys in the paths must be integers, text strings or byte strings, see Operation.Valid.
 func NewPatch(doc []byte) (Patch, error) {
 	var p Patch
 
-	err := cborUnmarshal(doc, &p)
+	doc, err := untagPatch(doc, PatchTag)
+	if err == nil {
+		err = cborUnmarshal(doc, &p)
+	}
 	if err == nil {
 		err = p.Valid()
 	}
@@ -120,6 +262,83 @@ func NewPatch(doc []byte) (Patch, error) {
 	return p, nil
 }
 
+// NewPatchWithOptions is like NewPatch, but checks the operations with the options,
+// so the paths can have keys of any type if options.AllowAnyMapKey is set, and the "from" of
+// "move" and "copy" operations can be a Relative JSON Pointer if options.AllowRelativeFrom is set.
+func NewPatchWithOptions(doc []byte, options *Options) (Patch, error) {
+	if options == nil {
+		options = NewOptions()
+	}
+
+	doc, err := untagPatch(doc, PatchTag)
+	if err != nil {
+		return nil, err
+	}
+
+	var p Patch
+	if options.AllowRelativeFrom {
+		var ops []*relativeFromOperation
+		if err = cborUnmarshal(doc, &ops); err != nil {
+			return nil, err
+		}
+		p = make(Patch, len(ops))
+		for i, op := range ops {
+			if op != nil {
+				op.Operation.From = Path(op.From)
+				p[i] = &op.Operation
+			}
+		}
+	} else if err = cborUnmarshal(doc, &p); err != nil {
+		return nil, err
+	}
+
+	for _, op := range p {
+		if err = validOp(op, options); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// relativeFromOperation is an Operation whose "from" can be a Relative JSON Pointer,
+// see Options.AllowRelativeFrom.
+type relativeFromOperation struct {
+	Operation
+	From relativeFromPath `cbor:"2,keyasint,omitempty"`
+}
+
+type relativeFromPath Path
+
+func (p *relativeFromPath) UnmarshalCBOR(data []byte) error {
+	if ReadCBORType(data) != CBORTypeTextString {
+		return (*Path)(p).UnmarshalCBOR(data)
+	}
+
+	var jsonpath string
+	if err := cborUnmarshal(data, &jsonpath); err != nil {
+		return err
+	}
+	path, err := relativePathFromJSON(jsonpath)
+	if err != nil {
+		return err
+	}
+	*p = relativeFromPath(path)
+	return nil
+}
+
+// ParsePatch decodes the passed document as an RFC 6902 patch in JSON or CBOR.
+// A document that begins with "[" after optional JSON white spaces and UTF-8 BOM is decoded
+// by PatchFromJSON, the others are decoded by NewPatch. A CBOR patch never begins with them,
+// because it is an array or a tagged array.
+func ParsePatch(doc []byte) (Patch, error) {
+	if data := bytes.TrimLeft(bytes.TrimPrefix(doc, utf8BOM), " \t\n\r"); len(data) > 0 && data[0] == '[' {
+		return PatchFromJSON(string(data))
+	}
+	return NewPatch(doc)
+}
+
+var utf8BOM = []byte{0xef, 0xbb, 0xbf}
+
 func (p Patch) Valid() error {
 	for _, op := range p {
 		if err := op.Valid(); err != nil {
@@ -129,6 +348,48 @@ func (p Patch) Valid() error {
 	return nil
 }
 
+// Rebase returns a copy of the patch with the prefix prepended to every "path" and "from",
+// so a patch for a sub-document can be applied to the document that contains it at prefix.
+func (p Patch) Rebase(prefix Path) Patch {
+	rebase := func(path Path) Path {
+		np := make(Path, 0, len(prefix)+len(path))
+		return append(append(np, prefix...), path...)
+	}
+
+	res := make(Patch, len(p))
+	for i, op := range p {
+		o := op.clone()
+		o.Path = rebase(op.Path)
+		if op.From != nil {
+			o.From = rebase(op.From)
+		}
+		res[i] = o
+	}
+	return res
+}
+
+// Filter returns the operations that touch the subtree at prefix,
+// whose "path" or "from" is the prefix path or a descendant of it.
+func (p Patch) Filter(prefix Path) Patch {
+	return p.filter(prefix, true)
+}
+
+// Exclude returns the operations that do not touch the subtree at prefix, see Filter.
+func (p Patch) Exclude(prefix Path) Patch {
+	return p.filter(prefix, false)
+}
+
+func (p Patch) filter(prefix Path, touched bool) Patch {
+	res := make(Patch, 0, len(p))
+	for _, op := range p {
+		t := op.Path.hasPrefix(prefix) || op.From != nil && op.From.hasPrefix(prefix)
+		if t == touched {
+			res = append(res, op)
+		}
+	}
+	return res
+}
+
 // Apply mutates a CBOR document according to the patch, and returns the new document.
 func (p Patch) Apply(doc []byte) ([]byte, error) {
 	return p.ApplyWithOptions(doc, NewOptions())
@@ -144,13 +405,56 @@ func (p Patch) ApplyWithOptions(doc []byte, options *Options) ([]byte, error) {
 	return node.MarshalCBOR()
 }
 
+// ApplyTo applies the patch to a CBOR document like ApplyWithOptions, and decodes the new document into out
+// with options.DecMode if it is set. It is a shorthand of ApplyWithOptions and decoding the result,
+// the new document is not decoded if out is a *RawMessage.
+func (p Patch) ApplyTo(doc []byte, out any, options *Options) error {
+	node := NewNode(doc)
+	if err := node.Patch(p, options); err != nil {
+		return err
+	}
+
+	data, err := node.MarshalCBOR()
+	if err != nil {
+		return err
+	}
+	if raw, ok := out.(*RawMessage); ok {
+		*raw = data
+		return nil
+	}
+	return newCodec(options).Unmarshal(data, out)
+}
+
+// Apply applies the patch to a CBOR document, and returns the new document decoded as a T value, see Patch.ApplyTo.
+func Apply[T any](doc []byte, p Patch, options *Options) (T, error) {
+	var v T
+	err := p.ApplyTo(doc, &v, options)
+	return v, err
+}
+
+// ApplyWithContext is like ApplyWithOptions, but stops applying the patch and returns ctx.Err()
+// once the context is done. The context is checked between operations and in deep recursions.
+func (p Patch) ApplyWithContext(ctx context.Context, doc []byte, options *Options) ([]byte, error) {
+	node := NewNode(doc)
+	if err := node.PatchWithContext(ctx, p, options); err != nil {
+		return nil, err
+	}
+	return node.MarshalCBOR()
+}
+
 // Node represents a lazy parsing CBOR document.
+// Reading a Node concurrently is only safe after Materialize.
 type Node struct {
 	raw   *RawMessage
 	doc   *partialDoc
 	ary   partialArray
 	ty    CBORType
 	which int
+	owner *cowOwner
+	codec *cborCodec
+	// dirty indicates that the decoded container is modified, so MarshalCBOR encodes it
+	// instead of returning its raw encoding, see markDirty.
+	dirty bool
 }
 
 // NewNode returns a new Node with the given raw encoded CBOR document.
@@ -167,7 +471,7 @@ func NewNode(doc RawMessage) *Node {
 
 // String returns the Node as CBOR diagnostic notation.
 func (n *Node) String() string {
-	if n.raw == nil || isNull(*n.raw) {
+	if n == nil || n.raw == nil || len(*n.raw) == 0 {
 		return "null"
 	}
 
@@ -177,91 +481,268 @@ func (n *Node) String() string {
 // Patch applies the given patch to the node.
 // It only supports string keys in a map node.
 func (n *Node) Patch(p Patch, options *Options) error {
+	return n.patch(p, options, nil)
+}
+
+// SetValue replaces the value at path in the node with v like a "replace" operation,
+// v is encoded to CBOR unless it is a RawMessage.
+func (n *Node) SetValue(path Path, v any) error {
+	return n.mutate(OpReplace, path, v)
+}
+
+// AddValue adds v at path in the node like an "add" operation,
+// v is encoded to CBOR unless it is a RawMessage.
+func (n *Node) AddValue(path Path, v any) error {
+	return n.mutate(OpAdd, path, v)
+}
+
+// RemoveValue removes the value at path in the node like a "remove" operation.
+func (n *Node) RemoveValue(path Path) error {
+	return n.mutate(OpRemove, path, nil)
+}
+
+func (n *Node) mutate(op Op, path Path, v any) error {
+	o := &Operation{Op: op, Path: path}
+	if op != OpRemove {
+		data, err := n.codec.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("unable to encode the value for %s operation, %w", op, err)
+		}
+		o.Value = data
+	}
+	return n.patch(Patch{o}, nil, nil)
+}
+
+// PatchWithContext is like Patch, but stops applying the patch and returns ctx.Err()
+// once the context is done, see Patch.ApplyWithContext.
+// The node may be partially patched when the context is done.
+func (n *Node) PatchWithContext(ctx context.Context, p Patch, options *Options) error {
+	if options == nil {
+		options = NewOptions()
+	}
+	opts := *options
+	opts.ctx = ctx
+	return n.patch(p, &opts, nil)
+}
+
+// patch applies the patch to the node.
+// If revert is not nil, the inverse operations are appended to it in reverse order, see Patch.Invert.
+func (n *Node) patch(p Patch, options *Options, revert *Patch) error {
+	if options == nil {
+		options = NewOptions()
+	}
+	if c := newCodec(options); n.owner != nil || c != nil {
+		opts := *options
+		opts.owner = n.owner
+		opts.codec = c
+		options = &opts
+	}
+	n.useCodec(options.codec)
+	if options.AllowIndefiniteLength {
+		if err := n.definiteLength(); err != nil {
+			return err
+		}
+		p = definitePatch(p)
+	}
+	if options.AllowRelativeFrom {
+		var err error
+		if p, err = absolutePatch(p); err != nil {
+			return err
+		}
+	}
+	if options.DupMapKey == DupMapKeyStrict {
+		if err := n.checkDupMapKeys(p); err != nil {
+			return err
+		}
+	}
+
 	pd, err := n.intoContainer()
 	switch {
 	case err != nil:
-		return fmt.Errorf("unexpected node %s, %v", n, err)
+		return fmt.Errorf("unexpected node %s, %w", n, err)
 	case pd == nil:
 		return fmt.Errorf("unexpected node %s", n)
 	}
 
-	if options == nil {
-		options = NewOptions()
+	if err = checkPatchOps(p, options); err != nil {
+		return err
 	}
+
 	var accumulatedCopySize int64
-	for _, op := range p {
-		if err = op.Valid(); err != nil {
+	for i, op := range p {
+		if err = options.ctxErr(); err != nil {
 			return err
 		}
+		if !options.validated {
+			if err = validOp(op, options); err != nil {
+				return newPatchError(i, op, err)
+			}
+		}
+		ops := []*Operation{op}
+		if options.AllowWildcard && op.Path.hasWildcard() {
+			ops = expandWildcard(pd, op, options)
+		}
+		for _, op := range ops {
+			if err = checkDepth(op, options, func() ([]byte, error) {
+				con, key := findObject(&pd, op.From, options)
+				if con == nil {
+					return nil, ErrMissing
+				}
+				val, err := con.get(key, options)
+				if err != nil {
+					return nil, err
+				}
+				return val.MarshalCBOR()
+			}); err != nil {
+				return newPatchError(i, op, err)
+			}
+			if options.OnBeforeOp != nil {
+				if err = options.OnBeforeOp(i, op); err != nil {
+					return newPatchError(i, op, err)
+				}
+			}
 
-		switch op.Op {
-		case OpAdd:
-			err = p.add(&pd, op, options)
-		case OpRemove:
-			err = p.remove(&pd, op, options)
-		case OpReplace:
-			err = p.replace(&pd, op, options)
-		case OpMove:
-			err = p.move(&pd, op, options)
-		case OpTest:
-			err = p.test(&pd, op, options)
-		case OpCopy:
-			err = p.copy(&pd, op, &accumulatedCopySize, options)
+			if op.Op == OpMove {
+				// mark the containers on the from path before the value is moved,
+				// the indexes on it may be shifted by the operation.
+				markDirty(pd, op.From, options)
+			}
+
+			var old RawMessage
+			audit := options.AuditWriter != nil && !op.Op.IsTest()
+			if audit {
+				old = auditValue(&pd, op.Path, options)
+			}
+
+			if revert != nil {
+				var ops Patch
+				if ops, err = p.invert(&pd, op, &accumulatedCopySize, options); err == nil {
+					*revert = append(*revert, ops...)
+				}
+			} else {
+				err = p.apply(&pd, op, &accumulatedCopySize, options)
+			}
+			if !op.Op.IsTest() {
+				// mark the containers on the paths, even if the operation fails halfway.
+				n.dirty = true
+				markDirty(pd, op.Path, options)
+			}
+			if audit && err == nil {
+				err = writeAudit(&pd, op, old, options)
+			}
+
+			if options.OnAfterOp != nil {
+				options.OnAfterOp(i, op, err)
+			}
+			if err != nil {
+				return newPatchError(i, op, err)
+			}
 		}
+	}
 
-		if err != nil {
-			return err
+	// the root container may be replaced with a different type.
+	switch c := pd.(type) {
+	case *partialDoc:
+		n.doc, n.ary = c, nil
+		n.which, n.ty = eDoc, CBORTypeMap
+	case *partialArray:
+		n.doc, n.ary = nil, *c
+		n.which, n.ty = eAry, CBORTypeArray
+	}
+
+	if err = options.ctxErr(); err != nil {
+		return err
+	}
+	return n.validateResult(options)
+}
+
+// markDirty marks the containers along the path in the container as modified,
+// other decoded nodes keep their raw encoding when the document is encoded.
+// The value at the path is only marked if it is decoded.
+func markDirty(pd container, path Path, options *Options) {
+	for i, key := range path {
+		child, err := pd.get(key, options)
+		if err != nil || child == nil {
+			return
+		}
+		if i == len(path)-1 && child.which != eDoc && child.which != eAry {
+			return
+		}
+		if child, err = cowChild(pd, key, child, options); err != nil {
+			return
 		}
+		if pd, err = child.intoContainer(); err != nil || pd == nil {
+			return
+		}
+		child.dirty = true
+	}
+}
+
+// isDirty reports whether the node or any of its decoded descendants is modified,
+// a descendant can be modified by its own Patch, such as a child node from GetChild.
+func (n *Node) isDirty() bool {
+	if n.dirty {
+		return true
 	}
 
 	switch n.which {
 	case eDoc:
-		n.doc = pd.(*partialDoc)
+		for _, v := range n.doc.obj {
+			if v != nil && v.isDirty() {
+				return true
+			}
+		}
 	case eAry:
-		n.ary = *(pd.(*partialArray))
+		for _, v := range n.ary {
+			if v != nil && v.isDirty() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (n *Node) validateResult(options *Options) error {
+	if options.Profile == nil && options.ResultValidator == nil {
+		return nil
+	}
+
+	data, err := n.MarshalCBOR()
+	if err != nil {
+		return err
+	}
+	if options.Profile != nil {
+		if err = options.Profile.Valid(data); err != nil {
+			return err
+		}
+	}
+	if options.ResultValidator != nil {
+		if err = options.ResultValidator.ValidateResult(data); err != nil {
+			return fmt.Errorf("invalid patched document, %w", err)
+		}
 	}
 	return nil
 }
 
 // MarshalCBOR implements the cbor.Marshaler interface.
+// Only the containers modified by patches are encoded, other nodes keep their raw encoding,
+// even if they are decoded to be read.
 func (n *Node) MarshalCBOR() ([]byte, error) {
 	if n == nil {
 		return copyBytes(rawCBORNull), nil
 	}
 
-	switch n.which {
-	case eRaw, eOther:
-		return cborMarshal(n.raw)
-	case eDoc:
-		return cborMarshal(n.doc)
-	case eAry:
-		return cborMarshal(n.ary)
-	default:
-		return nil, ErrUnknownType
-	}
-}
-
-// MarshalJSON implements the json.Marshaler interface.
-func (n *Node) MarshalJSON() ([]byte, error) {
-	if n == nil {
-		return []byte("null"), nil
+	if n.raw != nil && !n.isDirty() {
+		return n.codec.Marshal(n.raw)
 	}
 
-	n.intoContainer()
 	switch n.which {
-	case eOther:
-		if n.raw == nil {
-			return json.Marshal(nil)
-		}
-		var val any
-		if err := cborUnmarshal(*n.raw, &val); err != nil {
-			return nil, err
-		}
-		return json.Marshal(val)
+	case eRaw, eOther:
+		return n.codec.Marshal(n.raw)
 	case eDoc:
-		return json.Marshal(n.doc)
+		return n.doc.marshal(n.codec)
 	case eAry:
-		return json.Marshal(n.ary)
+		return n.codec.Marshal(n.ary)
 	default:
 		return nil, ErrUnknownType
 	}
@@ -283,6 +764,7 @@ func (n *Node) UnmarshalCBOR(data []byte) error {
 
 	*n.raw = append((*n.raw)[0:0], data...)
 	n.which = eRaw
+	n.dirty = false
 	n.ty = CBORTypePrimitives
 	return nil
 }
@@ -297,28 +779,70 @@ type container interface {
 
 type partialDoc struct {
 	obj map[RawKey]*Node
+	// keys is the order of the keys in obj, or nil if the keys are ordered by the encoder,
+	// see Options.PreserveKeyOrder.
+	keys []RawKey
 }
 
 type partialArray []*Node
 
 func (d *partialDoc) MarshalCBOR() ([]byte, error) {
-	return cborMarshal(d.obj)
+	return d.marshal(nil)
 }
 
-func (d *partialDoc) MarshalJSON() ([]byte, error) {
-	obj := make(map[string]*Node, len(d.obj))
-	for k := range d.obj {
-		obj[k.Key()] = d.obj[k]
+// marshal encodes the map with the codec, in the order of d.keys if it is not nil.
+func (d *partialDoc) marshal(c *cborCodec) ([]byte, error) {
+	if d.keys == nil {
+		return c.Marshal(d.obj)
+	}
+
+	data := appendCBORHead(nil, 5, uint64(len(d.keys)))
+	for _, k := range d.keys {
+		v, err := c.Marshal(d.obj[k])
+		if err != nil {
+			return nil, err
+		}
+		data = append(append(data, k...), v...)
+	}
+	return data, nil
+}
+
+// put sets the value of the key, appending the key to d.keys if it is new.
+func (d *partialDoc) put(key RawKey, val *Node) {
+	if _, ok := d.obj[key]; !ok && d.keys != nil {
+		d.keys = append(d.keys, key)
+	}
+	d.obj[key] = val
+}
+
+// del deletes the key, removing it from d.keys.
+func (d *partialDoc) del(key RawKey) {
+	if _, ok := d.obj[key]; ok && d.keys != nil {
+		for i, k := range d.keys {
+			if k == key {
+				d.keys = append(d.keys[:i:i], d.keys[i+1:]...)
+				break
+			}
+		}
 	}
-	return json.Marshal(obj)
+	delete(d.obj, key)
 }
 
 func (d *partialDoc) UnmarshalCBOR(data []byte) error {
-	return cborUnmarshal(data, &d.obj)
+	if err := cborUnmarshal(data, &d.obj); err != nil {
+		return err
+	}
+	for k := range d.obj {
+		if err := k.validType(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (d *partialDoc) set(key RawKey, val *Node, options *Options) error {
-	d.obj[key] = val
+	val.useCodec(options.codec)
+	d.put(key, val)
 	return nil
 }
 
@@ -329,7 +853,7 @@ func (d *partialDoc) add(key RawKey, val *Node, options *Options) error {
 func (d *partialDoc) get(key RawKey, options *Options) (*Node, error) {
 	v, ok := d.obj[key]
 	if !ok {
-		return nil, fmt.Errorf("unable to get nonexistent key %s, %v", key, ErrMissing)
+		return nil, fmt.Errorf("unable to get nonexistent key %s, %w", key, ErrMissing)
 	}
 	if v == nil {
 		v = NewNode(nil)
@@ -343,9 +867,9 @@ func (d *partialDoc) remove(key RawKey, options *Options) error {
 		if options.AllowMissingPathOnRemove {
 			return nil
 		}
-		return fmt.Errorf("unable to remove nonexistent key %s, %v", key, ErrMissing)
+		return fmt.Errorf("unable to remove nonexistent key %s, %w", key, ErrMissing)
 	}
-	delete(d.obj, key)
+	d.del(key)
 	return nil
 }
 
@@ -356,6 +880,7 @@ func (d *partialDoc) len() int {
 // set should only be used to implement the "replace" operation, so "key" must
 // be an already existing index in "d".
 func (d *partialArray) set(key RawKey, val *Node, options *Options) error {
+	val.useCodec(options.codec)
 	idx, err := key.toInt()
 	if err != nil {
 		return err
@@ -364,7 +889,7 @@ func (d *partialArray) set(key RawKey, val *Node, options *Options) error {
 	sz := len(*d)
 	if idx < 0 {
 		if !options.SupportNegativeIndices || idx < -sz {
-			return fmt.Errorf("unable to access invalid index %d, %v", idx, ErrInvalidIndex)
+			return fmt.Errorf("unable to access invalid index %d, %w", idx, ErrInvalidIndex)
 		}
 		idx += sz
 	}
@@ -374,6 +899,7 @@ func (d *partialArray) set(key RawKey, val *Node, options *Options) error {
 }
 
 func (d *partialArray) add(key RawKey, val *Node, options *Options) error {
+	val.useCodec(options.codec)
 	if key == minus {
 		*d = append(*d, val)
 		return nil
@@ -386,12 +912,12 @@ func (d *partialArray) add(key RawKey, val *Node, options *Options) error {
 
 	sz := len(*d) + 1
 	if idx >= sz {
-		return fmt.Errorf("unable to access invalid index %d, %v", idx, ErrInvalidIndex)
+		return fmt.Errorf("unable to access invalid index %d, %w", idx, ErrInvalidIndex)
 	}
 
 	if idx < 0 {
 		if !options.SupportNegativeIndices || idx < -sz {
-			return fmt.Errorf("unable to access invalid index %d, %v", idx, ErrInvalidIndex)
+			return fmt.Errorf("unable to access invalid index %d, %w", idx, ErrInvalidIndex)
 		}
 		idx += sz
 	}
@@ -415,13 +941,13 @@ func (d *partialArray) get(key RawKey, options *Options) (*Node, error) {
 	sz := len(*d)
 	if idx < 0 {
 		if !options.SupportNegativeIndices || idx < -sz {
-			return nil, fmt.Errorf("unable to access invalid index %d, %v", idx, ErrInvalidIndex)
+			return nil, fmt.Errorf("unable to access invalid index %d, %w", idx, ErrInvalidIndex)
 		}
 		idx += sz
 	}
 
 	if idx >= sz {
-		return nil, fmt.Errorf("unable to access invalid index %d, %v", idx, ErrInvalidIndex)
+		return nil, fmt.Errorf("unable to access invalid index %d, %w", idx, ErrInvalidIndex)
 	}
 	v := (*d)[idx]
 	if v == nil {
@@ -441,18 +967,18 @@ func (d *partialArray) remove(key RawKey, options *Options) error {
 		if options.AllowMissingPathOnRemove {
 			return nil
 		}
-		return fmt.Errorf("unable to access invalid index %d, %v", idx, ErrInvalidIndex)
+		return fmt.Errorf("unable to access invalid index %d, %w", idx, ErrInvalidIndex)
 	}
 
 	if idx < 0 {
 		if !options.SupportNegativeIndices {
-			return fmt.Errorf("unable to access invalid index %d, %v", idx, ErrInvalidIndex)
+			return fmt.Errorf("unable to access invalid index %d, %w", idx, ErrInvalidIndex)
 		}
 		if idx < -sz {
 			if options.AllowMissingPathOnRemove {
 				return nil
 			}
-			return fmt.Errorf("unable to access invalid index %d, %v", idx, ErrInvalidIndex)
+			return fmt.Errorf("unable to access invalid index %d, %w", idx, ErrInvalidIndex)
 		}
 		idx += sz
 	}
@@ -486,54 +1012,379 @@ func (n *Node) intoContainer() (container, error) {
 	}
 
 	n.ty = ReadCBORType(*n.raw)
+	if n.ty == CBORTypeMap || n.ty == CBORTypeArray {
+		if err := n.codec.checkDepth(*n.raw); err != nil {
+			return nil, err
+		}
+	}
 	switch n.ty {
 	case CBORTypeMap:
-		if err := cborUnmarshal(*n.raw, &n.doc); err != nil {
+		var obj map[RawKey]*Node
+		if err := n.codec.Unmarshal(*n.raw, &obj); err != nil {
 			return nil, err
 		}
+		if !n.codec.allowAnyMapKey() {
+			for k := range obj {
+				if err := k.validType(); err != nil {
+					return nil, err
+				}
+			}
+		}
+		n.doc = &partialDoc{obj: obj}
+		if n.codec.preserveKeyOrder() {
+			keys, err := rawMapKeys(*n.raw)
+			if err != nil {
+				return nil, err
+			}
+			n.doc.keys = keys
+		}
 		n.which = eDoc
+		if err := n.restoreUndefined(); err != nil {
+			return nil, err
+		}
+		if n.codec != nil {
+			for _, v := range n.doc.obj {
+				v.useCodec(n.codec)
+			}
+		}
 		return n.doc, nil
 	case CBORTypeArray:
-		if err := cborUnmarshal(*n.raw, &n.ary); err != nil {
+		if err := n.codec.Unmarshal(*n.raw, &n.ary); err != nil {
 			return nil, err
 		}
 		n.which = eAry
+		if err := n.restoreUndefined(); err != nil {
+			return nil, err
+		}
+		if n.codec != nil {
+			for _, v := range n.ary {
+				v.useCodec(n.codec)
+			}
+		}
 		return &n.ary, nil
 	}
 	return nil, ErrInvalid
 }
 
-func (n *Node) isNull() bool {
-	switch {
-	case n == nil:
-		return true
-
-	case n.which == eDoc || n.which == eAry:
-		return false
-
-	case n.raw == nil:
-		return true
+// restoreUndefined replaces the nil children decoded from CBOR undefined with undefined nodes,
+// as the decoder decodes both null and undefined to nil nodes.
+func (n *Node) restoreUndefined() error {
+	data := *n.raw
+	if bytes.IndexByte(data, 0xf7) < 0 {
+		return nil
 	}
 
-	return isNull(*n.raw)
-}
-
-// Equal indicates if two CBOR Nodes have the same structural equality.
-func (n *Node) Equal(o *Node) bool {
-	if n.isNull() {
-		return o.isNull()
+	major, arg, next, err := cborHead(data, 0)
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < arg; i++ {
+		var key RawKey
+		if major == 5 {
+			end, err := cborItemEnd(data, next)
+			if err != nil {
+				return err
+			}
+			key, next = RawKey(data[next:end]), end
+		}
+
+		end, err := cborItemEnd(data, next)
+		if err != nil {
+			return err
+		}
+		if IsUndefined(data[next:end]) {
+			if major == 5 && n.doc.obj[key] == nil {
+				n.doc.obj[key] = NewNode(data[next:end])
+			} else if major == 4 && n.ary[i] == nil {
+				n.ary[i] = NewNode(data[next:end])
+			}
+		}
+		next = end
+	}
+	return nil
+}
+
+// validOp is like Operation.Valid, and checks the types of the keys in the paths of the operation
+// unless options.AllowAnyMapKey is set.
+func validOp(op *Operation, options *Options) error {
+	if err := op.valid(); err != nil {
+		return err
+	}
+	if options.AllowWildcard && (op.From.hasWildcard() ||
+		op.Path.hasWildcard() && op.Op != OpRemove && op.Op != OpReplace && !op.Op.IsTest()) {
+		return fmt.Errorf("wildcard path of %q operation, %w", op.Op, ErrInvalid)
+	}
+	from := op.From
+	if options.AllowRelativeFrom {
+		if _, _, ok := op.Path.relative(); ok {
+			return fmt.Errorf("relative path %s, %w", op.Path, ErrInvalid)
+		}
+		if _, rest, ok := from.relative(); ok {
+			from = rest
+		}
+	}
+	if !options.AllowAnyMapKey {
+		return validKeyTypes(op.Path, from)
+	}
+	return nil
+}
+
+// definiteLength encodes the indefinite-length items in the raw node with definite lengths,
+// see Options.AllowIndefiniteLength.
+func (n *Node) definiteLength() error {
+	if n.which != eRaw || n.raw == nil {
+		return nil
+	}
+
+	data, err := definiteLength(*n.raw)
+	if err != nil {
+		return err
+	}
+	raw := RawMessage(data)
+	n.raw = &raw
+	return nil
+}
+
+// definitePatch returns the patch with the indefinite-length items in the operation values
+// encoded with definite lengths, or the patch itself if there is none.
+// Invalid values are kept, so that they are reported by Operation.Valid.
+func definitePatch(p Patch) Patch {
+	res := p
+	copied := false
+	for i, op := range p {
+		if len(op.Value) == 0 {
+			continue
+		}
+		val, err := definiteLength(op.Value)
+		if err != nil || sameBytes(val, op.Value) {
+			continue
+		}
+
+		if !copied {
+			res = make(Patch, len(p))
+			copy(res, p)
+			copied = true
+		}
+		o := op.clone()
+		o.Value = val
+		res[i] = o
+	}
+	return res
+}
+
+// wildcardKey is the text string key "*", see Options.AllowWildcard.
+const wildcardKey = RawKey("\x61*")
+
+// hasWildcard reports whether the path has a wildcard key, see Options.AllowWildcard.
+func (p Path) hasWildcard() bool {
+	for _, k := range p {
+		if k == wildcardKey {
+			return true
+		}
+	}
+	return false
+}
+
+// expandWildcard returns the operations on the existing paths in the container that the wildcard path
+// of the operation matches. The "remove" operations are in the reverse order of the paths,
+// so removing array elements does not shift the indexes of the others.
+func expandWildcard(pd container, op *Operation, options *Options) []*Operation {
+	var paths []Path
+	var match func(c container, prefix, rest Path)
+	match = func(c container, prefix, rest Path) {
+		keys := rest[:1]
+		if rest[0] == wildcardKey {
+			switch c := c.(type) {
+			case *partialDoc:
+				keys = c.orderedKeys()
+			case *partialArray:
+				keys = make([]RawKey, len(*c))
+				for i := range *c {
+					keys[i] = RawKey(MustMarshal(i))
+				}
+			}
+		}
+
+		for _, k := range keys {
+			child, err := c.get(k, options)
+			if err != nil {
+				continue
+			}
+			path := prefix.WithKey(k)
+			if len(rest) == 1 {
+				paths = append(paths, path)
+				continue
+			}
+			if child == nil {
+				continue
+			}
+			if child, err = cowChild(c, k, child, options); err != nil {
+				continue
+			}
+			if next, err := child.intoContainer(); err == nil && next != nil {
+				match(next, path, rest[1:])
+			}
+		}
+	}
+	match(pd, Path{}, op.Path)
+
+	ops := make([]*Operation, len(paths))
+	for i, path := range paths {
+		if op.Op == OpRemove {
+			path = paths[len(paths)-1-i]
+		}
+		o := op.clone()
+		o.Path = path
+		ops[i] = o
+	}
+	return ops
+}
+
+// absolutePatch returns the patch with the relative "from" paths of "move" and "copy" operations
+// resolved against their paths, see Options.AllowRelativeFrom.
+func absolutePatch(p Patch) (Patch, error) {
+	res := p
+	copied := false
+	for i, op := range p {
+		if _, _, ok := op.From.relative(); !ok || op.Op != OpMove && op.Op != OpCopy {
+			continue
+		}
+		from, err := op.From.resolve(op.Path)
+		if err != nil {
+			return nil, newPatchError(i, op, err)
+		}
+
+		if !copied {
+			res = make(Patch, len(p))
+			copy(res, p)
+			copied = true
+		}
+		o := op.clone()
+		o.From = from
+		res[i] = o
+	}
+	return res, nil
+}
+
+// checkDupMapKeys returns a DuplicateKeyError if a map in the node or the operation values
+// has duplicate keys, see DupMapKeyStrict.
+func (n *Node) checkDupMapKeys(p Patch) error {
+	data, err := n.MarshalCBOR()
+	if err != nil {
+		return err
+	}
+	if err = checkDupMapKeys(data, Path{}); err != nil {
+		return err
+	}
+
+	for i, op := range p {
+		if len(op.Value) > 0 {
+			if err = checkDupMapKeys(op.Value, op.Path); err != nil {
+				return newPatchError(i, op, err)
+			}
+		}
+	}
+	return nil
+}
+
+// useCodec sets the cborCodec of the node if it has none, the children inherit it when decoded.
+func (n *Node) useCodec(c *cborCodec) {
+	if n != nil && c != nil && n.codec == nil {
+		n.codec = c
+	}
+}
+
+// Materialize decodes the node and all its descendants eagerly.
+//
+// A Node is decoded lazily, so reading it (GetChild, GetValue, FindChildren, Equal, etc.)
+// mutates its internal state, and concurrent reads on a Node race with each other.
+// After Materialize, read methods are safe for concurrent use, until the node is patched.
+// Nodes returned by COWSnapshot and Patched are already materialized.
+func (n *Node) Materialize() error {
+	if n == nil {
+		return nil
+	}
+
+	if _, err := n.intoContainer(); err != nil && err != ErrInvalid {
+		return err
+	}
+
+	switch n.which {
+	case eDoc:
+		for _, v := range n.doc.obj {
+			if err := v.Materialize(); err != nil {
+				return err
+			}
+		}
+	case eAry:
+		for _, v := range n.ary {
+			if err := v.Materialize(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (n *Node) isNull() bool {
+	switch {
+	case n == nil:
+		return true
+
+	case n.which == eDoc || n.which == eAry:
+		return false
+
+	case n.raw == nil:
+		return true
+	}
+
+	return isNull(*n.raw)
+}
+
+// Equal indicates if two CBOR Nodes have the same structural equality.
+func (n *Node) Equal(o *Node) bool {
+	return n.equal(o, nil)
+}
+
+// EqualWithOptions is like Equal, but compares with the EqualOptions, see EqualWithOptions.
+func (n *Node) EqualWithOptions(o *Node, opts *EqualOptions) bool {
+	return n.equal(o, opts)
+}
+
+// equal is like Equal, but compares with the EqualOptions, nil is the default comparison.
+func (n *Node) equal(o *Node, eo *EqualOptions) bool {
+	if eq, ok := eo.equalUndefined(n, o); ok {
+		return eq
+	}
+
+	if n.isNull() {
+		return o.isNull()
 	}
 
 	if o.isNull() {
 		return n.isNull()
 	}
 
+	if eq, ok := eo.equalTags(n, o); ok {
+		return eq
+	}
+
 	n.intoContainer()
 	if n.which == eOther {
 		if o.which == eDoc || o.which == eAry {
 			return false
 		}
 
+		if eq, ok := eo.equalNumbers(*n.raw, *o.raw); ok {
+			return eq
+		}
+		if epsilon := eo.floatEpsilon(); epsilon > 0 {
+			if x, ok := rawFloat(*n.raw); ok {
+				if y, ok := rawFloat(*o.raw); ok {
+					return math.Abs(x-y) <= epsilon
+				}
+			}
+		}
 		return bytes.Equal(*n.raw, *o.raw)
 	}
 
@@ -548,7 +1399,7 @@ func (n *Node) Equal(o *Node) bool {
 		}
 
 		for k, v := range n.doc.obj {
-			if ov, ok := o.doc.obj[k]; !ok || !v.Equal(ov) {
+			if ov, ok := o.doc.obj[k]; !ok || !v.equal(ov, eo) {
 				return false
 			}
 		}
@@ -556,17 +1407,66 @@ func (n *Node) Equal(o *Node) bool {
 		return true
 	}
 
-	if len(n.ary) != len(o.ary) {
-		return false
-	}
+	return eo.equalArrays(n.ary, o.ary, func(x, y *Node) bool {
+		return x.equal(y, eo)
+	})
+}
 
-	for idx, val := range n.ary {
-		if !val.Equal(o.ary[idx]) {
-			return false
+func (p Patch) apply(doc *container, op *Operation, accumulatedCopySize *int64, options *Options) error {
+	switch op.Op {
+	case OpAdd:
+		return p.add(doc, op, options)
+	case OpRemove:
+		return p.remove(doc, op, options)
+	case OpReplace:
+		return p.replace(doc, op, options)
+	case OpMove:
+		return p.move(doc, op, options)
+	case OpTest:
+		return p.test(doc, op, options)
+	case OpTestContains:
+		return p.testContains(doc, op, options)
+	case OpTestType:
+		return p.testType(doc, op, options)
+	case OpTestMatch:
+		return p.testMatch(doc, op, options)
+	case OpTestLess, OpTestLessEqual, OpTestGreater, OpTestGreaterEqual:
+		return p.testCompare(doc, op, options)
+	case OpTestLength:
+		return p.testLength(doc, op, options)
+	case OpTestSubset:
+		val, err := testTarget(doc, op, options)
+		if err == nil && !subsetNode(val, NewNode(op.Value), &options.Equal) {
+			err = testFailedf("test-subset operation for path %s failed, expected a superset of %s, got %s",
+				op.Path, NewNode(op.Value), val)
+		}
+		return err
+	case OpTestDefined:
+		_, err := testTarget(doc, op, options)
+		return err
+	case OpTestUndefined:
+		if val, err := testTarget(doc, op, options); err == nil {
+			return testFailedf("test-undefined operation for path %s failed, got %s", op.Path, val)
 		}
+		return nil
+	case OpCopy:
+		return p.copy(doc, op, accumulatedCopySize, options)
+	case OpMerge:
+		return p.merge(doc, op, options)
+	case OpAppend:
+		return p.append(doc, op, options)
+	case OpSplice:
+		return p.splice(doc, op, options)
+	case OpIncr, OpDecr:
+		return p.incr(doc, op, options)
+	case OpToggle:
+		return p.toggle(doc, op, options)
+	case OpTextDiff:
+		return p.textDiff(doc, op, options)
+	case OpBinaryDiff:
+		return p.binaryDiff(doc, op, options)
 	}
-
-	return true
+	return nil
 }
 
 func (p Patch) add(doc *container, op *Operation, options *Options) error {
@@ -578,11 +1478,11 @@ func (p Patch) add(doc *container, op *Operation, options *Options) error {
 
 	con, key := findObject(doc, op.Path, options)
 	if con == nil {
-		return fmt.Errorf("add operation does not apply for %s, %v", op.Path, ErrMissing)
+		return fmt.Errorf("add operation does not apply for %s, %w", op.Path, ErrMissing)
 	}
 
 	if err := con.add(key, NewNode(op.Value), options); err != nil {
-		return fmt.Errorf("add operation does not apply for %s, %v", op.Path, err)
+		return fmt.Errorf("add operation does not apply for %s, %w", op.Path, err)
 	}
 
 	return nil
@@ -594,11 +1494,11 @@ func (p Patch) remove(doc *container, op *Operation, options *Options) error {
 		if options.AllowMissingPathOnRemove {
 			return nil
 		}
-		return fmt.Errorf("remove operation does not apply for %s, %v", op.Path, ErrMissing)
+		return fmt.Errorf("remove operation does not apply for %s, %w", op.Path, ErrMissing)
 	}
 
 	if err := con.remove(key, options); err != nil {
-		return fmt.Errorf("remove operation does not apply for %s, %v", op.Path, err)
+		return fmt.Errorf("remove operation does not apply for %s, %w", op.Path, err)
 	}
 	return nil
 }
@@ -622,16 +1522,16 @@ func (p Patch) replace(doc *container, op *Operation, options *Options) error {
 
 	con, key := findObject(doc, op.Path, options)
 	if con == nil {
-		return fmt.Errorf("replace operation does not apply for %s, %v", op.Path, ErrMissing)
+		return fmt.Errorf("replace operation does not apply for %s, %w", op.Path, ErrMissing)
 	}
 
 	_, ok := con.get(key, options)
 	if ok != nil {
-		return fmt.Errorf("replace operation does not apply for %s, %v", op.Path, ErrMissing)
+		return fmt.Errorf("replace operation does not apply for %s, %w", op.Path, ErrMissing)
 	}
 
 	if err := con.set(key, NewNode(op.Value), options); err != nil {
-		return fmt.Errorf("replace operation does not apply for %s, %v", op.Path, err)
+		return fmt.Errorf("replace operation does not apply for %s, %w", op.Path, err)
 	}
 	return nil
 }
@@ -639,25 +1539,25 @@ func (p Patch) replace(doc *container, op *Operation, options *Options) error {
 func (p Patch) move(doc *container, op *Operation, options *Options) error {
 	con, key := findObject(doc, op.From, options)
 	if con == nil {
-		return fmt.Errorf("move operation does not apply for from %s, %v", op.From, ErrMissing)
+		return fmt.Errorf("move operation does not apply for from %s, %w", op.From, ErrMissing)
 	}
 
 	val, err := con.get(key, options)
 	if err != nil {
-		return fmt.Errorf("move operation does not apply for from %s, %v", op.From, err)
+		return fmt.Errorf("move operation does not apply for from %s, %w", op.From, err)
 	}
 
 	if err = con.remove(key, options); err != nil {
-		return fmt.Errorf("move operation does not apply for from %s, %v", op.From, err)
+		return fmt.Errorf("move operation does not apply for from %s, %w", op.From, err)
 	}
 
 	con, key = findObject(doc, op.Path, options)
 	if con == nil {
-		return fmt.Errorf("move operation does not apply for path %s, %v", op.Path, ErrMissing)
+		return fmt.Errorf("move operation does not apply for path %s, %w", op.Path, ErrMissing)
 	}
 
 	if err = con.add(key, val, options); err != nil {
-		return fmt.Errorf("move operation does not apply for path %s, %v", op.Path, err)
+		return fmt.Errorf("move operation does not apply for path %s, %w", op.Path, err)
 	}
 	return nil
 }
@@ -676,63 +1576,377 @@ func (p Patch) test(doc *container, op *Operation, options *Options) error {
 			self.which = eAry
 		}
 
-		if self.Equal(NewNode(op.Value)) {
+		if self.equal(NewNode(op.Value), &options.Equal) {
 			return nil
 		}
 
-		return fmt.Errorf("test operation for path %s failed, not equal", op.Path)
+		return testFailedf("test operation for path %s failed, not equal", op.Path)
 	}
 
 	con, key := findObject(doc, op.Path, options)
 	if con == nil {
-		return fmt.Errorf("test operation for path %s failed, %v", op.Path, ErrMissing)
+		return testFailedf("test operation for path %s failed, %w", op.Path, ErrMissing)
 	}
 
 	val, err := con.get(key, options)
-	if err != nil && !strings.Contains(err.Error(), ErrMissing.Error()) {
-		return fmt.Errorf("test operation for path %s failed, %v", op.Path, err)
+	if err != nil && (options.FailOnMissingTestPath || !errors.Is(err, ErrMissing)) {
+		return testFailedf("test operation for path %s failed, %w", op.Path, err)
+	}
+
+	if eq, ok := options.Equal.equalUndefined(val, NewNode(op.Value)); ok {
+		if eq {
+			return nil
+		}
+		return testFailedf("test operation for path %s failed, expected %s, got %s",
+			op.Path, NewNode(op.Value), val)
 	}
 
 	if val == nil || val.isNull() {
 		if isNull(op.Value) {
 			return nil
 		}
-		return fmt.Errorf("test operation for path %s failed, expected %s, got nil",
+		return testFailedf("test operation for path %s failed, expected %s, got nil",
 			op.Path, NewNode(op.Value))
 
 	} else if op.Value == nil {
-		return fmt.Errorf("test operation for path %s failed, expected nil, got %s",
+		return testFailedf("test operation for path %s failed, expected nil, got %s",
 			op.Path, val)
 	}
 
-	if val.Equal(NewNode(op.Value)) {
+	if val.equal(NewNode(op.Value), &options.Equal) {
 		return nil
 	}
 
-	return fmt.Errorf("test operation for path %s failed, expected %s, got %s",
+	return testFailedf("test operation for path %s failed, expected %s, got %s",
 		op.Path, NewNode(op.Value), val)
 }
 
+func (p Patch) testContains(doc *container, op *Operation, options *Options) error {
+	val, err := testTarget(doc, op, options)
+	if err != nil {
+		return err
+	}
+
+	if !containsNode(val, op.Value, &options.Equal) {
+		return testFailedf("test-contains operation for path %s failed, %s does not contain %s",
+			op.Path, val, NewNode(op.Value))
+	}
+	return nil
+}
+
+func (p Patch) testType(doc *container, op *Operation, options *Options) error {
+	match, err := readTestType(op.Value)
+	if err != nil {
+		return fmt.Errorf("test-type operation does not apply for %s, %w", op.Path, err)
+	}
+
+	val, err := testTarget(doc, op, options)
+	if err != nil {
+		return err
+	}
+	data, err := val.MarshalCBOR()
+	if err != nil {
+		return testFailedf("test-type operation for path %s failed, %w", op.Path, err)
+	}
+
+	if !match(data) {
+		return testFailedf("test-type operation for path %s failed, %s is not of type %s",
+			op.Path, val, NewNode(op.Value))
+	}
+	return nil
+}
+
+func (p Patch) testMatch(doc *container, op *Operation, options *Options) error {
+	re, err := readTestMatch(op.Value)
+	if err != nil {
+		return fmt.Errorf("test-match operation does not apply for %s, %w", op.Path, err)
+	}
+
+	val, err := testTarget(doc, op, options)
+	if err != nil {
+		return err
+	}
+	data, err := val.MarshalCBOR()
+	if err != nil {
+		return testFailedf("test-match operation for path %s failed, %w", op.Path, err)
+	}
+	return matchText(re, op.Path, data)
+}
+
+// readTestMatch compiles the regular expression value of a "test-match" operation.
+func readTestMatch(value []byte) (*regexp.Regexp, error) {
+	if ReadCBORType(value) != CBORTypeTextString {
+		return nil, fmt.Errorf("%s is not a text string", NewNode(value))
+	}
+	var pattern string
+	if err := cborUnmarshal(value, &pattern); err != nil {
+		return nil, err
+	}
+	return regexp.Compile(pattern)
+}
+
+// matchText returns a test failed error if the raw encoded CBOR value is not a text string
+// that matches the regular expression.
+func matchText(re *regexp.Regexp, path Path, data []byte) error {
+	var text string
+	if ReadCBORType(data) != CBORTypeTextString || cborUnmarshal(data, &text) != nil {
+		return testFailedf("test-match operation for path %s failed, %s is not a text string",
+			path, NewNode(data))
+	}
+	if !re.MatchString(text) {
+		return testFailedf("test-match operation for path %s failed, %q does not match %q",
+			path, text, re)
+	}
+	return nil
+}
+
+func (p Patch) testCompare(doc *container, op *Operation, options *Options) error {
+	val, err := testTarget(doc, op, options)
+	if err != nil {
+		return err
+	}
+	data, err := val.MarshalCBOR()
+	if err != nil {
+		return testFailedf("%s operation for path %s failed, %w", op.Op, op.Path, err)
+	}
+	return compareValue(op, data)
+}
+
+// compareValue returns a test failed error if the raw encoded CBOR number does not compare
+// with the value of the operation as the operation asserts.
+func compareValue(op *Operation, data []byte) error {
+	var x, y any
+	if !isCBORNumber(data) || cborUnmarshal(data, &x) != nil {
+		return testFailedf("%s operation for path %s failed, %s is not a number", op.Op, op.Path, NewNode(data))
+	}
+	if err := cborUnmarshal(op.Value, &y); err != nil {
+		return fmt.Errorf("%s operation does not apply for %s, %w", op.Op, op.Path, err)
+	}
+
+	c, ok := compareNumbers(x, y)
+	if ok {
+		switch op.Op {
+		case OpTestLess:
+			ok = c < 0
+		case OpTestLessEqual:
+			ok = c <= 0
+		case OpTestGreater:
+			ok = c > 0
+		case OpTestGreaterEqual:
+			ok = c >= 0
+		}
+	}
+	if !ok {
+		return testFailedf("%s operation for path %s failed, %s compared with %s",
+			op.Op, op.Path, NewNode(data), NewNode(op.Value))
+	}
+	return nil
+}
+
+func (p Patch) testLength(doc *container, op *Operation, options *Options) error {
+	min, max, err := readTestLength(op.Value)
+	if err != nil {
+		return fmt.Errorf("test-length operation does not apply for %s, %w", op.Path, err)
+	}
+
+	val, err := testTarget(doc, op, options)
+	if err != nil {
+		return err
+	}
+
+	n := -1
+	if con, err := val.intoContainer(); err == nil {
+		n = con.len()
+	} else if data, err := val.MarshalCBOR(); err == nil {
+		n = rawLength(data)
+	}
+	return checkLength(op.Path, n, min, max, val)
+}
+
+// readTestLength decodes the value of a "test-length" operation, which is a length
+// or an array of min and max lengths.
+func readTestLength(value []byte) (int, int, error) {
+	var n uint64
+	if err := cborUnmarshal(value, &n); err == nil {
+		if n > math.MaxInt {
+			return 0, 0, fmt.Errorf("length %d is too large", n)
+		}
+		return int(n), int(n), nil
+	}
+
+	var bounds []uint64
+	if err := cborUnmarshal(value, &bounds); err != nil {
+		return 0, 0, err
+	}
+	if len(bounds) != 2 || bounds[0] > bounds[1] || bounds[1] > math.MaxInt {
+		return 0, 0, fmt.Errorf("invalid length range %v", bounds)
+	}
+	return int(bounds[0]), int(bounds[1]), nil
+}
+
+// rawLength returns the number of runes of the raw encoded CBOR text string,
+// the number of bytes of the byte string, or -1 for other values.
+func rawLength(data []byte) int {
+	switch ReadCBORType(data) {
+	case CBORTypeTextString:
+		var s string
+		if cborUnmarshal(data, &s) == nil {
+			return utf8.RuneCountInString(s)
+		}
+	case CBORTypeByteString:
+		var b []byte
+		if cborUnmarshal(data, &b) == nil {
+			return len(b)
+		}
+	}
+	return -1
+}
+
+// checkLength returns a test failed error if the length n is not in the range [min, max],
+// n is -1 if the value has no length.
+func checkLength(path Path, n, min, max int, val fmt.Stringer) error {
+	if n < 0 {
+		return testFailedf("test-length operation for path %s failed, %s has no length", path, val)
+	}
+	if n < min || n > max {
+		if min == max {
+			return testFailedf("test-length operation for path %s failed, expected length %d, got %d", path, min, n)
+		}
+		return testFailedf("test-length operation for path %s failed, expected length in [%d, %d], got %d",
+			path, min, max, n)
+	}
+	return nil
+}
+
+// testTarget returns the value at the path of a test operation, the root container is wrapped in a node.
+func testTarget(doc *container, op *Operation, options *Options) (*Node, error) {
+	switch sv := (*doc).(type) {
+	case *partialDoc:
+		if len(op.Path) == 0 {
+			return &Node{doc: sv, ty: CBORTypeMap, which: eDoc}, nil
+		}
+	case *partialArray:
+		if len(op.Path) == 0 {
+			return &Node{ary: *sv, ty: CBORTypeArray, which: eAry}, nil
+		}
+	}
+
+	con, key := findObject(doc, op.Path, options)
+	if con == nil {
+		return nil, testFailedf("%s operation for path %s failed, %w", op.Op, op.Path, ErrMissing)
+	}
+
+	val, err := con.get(key, options)
+	if err != nil {
+		return nil, testFailedf("%s operation for path %s failed, %w", op.Op, op.Path, err)
+	}
+	return val, nil
+}
+
+// subsetNode reports whether the node matches the value node as a subset, see NewTestSubset.
+// The values are compared with the EqualOptions.
+func subsetNode(n, v *Node, eo *EqualOptions) bool {
+	if eq, ok := eo.equalUndefined(n, v); ok {
+		return eq
+	}
+	if n.isNull() || v.isNull() {
+		return n.isNull() && v.isNull()
+	}
+
+	vc, err := v.intoContainer()
+	if err != nil {
+		return n.equal(v, eo)
+	}
+	nc, err := n.intoContainer()
+	if err != nil {
+		return false
+	}
+
+	switch vc := vc.(type) {
+	case *partialDoc:
+		nd, ok := nc.(*partialDoc)
+		if !ok {
+			return false
+		}
+		for k, ve := range vc.obj {
+			ne, ok := nd.obj[k]
+			if !ok || !subsetNode(ne, ve, eo) {
+				return false
+			}
+		}
+
+	case *partialArray:
+		na, ok := nc.(*partialArray)
+		if !ok || !eo.equalArrays(*vc, *na, func(ve, ne *Node) bool {
+			return subsetNode(ne, ve, eo)
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
+// containsNode reports whether the array node contains the value,
+// or the text string or byte string node contains the value as a substring.
+// The values are compared with the EqualOptions.
+func containsNode(n *Node, value RawMessage, eo *EqualOptions) bool {
+	if n == nil {
+		return false
+	}
+
+	if _, err := n.intoContainer(); err == nil {
+		if n.which == eAry {
+			v := NewNode(value)
+			for _, e := range n.ary {
+				if e.equal(v, eo) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	data, err := n.MarshalCBOR()
+	if err != nil {
+		return false
+	}
+	switch ty := ReadCBORType(data); ty {
+	case CBORTypeTextString:
+		var s, sub string
+		if ReadCBORType(value) != ty || cborUnmarshal(data, &s) != nil || cborUnmarshal(value, &sub) != nil {
+			return false
+		}
+		return strings.Contains(s, sub)
+	case CBORTypeByteString:
+		var s, sub []byte
+		if ReadCBORType(value) != ty || cborUnmarshal(data, &s) != nil || cborUnmarshal(value, &sub) != nil {
+			return false
+		}
+		return bytes.Contains(s, sub)
+	}
+	return false
+}
+
 func (p Patch) copy(doc *container, op *Operation, accumulatedCopySize *int64, options *Options) error {
 	con, key := findObject(doc, op.From, options)
 
 	if con == nil {
-		return fmt.Errorf("copy operation does not apply for from path %s, %v", op.From, ErrMissing)
+		return fmt.Errorf("copy operation does not apply for from path %s, %w", op.From, ErrMissing)
 	}
 
 	val, err := con.get(key, options)
 	if err != nil {
-		return fmt.Errorf("copy operation does not apply for from path %s, %v", op.From, err)
+		return fmt.Errorf("copy operation does not apply for from path %s, %w", op.From, err)
 	}
 
 	con, key = findObject(doc, op.Path, options)
 	if con == nil {
-		return fmt.Errorf("copy operation does not apply for path %s, %v", op.Path, ErrMissing)
+		return fmt.Errorf("copy operation does not apply for path %s, %w", op.Path, ErrMissing)
 	}
 
 	valCopy, sz, err := deepCopy(val)
 	if err != nil {
-		return fmt.Errorf("copy operation does not apply for path %s while performing deep copy, %v", op.Path, err)
+		return fmt.Errorf("copy operation does not apply for path %s while performing deep copy, %w", op.Path, err)
 	}
 
 	(*accumulatedCopySize) += int64(sz)
@@ -742,13 +1956,367 @@ func (p Patch) copy(doc *container, op *Operation, accumulatedCopySize *int64, o
 
 	err = con.add(key, valCopy, options)
 	if err != nil {
-		return fmt.Errorf("copy operation does not apply for path %s while adding value during copy, %v",
+		return fmt.Errorf("copy operation does not apply for path %s while adding value during copy, %w",
 			op.Path, err)
 	}
 
 	return nil
 }
 
+// update replaces the target of the operation with the node returned by fn.
+// fn must not modify the target node, since it may be shared.
+func (p Patch) update(doc *container, op *Operation, options *Options, fn func(*Node) (*Node, error)) error {
+	if len(op.Path) == 0 {
+		var cur *Node
+		switch c := (*doc).(type) {
+		case *partialDoc:
+			cur = &Node{doc: c, ty: CBORTypeMap, which: eDoc}
+		case *partialArray:
+			cur = &Node{ary: *c, ty: CBORTypeArray, which: eAry}
+		}
+
+		val, err := fn(cur)
+		if err != nil {
+			return fmt.Errorf("%s operation does not apply for %s, %w", op.Op, op.Path, err)
+		}
+		pd, err := val.intoContainer()
+		if err != nil {
+			return fmt.Errorf("%s operation does not apply for %s, %w", op.Op, op.Path, err)
+		}
+		*doc = pd
+		return nil
+	}
+
+	con, key := findObject(doc, op.Path, options)
+	if con == nil {
+		return fmt.Errorf("%s operation does not apply for %s, %w", op.Op, op.Path, ErrMissing)
+	}
+
+	cur, err := con.get(key, options)
+	if err == nil {
+		var val *Node
+		if val, err = fn(cur); err == nil {
+			err = con.set(key, val, options)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%s operation does not apply for %s, %w", op.Op, op.Path, err)
+	}
+	return nil
+}
+
+// merge merges the map value into the target recursively, see MergePatch.
+// The target is re-decoded rather than modified in place, since it may be shared.
+func (p Patch) merge(doc *container, op *Operation, options *Options) error {
+	return p.update(doc, op, options, func(cur *Node) (*Node, error) {
+		data, err := cur.MarshalCBOR()
+		if err != nil {
+			return nil, err
+		}
+		return mergeNode(NewNode(data), NewNode(op.Value)), nil
+	})
+}
+
+// append appends the elements of the array value to the target array,
+// or concatenates the text string or byte string value onto the target.
+func (p Patch) append(doc *container, op *Operation, options *Options) error {
+	return p.update(doc, op, options, func(cur *Node) (*Node, error) {
+		return appendNode(cur, op.Value, options)
+	})
+}
+
+// splice removes op.RemoveCount elements from the target array starting at op.Index,
+// and inserts the elements of the array value there.
+func (p Patch) splice(doc *container, op *Operation, options *Options) error {
+	return p.update(doc, op, options, func(cur *Node) (*Node, error) {
+		return spliceNode(cur, op, options)
+	})
+}
+
+// appendNode returns a new node of the value appended to the node, the node is not modified.
+func appendNode(n *Node, value RawMessage, options *Options) (*Node, error) {
+	if _, err := n.intoContainer(); err != nil && err != ErrInvalid {
+		return nil, err
+	}
+
+	vt := ReadCBORType(value)
+	switch {
+	case n.which == eAry && vt == CBORTypeArray:
+		var elems partialArray
+		if err := options.codec.Unmarshal(value, &elems); err != nil {
+			return nil, err
+		}
+		ary := make(partialArray, 0, len(n.ary)+len(elems))
+		ary = append(append(ary, n.ary...), elems...)
+		return &Node{ary: ary, ty: CBORTypeArray, which: eAry}, nil
+
+	case n.which == eOther && n.ty == CBORTypeTextString && vt == CBORTypeTextString:
+		var s, v string
+		if err := options.codec.Unmarshal(*n.raw, &s); err != nil {
+			return nil, err
+		}
+		if err := options.codec.Unmarshal(value, &v); err != nil {
+			return nil, err
+		}
+		data, err := options.codec.Marshal(s + v)
+		if err != nil {
+			return nil, err
+		}
+		return NewNode(data), nil
+
+	case n.which == eOther && n.ty == CBORTypeByteString && vt == CBORTypeByteString:
+		var b, v []byte
+		if err := options.codec.Unmarshal(*n.raw, &b); err != nil {
+			return nil, err
+		}
+		if err := options.codec.Unmarshal(value, &v); err != nil {
+			return nil, err
+		}
+		data, err := options.codec.Marshal(append(b, v...))
+		if err != nil {
+			return nil, err
+		}
+		return NewNode(data), nil
+	}
+	return nil, fmt.Errorf("unable to append %s to %s, %w", NewNode(value), n, ErrInvalid)
+}
+
+// spliceNode returns a new array node of the splice operation applied to the node, the node is not modified.
+func spliceNode(n *Node, op *Operation, options *Options) (*Node, error) {
+	if _, err := n.intoContainer(); err != nil || n.which != eAry {
+		return nil, fmt.Errorf("unable to splice %s, %w", n, ErrInvalid)
+	}
+
+	idx, err := spliceIndex(op.Index, op.RemoveCount, len(n.ary), options)
+	if err != nil {
+		return nil, err
+	}
+
+	var elems partialArray
+	if op.Value != nil {
+		if err := options.codec.Unmarshal(op.Value, &elems); err != nil {
+			return nil, err
+		}
+	}
+
+	ary := make(partialArray, 0, len(n.ary)-op.RemoveCount+len(elems))
+	ary = append(ary, n.ary[:idx]...)
+	ary = append(ary, elems...)
+	ary = append(ary, n.ary[idx+op.RemoveCount:]...)
+	return &Node{ary: ary, ty: CBORTypeArray, which: eAry}, nil
+}
+
+// spliceIndex resolves the index of a "splice" operation on an array of sz elements.
+func spliceIndex(idx, removeCount, sz int, options *Options) (int, error) {
+	if idx < 0 {
+		if !options.SupportNegativeIndices || idx < -sz {
+			return 0, fmt.Errorf("unable to access invalid index %d, %w", idx, ErrInvalidIndex)
+		}
+		idx += sz
+	}
+	if idx > sz || removeCount > sz-idx {
+		return 0, fmt.Errorf("unable to splice %d elements at index %d of %d elements, %w",
+			removeCount, idx, sz, ErrInvalidIndex)
+	}
+	return idx, nil
+}
+
+// incr adds the numeric value to the target number, or subtracts it for a "decr" operation.
+func (p Patch) incr(doc *container, op *Operation, options *Options) error {
+	return p.update(doc, op, options, func(cur *Node) (*Node, error) {
+		data, err := cur.MarshalCBOR()
+		if err != nil {
+			return nil, err
+		}
+		var v, delta any
+		if err = options.codec.Unmarshal(data, &v); err != nil {
+			return nil, err
+		}
+		if err = options.codec.Unmarshal(op.Value, &delta); err != nil {
+			return nil, err
+		}
+		if v, err = addNumber(v, delta, op.Op == OpDecr); err != nil {
+			return nil, err
+		}
+		if data, err = options.codec.Marshal(v); err != nil {
+			return nil, err
+		}
+		return NewNode(data), nil
+	})
+}
+
+// toggle flips the target boolean.
+func (p Patch) toggle(doc *container, op *Operation, options *Options) error {
+	return p.update(doc, op, options, func(cur *Node) (*Node, error) {
+		data, err := cur.MarshalCBOR()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case bytes.Equal(data, rawCBORTrue):
+			return NewNode(copyBytes(rawCBORFalse)), nil
+		case bytes.Equal(data, rawCBORFalse):
+			return NewNode(copyBytes(rawCBORTrue)), nil
+		}
+		return nil, fmt.Errorf("unable to toggle %s, %w", cur, ErrInvalid)
+	})
+}
+
+// textDiff applies the edit script to the target text string, see TextDiff.
+func (p Patch) textDiff(doc *container, op *Operation, options *Options) error {
+	return p.update(doc, op, options, func(cur *Node) (*Node, error) {
+		data, err := cur.MarshalCBOR()
+		if err != nil {
+			return nil, err
+		}
+		if ReadCBORType(data) != CBORTypeTextString {
+			return nil, fmt.Errorf("unable to apply text-diff to %s, %w", cur, ErrInvalid)
+		}
+
+		var text string
+		var script []any
+		if err = options.codec.Unmarshal(data, &text); err != nil {
+			return nil, err
+		}
+		if err = options.codec.Unmarshal(op.Value, &script); err != nil {
+			return nil, err
+		}
+		if text, err = applyTextDiff(text, script); err != nil {
+			return nil, err
+		}
+		if data, err = options.codec.Marshal(text); err != nil {
+			return nil, err
+		}
+		return NewNode(data), nil
+	})
+}
+
+// binaryDiff applies the delta to the target byte string, see BinaryDiff.
+func (p Patch) binaryDiff(doc *container, op *Operation, options *Options) error {
+	return p.update(doc, op, options, func(cur *Node) (*Node, error) {
+		data, err := cur.MarshalCBOR()
+		if err != nil {
+			return nil, err
+		}
+		if ReadCBORType(data) != CBORTypeByteString {
+			return nil, fmt.Errorf("unable to apply binary-diff to %s, %w", cur, ErrInvalid)
+		}
+
+		var bs []byte
+		var delta []any
+		if err = options.codec.Unmarshal(data, &bs); err != nil {
+			return nil, err
+		}
+		if err = options.codec.Unmarshal(op.Value, &delta); err != nil {
+			return nil, err
+		}
+		if bs, err = applyBinaryDiff(bs, delta); err != nil {
+			return nil, err
+		}
+		if data, err = options.codec.Marshal(bs); err != nil {
+			return nil, err
+		}
+		return NewNode(data), nil
+	})
+}
+
+// addNumber returns v + delta, or v - delta if neg is true.
+// The result is an integer if both are integers, otherwise it is a float64.
+// It returns an error if the integer result overflows the CBOR integer range.
+func addNumber(v, delta any, neg bool) (any, error) {
+	x, xok := bigIntOf(v)
+	y, yok := bigIntOf(delta)
+	if xok && yok {
+		if neg {
+			y.Neg(y)
+		}
+		r := x.Add(x, y)
+		switch {
+		case r.IsUint64():
+			return r.Uint64(), nil
+		case r.IsInt64():
+			return r.Int64(), nil
+		case new(big.Int).Not(r).IsUint64():
+			return *r, nil
+		}
+		return nil, fmt.Errorf("integer overflow of %s, %w", r, ErrInvalid)
+	}
+
+	f, fok := floatOf(v)
+	g, gok := floatOf(delta)
+	if !fok || !gok {
+		return nil, fmt.Errorf("unable to add %v to %v, %w", delta, v, ErrInvalid)
+	}
+	if neg {
+		g = -g
+	}
+	return f + g, nil
+}
+
+// compareNumbers compares the decoded CBOR numbers by their numeric values,
+// it returns false if they are not comparable, such as NaN.
+func compareNumbers(a, b any) (int, bool) {
+	x, xok := bigIntOf(a)
+	y, yok := bigIntOf(b)
+	if xok && yok {
+		return x.Cmp(y), true
+	}
+
+	f, fok := bigFloatOf(a)
+	g, gok := bigFloatOf(b)
+	if !fok || !gok {
+		return 0, false
+	}
+	return f.Cmp(g), true
+}
+
+func bigFloatOf(v any) (*big.Float, bool) {
+	switch n := v.(type) {
+	case float64:
+		if math.IsNaN(n) {
+			return nil, false
+		}
+		return big.NewFloat(n), true
+	case float32:
+		if math.IsNaN(float64(n)) {
+			return nil, false
+		}
+		return big.NewFloat(float64(n)), true
+	}
+	if i, ok := bigIntOf(v); ok {
+		return new(big.Float).SetInt(i), true
+	}
+	return nil, false
+}
+
+func bigIntOf(v any) (*big.Int, bool) {
+	switch n := v.(type) {
+	case uint64:
+		return new(big.Int).SetUint64(n), true
+	case int64:
+		return big.NewInt(n), true
+	case big.Int:
+		return new(big.Int).Set(&n), true
+	case *big.Int:
+		return new(big.Int).Set(n), true
+	}
+	return nil, false
+}
+
+func floatOf(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	}
+	if i, ok := bigIntOf(v); ok {
+		f, _ := new(big.Float).SetInt(i).Float64()
+		return f, true
+	}
+	return 0, false
+}
+
 func findObject(pd *container, path Path, options *Options) (container, RawKey) {
 	doc := *pd
 
@@ -764,6 +2332,9 @@ func findObject(pd *container, path Path, options *Options) (container, RawKey)
 		if next == nil || ok != nil {
 			return nil, ""
 		}
+		if next, ok = cowChild(doc, k, next, options); ok != nil {
+			return nil, ""
+		}
 		doc, _ = next.intoContainer()
 		if doc == nil {
 			return nil, ""
@@ -772,6 +2343,19 @@ func findObject(pd *container, path Path, options *Options) (container, RawKey)
 	return doc, key
 }
 
+// checkPadding returns an error if padding n nulls exceeds options.MaxEnsurePathPadding.
+func checkPadding(n int, options *Options) error {
+	max := options.MaxEnsurePathPadding
+	if max == 0 {
+		max = DefaultMaxEnsurePathPadding
+	}
+	if max > 0 && n > max {
+		return fmt.Errorf("unable to pad array with %d nulls, exceeding the limit %d, %w",
+			n, max, ErrInvalidIndex)
+	}
+	return nil
+}
+
 // Given a document and a path to a key, walk the path and create all missing elements
 // creating objects and arrays as needed.
 func ensurePathExists(pd *container, path Path, options *Options) error {
@@ -782,8 +2366,14 @@ func ensurePathExists(pd *container, path Path, options *Options) error {
 	if len(path) == 0 {
 		return nil
 	}
+	if options.MaxNestingDepth > 0 && len(path) > options.MaxNestingDepth {
+		return fmt.Errorf("unable to ensure path %s, nested deeper than %d, %w", path, options.MaxNestingDepth, ErrTooDeep)
+	}
 
 	for pi, key := range path {
+		if err = options.ctxErr(); err != nil {
+			return err
+		}
 		// Have we reached the key part of the path?
 		// If yes, we're done.
 		if pi == len(path)-1 {
@@ -801,6 +2391,9 @@ func ensurePathExists(pd *container, path Path, options *Options) error {
 					return err
 				}
 				if arrIndex >= pa.len()+1 {
+					if err = checkPadding(arrIndex-pa.len(), options); err != nil {
+						return err
+					}
 					// Pad the array with null values up to the required index.
 					for i := pa.len(); i <= arrIndex-1; i++ {
 						if err = doc.add(encodeArrayIdx(i), NewNode(nil), options); err != nil {
@@ -820,17 +2413,20 @@ func ensurePathExists(pd *container, path Path, options *Options) error {
 
 				if arrIndex < 0 {
 					if !options.SupportNegativeIndices {
-						return fmt.Errorf("unable to ensure path for invalid index 9 %d, %v",
+						return fmt.Errorf("unable to ensure path for invalid index 9 %d, %w",
 							arrIndex, ErrInvalidIndex)
 					}
 
 					if arrIndex < -1 {
-						return fmt.Errorf("unable to ensure path for invalid index 10 %d, %v",
+						return fmt.Errorf("unable to ensure path for invalid index 10 %d, %w",
 							arrIndex, ErrInvalidIndex)
 					}
 
 					arrIndex = 0
 				}
+				if err = checkPadding(arrIndex, options); err != nil {
+					return err
+				}
 
 				node := NewNode(rawCBORArray)
 				if err = doc.add(key, node, options); err != nil {
@@ -856,9 +2452,12 @@ func ensurePathExists(pd *container, path Path, options *Options) error {
 				}
 			}
 		} else {
+			if target, err = cowChild(doc, key, target, options); err != nil {
+				return err
+			}
 			doc, err = target.intoContainer()
 			if doc == nil {
-				return fmt.Errorf("unable to ensure path for invalid target %s, %v", target, err)
+				return fmt.Errorf("unable to ensure path for invalid target %s, %w", target, err)
 			}
 		}
 	}
@@ -889,6 +2488,112 @@ func encodeArrayIdx(i int) RawKey {
 	return RawKey(MustMarshal(i))
 }
 
+// checkPatchOps returns an error if the patch has more operations than options.MaxPatchOps.
+func checkPatchOps(p Patch, options *Options) error {
+	if options.MaxPatchOps > 0 && len(p) > options.MaxPatchOps {
+		return fmt.Errorf("patch has %d operations, exceeding the limit %d, %w", len(p), options.MaxPatchOps, ErrTooManyOps)
+	}
+	return nil
+}
+
+// checkDepth returns an error if the operation addresses or produces values nested deeper than
+// options.MaxNestingDepth, source returns the value at the "from" path of "copy" and "move" operations.
+func checkDepth(op *Operation, options *Options, source func() ([]byte, error)) error {
+	max := options.MaxNestingDepth
+	if max <= 0 {
+		return nil
+	}
+	if len(op.Path) > max || len(op.From) > max {
+		return fmt.Errorf("%s operation does not apply for %s, path is nested deeper than %d, %w",
+			op.Op, op.Path, max, ErrTooDeep)
+	}
+
+	var value []byte
+	switch op.Op {
+	case OpAdd, OpReplace, OpMerge, OpAppend, OpSplice:
+		// the elements of "append" and "splice" are nested in the target array like in the value.
+		value = op.Value
+	case OpCopy, OpMove:
+		if len(op.Path) > len(op.From) {
+			// the operation fails later if the source is missing.
+			value, _ = source()
+		}
+	}
+	if len(value) == 0 {
+		return nil
+	}
+
+	depth, err := rawDepth(value)
+	if err == nil && len(op.Path)+depth > max {
+		return fmt.Errorf("%s operation does not apply for %s, value is nested deeper than %d, %w",
+			op.Op, op.Path, max, ErrTooDeep)
+	}
+	return nil
+}
+
+// PatchError is the error returned when an operation of a patch fails to apply.
+// Err is the cause, which wraps the sentinel errors such as ErrMissing and ErrTestFailed,
+// so errors.Is and errors.As see through a PatchError.
+type PatchError struct {
+	// OpIndex is the index of the failed operation in the patch.
+	OpIndex int
+	// Op is the type of the failed operation.
+	Op Op
+	// Path is the target path of the failed operation.
+	Path Path
+	// Err is the cause of the failure.
+	Err error
+}
+
+func newPatchError(i int, op *Operation, err error) *PatchError {
+	return &PatchError{OpIndex: i, Op: op.Op, Path: op.Path, Err: err}
+}
+
+// Error implements the error interface, it returns the message of Err,
+// which already describes the failed operation.
+func (e *PatchError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the cause of the failure.
+func (e *PatchError) Unwrap() error {
+	return e.Err
+}
+
+// DupMapKeyPolicy specifies how the maps with duplicate keys in the patched document are handled.
+type DupMapKeyPolicy int
+
+const (
+	// DupMapKeyEnforced rejects a map with duplicate keys when it is decoded by the patch,
+	// maps that are not decoded are not checked.
+	DupMapKeyEnforced DupMapKeyPolicy = iota
+	// DupMapKeyStrict checks the whole document and the operation values before applying the patch,
+	// and rejects them with a DuplicateKeyError of the first duplicate key.
+	DupMapKeyStrict
+	// DupMapKeyLastWins accepts the maps with duplicate keys, the value of the last one wins.
+	// It has no effect if Options.DecMode is set.
+	DupMapKeyLastWins
+)
+
+// DuplicateKeyError is an error type returned when a map has duplicate keys, see DupMapKeyStrict.
+type DuplicateKeyError struct {
+	// Path is the path of the map in the document, or in the patched document
+	// if the map is in an operation value.
+	Path Path
+	// Key is the duplicate key.
+	Key RawKey
+}
+
+// Error implements the error interface.
+func (e *DuplicateKeyError) Error() string {
+	return fmt.Sprintf("duplicate map key %s at path %s, %v", e.Key, e.Path, ErrInvalid)
+}
+
+// Unwrap returns ErrInvalid.
+func (e *DuplicateKeyError) Unwrap() error {
+	return ErrInvalid
+}
+
 // AccumulatedCopySizeError is an error type returned when the accumulated size
 // increase caused by copy operations in a patch operation has exceeded the
 // limit.
@@ -909,6 +2614,31 @@ func (a *AccumulatedCopySizeError) Error() string {
 		a.accumulated, a.limit)
 }
 
+// testFailedError is returned when a "test" operation fails, it matches ErrTestFailed
+// and the errors wrapped by its format.
+type testFailedError struct {
+	err error
+}
+
+func testFailedf(format string, a ...any) error {
+	return &testFailedError{err: fmt.Errorf(format, a...)}
+}
+
+// Error implements the error interface.
+func (e *testFailedError) Error() string {
+	return e.err.Error()
+}
+
+// Is reports whether the target is ErrTestFailed.
+func (e *testFailedError) Is(target error) bool {
+	return target == ErrTestFailed
+}
+
+// Unwrap returns the error of the format.
+func (e *testFailedError) Unwrap() error {
+	return e.err
+}
+
 func copyBytes(data []byte) []byte {
 	if data == nil {
 		return nil
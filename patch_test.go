@@ -39,11 +39,16 @@ package cborpatch
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"math/big"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 func reformatJSON(j string) string {
@@ -601,6 +606,68 @@ var Cases = []Case{
 		false,
 		false,
 	},
+	{
+		`{"a":{"b":1,"c":{"d":2,"e":3}},"f":[1]}`,
+		`[
+						 {"op": "merge", "path": "/a", "value": {"b":null,"c":{"d":4,"g":[5]},"h":"x"}},
+						 {"op": "merge", "path": "", "value": {"f":{"i":true}}}
+					 ]`,
+		`{"a":{"c":{"d":4,"e":3,"g":[5]},"h":"x"},"f":{"i":true}}`,
+		false,
+		false,
+	},
+	{
+		`{"log":[1],"msg":"he"}`,
+		`[
+						 {"op": "append", "path": "/log", "value": [2, [3]]},
+						 {"op": "append", "path": "/msg", "value": "llo"},
+						 {"op": "append", "path": "/log", "value": []}
+					 ]`,
+		`{"log":[1,2,[3]],"msg":"hello"}`,
+		false,
+		false,
+	},
+	{
+		`[1]`,
+		`[ {"op": "append", "path": "", "value": [2]} ]`,
+		`[1,2]`,
+		false,
+		false,
+	},
+	{
+		`{"a":[0,1,2,3,4]}`,
+		`[
+						 {"op": "splice", "path": "/a", "index": 1, "remove-count": 2, "value": ["x", "y", "z"]},
+						 {"op": "splice", "path": "/a", "index": 6, "value": [5]},
+						 {"op": "splice", "path": "/a", "remove-count": 1}
+					 ]`,
+		`{"a":["x","y","z",3,4,5]}`,
+		false,
+		false,
+	},
+	{
+		`{"n":1,"m":[-2,0.5]}`,
+		`[
+						 {"op": "incr", "path": "/n", "value": 2},
+						 {"op": "decr", "path": "/n", "value": 5},
+						 {"op": "incr", "path": "/m/0", "value": 3},
+						 {"op": "decr", "path": "/m/1", "value": -1.25}
+					 ]`,
+		`{"n":-2,"m":[1,1.75]}`,
+		false,
+		false,
+	},
+	{
+		`{"a":true,"b":[false]}`,
+		`[
+						 {"op": "toggle", "path": "/a"},
+						 {"op": "toggle", "path": "/b/0"},
+						 {"op": "toggle", "path": "/b/0"}
+					 ]`,
+		`{"a":false,"b":[false]}`,
+		false,
+		false,
+	},
 }
 
 type BadCase struct {
@@ -619,6 +686,74 @@ var MutationTestCases = []BadCase{
 }
 
 var BadCases = []BadCase{
+	{
+		`{ "foo": "bar" }`,
+		`[ { "op": "text-diff", "path": "/foo", "value": [2, -2] } ]`,
+	},
+	{
+		`{ "foo": "bar" }`,
+		`[ { "op": "text-diff", "path": "/foo", "value": "baz" } ]`,
+	},
+	{
+		`{ "foo": null }`,
+		`[ { "op": "toggle", "path": "/foo" } ]`,
+	},
+	{
+		`{ "foo": true }`,
+		`[ { "op": "toggle", "path": "" } ]`,
+	},
+	{
+		`{ "foo": "1" }`,
+		`[ { "op": "incr", "path": "/foo", "value": 1 } ]`,
+	},
+	{
+		`{ "foo": 1 }`,
+		`[ { "op": "decr", "path": "/bar", "value": 1 } ]`,
+	},
+	{
+		`{ "foo": 1 }`,
+		`[ { "op": "incr", "path": "/foo", "value": "1" } ]`,
+	},
+	{
+		`{ "foo": 18446744073709551615 }`,
+		`[ { "op": "incr", "path": "/foo", "value": 1 } ]`,
+	},
+	{
+		`{ "foo": [1, 2] }`,
+		`[ { "op": "splice", "path": "/foo", "index": 1, "remove-count": 2 } ]`,
+	},
+	{
+		`{ "foo": [1, 2] }`,
+		`[ { "op": "splice", "path": "/foo", "index": 3 } ]`,
+	},
+	{
+		`{ "foo": "bar" }`,
+		`[ { "op": "splice", "path": "/foo", "value": ["x"] } ]`,
+	},
+	{
+		`{ "foo": [1] }`,
+		`[ { "op": "add", "path": "/foo/0", "index": 1, "value": 0 } ]`,
+	},
+	{
+		`{ "foo": [] }`,
+		`[ { "op": "append", "path": "/foo", "value": "bar" } ]`,
+	},
+	{
+		`{ "foo": {} }`,
+		`[ { "op": "append", "path": "/foo", "value": [1] } ]`,
+	},
+	{
+		`{ "foo": "bar" }`,
+		`[ { "op": "append", "path": "", "value": [1] } ]`,
+	},
+	{
+		`{ "foo": "bar" }`,
+		`[ { "op": "merge", "path": "/baz", "value": {"a": 1} } ]`,
+	},
+	{
+		`{ "foo": {} }`,
+		`[ { "op": "merge", "path": "/foo", "value": [1] } ]`,
+	},
 	{
 		``,
 		`[
@@ -726,23 +861,39 @@ var BadCases = []BadCase{
 	},
 }
 
-// This is not thread safe, so we cannot run patch tests in parallel.
-func configureGlobals(accumulatedCopySizeLimit int64) func() {
-	oldAccumulatedCopySizeLimit := AccumulatedCopySizeLimit
-	AccumulatedCopySizeLimit = accumulatedCopySizeLimit
-	return func() {
-		AccumulatedCopySizeLimit = oldAccumulatedCopySizeLimit
+func TestDefaultOptions(t *testing.T) {
+	options := DefaultOptions()
+	if !options.SupportNegativeIndices || options.AccumulatedCopySizeLimit != 0 ||
+		options.MaxEnsurePathPadding != DefaultMaxEnsurePathPadding {
+		t.Errorf("unexpected default options %+v", options)
+	}
+
+	old := SupportNegativeIndices
+	SupportNegativeIndices = false
+	defer func() { SupportNegativeIndices = old }()
+
+	if NewOptions().SupportNegativeIndices {
+		t.Errorf("NewOptions should read the package-level SupportNegativeIndices")
+	}
+	if !DefaultOptions().SupportNegativeIndices {
+		t.Errorf("DefaultOptions should not read the package-level SupportNegativeIndices")
+	}
+
+	out, err := applyPatchWithOptions(`{ "foo": [1, 2] }`, `[{ "op": "remove", "path": "/foo/-1" }]`, DefaultOptions())
+	if err != nil || !compareJSON(out, `{ "foo": [1] }`) {
+		t.Errorf("unexpected result %s, %v", out, err)
 	}
 }
 
 func TestAllCases(t *testing.T) {
-	defer configureGlobals(int64(100))()
+	limited := DefaultOptions()
+	limited.AccumulatedCopySizeLimit = 100
 
 	// Test patch.Apply happy-path cases.
 	for i, c := range Cases {
 		t.Run(fmt.Sprintf("Case %d", i), func(t *testing.T) {
 			if !c.allowMissingPathOnRemove && !c.ensurePathExistsOnAdd {
-				out, err := applyPatch(c.doc, c.patch)
+				out, err := applyPatchWithOptions(c.doc, c.patch, limited)
 
 				if err != nil {
 					t.Errorf("Unable to apply patch: %s", err)
@@ -757,7 +908,8 @@ func TestAllCases(t *testing.T) {
 	}
 
 	// Test patch.ApplyWithOptions happy-path cases.
-	options := NewOptions()
+	options := DefaultOptions()
+	options.AccumulatedCopySizeLimit = 100
 
 	for i, c := range Cases {
 		t.Run(fmt.Sprintf("Case %d", i), func(t *testing.T) {
@@ -778,7 +930,7 @@ func TestAllCases(t *testing.T) {
 	}
 
 	for _, c := range MutationTestCases {
-		out, err := applyPatch(c.doc, c.patch)
+		out, err := applyPatchWithOptions(c.doc, c.patch, limited)
 
 		if err != nil {
 			t.Errorf("Unable to apply patch: %s", err)
@@ -791,7 +943,7 @@ func TestAllCases(t *testing.T) {
 	}
 
 	for _, c := range BadCases {
-		_, err := applyPatch(c.doc, c.patch)
+		_, err := applyPatchWithOptions(c.doc, c.patch, limited)
 
 		if err == nil {
 			t.Errorf("Patch %q should have failed to apply but it did not", c.patch)
@@ -909,126 +1061,869 @@ func TestAllTest(t *testing.T) {
 	}
 }
 
-func TestAdd(t *testing.T) {
-	testCases := []struct {
-		name                   string
-		key                    RawKey
-		val                    Node
-		arr                    partialArray
-		rejectNegativeIndicies bool
-		err                    string
+func TestMaxPatchOps(t *testing.T) {
+	options := NewOptions()
+	options.MaxPatchOps = 2
+
+	p, err := PatchFromJSON(`[
+		{ "op": "add", "path": "/a", "value": 1 },
+		{ "op": "add", "path": "/b", "value": 2 },
+		{ "op": "add", "path": "/c", "value": 3 }
+	]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc := MustFromJSON(`{}`)
+	if _, err = p.ApplyWithOptions(doc, options); !errors.Is(err, ErrTooManyOps) {
+		t.Errorf("expected ErrTooManyOps, got %v", err)
+	}
+	if _, err = ApplyToTree(map[string]any{}, p, options); !errors.Is(err, ErrTooManyOps) {
+		t.Errorf("expected ErrTooManyOps for tree, got %v", err)
+	}
+
+	node := NewNode(doc)
+	if err = node.Patch(p, options); !errors.Is(err, ErrTooManyOps) {
+		t.Errorf("expected ErrTooManyOps for node, got %v", err)
+	}
+	if MustToJSON(MustMarshal(node)) != `{}` {
+		t.Errorf("no operation should be applied, got %s", MustToJSON(MustMarshal(node)))
+	}
+
+	if _, err = p[:2].ApplyWithOptions(doc, options); err != nil {
+		t.Errorf("patch within the limit failed, %v", err)
+	}
+}
+
+func TestMaxNestingDepth(t *testing.T) {
+	options := NewOptions()
+	options.MaxNestingDepth = 3
+
+	doc := MustFromJSON(`{ "a": { "b": [1, 2] } }`)
+	for i, c := range []struct {
+		patch string
+		ok    bool
 	}{
-		{
-			name: "should work",
-			key:  RawKey(MustMarshal(0)),
-			val:  Node{},
-			arr:  partialArray{},
-		},
-		{
-			name: "index too large",
-			key:  RawKey(MustMarshal(1)),
-			val:  Node{},
-			arr:  partialArray{},
-			err:  "unable to access invalid index 1, invalid index referenced",
-		},
-		{
-			name: "negative should work",
-			key:  RawKey(MustMarshal(-1)),
-			val:  Node{},
-			arr:  partialArray{},
-		},
-		{
-			name: "negative too small",
-			key:  RawKey(MustMarshal(-2)),
-			val:  Node{},
-			arr:  partialArray{},
-			err:  "unable to access invalid index -2, invalid index referenced",
-		},
-		{
-			name:                   "negative but negative disabled",
-			key:                    RawKey(MustMarshal(-1)),
-			val:                    Node{},
-			arr:                    partialArray{},
-			rejectNegativeIndicies: true,
-			err:                    "unable to access invalid index -1, invalid index referenced",
-		},
+		{`[{ "op": "add", "path": "/a/c", "value": [1] }]`, true},
+		{`[{ "op": "add", "path": "/a/c", "value": [[1]] }]`, false},
+		{`[{ "op": "add", "path": "/a/b/0/c", "value": 1 }]`, false},
+		{`[{ "op": "replace", "path": "/a", "value": { "x": { "y": 1 } } }]`, true},
+		{`[{ "op": "replace", "path": "/a", "value": { "x": { "y": [1] } } }]`, false},
+		{`[{ "op": "append", "path": "/a/b", "value": [3] }]`, true},
+		{`[{ "op": "append", "path": "/a/b", "value": [[3]] }]`, false},
+		{`[{ "op": "copy", "from": "/a/b", "path": "/c" }]`, true},
+		{`[{ "op": "copy", "from": "/a", "path": "/c/d" }]`, false},
+		{`[{ "op": "move", "from": "/a/b", "path": "/a/c" }]`, true},
+	} {
+		p, err := PatchFromJSON(c.patch)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = p.ApplyWithOptions(doc, options)
+		if c.ok != (err == nil) {
+			t.Errorf("#%d: unexpected result for %s, %v", i, c.patch, err)
+		}
+		if err != nil && !errors.Is(err, ErrTooDeep) {
+			t.Errorf("#%d: expected ErrTooDeep, got %v", i, err)
+		}
+
+		var tree any
+		if err = cborUnmarshal(doc, &tree); err != nil {
+			t.Fatal(err)
+		}
+		_, err = ApplyToTree(tree, p, options)
+		if c.ok != (err == nil) {
+			t.Errorf("#%d: unexpected result for %s on tree, %v", i, c.patch, err)
+		}
+	}
+
+	deep := MustFromJSON(`{ "a": { "b": { "c": { "d": { "id": 1 } } } } }`)
+	tests := []*PV{{Path: PathMustFromJSON(`/id`), Value: MustMarshal(1)}}
+	if _, err := NewNode(deep).FindChildren(tests, options); !errors.Is(err, ErrTooDeep) {
+		t.Errorf("expected ErrTooDeep for FindChildren, got %v", err)
+	}
+	if res, err := NewNode(deep).FindChildren(tests, nil); err != nil || len(res) != 1 {
+		t.Errorf("unexpected FindChildren result without limit, %v, %v", res, err)
+	}
+
+	// a document nested deeper is rejected when it is decoded.
+	p := Patch{{Op: OpAdd, Path: PathMustFrom("x"), Value: MustMarshal(1)}}
+	if _, err := p.ApplyWithOptions(deep, options); !errors.Is(err, ErrTooDeep) {
+		t.Errorf("expected ErrTooDeep for a deep document, got %v", err)
 	}
+	if _, err := NewNode(deep).GetValue(PathMustFrom("a"), options); !errors.Is(err, ErrTooDeep) {
+		t.Errorf("expected ErrTooDeep for GetValue, got %v", err)
+	}
+	if _, err := p.ApplyWithOptions(deep, nil); err != nil {
+		t.Errorf("unexpected error without limit, %v", err)
+	}
+}
 
+func TestMaxEnsurePathPadding(t *testing.T) {
 	options := NewOptions()
+	options.EnsurePathExistsOnAdd = true
+	options.MaxEnsurePathPadding = 3
 
-	for _, tc := range testCases {
-		t.Run(tc.name, func(t *testing.T) {
-			arr := &tc.arr
-			val := &tc.val
-			options.SupportNegativeIndices = !tc.rejectNegativeIndicies
-			err := arr.add(tc.key, val, options)
-			if err == nil && tc.err != "" {
-				t.Errorf("Expected error but got none! %v", tc.err)
-			} else if err != nil && tc.err == "" {
-				t.Errorf("Did not expect error but go: %v", err)
-			} else if err != nil && err.Error() != tc.err {
-				t.Errorf("Expected error %v but got error %v", tc.err, err)
-			}
-		})
+	doc := MustFromJSON(`{ "a": [1] }`)
+	for i, c := range []struct {
+		patch string
+		ok    bool
+	}{
+		{`[{ "op": "add", "path": "/b/3", "value": 1 }]`, true},
+		{`[{ "op": "add", "path": "/b/4", "value": 1 }]`, false},
+		{`[{ "op": "add", "path": "/a/4/b", "value": 1 }]`, true},
+		{`[{ "op": "add", "path": "/a/5/b", "value": 1 }]`, false},
+		{`[{ "op": "add", "path": "/b/1000000000", "value": 1 }]`, false},
+	} {
+		p, err := PatchFromJSON(c.patch)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = p.ApplyWithOptions(doc, options)
+		if c.ok != (err == nil) {
+			t.Errorf("#%d: unexpected result for %s, %v", i, c.patch, err)
+		}
+
+		var tree any
+		if err = cborUnmarshal(doc, &tree); err != nil {
+			t.Fatal(err)
+		}
+		_, err = ApplyToTree(tree, p, options)
+		if c.ok != (err == nil) {
+			t.Errorf("#%d: unexpected result for %s on tree, %v", i, c.patch, err)
+		}
+	}
+
+	// a zero value of Options is limited by DefaultMaxEnsurePathPadding.
+	options = &Options{EnsurePathExistsOnAdd: true}
+	p, err := PatchFromJSON(`[{ "op": "add", "path": "/b/100", "value": 1 }]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = p.ApplyWithOptions(doc, options); err != nil {
+		t.Errorf("padding within the default limit failed, %v", err)
+	}
+	p, err = PatchFromJSON(`[{ "op": "add", "path": "/b/10001", "value": 1 }]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = p.ApplyWithOptions(doc, options); err == nil {
+		t.Error("padding beyond the default limit should fail")
+	}
+
+	options.MaxEnsurePathPadding = -1
+	if _, err = p.ApplyWithOptions(doc, options); err != nil {
+		t.Errorf("padding without limit failed, %v", err)
 	}
 }
 
-type EqualityCase struct {
-	name  string
-	a, b  string
-	equal bool
+func TestApplyWithContext(t *testing.T) {
+	p, err := PatchFromJSON(`[
+		{ "op": "add", "path": "/a", "value": 1 },
+		{ "op": "add", "path": "/b/c/d", "value": 2 }
+	]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := MustFromJSON(`{}`)
+
+	out, err := p.ApplyWithContext(context.Background(), doc, nil)
+	if err == nil || errors.Is(err, context.Canceled) {
+		t.Errorf("expected missing path error, got %v", err)
+	}
+	options := NewOptions()
+	options.EnsurePathExistsOnAdd = true
+	out, err = p.ApplyWithContext(context.Background(), doc, options)
+	if err != nil || MustToJSON(out) != `{"a":1,"b":{"c":{"d":2}}}` {
+		t.Errorf("unexpected result %s, %v", MustToJSON(out), err)
+	}
+	if options.ctx != nil {
+		t.Errorf("the options should not be modified")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = p.ApplyWithContext(ctx, doc, options); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+
+	ctx, cancel = context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	node := NewNode(doc)
+	if err = node.PatchWithContext(ctx, p, options); err != context.DeadlineExceeded {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if MustToJSON(MustMarshal(node)) != `{}` {
+		t.Errorf("no operation should be applied, got %s", MustToJSON(MustMarshal(node)))
+	}
 }
 
-var EqualityCases = []EqualityCase{
-	{
-		"ExtraKeyFalse",
-		`{"foo": "bar"}`,
-		`{"foo": "bar", "baz": "qux"}`,
-		false,
-	},
-	{
-		"StripWhitespaceTrue",
-		`{
-			"foo": "bar",
-			"baz": "qux"
-		}`,
-		`{"foo": "bar", "baz": "qux"}`,
-		true,
-	},
-	{
-		"KeysOutOfOrderTrue",
-		`{
-			"baz": "qux",
-			"foo": "bar"
-		}`,
-		`{"foo": "bar", "baz": "qux"}`,
-		true,
-	},
-	{
-		"ComparingNullFalse",
-		`{"foo": null}`,
-		`{"foo": "bar"}`,
-		false,
-	},
-	{
-		"ComparingNullTrue",
-		`{"foo": null}`,
-		`{"foo": null}`,
-		true,
-	},
-	{
-		"ArrayOutOfOrderFalse",
-		`["foo", "bar", "baz"]`,
-		`["bar", "baz", "foo"]`,
-		false,
-	},
-	{
-		"ArrayTrue",
-		`["foo", "bar", "baz"]`,
-		`["foo", "bar", "baz"]`,
-		true,
-	},
-	{
+func TestPatchError(t *testing.T) {
+	p, err := PatchFromJSON(`[
+		{ "op": "add", "path": "/a", "value": 1 },
+		{ "op": "test", "path": "/a", "value": 1 },
+		{ "op": "remove", "path": "/b/c" }
+	]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc := MustFromJSON(`{ "b": {} }`)
+	var tree any
+	if err = cborUnmarshal(doc, &tree); err != nil {
+		t.Fatal(err)
+	}
+	_, err1 := p.Apply(doc)
+	_, err2 := ApplyToTree(tree, p, nil)
+	for _, err := range []error{err1, err2} {
+		var pe *PatchError
+		if !errors.As(err, &pe) {
+			t.Fatalf("expected a *PatchError, got %v", err)
+		}
+		if pe.OpIndex != 2 || pe.Op != OpRemove || pe.Path.String() != PathMustFromJSON("/b/c").String() {
+			t.Errorf("unexpected PatchError %d, %s, %s", pe.OpIndex, pe.Op, pe.Path)
+		}
+		if !errors.Is(err, ErrMissing) {
+			t.Errorf("expected ErrMissing, got %v", err)
+		}
+		if err.Error() != pe.Err.Error() {
+			t.Errorf("unexpected error message %q", err.Error())
+		}
+	}
+
+	p[1] = &Operation{Op: OpTest, Path: PathMustFromJSON("/a"), Value: MustMarshal(2)}
+	_, err = p.Apply(doc)
+	var pe *PatchError
+	if !errors.As(err, &pe) || pe.OpIndex != 1 || !errors.Is(err, ErrTestFailed) {
+		t.Errorf("expected a failed test at 1, got %v", err)
+	}
+}
+
+func TestOpHooks(t *testing.T) {
+	p, err := PatchFromJSON(`[
+		{ "op": "add", "path": "/a", "value": 1 },
+		{ "op": "remove", "path": "/x" },
+		{ "op": "add", "path": "/secret", "value": 2 }
+	]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errDenied := errors.New("denied")
+	var logs []string
+	options := NewOptions()
+	options.AllowMissingPathOnRemove = true
+	options.OnBeforeOp = func(i int, op *Operation) error {
+		if op.Path.String() == PathMustFromJSON("/secret").String() {
+			return errDenied
+		}
+		logs = append(logs, fmt.Sprintf("before %d %s", i, op.Op))
+		return nil
+	}
+	options.OnAfterOp = func(i int, op *Operation, err error) {
+		logs = append(logs, fmt.Sprintf("after %d %s %v", i, op.Op, err))
+	}
+
+	doc := MustFromJSON(`{}`)
+	expected := "before 0 add,after 0 add <nil>,before 1 remove,after 1 remove <nil>"
+
+	_, err = p.ApplyWithOptions(doc, options)
+	var pe *PatchError
+	if !errors.As(err, &pe) || pe.OpIndex != 2 || !errors.Is(err, errDenied) {
+		t.Errorf("expected the third operation denied, got %v", err)
+	}
+	if strings.Join(logs, ",") != expected {
+		t.Errorf("unexpected hook calls %v", logs)
+	}
+
+	logs = logs[:0]
+	if _, err = ApplyToTree(map[string]any{}, p, options); !errors.Is(err, errDenied) {
+		t.Errorf("expected the third operation denied for tree, got %v", err)
+	}
+	if strings.Join(logs, ",") != expected {
+		t.Errorf("unexpected hook calls for tree %v", logs)
+	}
+
+	logs = logs[:0]
+	options.AllowMissingPathOnRemove = false
+	if _, err = p.ApplyWithOptions(doc, options); !errors.Is(err, ErrMissing) {
+		t.Errorf("expected ErrMissing, got %v", err)
+	}
+	if len(logs) != 4 || !strings.HasPrefix(logs[3], "after 1 remove remove operation") {
+		t.Errorf("unexpected hook calls %v", logs)
+	}
+}
+
+func TestFailOnMissingTestPath(t *testing.T) {
+	options := NewOptions()
+	options.FailOnMissingTestPath = true
+
+	doc := MustFromJSON(`{ "baz": "qux", "foo": null }`)
+	for i, c := range []struct {
+		patch  string
+		result bool
+	}{
+		{`[ { "op": "test", "path": "/foo", "value": null } ]`, true},
+		{`[ { "op": "test", "path": "/bar", "value": null } ]`, false},
+		{`[ { "op": "test", "path": "/bar" } ]`, false},
+		{`[ { "op": "test", "path": "/bar/baz", "value": null } ]`, false},
+	} {
+		p, err := PatchFromJSON(c.patch)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = p.ApplyWithOptions(doc, options)
+		if c.result && err != nil {
+			t.Errorf("Testing case %d failed when it should have passed: %s", i, err)
+		} else if !c.result && !errors.Is(err, ErrTestFailed) {
+			t.Errorf("Testing case %d should have failed the test, got %v", i, err)
+		} else if !c.result && !errors.Is(err, ErrMissing) {
+			t.Errorf("Testing case %d should have failed on a missing path, got %v", i, err)
+		}
+
+		var tree any
+		if err = cborUnmarshal(doc, &tree); err != nil {
+			t.Fatal(err)
+		}
+		_, err = ApplyToTree(tree, p, options)
+		if c.result && err != nil {
+			t.Errorf("Testing tree case %d failed when it should have passed: %s", i, err)
+		} else if !c.result && !errors.Is(err, ErrTestFailed) {
+			t.Errorf("Testing tree case %d should have failed the test, got %v", i, err)
+		} else if !c.result && !errors.Is(err, ErrMissing) {
+			t.Errorf("Testing tree case %d should have failed on a missing path, got %v", i, err)
+		}
+	}
+}
+
+func TestTestContains(t *testing.T) {
+	doc := `{"tags":["a",{"b":1},null],"msg":"hello world","empty":[],"n":1}`
+	for i, c := range []struct {
+		patch  string
+		result bool
+	}{
+		{`[ { "op": "test-contains", "path": "/tags", "value": "a" } ]`, true},
+		{`[ { "op": "test-contains", "path": "/tags", "value": {"b":1} } ]`, true},
+		{`[ { "op": "test-contains", "path": "/tags", "value": null } ]`, true},
+		{`[ { "op": "test-contains", "path": "/tags", "value": "b" } ]`, false},
+		{`[ { "op": "test-contains", "path": "/msg", "value": "lo wo" } ]`, true},
+		{`[ { "op": "test-contains", "path": "/msg", "value": "" } ]`, true},
+		{`[ { "op": "test-contains", "path": "/msg", "value": "worlds" } ]`, false},
+		{`[ { "op": "test-contains", "path": "/msg", "value": ["h"] } ]`, false},
+		{`[ { "op": "test-contains", "path": "/empty", "value": 1 } ]`, false},
+		{`[ { "op": "test-contains", "path": "/n", "value": 1 } ]`, false},
+		{`[ { "op": "test-contains", "path": "/x", "value": 1 } ]`, false},
+		{`[ { "op": "test-contains", "path": "", "value": 1 } ]`, false},
+	} {
+		_, err := applyPatch(doc, c.patch)
+		if c.result && err != nil {
+			t.Errorf("Testing case %d failed when it should have passed: %s", i, err)
+		} else if !c.result && !errors.Is(err, ErrTestFailed) {
+			t.Errorf("Testing case %d should have failed the test, got %v", i, err)
+		}
+
+		p, err := PatchFromJSON(c.patch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var tree any
+		if err = cborUnmarshal(MustFromJSON(doc), &tree); err != nil {
+			t.Fatal(err)
+		}
+		_, err = ApplyToTree(tree, p, nil)
+		if c.result && err != nil {
+			t.Errorf("Testing tree case %d failed when it should have passed: %s", i, err)
+		} else if !c.result && !errors.Is(err, ErrTestFailed) {
+			t.Errorf("Testing tree case %d should have failed the test, got %v", i, err)
+		}
+	}
+}
+
+func TestTestType(t *testing.T) {
+	doc := `{"m":{},"a":[1],"i":-1,"f":1.5,"s":"x","b":false,"n":null}`
+	for i, c := range []struct {
+		patch  string
+		result bool
+	}{
+		{`[ { "op": "test-type", "path": "", "value": "map" } ]`, true},
+		{`[ { "op": "test-type", "path": "/m", "value": "map" } ]`, true},
+		{`[ { "op": "test-type", "path": "/a", "value": "array" } ]`, true},
+		{`[ { "op": "test-type", "path": "/a", "value": 4 } ]`, true},
+		{`[ { "op": "test-type", "path": "/a/0", "value": "int" } ]`, true},
+		{`[ { "op": "test-type", "path": "/a/0", "value": 0 } ]`, true},
+		{`[ { "op": "test-type", "path": "/i", "value": "int" } ]`, true},
+		{`[ { "op": "test-type", "path": "/i", "value": 0 } ]`, false},
+		{`[ { "op": "test-type", "path": "/f", "value": "float" } ]`, true},
+		{`[ { "op": "test-type", "path": "/f", "value": "int" } ]`, false},
+		{`[ { "op": "test-type", "path": "/s", "value": "text" } ]`, true},
+		{`[ { "op": "test-type", "path": "/s", "value": "bytes" } ]`, false},
+		{`[ { "op": "test-type", "path": "/b", "value": "bool" } ]`, true},
+		{`[ { "op": "test-type", "path": "/n", "value": "null" } ]`, true},
+		{`[ { "op": "test-type", "path": "/n", "value": "bool" } ]`, false},
+		{`[ { "op": "test-type", "path": "/x", "value": "null" } ]`, false},
+	} {
+		_, err := applyPatch(doc, c.patch)
+		if c.result && err != nil {
+			t.Errorf("Testing case %d failed when it should have passed: %s", i, err)
+		} else if !c.result && !errors.Is(err, ErrTestFailed) {
+			t.Errorf("Testing case %d should have failed the test, got %v", i, err)
+		}
+
+		p, err := PatchFromJSON(c.patch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var tree any
+		if err = cborUnmarshal(MustFromJSON(doc), &tree); err != nil {
+			t.Fatal(err)
+		}
+		_, err = ApplyToTree(tree, p, nil)
+		if c.result && err != nil {
+			t.Errorf("Testing tree case %d failed when it should have passed: %s", i, err)
+		} else if !c.result && !errors.Is(err, ErrTestFailed) {
+			t.Errorf("Testing tree case %d should have failed the test, got %v", i, err)
+		}
+	}
+
+	for _, patch := range []string{
+		`[ { "op": "test-type", "path": "/m", "value": "object" } ]`,
+		`[ { "op": "test-type", "path": "/m", "value": 8 } ]`,
+		`[ { "op": "test-type", "path": "/m" } ]`,
+	} {
+		if _, err := applyPatch(doc, patch); err == nil || errors.Is(err, ErrTestFailed) {
+			t.Errorf("%s should be invalid, got %v", patch, err)
+		}
+	}
+}
+
+func TestTestMatch(t *testing.T) {
+	doc := `{"id":"user-42","version":"v1.10.3","n":1}`
+	for i, c := range []struct {
+		patch  string
+		result bool
+	}{
+		{`[ { "op": "test-match", "path": "/id", "value": "^user-[0-9]+$" } ]`, true},
+		{`[ { "op": "test-match", "path": "/id", "value": "[0-9]" } ]`, true},
+		{`[ { "op": "test-match", "path": "/id", "value": "^[0-9]+$" } ]`, false},
+		{`[ { "op": "test-match", "path": "/version", "value": "^v1\\.\\d+\\.\\d+$" } ]`, true},
+		{`[ { "op": "test-match", "path": "/version", "value": "^v2\\." } ]`, false},
+		{`[ { "op": "test-match", "path": "/n", "value": "1" } ]`, false},
+		{`[ { "op": "test-match", "path": "/x", "value": "" } ]`, false},
+		{`[ { "op": "test-match", "path": "", "value": "" } ]`, false},
+	} {
+		_, err := applyPatch(doc, c.patch)
+		if c.result && err != nil {
+			t.Errorf("Testing case %d failed when it should have passed: %s", i, err)
+		} else if !c.result && !errors.Is(err, ErrTestFailed) {
+			t.Errorf("Testing case %d should have failed the test, got %v", i, err)
+		}
+
+		p, err := PatchFromJSON(c.patch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var tree any
+		if err = cborUnmarshal(MustFromJSON(doc), &tree); err != nil {
+			t.Fatal(err)
+		}
+		_, err = ApplyToTree(tree, p, nil)
+		if c.result && err != nil {
+			t.Errorf("Testing tree case %d failed when it should have passed: %s", i, err)
+		} else if !c.result && !errors.Is(err, ErrTestFailed) {
+			t.Errorf("Testing tree case %d should have failed the test, got %v", i, err)
+		}
+	}
+
+	for _, patch := range []string{
+		`[ { "op": "test-match", "path": "/id", "value": "(" } ]`,
+		`[ { "op": "test-match", "path": "/id", "value": 1 } ]`,
+	} {
+		if _, err := applyPatch(doc, patch); err == nil || errors.Is(err, ErrTestFailed) {
+			t.Errorf("%s should be invalid, got %v", patch, err)
+		}
+	}
+}
+
+func TestTestCompare(t *testing.T) {
+	big70 := new(big.Int).Lsh(big.NewInt(1), 70)
+	doc := MustMarshal(map[string]any{
+		"u":   uint64(math.MaxUint64),
+		"i":   int64(math.MinInt64),
+		"f":   1.5,
+		"b":   big70,
+		"nan": math.NaN(),
+		"s":   "1",
+	})
+
+	for i, c := range []struct {
+		op     Op
+		path   string
+		value  any
+		result bool
+	}{
+		{OpTestLess, "f", 2, true},
+		{OpTestLess, "f", 1.5, false},
+		{OpTestLessEqual, "f", 1.5, true},
+		{OpTestGreater, "f", 1, true},
+		{OpTestGreaterEqual, "f", uint64(2), false},
+		{OpTestGreater, "u", int64(math.MaxInt64), true},
+		{OpTestLess, "u", big70, true},
+		{OpTestGreater, "b", uint64(math.MaxUint64), true},
+		{OpTestLess, "b", 1e30, true},
+		{OpTestLess, "i", -9.2e18, true},
+		{OpTestGreaterEqual, "i", int64(math.MinInt64), true},
+		{OpTestLess, "nan", 1, false},
+		{OpTestGreaterEqual, "nan", 1, false},
+		{OpTestLess, "s", 2, false},
+		{OpTestLess, "x", 2, false},
+	} {
+		op := &Operation{Op: c.op, Path: PathMustFrom(c.path), Value: MustMarshal(c.value)}
+		_, err := Patch{op}.Apply(doc)
+		if c.result && err != nil {
+			t.Errorf("Testing case %d failed when it should have passed: %s", i, err)
+		} else if !c.result && !errors.Is(err, ErrTestFailed) {
+			t.Errorf("Testing case %d should have failed the test, got %v", i, err)
+		}
+
+		var tree any
+		if err = cborUnmarshal(doc, &tree); err != nil {
+			t.Fatal(err)
+		}
+		_, err = ApplyToTree(tree, Patch{op}, nil)
+		if c.result && err != nil {
+			t.Errorf("Testing tree case %d failed when it should have passed: %s", i, err)
+		} else if !c.result && !errors.Is(err, ErrTestFailed) {
+			t.Errorf("Testing tree case %d should have failed the test, got %v", i, err)
+		}
+	}
+
+	p, err := NewBuilder().TestRange(PathMustFrom("f"), 1, 2).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = p.Apply(doc); err != nil {
+		t.Errorf("test range failed, %v", err)
+	}
+
+	if _, err = applyPatch(`{"a":1}`, `[ { "op": "test-less", "path": "/a", "value": "2" } ]`); err == nil ||
+		errors.Is(err, ErrTestFailed) {
+		t.Errorf("test-less operation with text value should be invalid, got %v", err)
+	}
+}
+
+func TestTestLength(t *testing.T) {
+	doc := `{"a":[1,2,3],"m":{"x":1},"s":"你好","e":"","n":1}`
+	for i, c := range []struct {
+		patch  string
+		result bool
+	}{
+		{`[ { "op": "test-length", "path": "/a", "value": 3 } ]`, true},
+		{`[ { "op": "test-length", "path": "/a", "value": 2 } ]`, false},
+		{`[ { "op": "test-length", "path": "/a", "value": [0, 3] } ]`, true},
+		{`[ { "op": "test-length", "path": "/a", "value": [4, 10] } ]`, false},
+		{`[ { "op": "test-length", "path": "/m", "value": 1 } ]`, true},
+		{`[ { "op": "test-length", "path": "", "value": 5 } ]`, true},
+		{`[ { "op": "test-length", "path": "/s", "value": 2 } ]`, true},
+		{`[ { "op": "test-length", "path": "/e", "value": 0 } ]`, true},
+		{`[ { "op": "test-length", "path": "/n", "value": 1 } ]`, false},
+		{`[ { "op": "test-length", "path": "/x", "value": 0 } ]`, false},
+	} {
+		_, err := applyPatch(doc, c.patch)
+		if c.result && err != nil {
+			t.Errorf("Testing case %d failed when it should have passed: %s", i, err)
+		} else if !c.result && !errors.Is(err, ErrTestFailed) {
+			t.Errorf("Testing case %d should have failed the test, got %v", i, err)
+		}
+
+		p, err := PatchFromJSON(c.patch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var tree any
+		if err = cborUnmarshal(MustFromJSON(doc), &tree); err != nil {
+			t.Fatal(err)
+		}
+		_, err = ApplyToTree(tree, p, nil)
+		if c.result && err != nil {
+			t.Errorf("Testing tree case %d failed when it should have passed: %s", i, err)
+		} else if !c.result && !errors.Is(err, ErrTestFailed) {
+			t.Errorf("Testing tree case %d should have failed the test, got %v", i, err)
+		}
+	}
+
+	op, err := NewTestLength(PathMustFrom("b"), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = (Patch{op}).Apply(MustMarshal(map[string]any{"b": []byte("abc")})); err != nil {
+		t.Errorf("test-length operation for byte string failed, %v", err)
+	}
+
+	for _, patch := range []string{
+		`[ { "op": "test-length", "path": "/a", "value": -1 } ]`,
+		`[ { "op": "test-length", "path": "/a", "value": [3, 1] } ]`,
+		`[ { "op": "test-length", "path": "/a", "value": [1] } ]`,
+		`[ { "op": "test-length", "path": "/a", "value": "3" } ]`,
+	} {
+		if _, err := applyPatch(doc, patch); err == nil || errors.Is(err, ErrTestFailed) {
+			t.Errorf("%s should be invalid, got %v", patch, err)
+		}
+	}
+}
+
+func TestFloatEpsilon(t *testing.T) {
+	doc := MustMarshal(map[string]any{"f": float32(0.1), "a": []any{float32(1.1), "x"}})
+	options := NewOptions()
+
+	for _, op := range []*Operation{
+		{Op: OpTest, Path: PathMustFrom("f"), Value: MustMarshal(0.1)},
+		{Op: OpTest, Path: PathMustFrom("a"), Value: MustMarshal([]any{1.1, "x"})},
+		{Op: OpTest, Path: Path{}, Value: MustMarshal(map[string]any{"f": 0.1, "a": []any{1.1, "x"}})},
+		{Op: OpTestContains, Path: PathMustFrom("a"), Value: MustMarshal(1.1)},
+		{Op: OpTestSubset, Path: Path{}, Value: MustMarshal(map[string]any{"a": []any{1.1, "x"}})},
+	} {
+		options.Equal.FloatEpsilon = 0
+		if _, err := (Patch{op}).ApplyWithOptions(doc, options); !errors.Is(err, ErrTestFailed) {
+			t.Errorf("%s operation without FloatEpsilon should fail, got %v", op.Op, err)
+		}
+		var tree any
+		if err := cborUnmarshal(doc, &tree); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ApplyToTree(tree, Patch{op}, options); !errors.Is(err, ErrTestFailed) {
+			t.Errorf("%s operation on tree without FloatEpsilon should fail, got %v", op.Op, err)
+		}
+
+		options.Equal.FloatEpsilon = 1e-6
+		if _, err := (Patch{op}).ApplyWithOptions(doc, options); err != nil {
+			t.Errorf("%s operation with FloatEpsilon failed, %v", op.Op, err)
+		}
+		if _, err := ApplyToTree(tree, Patch{op}, options); err != nil {
+			t.Errorf("%s operation on tree with FloatEpsilon failed, %v", op.Op, err)
+		}
+	}
+
+	options.Equal.FloatEpsilon = 1e-6
+	op := &Operation{Op: OpTest, Path: PathMustFrom("f"), Value: MustMarshal(0.2)}
+	if _, err := (Patch{op}).ApplyWithOptions(doc, options); !errors.Is(err, ErrTestFailed) {
+		t.Errorf("test operation out of FloatEpsilon should fail, got %v", err)
+	}
+}
+
+func TestTestSubset(t *testing.T) {
+	doc := `{"a":{"b":1,"c":[{"d":2,"e":3}],"f":null},"g":"x"}`
+	for i, c := range []struct {
+		patch  string
+		result bool
+	}{
+		{`[ { "op": "test-subset", "path": "", "value": {} } ]`, true},
+		{`[ { "op": "test-subset", "path": "", "value": {"g":"x"} } ]`, true},
+		{`[ { "op": "test-subset", "path": "/a", "value": {"b":1,"c":[{"e":3}]} } ]`, true},
+		{`[ { "op": "test-subset", "path": "/a", "value": {"f":null} } ]`, true},
+		{`[ { "op": "test-subset", "path": "/a", "value": {"b":2} } ]`, false},
+		{`[ { "op": "test-subset", "path": "/a", "value": {"x":null} } ]`, false},
+		{`[ { "op": "test-subset", "path": "/a", "value": {"c":[]} } ]`, false},
+		{`[ { "op": "test-subset", "path": "/a", "value": {"c":{}} } ]`, false},
+		{`[ { "op": "test-subset", "path": "/a/c/0", "value": {"d":2,"e":3} } ]`, true},
+		{`[ { "op": "test-subset", "path": "/g", "value": "x" } ]`, true},
+		{`[ { "op": "test-subset", "path": "/g", "value": {} } ]`, false},
+		{`[ { "op": "test-subset", "path": "/x", "value": {} } ]`, false},
+	} {
+		_, err := applyPatch(doc, c.patch)
+		if c.result && err != nil {
+			t.Errorf("Testing case %d failed when it should have passed: %s", i, err)
+		} else if !c.result && !errors.Is(err, ErrTestFailed) {
+			t.Errorf("Testing case %d should have failed the test, got %v", i, err)
+		}
+
+		p, err := PatchFromJSON(c.patch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var tree any
+		if err = cborUnmarshal(MustFromJSON(doc), &tree); err != nil {
+			t.Fatal(err)
+		}
+		_, err = ApplyToTree(tree, p, nil)
+		if c.result && err != nil {
+			t.Errorf("Testing tree case %d failed when it should have passed: %s", i, err)
+		} else if !c.result && !errors.Is(err, ErrTestFailed) {
+			t.Errorf("Testing tree case %d should have failed the test, got %v", i, err)
+		}
+	}
+}
+
+func TestTestDefined(t *testing.T) {
+	doc := `{"a":null,"b":[0],"c":{"d":1}}`
+	for i, c := range []struct {
+		patch  string
+		result bool
+	}{
+		{`[ { "op": "test-defined", "path": "" } ]`, true},
+		{`[ { "op": "test-defined", "path": "/a" } ]`, true},
+		{`[ { "op": "test-defined", "path": "/b/0" } ]`, true},
+		{`[ { "op": "test-defined", "path": "/c/d" } ]`, true},
+		{`[ { "op": "test-defined", "path": "/x" } ]`, false},
+		{`[ { "op": "test-defined", "path": "/b/1" } ]`, false},
+		{`[ { "op": "test-defined", "path": "/c/d/e" } ]`, false},
+		{`[ { "op": "test-undefined", "path": "/x" } ]`, true},
+		{`[ { "op": "test-undefined", "path": "/b/1" } ]`, true},
+		{`[ { "op": "test-undefined", "path": "/x/y" } ]`, true},
+		{`[ { "op": "test-undefined", "path": "/a" } ]`, false},
+		{`[ { "op": "test-undefined", "path": "" } ]`, false},
+	} {
+		_, err := applyPatch(doc, c.patch)
+		if c.result && err != nil {
+			t.Errorf("Testing case %d failed when it should have passed: %s", i, err)
+		} else if !c.result && !errors.Is(err, ErrTestFailed) {
+			t.Errorf("Testing case %d should have failed the test, got %v", i, err)
+		}
+
+		p, err := PatchFromJSON(c.patch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var tree any
+		if err = cborUnmarshal(MustFromJSON(doc), &tree); err != nil {
+			t.Fatal(err)
+		}
+		_, err = ApplyToTree(tree, p, nil)
+		if c.result && err != nil {
+			t.Errorf("Testing tree case %d failed when it should have passed: %s", i, err)
+		} else if !c.result && !errors.Is(err, ErrTestFailed) {
+			t.Errorf("Testing tree case %d should have failed the test, got %v", i, err)
+		}
+	}
+
+	if _, err := applyPatch(doc, `[ { "op": "test-defined", "path": "/a", "value": 1 } ]`); err == nil ||
+		errors.Is(err, ErrTestFailed) {
+		t.Errorf("test-defined operation with value should be invalid, got %v", err)
+	}
+}
+
+func TestAdd(t *testing.T) {
+	testCases := []struct {
+		name                   string
+		key                    RawKey
+		val                    Node
+		arr                    partialArray
+		rejectNegativeIndicies bool
+		err                    string
+	}{
+		{
+			name: "should work",
+			key:  RawKey(MustMarshal(0)),
+			val:  Node{},
+			arr:  partialArray{},
+		},
+		{
+			name: "index too large",
+			key:  RawKey(MustMarshal(1)),
+			val:  Node{},
+			arr:  partialArray{},
+			err:  "unable to access invalid index 1, invalid index referenced",
+		},
+		{
+			name: "negative should work",
+			key:  RawKey(MustMarshal(-1)),
+			val:  Node{},
+			arr:  partialArray{},
+		},
+		{
+			name: "negative too small",
+			key:  RawKey(MustMarshal(-2)),
+			val:  Node{},
+			arr:  partialArray{},
+			err:  "unable to access invalid index -2, invalid index referenced",
+		},
+		{
+			name:                   "negative but negative disabled",
+			key:                    RawKey(MustMarshal(-1)),
+			val:                    Node{},
+			arr:                    partialArray{},
+			rejectNegativeIndicies: true,
+			err:                    "unable to access invalid index -1, invalid index referenced",
+		},
+	}
+
+	options := NewOptions()
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			arr := &tc.arr
+			val := &tc.val
+			options.SupportNegativeIndices = !tc.rejectNegativeIndicies
+			err := arr.add(tc.key, val, options)
+			if err == nil && tc.err != "" {
+				t.Errorf("Expected error but got none! %v", tc.err)
+			} else if err != nil && tc.err == "" {
+				t.Errorf("Did not expect error but go: %v", err)
+			} else if err != nil && err.Error() != tc.err {
+				t.Errorf("Expected error %v but got error %v", tc.err, err)
+			}
+		})
+	}
+}
+
+type EqualityCase struct {
+	name  string
+	a, b  string
+	equal bool
+}
+
+var EqualityCases = []EqualityCase{
+	{
+		"ExtraKeyFalse",
+		`{"foo": "bar"}`,
+		`{"foo": "bar", "baz": "qux"}`,
+		false,
+	},
+	{
+		"StripWhitespaceTrue",
+		`{
+			"foo": "bar",
+			"baz": "qux"
+		}`,
+		`{"foo": "bar", "baz": "qux"}`,
+		true,
+	},
+	{
+		"KeysOutOfOrderTrue",
+		`{
+			"baz": "qux",
+			"foo": "bar"
+		}`,
+		`{"foo": "bar", "baz": "qux"}`,
+		true,
+	},
+	{
+		"ComparingNullFalse",
+		`{"foo": null}`,
+		`{"foo": "bar"}`,
+		false,
+	},
+	{
+		"ComparingNullTrue",
+		`{"foo": null}`,
+		`{"foo": null}`,
+		true,
+	},
+	{
+		"ArrayOutOfOrderFalse",
+		`["foo", "bar", "baz"]`,
+		`["bar", "baz", "foo"]`,
+		false,
+	},
+	{
+		"ArrayTrue",
+		`["foo", "bar", "baz"]`,
+		`["foo", "bar", "baz"]`,
+		true,
+	},
+	{
 		"NonStringTypesTrue",
 		`{"int": 6, "bool": true, "float": 7.0, "string": "the_string", "null": null}`,
 		`{"int": 6, "bool": true, "float": 7.0, "string": "the_string", "null": null}`,
@@ -1081,3 +1976,639 @@ func TestEquality(t *testing.T) {
 		})
 	}
 }
+
+func TestPatchRebase(t *testing.T) {
+	p, err := PatchFromJSON(`[{"op":"add","path":"/a","value":1},{"op":"move","from":"/a","path":"/b"},{"op":"replace","path":"","value":{"c":2}}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rebased := p.Rebase(PathMustFrom("tenants", 1))
+	data, err := PatchToJSON(rebased)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `[{"op":"add","path":"/tenants/1/a","value":1},{"op":"move","from":"/tenants/1/a","path":"/tenants/1/b"},{"op":"replace","path":"/tenants/1","value":{"c":2}}]`
+	if string(data) != expected {
+		t.Errorf("Rebase = %s, expected %s", data, expected)
+	}
+
+	doc, err := rebased.Apply(MustFromJSON(`{"tenants": [{}, {"x": 0}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := MustToJSON(doc); s != `{"tenants":[{},{"c":2}]}` {
+		t.Errorf("Apply rebased patch = %s", s)
+	}
+
+	if data, _ = PatchToJSON(p); string(data) != `[{"op":"add","path":"/a","value":1},{"op":"move","from":"/a","path":"/b"},{"op":"replace","path":"","value":{"c":2}}]` {
+		t.Errorf("Rebase should not change the patch, got %s", data)
+	}
+}
+
+func TestPatchFilter(t *testing.T) {
+	p, err := PatchFromJSON(`[{"op":"add","path":"/a/x","value":1},{"op":"move","from":"/b","path":"/a/y"},{"op":"remove","path":"/b"},{"op":"test","path":"/ab","value":1},{"op":"replace","path":"","value":{}}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		p        Patch
+		expected string
+	}{
+		{p.Filter(PathMustFrom("a")), `[{"op":"add","path":"/a/x","value":1},{"op":"move","from":"/b","path":"/a/y"}]`},
+		{p.Exclude(PathMustFrom("a")), `[{"op":"remove","path":"/b"},{"op":"test","path":"/ab","value":1},{"op":"replace","path":"","value":{}}]`},
+		{p.Filter(PathMustFrom("b")), `[{"op":"move","from":"/b","path":"/a/y"},{"op":"remove","path":"/b"}]`},
+		{p.Filter(Path{}), `[{"op":"add","path":"/a/x","value":1},{"op":"move","from":"/b","path":"/a/y"},{"op":"remove","path":"/b"},{"op":"test","path":"/ab","value":1},{"op":"replace","path":"","value":{}}]`},
+		{p.Exclude(Path{}), `[]`},
+	} {
+		data, err := PatchToJSON(c.p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != c.expected {
+			t.Errorf("got %s, expected %s", data, c.expected)
+		}
+	}
+}
+
+func TestAppendByteString(t *testing.T) {
+	op, err := NewAppend(PathMustFrom("b"), []byte{3, 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := Patch{op}.Apply(MustMarshal(map[string]any{"b": []byte{1, 2}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := MustMarshal(map[string]any{"b": []byte{1, 2, 3, 4}}); !Equal(doc, expected) {
+		t.Errorf("append byte string got %s, expected %s", NewNode(doc), NewNode(expected))
+	}
+
+	if _, err = NewAppend(PathMustFrom("b"), 1); err == nil {
+		t.Errorf("append operation with integer value should be invalid")
+	}
+}
+
+func TestApplyTo(t *testing.T) {
+	p, err := PatchFromJSON(`[{ "op": "replace", "path": "/name", "value": "bar" }]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := MustFromJSON(`{ "name": "foo", "tags": ["a", "b"] }`)
+
+	var out struct {
+		Name string   `cbor:"name"`
+		Tags []string `cbor:"tags"`
+	}
+	if err = p.ApplyTo(doc, &out, nil); err != nil {
+		t.Fatal(err)
+	}
+	if out.Name != "bar" || !reflect.DeepEqual(out.Tags, []string{"a", "b"}) {
+		t.Errorf("unexpected result %+v", out)
+	}
+
+	var raw RawMessage
+	if err = p.ApplyTo(doc, &raw, nil); err != nil {
+		t.Fatal(err)
+	}
+	if MustToJSON(raw) != `{"name":"bar","tags":["a","b"]}` {
+		t.Errorf("unexpected result %s", MustToJSON(raw))
+	}
+
+	var n int
+	if err = p.ApplyTo(doc, &n, nil); err == nil {
+		t.Errorf("expected a decoding error")
+	}
+	if err = p.ApplyTo(MustFromJSON(`[]`), &out, nil); err == nil {
+		t.Errorf("expected a patching error")
+	}
+}
+
+func TestApplyGeneric(t *testing.T) {
+	type document struct {
+		Name  string `cbor:"name"`
+		Count int    `cbor:"count"`
+	}
+
+	p, err := PatchFromJSON(`[{ "op": "incr", "path": "/count", "value": 2 }]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := MustFromJSON(`{ "name": "foo", "count": 1 }`)
+
+	out, err := Apply[document](doc, p, nil)
+	if err != nil || out != (document{Name: "foo", Count: 3}) {
+		t.Errorf("unexpected result %+v, %v", out, err)
+	}
+
+	m, err := Apply[map[string]any](doc, p, nil)
+	if err != nil || m["count"] != uint64(3) {
+		t.Errorf("unexpected result %+v, %v", m, err)
+	}
+
+	if _, err = Apply[string](doc, p, nil); err == nil {
+		t.Errorf("expected a decoding error")
+	}
+}
+
+func TestNodeMutations(t *testing.T) {
+	node := NewNode(MustFromJSON(`{ "a": 1, "b": [1, 2] }`))
+
+	if err := node.SetValue(PathMustFromJSON("/a"), "x"); err != nil {
+		t.Fatal(err)
+	}
+	if err := node.AddValue(PathMustFromJSON("/b/1"), map[string]int{"c": 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := node.AddValue(PathMustFromJSON("/d"), RawMessage(MustFromJSON(`[true]`))); err != nil {
+		t.Fatal(err)
+	}
+	if err := node.RemoveValue(PathMustFromJSON("/b/0")); err != nil {
+		t.Fatal(err)
+	}
+	if s := MustToJSON(MustMarshal(node)); s != `{"a":"x","b":[{"c":3},2],"d":[true]}` {
+		t.Errorf("unexpected result %s", s)
+	}
+
+	if err := node.SetValue(PathMustFromJSON("/x"), 1); !errors.Is(err, ErrMissing) {
+		t.Errorf("expected ErrMissing, got %v", err)
+	}
+	if err := node.RemoveValue(PathMustFromJSON("/b/5")); err == nil {
+		t.Errorf("expected an error")
+	}
+	if err := node.AddValue(PathMustFromJSON("/y"), func() {}); err == nil {
+		t.Errorf("expected an encoding error")
+	}
+
+	snap := node.COWSnapshot()
+	if err := node.SetValue(PathMustFromJSON("/a"), "y"); err != nil {
+		t.Fatal(err)
+	}
+	if s := MustToJSON(MustMarshal(snap)); s != `{"a":"x","b":[{"c":3},2],"d":[true]}` {
+		t.Errorf("the snapshot should not be modified, got %s", s)
+	}
+}
+
+func TestPreserveKeyOrder(t *testing.T) {
+	orderedMap := func(kvs ...[]byte) []byte {
+		data := appendCBORHead(nil, 5, uint64(len(kvs)/2))
+		for _, v := range kvs {
+			data = append(data, v...)
+		}
+		return data
+	}
+	// {"z": 1, "a": {"y": 2, "b": 3}, "m": [1]}
+	doc := orderedMap(
+		MustMarshal("z"), MustMarshal(1),
+		MustMarshal("a"), orderedMap(MustMarshal("y"), MustMarshal(2), MustMarshal("b"), MustMarshal(3)),
+		MustMarshal("m"), MustMarshal([]int{1}),
+	)
+	p, err := PatchFromJSON(`[
+		{ "op": "replace", "path": "/a/b", "value": 4 },
+		{ "op": "add", "path": "/c", "value": 5 },
+		{ "op": "remove", "path": "/z" },
+		{ "op": "add", "path": "/a/x", "value": 6 }
+	]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options := NewOptions()
+	options.PreserveKeyOrder = true
+	expected := `{"a": {"y": 2, "b": 4, "x": 6}, "m": [1], "c": 5}`
+
+	res, err := p.ApplyWithOptions(doc, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := Diagify(res); s != expected {
+		t.Errorf("unexpected result %s", s)
+	}
+
+	node := NewNode(doc)
+	if err = node.PatchConcurrently(p, options); err != nil {
+		t.Fatal(err)
+	}
+	if s := Diagify(MustMarshal(node)); s != expected {
+		t.Errorf("unexpected result of PatchConcurrently %s", s)
+	}
+
+	node = NewNode(doc)
+	if err = node.Patch(p[:1], options); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := node.COWSnapshot()
+	if err = node.Patch(p[1:], options); err != nil {
+		t.Fatal(err)
+	}
+	if s := Diagify(MustMarshal(node)); s != expected {
+		t.Errorf("unexpected result of COW patch %s", s)
+	}
+	if s := Diagify(MustMarshal(snapshot)); s != `{"z": 1, "a": {"y": 2, "b": 4}, "m": [1]}` {
+		t.Errorf("unexpected snapshot %s", s)
+	}
+
+	res, err = p.Apply(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := Diagify(res); s != `{"a": {"b": 4, "x": 6, "y": 2}, "c": 5, "m": [1]}` {
+		t.Errorf("unexpected result without PreserveKeyOrder %s", s)
+	}
+}
+
+func TestUntouchedSubtrees(t *testing.T) {
+	// {"b": 1, "a": 1.5} with float64 encoding
+	z := append(appendCBORHead(nil, 5, 2), MustMarshal("b")...)
+	z = append(append(z, MustMarshal(1)...), MustMarshal("a")...)
+	z = append(z, 0xfb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0)
+
+	doc := append(appendCBORHead(nil, 5, 2), MustMarshal("z")...)
+	doc = append(append(doc, z...), MustMarshal("a")...)
+	doc = append(doc, MustMarshal(map[string]int{"x": 1})...)
+
+	p, err := PatchFromJSON(`[
+		{ "op": "test", "path": "/z/a", "value": 1.5 },
+		{ "op": "copy", "from": "/z/b", "path": "/a/y" },
+		{ "op": "replace", "path": "/a/x", "value": 2 }
+	]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := p.Apply(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the modified root and "a" are encoded, "z" keeps its raw encoding.
+	expected := append(appendCBORHead(nil, 5, 2), MustMarshal("a")...)
+	expected = append(expected, MustMarshal(map[string]int{"x": 2, "y": 1})...)
+	expected = append(append(expected, MustMarshal("z")...), z...)
+	if !bytes.Equal(res, expected) {
+		t.Errorf("unexpected result %x, expected %x", res, expected)
+	}
+
+	node := NewNode(doc)
+	if err = node.Materialize(); err != nil {
+		t.Fatal(err)
+	}
+	if data := MustMarshal(node); !bytes.Equal(data, doc) {
+		t.Errorf("unexpected encoding of materialized node %x", data)
+	}
+
+	// a child node modified by itself is encoded in its parent.
+	node = NewNode(MustFromJSON(`{"a": {"x": 0}, "b": [{"y": 0}]}`))
+	child, err := node.GetChild(PathMustFrom("a"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = child.SetValue(PathMustFrom("x"), 42); err != nil {
+		t.Fatal(err)
+	}
+	if child, err = node.GetChild(PathMustFrom("b", 0), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err = child.AddValue(PathMustFrom("z"), 1); err != nil {
+		t.Fatal(err)
+	}
+	if s := MustToJSON(MustMarshal(node)); s != `{"a":{"x":42},"b":[{"y":0,"z":1}]}` {
+		t.Errorf("unexpected result with modified children %s", s)
+	}
+}
+
+func TestAllowIndefiniteLength(t *testing.T) {
+	// {_ "a": [_ 1, 2], "b": (_ "x" "y")}
+	doc := []byte{0xbf, 0x61, 'a', 0x9f, 0x01, 0x02, 0xff, 0x61, 'b', 0x7f, 0x61, 'x', 0x61, 'y', 0xff, 0xff}
+	p := Patch{
+		// [_ 4]
+		{Op: OpAdd, Path: PathMustFrom("c"), Value: RawMessage{0x9f, 0x04, 0xff}},
+	}
+
+	if _, err := p.Apply(doc); err == nil {
+		t.Error("expected an error for indefinite-length document")
+	}
+
+	options := NewOptions()
+	options.AllowIndefiniteLength = true
+	res, err := p.ApplyWithOptions(doc, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := Diagify(res); s != `{"a": [1, 2], "b": "xy", "c": [4]}` {
+		t.Errorf("unexpected result %s", s)
+	}
+	if !bytes.Equal(p[0].Value, RawMessage{0x9f, 0x04, 0xff}) {
+		t.Error("the patch should not be modified")
+	}
+
+	tree, err := ApplyToTree(map[string]any{}, p, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := Diagify(MustMarshal(tree)); s != `{"c": [4]}` {
+		t.Errorf("unexpected tree %s", s)
+	}
+
+	data, err := definiteLength(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := fmt.Sprintf("%x", data); s != "a261618201026162627879" {
+		t.Errorf("unexpected definite-length encoding %s", s)
+	}
+	if data, err = definiteLength(res); err != nil || !sameBytes(data, res) {
+		t.Errorf("definite-length document should be returned as is, %v", err)
+	}
+}
+
+func TestDupMapKeyPolicy(t *testing.T) {
+	dupMap := func(key string) []byte {
+		data := append(appendCBORHead(nil, 5, 2), MustMarshal(key)...)
+		data = append(append(data, 0x01), MustMarshal(key)...)
+		return append(data, 0x02)
+	}
+	// {"a": 1, "b": [0, {"x": 1, "x": 2}]}
+	doc := append(appendCBORHead(nil, 5, 2), MustMarshal("a")...)
+	doc = append(append(doc, 0x01), MustMarshal("b")...)
+	doc = append(append(doc, 0x82, 0x00), dupMap("x")...)
+
+	replaceA, err := PatchFromJSON(`[{ "op": "replace", "path": "/a", "value": 2 }]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addY, err := PatchFromJSON(`[{ "op": "add", "path": "/b/1/y", "value": 3 }]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the duplicate keys are not checked if the map is not decoded.
+	if _, err = replaceA.Apply(doc); err != nil {
+		t.Error(err)
+	}
+	if _, err = addY.Apply(doc); err == nil {
+		t.Error("expected an error for duplicate keys")
+	}
+
+	options := NewOptions()
+	options.DupMapKey = DupMapKeyStrict
+	_, err = replaceA.ApplyWithOptions(doc, options)
+	var dupErr *DuplicateKeyError
+	if !errors.As(err, &dupErr) || !errors.Is(err, ErrInvalid) {
+		t.Fatalf("expected DuplicateKeyError, got %v", err)
+	}
+	if dupErr.Path.String() != `["b", 1]` || dupErr.Key.String() != `"x"` {
+		t.Errorf("unexpected DuplicateKeyError %v", dupErr)
+	}
+
+	p := Patch{{Op: OpAdd, Path: PathMustFrom("c"), Value: dupMap("y")}}
+	_, err = p.ApplyWithOptions(MustMarshal(map[string]int{}), options)
+	var patchErr *PatchError
+	if !errors.As(err, &patchErr) || !errors.As(err, &dupErr) {
+		t.Fatalf("expected PatchError of DuplicateKeyError, got %v", err)
+	}
+	if dupErr.Path.String() != `["c"]` || dupErr.Key.String() != `"y"` {
+		t.Errorf("unexpected DuplicateKeyError %v", dupErr)
+	}
+
+	options.DupMapKey = DupMapKeyLastWins
+	res, err := addY.ApplyWithOptions(doc, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := Diagify(res); s != `{"a": 1, "b": [0, {"x": 2, "y": 3}]}` {
+		t.Errorf("unexpected result %s", s)
+	}
+}
+
+func TestAllowAnyMapKey(t *testing.T) {
+	// {true: 1, [1, 2]: "a"}
+	doc := append(appendCBORHead(nil, 5, 2), MustMarshal(true)...)
+	doc = append(append(doc, MustMarshal(1)...), MustMarshal([]int{1, 2})...)
+	doc = append(doc, MustMarshal("a")...)
+
+	jp := `[
+		{ "op": "replace", "path": "/~c9Q", "value": 2 },
+		{ "op": "move", "from": "/~cggEC", "path": "/~c-T4A" },
+		{ "op": "add", "path": "/b", "value": 3 }
+	]`
+	if _, err := PatchFromJSON(jp); !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected ErrInvalid for non-string path keys, got %v", err)
+	}
+
+	options := NewOptions()
+	options.AllowAnyMapKey = true
+	p, err := PatchFromJSONWithOptions(jp, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = p.Apply(doc); err == nil {
+		t.Error("expected an error for non-string map keys")
+	}
+	if _, err = p.Apply(MustMarshal(map[string]int{})); err == nil ||
+		!strings.Contains(err.Error(), "can not be used as map key") {
+		t.Errorf("expected an error for non-string path keys, got %v", err)
+	}
+
+	// patches with such keys can be encoded, and decoded with AllowAnyMapKey.
+	// 1.5, [1] and 100("a")
+	for _, key := range []RawKey{RawKey(MustMarshal(1.5)), RawKey(MustMarshal([]int{1})), RawKey("\xd8\x64\x61a")} {
+		data := MustMarshal(Patch{{Op: OpAdd, Path: Path{key}, Value: MustMarshal(1)}})
+		if _, err = NewPatch(data); !errors.Is(err, ErrInvalid) {
+			t.Errorf("expected ErrInvalid for path key %v, got %v", key, err)
+		}
+		if _, err = NewPatchWithOptions(data, nil); !errors.Is(err, ErrInvalid) {
+			t.Errorf("expected ErrInvalid for path key %v, got %v", key, err)
+		}
+		if _, err = NewPatchWithOptions(data, options); err != nil {
+			t.Errorf("unexpected error for path key %v, %v", key, err)
+		}
+	}
+	if p, err = NewPatchWithOptions(MustMarshal(p), options); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := p.ApplyWithOptions(doc, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := Diagify(res); s != `{"b": 3, true: 2, 1.5: "a"}` {
+		t.Errorf("unexpected result %s", s)
+	}
+
+	// the query API accepts such keys too.
+	key := RawKey(MustMarshal([]int{1, 2}))
+	if _, err = NewNode(doc).GetValue(Path{key}, nil); err == nil {
+		t.Error("expected an error for non-string map keys")
+	}
+	v, err := NewNode(doc).GetValue(Path{key}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := Diagify(v); s != `"a"` {
+		t.Errorf("unexpected value %s", s)
+	}
+	if !NewNode(doc).Exists(Path{RawKey(MustMarshal(true))}, options) {
+		t.Error("expected the key true to exist")
+	}
+}
+
+func TestAllowRelativeFrom(t *testing.T) {
+	doc := MustFromJSON(`{"a": {"b": {"c": 1, "d": [2, 3]}}}`)
+
+	from, err := RelativePath(1, "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := Patch{
+		{Op: OpMove, From: from, Path: PathMustFrom("a", "b", "e")},
+		{Op: OpCopy, From: append(Path{relativeKey(1)}, PathMustFrom(0)...), Path: PathMustFrom("a", "b", "d", "-")},
+	}
+	if _, err = p.Apply(doc); err == nil {
+		t.Error("expected an error for relative from")
+	}
+
+	options := NewOptions()
+	options.AllowRelativeFrom = true
+	res, err := p.ApplyWithOptions(doc, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := MustToJSON(res); s != `{"a":{"b":{"d":[2,3,2],"e":1}}}` {
+		t.Errorf("unexpected result %s", s)
+	}
+
+	// relative from in JSON patches and in CBOR patches with JSON Pointers.
+	js := `[{"op":"move","from":"1/c","path":"/a/b/e"},{"op":"copy","from":"1/0","path":"/a/b/d/-"}]`
+	if _, err = PatchFromJSON(js); err == nil {
+		t.Error("expected an error for relative from in JSON patch")
+	}
+	if _, err = PatchFromJSONWithOptions(js, nil); err == nil {
+		t.Error("expected an error for relative from in JSON patch")
+	}
+	jp, err := PatchFromJSONWithOptions(js, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := PatchToJSON(jp); err != nil || !strings.Contains(string(data), `"from":"1/c"`) {
+		t.Errorf("unexpected JSON patch %s, %v", data, err)
+	}
+	cs := MustMarshal([]map[int]any{{1: OpMove, 2: "1/c", 3: "/a/b/e"}, {1: OpCopy, 2: "1/0", 3: "/a/b/d/-"}})
+	if _, err = NewPatch(cs); err == nil {
+		t.Error("expected an error for relative from in CBOR patch")
+	}
+	if _, err = NewPatchWithOptions(cs, nil); err == nil {
+		t.Error("expected an error for relative from in CBOR patch")
+	}
+	cp, err := NewPatchWithOptions(cs, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []Patch{jp, cp} {
+		if res, err = p.ApplyWithOptions(doc, options); err != nil {
+			t.Fatal(err)
+		}
+		if s := MustToJSON(res); s != `{"a":{"b":{"d":[2,3,2],"e":1}}}` {
+			t.Errorf("unexpected result %s", s)
+		}
+		if tree, err := ApplyToTree(map[string]any{"a": map[string]any{"b": map[string]any{"c": 1, "d": []any{2, 3}}}}, p, options); err != nil {
+			t.Error(err)
+		} else if s := fmt.Sprint(tree); s != "map[a:map[b:map[d:[2 3 2] e:1]]]" {
+			t.Errorf("unexpected tree %s", s)
+		}
+	}
+
+	p, err = PatchFromJSONWithOptions(`[{"op":"move","from":"4/c","path":"/a/b/e"}]`, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = p.ApplyWithOptions(doc, options); !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected ErrInvalid for relative from above the root, got %v", err)
+	}
+	// the relative form is only accepted for "from".
+	if _, err = PatchFromJSONWithOptions(`[{"op":"add","path":"1/e","value":1}]`, options); err == nil {
+		t.Error("expected an error for relative path")
+	}
+	if _, err = NewPatchWithOptions(MustMarshal([]map[int]any{{1: OpAdd, 3: "1/e", 4: 1}}), options); err == nil {
+		t.Error("expected an error for relative path")
+	}
+}
+
+func TestAllowWildcard(t *testing.T) {
+	doc := MustFromJSON(`{"users": [
+		{"name": "a", "password": "x", "active": true},
+		{"name": "b", "active": true},
+		{"name": "c", "password": "y", "active": true}
+	], "tags": {"x": 1, "y": 2}}`)
+
+	p, err := PatchFromJSON(`[
+		{"op": "test", "path": "/users/*/active", "value": true},
+		{"op": "remove", "path": "/users/*/password"},
+		{"op": "replace", "path": "/tags/*", "value": 0}
+	]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = p.Apply(doc); err == nil {
+		t.Error("expected an error for the literal \"*\" key")
+	}
+
+	options := NewOptions()
+	options.AllowWildcard = true
+	var paths []string
+	options.OnAfterOp = func(i int, op *Operation, err error) {
+		paths = append(paths, PathToJSON(op.Path))
+	}
+	res, err := p.ApplyWithOptions(doc, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := MustToJSON(res); s != `{"tags":{"x":0,"y":0},"users":[{"active":true,"name":"a"},{"active":true,"name":"b"},{"active":true,"name":"c"}]}` {
+		t.Errorf("unexpected result %s", s)
+	}
+	if s := strings.Join(paths, ","); s != "/users/0/active,/users/1/active,/users/2/active,/users/2/password,/users/0/password,/tags/x,/tags/y" {
+		t.Errorf("unexpected paths %s", s)
+	}
+	options.OnAfterOp = nil
+
+	// array elements are removed from the last one.
+	p = Patch{{Op: OpRemove, Path: PathMustFrom("users", "*")}}
+	if res, err = p.ApplyWithOptions(doc, options); err != nil {
+		t.Fatal(err)
+	}
+	if s := MustToJSON(res); s != `{"tags":{"x":1,"y":2},"users":[]}` {
+		t.Errorf("unexpected result %s", s)
+	}
+
+	for _, c := range []struct {
+		p  Patch
+		ok bool
+	}{
+		{Patch{{Op: OpTest, Path: PathMustFrom("users", "*", "name"), Value: MustMarshal("a")}}, false},
+		{Patch{{Op: OpTest, Path: PathMustFrom("users", "*", "missing"), Value: MustMarshal("a")}}, true},
+		{Patch{{Op: OpRemove, Path: PathMustFrom("*", "x")}}, true},
+		{Patch{{Op: OpAdd, Path: PathMustFrom("users", "*", "x"), Value: MustMarshal(1)}}, false},
+		{Patch{{Op: OpMove, From: PathMustFrom("users", "*"), Path: PathMustFrom("x")}}, false},
+	} {
+		_, err := c.p.ApplyWithOptions(doc, options)
+		if (err == nil) != c.ok {
+			t.Errorf("ApplyWithOptions(%v) error %v, expected ok %v", c.p, err, c.ok)
+		}
+	}
+
+	if _, err = ApplyToTree(map[string]any{"a": []any{1}}, Patch{{Op: OpRemove, Path: PathMustFrom("a", "*")}}, options); !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected ErrInvalid from ApplyToTree, got %v", err)
+	}
+
+	node := NewNode(doc)
+	if err = node.PatchConcurrently(Patch{
+		{Op: OpRemove, Path: PathMustFrom("*", "x")},
+		{Op: OpReplace, Path: PathMustFrom("users", "*", "name"), Value: MustMarshal("z")},
+	}, options); err != nil {
+		t.Fatal(err)
+	}
+	if s := MustToJSON(MustMarshal(node)); s != `{"tags":{"y":2},"users":[{"active":true,"name":"z","password":"x"},{"active":true,"name":"z"},{"active":true,"name":"z","password":"y"}]}` {
+		t.Errorf("unexpected result %s", s)
+	}
+}
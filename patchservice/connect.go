@@ -0,0 +1,132 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package patchservice
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// ContentType is the content type of the Connect protocol with the CBOR codec.
+const ContentType = "application/cbor"
+
+var (
+	encMode, _ = cbor.CoreDetEncOptions().EncMode()
+	decMode, _ = cbor.DecOptions{
+		DupMapKey:   cbor.DupMapKeyEnforcedAPF,
+		IndefLength: cbor.IndefLengthForbidden,
+	}.DecMode()
+)
+
+// NewHandler returns a http.Handler that serves the unary methods of the PatchService
+// with the Connect protocol and the CBOR codec, such as "POST /cborpatch.v1.PatchService/Apply".
+// The handler should be mounted at the path returned by HandlerPath.
+func NewHandler(svc PatchService) http.Handler {
+	return &handler{svc: svc, maxBodySize: DefaultMaxDocumentSize}
+}
+
+// HandlerPath returns the path prefix that the handler returned by NewHandler serves.
+func HandlerPath() string {
+	return "/" + ServiceName + "/"
+}
+
+type handler struct {
+	svc         PatchService
+	maxBodySize int64
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, &Error{Code: CodeUnimplemented, Message: "method not allowed"})
+		return
+	}
+
+	if mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mt != ContentType {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+
+	method := strings.TrimPrefix(r.URL.Path, HandlerPath())
+	// read one more byte to tell an oversized body from a body of the limit size.
+	body, err := io.ReadAll(io.LimitReader(r.Body, h.maxBodySize+1))
+	if err != nil {
+		writeError(w, &Error{Code: CodeInvalidArgument, Message: err.Error()})
+		return
+	}
+	if int64(len(body)) > h.maxBodySize {
+		writeError(w, &Error{Code: CodeResourceExhausted,
+			Message: fmt.Sprintf("request body exceeds the limit of %d bytes", h.maxBodySize)})
+		return
+	}
+
+	var res any
+	ctx := r.Context()
+	switch method {
+	case "Apply":
+		res, err = unary(ctx, body, h.svc.Apply)
+	case "Diff":
+		res, err = unary(ctx, body, h.svc.Diff)
+	case "Test":
+		res, err = unary(ctx, body, h.svc.Test)
+	default:
+		err = &Error{Code: CodeUnimplemented, Message: "unknown method " + method}
+	}
+
+	if err != nil {
+		writeError(w, toError(err))
+		return
+	}
+
+	data, err := encMode.Marshal(res)
+	if err != nil {
+		writeError(w, &Error{Code: CodeInternal, Message: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+func unary[Req, Res any](ctx context.Context, body []byte,
+	fn func(context.Context, *Req) (*Res, error)) (*Res, error) {
+	req := new(Req)
+	if err := decMode.Unmarshal(body, req); err != nil {
+		return nil, &Error{Code: CodeInvalidArgument, Message: err.Error()}
+	}
+	return fn(ctx, req)
+}
+
+// writeError writes the error as a Connect protocol error.
+func writeError(w http.ResponseWriter, e *Error) {
+	status := http.StatusInternalServerError
+	switch e.Code {
+	case CodeCanceled:
+		status = 499
+	case CodeInvalidArgument, CodeFailedPrecondition:
+		status = http.StatusBadRequest
+	case CodeDeadlineExceeded:
+		status = http.StatusGatewayTimeout
+	case CodeResourceExhausted:
+		status = http.StatusTooManyRequests
+	case CodeUnimplemented:
+		status = http.StatusNotImplemented
+	}
+
+	data, _ := json.Marshal(map[string]string{
+		"code":    e.Code.String(),
+		"message": e.Message,
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
@@ -0,0 +1,76 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package patchservice
+
+import (
+	"context"
+	"errors"
+
+	cborpatch "github.com/ldclabs/cbor-patch"
+)
+
+// Code is a status code of the PatchService.
+// The values are the same as the gRPC status codes.
+type Code uint32
+
+// Status codes used by the PatchService.
+const (
+	CodeCanceled           Code = 1
+	CodeUnknown            Code = 2
+	CodeInvalidArgument    Code = 3
+	CodeDeadlineExceeded   Code = 4
+	CodeResourceExhausted  Code = 8
+	CodeFailedPrecondition Code = 9
+	CodeUnimplemented      Code = 12
+	CodeInternal           Code = 13
+)
+
+// String returns the Connect protocol name of the code.
+func (c Code) String() string {
+	switch c {
+	case CodeCanceled:
+		return "canceled"
+	case CodeInvalidArgument:
+		return "invalid_argument"
+	case CodeDeadlineExceeded:
+		return "deadline_exceeded"
+	case CodeResourceExhausted:
+		return "resource_exhausted"
+	case CodeFailedPrecondition:
+		return "failed_precondition"
+	case CodeUnimplemented:
+		return "unimplemented"
+	case CodeInternal:
+		return "internal"
+	default:
+		return "unknown"
+	}
+}
+
+// Error is an error with a status code returned by the PatchService.
+type Error struct {
+	Code    Code   `cbor:"1,keyasint" json:"code"`
+	Message string `cbor:"2,keyasint,omitempty" json:"message,omitempty"`
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	return e.Code.String() + ": " + e.Message
+}
+
+func toError(err error) *Error {
+	var e *Error
+	switch {
+	case errors.As(err, &e):
+		return e
+	case errors.Is(err, context.Canceled):
+		return &Error{Code: CodeCanceled, Message: err.Error()}
+	case errors.Is(err, context.DeadlineExceeded):
+		return &Error{Code: CodeDeadlineExceeded, Message: err.Error()}
+	case errors.Is(err, cborpatch.ErrTestFailed):
+		return &Error{Code: CodeFailedPrecondition, Message: err.Error()}
+	default:
+		return &Error{Code: CodeInvalidArgument, Message: err.Error()}
+	}
+}
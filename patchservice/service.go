@@ -0,0 +1,220 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+// Package patchservice provides a transport independent PatchService backed by cborpatch,
+// with a Connect protocol compatible HTTP handler using the CBOR codec.
+//
+// The service definition is:
+//
+//	service PatchService {
+//		rpc Apply(ApplyRequest) returns (ApplyResponse);
+//		rpc Diff(DiffRequest) returns (DiffResponse);
+//		rpc Test(TestRequest) returns (TestResponse);
+//		rpc ApplyStream(stream ApplyChunk) returns (stream ApplyChunk);
+//	}
+//
+// The streaming method has the same shape as a gRPC bidirectional streaming method,
+// so the Server can be registered to a gRPC server with a CBOR codec.
+package patchservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	cborpatch "github.com/ldclabs/cbor-patch"
+)
+
+// ServiceName is the fully-qualified name of the PatchService.
+const ServiceName = "cborpatch.v1.PatchService"
+
+// DefaultMaxDocumentSize is the default limit of a document size in ApplyStream.
+const DefaultMaxDocumentSize = 64 << 20
+
+// ApplyRequest is the request of PatchService.Apply.
+type ApplyRequest struct {
+	Document cborpatch.RawMessage `cbor:"1,keyasint"`
+	Patch    cborpatch.Patch      `cbor:"2,keyasint"`
+}
+
+// ApplyResponse is the response of PatchService.Apply.
+type ApplyResponse struct {
+	Document cborpatch.RawMessage `cbor:"1,keyasint"`
+}
+
+// DiffRequest is the request of PatchService.Diff.
+type DiffRequest struct {
+	Original cborpatch.RawMessage `cbor:"1,keyasint"`
+	Modified cborpatch.RawMessage `cbor:"2,keyasint"`
+}
+
+// DiffResponse is the response of PatchService.Diff.
+type DiffResponse struct {
+	Patch cborpatch.Patch `cbor:"1,keyasint"`
+}
+
+// TestRequest is the request of PatchService.Test.
+// Only the "test" operations in the patch are evaluated.
+type TestRequest struct {
+	Document cborpatch.RawMessage `cbor:"1,keyasint"`
+	Patch    cborpatch.Patch      `cbor:"2,keyasint"`
+}
+
+// TestResponse is the response of PatchService.Test.
+type TestResponse struct {
+	OK      bool   `cbor:"1,keyasint"`
+	Message string `cbor:"2,keyasint,omitempty"`
+}
+
+// ApplyChunk is a message of the PatchService.ApplyStream.
+//
+// A client sends the patch in any chunk, the document in one or more chunks in order,
+// and then closes the sending direction. The server responds with the patched document
+// in one or more chunks.
+type ApplyChunk struct {
+	Patch cborpatch.Patch `cbor:"1,keyasint,omitempty"`
+	Data  []byte          `cbor:"2,keyasint,omitempty"`
+}
+
+// ApplyStreamServer is the server side stream of PatchService.ApplyStream.
+type ApplyStreamServer interface {
+	Context() context.Context
+	Send(*ApplyChunk) error
+	Recv() (*ApplyChunk, error)
+}
+
+// PatchService is the service interface.
+type PatchService interface {
+	Apply(context.Context, *ApplyRequest) (*ApplyResponse, error)
+	Diff(context.Context, *DiffRequest) (*DiffResponse, error)
+	Test(context.Context, *TestRequest) (*TestResponse, error)
+	ApplyStream(ApplyStreamServer) error
+}
+
+// Server implements the PatchService.
+type Server struct {
+	// Options is used to apply patches, cborpatch.NewOptions() is used if it is nil.
+	Options *cborpatch.Options
+	// MaxDocumentSize limits the document size in ApplyStream, DefaultMaxDocumentSize is used if it is zero.
+	MaxDocumentSize int
+	// ChunkSize is the size of document chunks sent by ApplyStream, 64KB is used if it is zero.
+	ChunkSize int
+}
+
+var _ PatchService = (*Server)(nil)
+
+// Apply implements the PatchService interface.
+func (s *Server) Apply(ctx context.Context, req *ApplyRequest) (*ApplyResponse, error) {
+	doc, err := s.apply(req.Document, req.Patch)
+	if err != nil {
+		return nil, err
+	}
+	return &ApplyResponse{Document: doc}, nil
+}
+
+// Diff implements the PatchService interface.
+func (s *Server) Diff(ctx context.Context, req *DiffRequest) (*DiffResponse, error) {
+	patch, err := cborpatch.CreatePatch(req.Original, req.Modified)
+	if err != nil {
+		return nil, toError(err)
+	}
+	return &DiffResponse{Patch: patch}, nil
+}
+
+// Test implements the PatchService interface.
+func (s *Server) Test(ctx context.Context, req *TestRequest) (*TestResponse, error) {
+	tests := make(cborpatch.Patch, 0, len(req.Patch))
+	for _, op := range req.Patch {
+		if op != nil && op.Op.IsTest() {
+			tests = append(tests, op)
+		}
+	}
+
+	_, err := s.apply(req.Document, tests)
+	var e *Error
+	switch {
+	case err == nil:
+		return &TestResponse{OK: true}, nil
+	case errors.As(err, &e) && e.Code == CodeFailedPrecondition:
+		return &TestResponse{OK: false, Message: e.Message}, nil
+	default:
+		return nil, err
+	}
+}
+
+// ApplyStream implements the PatchService interface.
+func (s *Server) ApplyStream(stream ApplyStreamServer) error {
+	limit := s.MaxDocumentSize
+	if limit <= 0 {
+		limit = DefaultMaxDocumentSize
+	}
+
+	var doc []byte
+	var patch cborpatch.Patch
+	ctx := stream.Context()
+	for {
+		if err := ctx.Err(); err != nil {
+			return toError(err)
+		}
+
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if chunk.Patch != nil {
+			if patch != nil {
+				return &Error{Code: CodeInvalidArgument, Message: "patch is sent more than once"}
+			}
+			patch = chunk.Patch
+		}
+
+		if len(doc)+len(chunk.Data) > limit {
+			return &Error{Code: CodeResourceExhausted,
+				Message: fmt.Sprintf("document size exceeds the limit %d", limit)}
+		}
+		doc = append(doc, chunk.Data...)
+	}
+
+	doc, err := s.apply(doc, patch)
+	if err != nil {
+		return err
+	}
+
+	size := s.ChunkSize
+	if size <= 0 {
+		size = 64 << 10
+	}
+	for len(doc) > 0 {
+		n := size
+		if n > len(doc) {
+			n = len(doc)
+		}
+		if err = stream.Send(&ApplyChunk{Data: doc[:n]}); err != nil {
+			return err
+		}
+		doc = doc[n:]
+	}
+	return nil
+}
+
+func (s *Server) apply(doc []byte, patch cborpatch.Patch) ([]byte, error) {
+	if err := patch.Valid(); err != nil {
+		return nil, &Error{Code: CodeInvalidArgument, Message: err.Error()}
+	}
+
+	options := s.Options
+	if options == nil {
+		options = cborpatch.NewOptions()
+	}
+
+	doc, err := patch.ApplyWithOptions(doc, options)
+	if err != nil {
+		return nil, toError(err)
+	}
+	return doc, nil
+}
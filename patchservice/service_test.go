@@ -0,0 +1,183 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package patchservice
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	cborpatch "github.com/ldclabs/cbor-patch"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockStream struct {
+	ctx  context.Context
+	in   []*ApplyChunk
+	out  []*ApplyChunk
+	recv int
+}
+
+func (s *mockStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *mockStream) Send(c *ApplyChunk) error {
+	s.out = append(s.out, c)
+	return nil
+}
+
+func (s *mockStream) Recv() (*ApplyChunk, error) {
+	if s.recv >= len(s.in) {
+		return nil, io.EOF
+	}
+	s.recv++
+	return s.in[s.recv-1], nil
+}
+
+func TestServer(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx := context.Background()
+	srv := &Server{}
+	doc := cborpatch.MustFromJSON(`{"name": "John", "age": 24}`)
+	patch, err := cborpatch.PatchFromJSON(`[
+		{"op": "test", "path": "/name", "value": "John"},
+		{"op": "replace", "path": "/name", "value": "Jane"}
+	]`)
+	assert.NoError(err)
+
+	res, err := srv.Apply(ctx, &ApplyRequest{Document: doc, Patch: patch})
+	assert.NoError(err)
+	assert.Equal(`{"age":24,"name":"Jane"}`, cborpatch.MustToJSON(res.Document))
+
+	_, err = srv.Apply(ctx, &ApplyRequest{Document: res.Document, Patch: patch})
+	var e *Error
+	assert.True(errors.As(err, &e))
+	assert.Equal(CodeFailedPrecondition, e.Code)
+
+	_, err = srv.Apply(ctx, &ApplyRequest{Document: doc, Patch: cborpatch.Patch{{Op: cborpatch.OpMove}}})
+	assert.True(errors.As(err, &e))
+	assert.Equal(CodeInvalidArgument, e.Code)
+
+	dr, err := srv.Diff(ctx, &DiffRequest{Original: doc, Modified: res.Document})
+	assert.NoError(err)
+	assert.Equal(1, len(dr.Patch))
+	assert.Equal(cborpatch.OpReplace, dr.Patch[0].Op)
+	assert.Equal(`"Jane"`, dr.Patch[0].ValueNode().String())
+
+	_, err = srv.Diff(ctx, &DiffRequest{Original: []byte{0xa1}, Modified: doc})
+	assert.True(errors.As(err, &e))
+	assert.Equal(CodeInvalidArgument, e.Code)
+
+	tr, err := srv.Test(ctx, &TestRequest{Document: doc, Patch: patch})
+	assert.NoError(err)
+	assert.True(tr.OK)
+
+	tr, err = srv.Test(ctx, &TestRequest{Document: res.Document, Patch: patch})
+	assert.NoError(err)
+	assert.False(tr.OK)
+	assert.Contains(tr.Message, "test operation for path")
+
+	stream := &mockStream{ctx: ctx, in: []*ApplyChunk{
+		{Patch: patch, Data: doc[:3]},
+		{Data: doc[3:]},
+	}}
+	srv.ChunkSize = 4
+	assert.NoError(srv.ApplyStream(stream))
+	var out []byte
+	for _, c := range stream.out {
+		assert.True(len(c.Data) <= 4)
+		out = append(out, c.Data...)
+	}
+	assert.Equal(res.Document, cborpatch.RawMessage(out))
+
+	srv.MaxDocumentSize = 4
+	stream = &mockStream{ctx: ctx, in: []*ApplyChunk{{Patch: patch, Data: doc}}}
+	err = srv.ApplyStream(stream)
+	assert.True(errors.As(err, &e))
+	assert.Equal(CodeResourceExhausted, e.Code)
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	err = (&Server{}).ApplyStream(&mockStream{ctx: cctx})
+	assert.True(errors.As(err, &e))
+	assert.Equal(CodeCanceled, e.Code)
+}
+
+func TestHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	mux := http.NewServeMux()
+	mux.Handle(HandlerPath(), NewHandler(&Server{}))
+
+	call := func(method string, req any) *httptest.ResponseRecorder {
+		data, err := encMode.Marshal(req)
+		assert.NoError(err)
+		r := httptest.NewRequest(http.MethodPost, HandlerPath()+method, bytes.NewReader(data))
+		r.Header.Set("Content-Type", ContentType)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+
+	doc := cborpatch.MustFromJSON(`{"name": "John"}`)
+	patch := cborpatch.Patch{
+		{Op: cborpatch.OpAdd, Path: cborpatch.PathMustFrom("age"), Value: cborpatch.MustMarshal(24)},
+	}
+
+	w := call("Apply", &ApplyRequest{Document: doc, Patch: patch})
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal(ContentType, w.Header().Get("Content-Type"))
+	var res ApplyResponse
+	assert.NoError(decMode.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(`{"age":24,"name":"John"}`, cborpatch.MustToJSON(res.Document))
+
+	w = call("Test", &TestRequest{Document: doc, Patch: patch})
+	assert.Equal(http.StatusOK, w.Code)
+
+	w = call("Apply", &ApplyRequest{Document: doc, Patch: cborpatch.Patch{
+		{Op: cborpatch.OpRemove, Path: cborpatch.PathMustFrom("age")},
+	}})
+	assert.Equal(http.StatusBadRequest, w.Code)
+	assert.Contains(w.Body.String(), `"code":"invalid_argument"`)
+
+	w = call("Unknown", &ApplyRequest{})
+	assert.Equal(http.StatusNotImplemented, w.Code)
+
+	r := httptest.NewRequest(http.MethodPost, HandlerPath()+"Apply", bytes.NewReader([]byte("{}")))
+	r.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	assert.Equal(http.StatusUnsupportedMediaType, w.Code)
+
+	// media type parameters are accepted.
+	data, err := encMode.Marshal(&TestRequest{Document: doc, Patch: patch})
+	assert.NoError(err)
+	r = httptest.NewRequest(http.MethodPost, HandlerPath()+"Test", bytes.NewReader(data))
+	r.Header.Set("Content-Type", "application/cbor; charset=binary")
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	assert.Equal(http.StatusOK, w.Code)
+
+	// an oversized body is rejected rather than truncated.
+	h := &handler{svc: &Server{}, maxBodySize: int64(len(data)) - 1}
+	r = httptest.NewRequest(http.MethodPost, HandlerPath()+"Test", bytes.NewReader(data))
+	r.Header.Set("Content-Type", ContentType)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(http.StatusTooManyRequests, w.Code)
+	assert.Contains(w.Body.String(), `"code":"resource_exhausted"`)
+
+	h.maxBodySize = int64(len(data))
+	r = httptest.NewRequest(http.MethodPost, HandlerPath()+"Test", bytes.NewReader(data))
+	r.Header.Set("Content-Type", ContentType)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(http.StatusOK, w.Code)
+}
@@ -4,7 +4,11 @@
 package cborpatch
 
 import (
+	"errors"
 	"fmt"
+	"reflect"
+	"sort"
+	"strings"
 )
 
 // GetValueByPath returns the value of a given path in a raw encoded CBOR document.
@@ -14,24 +18,155 @@ func GetValueByPath(doc []byte, path Path) ([]byte, error) {
 
 // GetChild returns the child node of a given path in the node.
 func (n *Node) GetChild(path Path, options *Options) (*Node, error) {
+	if options == nil {
+		options = NewOptions()
+	}
+	n.useCodec(newCodec(options))
+
 	pd, err := n.intoContainer()
 	switch {
 	case err != nil:
-		return nil, fmt.Errorf("unexpected node %s, %v", n, err)
+		return nil, fmt.Errorf("unexpected node %s, %w", n, err)
 	case pd == nil:
 		return nil, fmt.Errorf("unexpected node %s", n)
 	}
 
-	if options == nil {
-		options = NewOptions()
-	}
 	con, key := findObject(&pd, path, options)
 	if con == nil {
-		return nil, fmt.Errorf("unable to get child node by path %s, %v", path, ErrMissing)
+		return nil, fmt.Errorf("unable to get child node by path %s, %w", path, ErrMissing)
 	}
 	return con.get(key, options)
 }
 
+// Kind returns the CBOR type of the node, CBORTypeInvalid if the node is invalid.
+// A nil node is CBOR null, which is CBORTypePrimitives.
+func (n *Node) Kind() CBORType {
+	switch {
+	case n == nil:
+		return CBORTypePrimitives
+	case n.which == eDoc:
+		return CBORTypeMap
+	case n.which == eAry:
+		return CBORTypeArray
+	case n.raw == nil:
+		return CBORTypeInvalid
+	}
+	return ReadCBORType(*n.raw)
+}
+
+// IsNull reports whether the node is CBOR null, a nil node is null.
+func (n *Node) IsNull() bool {
+	return n == nil || n.which != eDoc && n.which != eAry && n.raw != nil && IsNull(*n.raw)
+}
+
+// IsUndefined reports whether the node is CBOR undefined.
+func (n *Node) IsUndefined() bool {
+	return n != nil && n.which != eDoc && n.which != eAry && n.raw != nil && IsUndefined(*n.raw)
+}
+
+// Len returns the number of elements of an array node or the number of entries of a map node,
+// or 0 for other nodes. The node is decoded lazily.
+func (n *Node) Len() int {
+	if n == nil {
+		return 0
+	}
+	doc, err := n.intoContainer()
+	if err != nil || doc == nil {
+		return 0
+	}
+	return doc.len()
+}
+
+// MapKeys returns the keys of a map node in bytewise order, or the preserved order,
+// see Options.PreserveKeyOrder, or nil for other nodes.
+// The node is decoded lazily.
+func (n *Node) MapKeys() []RawKey {
+	if n == nil {
+		return nil
+	}
+	if _, err := n.intoContainer(); err != nil || n.which != eDoc {
+		return nil
+	}
+	return n.doc.orderedKeys()
+}
+
+// Exists reports whether the path exists in the node, the empty path is the node itself.
+// "-" in an array refers to the position after the last element, which does not exist.
+func (n *Node) Exists(path Path, options *Options) bool {
+	if n == nil {
+		return false
+	}
+	if options == nil {
+		options = NewOptions()
+	}
+	n.useCodec(newCodec(options))
+
+	cur := n
+	for _, key := range path {
+		doc, err := cur.intoContainer()
+		if err != nil || doc == nil {
+			return false
+		}
+		if _, ok := doc.(*partialArray); ok && key.isMinus() {
+			return false
+		}
+		if cur, err = doc.get(key, options); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// ResolvePath returns a copy of the path with the array indexes normalized against the node:
+// negative indexes are converted to the absolute indexes, and "-" at the end of the path is
+// converted to the length of the array, the index an "add" operation appends to.
+// The parents of the last key must exist, while the last key may be missing.
+func (n *Node) ResolvePath(path Path, options *Options) (Path, error) {
+	if options == nil {
+		options = NewOptions()
+	}
+	n.useCodec(newCodec(options))
+
+	res := make(Path, len(path))
+	copy(res, path)
+	cur := n
+	for i, key := range path {
+		doc, err := cur.intoContainer()
+		if err != nil || doc == nil {
+			return nil, fmt.Errorf("unable to resolve path %s at %s, %w", path, path[:i], ErrMissing)
+		}
+
+		if ary, ok := doc.(*partialArray); ok {
+			switch {
+			case key.isMinus():
+				if i < len(path)-1 {
+					return nil, fmt.Errorf("unable to resolve path %s, \"-\" is not the last key, %w", path, ErrInvalidIndex)
+				}
+				res[i] = encodeArrayIdx(ary.len())
+			case key.isIndex():
+				idx, err := key.toInt()
+				if err != nil {
+					return nil, err
+				}
+				if idx < 0 {
+					if !options.SupportNegativeIndices || idx < -ary.len() {
+						return nil, fmt.Errorf("unable to resolve path %s for invalid index %d, %w", path, idx, ErrInvalidIndex)
+					}
+					res[i] = encodeArrayIdx(idx + ary.len())
+				}
+			}
+		}
+
+		if i == len(path)-1 {
+			break
+		}
+		if cur, err = doc.get(key, options); err != nil {
+			return nil, fmt.Errorf("unable to resolve path %s, %w", path, err)
+		}
+	}
+	return res, nil
+}
+
 // GetValue returns the child node of a given path in the node.
 func (n *Node) GetValue(path Path, options *Options) (RawMessage, error) {
 	cn, err := n.GetChild(path, options)
@@ -41,6 +176,108 @@ func (n *Node) GetValue(path Path, options *Options) (RawMessage, error) {
 	return cn.MarshalCBOR()
 }
 
+// descentKey is the text string key "**" that matches zero or more levels of descendants, see GetValues.
+const descentKey = RawKey("\x62**")
+
+// GetValues returns the path-value pairs of the descendants of the node that match the pattern,
+// with their absolute paths, in the depth-first order of Walk. The pattern is a path in which
+// the text string key "*" matches every key of a map and every index of an array, and the text string
+// key "**" matches zero or more levels of descendants, such as ["users", "*", "name"] and ["**", "id"].
+// The other keys match like GetValue, and negative indexes are resolved in the returned paths.
+// It returns an empty result if nothing matches, and a path is returned once even if it matches
+// the pattern in more than one way.
+func (n *Node) GetValues(pattern Path, options *Options) ([]*PV, error) {
+	if options == nil {
+		options = NewOptions()
+	}
+	n.useCodec(newCodec(options))
+
+	var res []*PV
+	seen := make(map[string]bool)
+	var match func(n *Node, path, pattern Path) error
+	match = func(n *Node, path, pattern Path) error {
+		if n == nil {
+			n = NewNode(nil)
+		}
+
+		if len(pattern) == 0 {
+			id := pathID(path)
+			if seen[id] {
+				return nil
+			}
+			seen[id] = true
+			data, err := n.MarshalCBOR()
+			if err != nil {
+				return err
+			}
+			res = append(res, &PV{Path: path, Value: data})
+			return nil
+		}
+
+		if pattern[0] == descentKey {
+			if err := match(n, path, pattern[1:]); err != nil {
+				return err
+			}
+		}
+
+		if _, err := n.intoContainer(); err != nil || n.which != eDoc && n.which != eAry {
+			return nil
+		}
+
+		switch key := pattern[0]; {
+		case key == wildcardKey || key == descentKey:
+			rest := pattern[1:]
+			if key == descentKey {
+				rest = pattern
+			}
+			if n.which == eDoc {
+				for _, k := range n.doc.orderedKeys() {
+					if err := match(n.doc.obj[k], path.WithKey(k), rest); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+			for i, v := range n.ary {
+				if err := match(v, path.withIndex(i), rest); err != nil {
+					return err
+				}
+			}
+
+		case n.which == eDoc:
+			if v, err := n.doc.get(key, options); err == nil {
+				return match(v, path.WithKey(key), pattern[1:])
+			}
+
+		default:
+			v, err := n.ary.get(key, options)
+			if err != nil {
+				return nil
+			}
+			idx, _ := key.toInt()
+			if idx < 0 {
+				idx += len(n.ary)
+			}
+			return match(v, path.withIndex(idx), pattern[1:])
+		}
+		return nil
+	}
+
+	if err := match(n, Path{}, pattern); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// pathID returns a string that identifies the path, the keys are self-delimiting CBOR values.
+func pathID(path Path) string {
+	var sb strings.Builder
+	for _, k := range path {
+		sb.WriteString(string(k))
+	}
+	return sb.String()
+}
+
 // FindChildren returns the children nodes that pass the given tests in the node.
 func (n *Node) FindChildren(tests []*PV, options *Options) (result []*PV, err error) {
 	if len(tests) == 0 {
@@ -50,6 +287,7 @@ func (n *Node) FindChildren(tests []*PV, options *Options) (result []*PV, err er
 	if options == nil {
 		options = NewOptions()
 	}
+	n.useCodec(newCodec(options))
 
 	res, err := findChildNodes(n, NewNode(tests[0].Value), Path{}, tests[0].Path, options)
 	if err != nil {
@@ -77,6 +315,66 @@ func (n *Node) FindChildren(tests []*PV, options *Options) (result []*PV, err er
 	return
 }
 
+// Walk traverses the node and its descendants depth-first, calling fn with the path and the node
+// of each of them, starting with the node itself at the empty path. The children of a map are
+// visited in the bytewise order of their keys, or the preserved order, and the children of an array in order.
+// If fn returns false, the children of the node are skipped, and if it returns an error,
+// Walk stops and returns the error. Containers are decoded lazily when they are descended.
+func (n *Node) Walk(fn func(path Path, n *Node) (descend bool, err error)) error {
+	return n.walk(Path{}, fn)
+}
+
+func (n *Node) walk(path Path, fn func(path Path, n *Node) (bool, error)) error {
+	if n == nil {
+		n = NewNode(nil)
+	}
+
+	descend, err := fn(path, n)
+	if err != nil || !descend {
+		return err
+	}
+
+	if _, err = n.intoContainer(); err != nil {
+		if err == ErrInvalid {
+			return nil
+		}
+		return fmt.Errorf("unable to walk node at %s, %w", path, err)
+	}
+
+	switch n.which {
+	case eDoc:
+		for _, k := range n.doc.orderedKeys() {
+			if err = n.doc.obj[k].walk(path.WithKey(k), fn); err != nil {
+				return err
+			}
+		}
+	case eAry:
+		for i, v := range n.ary {
+			if err = v.walk(path.withIndex(i), fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// orderedKeys returns the keys of the map in the order they are encoded,
+// the preserved order if any, see Options.PreserveKeyOrder, otherwise bytewise order.
+func (d *partialDoc) orderedKeys() []RawKey {
+	if d.keys != nil {
+		keys := make([]RawKey, len(d.keys))
+		copy(keys, d.keys)
+		return keys
+	}
+
+	keys := make([]RawKey, 0, len(d.obj))
+	for k := range d.obj {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
+
 // PV represents a node with a path and a raw encoded CBOR value.
 type PV struct {
 	Path  Path       `cbor:"3,keyasint,omitempty"`
@@ -95,9 +393,20 @@ func findChildNodes(
 	node, value *Node, parentpath Path, subpath Path, options *Options,
 ) (res []*nodePV, err error) {
 
-	node.intoContainer()
+	if options.MaxNestingDepth > 0 && len(parentpath) > options.MaxNestingDepth {
+		return nil, fmt.Errorf("unable to find children at %s, nested deeper than %d, %w",
+			parentpath, options.MaxNestingDepth, ErrTooDeep)
+	}
+
+	if err = options.ctxErr(); err != nil {
+		return nil, err
+	}
+
+	if _, err = node.intoContainer(); errors.Is(err, ErrTooDeep) {
+		return nil, err
+	}
 	if node.which == eOther {
-		return
+		return nil, nil
 	}
 
 	if assertObject(node, subpath, value, options) {
@@ -154,7 +463,7 @@ func assertObject(node *Node, subpath Path, value *Node, options *Options) bool
 			if next == nil {
 				return value.isNull()
 			}
-			return next.Equal(value)
+			return next.equal(value, &options.Equal)
 		}
 
 		if next == nil {
@@ -168,3 +477,141 @@ func assertObject(node *Node, subpath Path, value *Node, options *Options) bool
 	}
 	return false
 }
+
+// GetValueAs returns the value of a given path in a raw encoded CBOR document, decoded as a T value.
+func GetValueAs[T any](doc []byte, path Path) (T, error) {
+	return GetNodeValueAs[T](NewNode(doc), path, nil)
+}
+
+// GetNodeValueAs returns the value of a given path in the node, decoded as a T value
+// with options.DecMode if it is set, or the decoding mode of the node, see Node.Unmarshal.
+// It returns a *ValueTypeError if the value can not be decoded as T, or if it is null or
+// undefined and T is not a pointer or interface type.
+func GetNodeValueAs[T any](n *Node, path Path, options *Options) (T, error) {
+	var v T
+
+	data, err := n.GetValue(path, options)
+	if err != nil {
+		return v, err
+	}
+
+	if IsNull(data) || IsUndefined(data) {
+		ty := reflect.TypeOf(&v).Elem()
+		if k := ty.Kind(); k != reflect.Pointer && k != reflect.Interface {
+			return v, &ValueTypeError{Path: path, Type: ty, Value: data, err: errNullValue}
+		}
+	}
+
+	c := newCodec(options)
+	if c == nil {
+		c = n.codec
+	}
+	if err = c.Unmarshal(data, &v); err != nil {
+		return v, &ValueTypeError{Path: path, Type: reflect.TypeOf(&v).Elem(), Value: data, err: err}
+	}
+	return v, nil
+}
+
+// Unmarshal decodes the value at path in the node into v, with the decoding mode of the node,
+// which is Options.DecMode of the patches applied to the node, or the global one set by SetCBOR.
+// It returns a *ValueTypeError if the value can not be decoded into v.
+func (n *Node) Unmarshal(path Path, v any) error {
+	data, err := n.GetValue(path, nil)
+	if err != nil {
+		return err
+	}
+	return n.unmarshal(path, data, v)
+}
+
+// UnmarshalRoot decodes the whole node into v, see Unmarshal.
+func (n *Node) UnmarshalRoot(v any) error {
+	data, err := n.MarshalCBOR()
+	if err != nil {
+		return err
+	}
+	return n.unmarshal(Path{}, data, v)
+}
+
+func (n *Node) unmarshal(path Path, data []byte, v any) error {
+	if err := n.codec.Unmarshal(data, v); err != nil {
+		ty := reflect.TypeOf(v)
+		if ty != nil && ty.Kind() == reflect.Ptr {
+			ty = ty.Elem()
+		}
+		return &ValueTypeError{Path: path, Type: ty, Value: data, err: err}
+	}
+	return nil
+}
+
+// GetString returns the text string at path in the node, see GetNodeValueAs.
+func (n *Node) GetString(path Path) (string, error) {
+	return GetNodeValueAs[string](n, path, nil)
+}
+
+// GetInt returns the integer at path in the node, see GetNodeValueAs.
+// It returns a *ValueTypeError if the value is not an integer or overflows int64.
+func (n *Node) GetInt(path Path) (int64, error) {
+	return GetNodeValueAs[int64](n, path, nil)
+}
+
+// GetUint returns the unsigned integer at path in the node, see GetNodeValueAs.
+func (n *Node) GetUint(path Path) (uint64, error) {
+	return GetNodeValueAs[uint64](n, path, nil)
+}
+
+// GetFloat returns the number at path in the node as float64, integers are converted, see GetNodeValueAs.
+func (n *Node) GetFloat(path Path) (float64, error) {
+	return GetNodeValueAs[float64](n, path, nil)
+}
+
+// GetBool returns the boolean at path in the node, see GetNodeValueAs.
+func (n *Node) GetBool(path Path) (bool, error) {
+	return GetNodeValueAs[bool](n, path, nil)
+}
+
+// GetBytes returns the byte string at path in the node, see GetNodeValueAs.
+func (n *Node) GetBytes(path Path) ([]byte, error) {
+	return GetNodeValueAs[[]byte](n, path, nil)
+}
+
+// GetSimple returns the simple value at path in the node, false, true, null and undefined are
+// the simple values 20 to 23, see ReadSimpleValue.
+// It returns a *ValueTypeError if the value is not a simple value.
+func (n *Node) GetSimple(path Path) (SimpleValue, error) {
+	data, err := n.GetValue(path, nil)
+	if err != nil {
+		return 0, err
+	}
+	if v, ok := ReadSimpleValue(data); ok {
+		return v, nil
+	}
+	return 0, &ValueTypeError{Path: path, Type: reflect.TypeOf(SimpleValue(0)), Value: data, err: errNotSimpleValue}
+}
+
+var (
+	errNotSimpleValue = errors.New("not a simple value")
+	errNullValue      = errors.New("null or undefined value")
+)
+
+// ValueTypeError is an error type returned when a value can not be decoded as the requested Go type.
+type ValueTypeError struct {
+	Path  Path
+	Type  reflect.Type
+	Value RawMessage
+	err   error
+}
+
+// Error implements the error interface.
+func (e *ValueTypeError) Error() string {
+	value := Diagify(e.Value)
+	if len(value) > 64 {
+		value = value[:61] + "..."
+	}
+	return fmt.Sprintf("unable to decode %s value %s at path %s as %s, %v",
+		ReadCBORType(e.Value), value, e.Path, e.Type, e.err)
+}
+
+// Unwrap returns the underlying decoding error.
+func (e *ValueTypeError) Unwrap() error {
+	return e.err
+}
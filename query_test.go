@@ -4,6 +4,8 @@
 package cborpatch
 
 import (
+	"errors"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -391,3 +393,362 @@ func TestFindChildren(t *testing.T) {
 		}
 	}
 }
+
+func TestConcurrentReadsAfterMaterialize(t *testing.T) {
+	assert := assert.New(t)
+
+	node := NewNode(MustFromJSON(`{"a": [{"b": 1}, {"b": 2}], "c": {"d": [true, null]}}`))
+	assert.NoError(node.Materialize())
+	other := NewNode(MustFromJSON(`{"c": {"d": [true, null]}, "a": [{"b": 1}, {"b": 2}]}`))
+	assert.NoError(other.Materialize())
+
+	tests := PVs{{PathMustFromJSON("/b"), MustMarshal(2)}}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := node.GetValue(PathMustFromJSON("/c/d/0"), nil)
+			assert.NoError(err)
+			assert.Equal(MustMarshal(true), []byte(v))
+
+			res, err := node.FindChildren(tests, nil)
+			assert.NoError(err)
+			assert.Equal(1, len(res))
+
+			assert.True(node.Equal(other))
+			_, err = node.MarshalCBOR()
+			assert.NoError(err)
+		}()
+	}
+	wg.Wait()
+
+	assert.Error(NewNode([]byte{0xa1, 0x01}).Materialize())
+	assert.NoError(NewNode(nil).Materialize())
+}
+
+func TestGetValueAs(t *testing.T) {
+	assert := assert.New(t)
+
+	doc := MustFromJSON(`{"name": "John", "age": 24, "tags": ["a", "b"], "meta": {"x": 1.5}}`)
+
+	name, err := GetValueAs[string](doc, PathMustFrom("name"))
+	assert.NoError(err)
+	assert.Equal("John", name)
+
+	age, err := GetValueAs[uint8](doc, PathMustFrom("age"))
+	assert.NoError(err)
+	assert.Equal(uint8(24), age)
+
+	tags, err := GetValueAs[[]string](doc, PathMustFrom("tags"))
+	assert.NoError(err)
+	assert.Equal([]string{"a", "b"}, tags)
+
+	meta, err := GetNodeValueAs[map[string]float64](NewNode(doc), PathMustFrom("meta"), nil)
+	assert.NoError(err)
+	assert.Equal(map[string]float64{"x": 1.5}, meta)
+
+	_, err = GetValueAs[int](doc, PathMustFrom("name"))
+	var te *ValueTypeError
+	assert.ErrorAs(err, &te)
+	assert.Equal(PathMustFrom("name"), te.Path)
+	assert.Equal("int", te.Type.String())
+	assert.ErrorContains(err, `unable to decode UTF-8 text string value "John" at path ["name"] as int`)
+
+	_, err = GetValueAs[[]int](doc, PathMustFrom("tags"))
+	assert.ErrorContains(err, `unable to decode array value ["a", "b"] at path ["tags"] as []int`)
+
+	_, err = GetValueAs[string](doc, PathMustFrom("missing"))
+	assert.ErrorContains(err, "missing value")
+
+	doc = MustMarshal(map[string]any{"n": nil, "u": RawMessage{0xf7}})
+	for _, key := range []string{"n", "u"} {
+		_, err = GetValueAs[int](doc, PathMustFrom(key))
+		assert.ErrorAs(err, &te)
+		assert.Equal("int", te.Type.String())
+		_, err = GetValueAs[map[string]any](doc, PathMustFrom(key))
+		assert.ErrorAs(err, &te)
+		_, err = GetValueAs[struct{}](doc, PathMustFrom(key))
+		assert.ErrorAs(err, &te)
+
+		p, err := GetValueAs[*int](doc, PathMustFrom(key))
+		assert.NoError(err)
+		assert.Nil(p)
+		_, err = GetValueAs[any](doc, PathMustFrom(key))
+		assert.NoError(err)
+	}
+	_, err = GetValueAs[string](doc, PathMustFrom("n"))
+	assert.ErrorContains(err, `unable to decode primitives value null at path ["n"] as string, null or undefined value`)
+}
+
+func TestNodeWalk(t *testing.T) {
+	assert := assert.New(t)
+
+	node := NewNode(MustFromJSON(`{ "b": [1, { "c": null }], "a": "x", "s": { "secret": 1 } }`))
+	var visited []string
+	err := node.Walk(func(path Path, n *Node) (bool, error) {
+		visited = append(visited, path.String()+"="+n.String())
+		return path.String() != PathMustFromJSON("/s").String(), nil
+	})
+	assert.NoError(err)
+	assert.Equal([]string{
+		`[]={"a": "x", "b": [1, {"c": null}], "s": {"secret": 1}}`,
+		`["a"]="x"`,
+		`["b"]=[1, {"c": null}]`,
+		`["b", 0]=1`,
+		`["b", 1]={"c": null}`,
+		`["b", 1, "c"]=null`,
+		`["s"]={"secret": 1}`,
+	}, visited)
+
+	errStop := errors.New("stop")
+	count := 0
+	err = node.Walk(func(path Path, n *Node) (bool, error) {
+		count++
+		if len(path) == 2 {
+			return false, errStop
+		}
+		return true, nil
+	})
+	assert.ErrorIs(err, errStop)
+	assert.Equal(4, count)
+
+	count = 0
+	assert.NoError(NewNode(MustMarshal(1)).Walk(func(path Path, n *Node) (bool, error) {
+		count++
+		return true, nil
+	}))
+	assert.Equal(1, count)
+}
+
+func TestNodeTypedGetters(t *testing.T) {
+	assert := assert.New(t)
+
+	node := NewNode(MustMarshal(map[string]any{
+		"user":  map[string]any{"name": "Alice", "age": 30, "admin": true, "score": 9.5, "key": []byte{1, 2}},
+		"delta": -3,
+	}))
+
+	s, err := node.GetString(PathMustFrom("user", "name"))
+	assert.NoError(err)
+	assert.Equal("Alice", s)
+
+	i, err := node.GetInt(PathMustFrom("delta"))
+	assert.NoError(err)
+	assert.Equal(int64(-3), i)
+
+	u, err := node.GetUint(PathMustFrom("user", "age"))
+	assert.NoError(err)
+	assert.Equal(uint64(30), u)
+
+	f, err := node.GetFloat(PathMustFrom("user", "score"))
+	assert.NoError(err)
+	assert.Equal(9.5, f)
+	f, err = node.GetFloat(PathMustFrom("user", "age"))
+	assert.NoError(err)
+	assert.Equal(30.0, f)
+
+	b, err := node.GetBool(PathMustFrom("user", "admin"))
+	assert.NoError(err)
+	assert.True(b)
+
+	data, err := node.GetBytes(PathMustFrom("user", "key"))
+	assert.NoError(err)
+	assert.Equal([]byte{1, 2}, data)
+
+	_, err = node.GetString(PathMustFrom("user", "age"))
+	var te *ValueTypeError
+	assert.ErrorAs(err, &te)
+	assert.ErrorContains(err, `unable to decode positive integer value 30 at path ["user", "age"] as string`)
+
+	_, err = node.GetInt(PathMustFrom("user", "score"))
+	assert.ErrorAs(err, &te)
+	_, err = node.GetUint(PathMustFrom("delta"))
+	assert.ErrorAs(err, &te)
+	_, err = node.GetBool(PathMustFrom("user", "missing"))
+	assert.ErrorIs(err, ErrMissing)
+
+	node = NewNode(MustMarshal(map[string]any{"n": nil, "u": RawMessage{0xf7}}))
+	for _, key := range []string{"n", "u"} {
+		path := PathMustFrom(key)
+		_, err = node.GetString(path)
+		assert.ErrorAs(err, &te)
+		_, err = node.GetInt(path)
+		assert.ErrorAs(err, &te)
+		_, err = node.GetUint(path)
+		assert.ErrorAs(err, &te)
+		_, err = node.GetFloat(path)
+		assert.ErrorAs(err, &te)
+		_, err = node.GetBool(path)
+		assert.ErrorAs(err, &te)
+		_, err = node.GetBytes(path)
+		assert.ErrorAs(err, &te)
+		assert.Equal(path, te.Path)
+		assert.Equal("[]uint8", te.Type.String())
+	}
+}
+
+func TestNodeUnmarshal(t *testing.T) {
+	assert := assert.New(t)
+
+	type user struct {
+		Name string `cbor:"name"`
+		Age  int    `cbor:"age"`
+	}
+
+	node := NewNode(MustFromJSON(`{ "users": [{ "name": "Alice", "age": 30 }], "total": 1 }`))
+	var u user
+	assert.NoError(node.Unmarshal(PathMustFrom("users", 0), &u))
+	assert.Equal(user{Name: "Alice", Age: 30}, u)
+
+	var doc struct {
+		Users []user `cbor:"users"`
+		Total int    `cbor:"total"`
+	}
+	assert.NoError(node.UnmarshalRoot(&doc))
+	assert.Equal(1, doc.Total)
+	assert.Equal([]user{u}, doc.Users)
+
+	var te *ValueTypeError
+	err := node.Unmarshal(PathMustFrom("total"), &u)
+	assert.ErrorAs(err, &te)
+	assert.Equal("cborpatch.user", te.Type.String())
+	assert.ErrorIs(node.Unmarshal(PathMustFrom("missing"), &u), ErrMissing)
+	assert.ErrorAs(node.UnmarshalRoot(u), &te)
+}
+
+func TestNodeExistsAndResolvePath(t *testing.T) {
+	assert := assert.New(t)
+
+	node := NewNode(MustFromJSON(`{ "a": [1, [2, 3]], "b": null, "-": 1 }`))
+	options := NewOptions()
+	options.SupportNegativeIndices = false
+	for _, c := range []struct {
+		path    string
+		exists  bool
+		options *Options
+	}{
+		{"", true, nil},
+		{"/a/1/0", true, nil},
+		{"/b", true, nil},
+		{"/-", true, nil},
+		{"/a/-", false, nil},
+		{"/a/1/-", false, nil},
+		{"/a/-/0", false, nil},
+		{"/a/-1", true, nil},
+		{"/a/-2", true, nil},
+		{"/a/-3", false, nil},
+		{"/a/-1", false, options},
+		{"/a/0", true, options},
+		{"/a/2", false, nil},
+		{"/c", false, nil},
+		{"/b/c", false, nil},
+		{"/c/d", false, nil},
+		{"/a/1/0/x", false, nil},
+	} {
+		assert.Equal(c.exists, node.Exists(PathMustFromJSON(c.path), c.options), c.path)
+	}
+	assert.True(NewNode(MustMarshal(1)).Exists(Path{}, nil))
+	var nilNode *Node
+	assert.False(nilNode.Exists(Path{}, nil))
+
+	for _, c := range []struct{ path, resolved string }{
+		{"/a/-1/-", "/a/1/2"},
+		{"/a/-2", "/a/0"},
+		{"/a/-", "/a/2"},
+		{"/a/1/5", "/a/1/5"},
+		{"/c", "/c"},
+		{"", ""},
+	} {
+		path := PathMustFromJSON(c.path)
+		res, err := node.ResolvePath(path, nil)
+		assert.NoError(err, c.path)
+		assert.Equal(PathMustFromJSON(c.resolved), res, c.path)
+	}
+
+	_, err := node.ResolvePath(PathMustFromJSON("/a/-3"), nil)
+	assert.ErrorIs(err, ErrInvalidIndex)
+	_, err = node.ResolvePath(PathMustFromJSON("/a/-1"), options)
+	assert.ErrorIs(err, ErrInvalidIndex)
+	_, err = node.ResolvePath(PathMustFromJSON("/c/d"), nil)
+	assert.ErrorIs(err, ErrMissing)
+	_, err = node.ResolvePath(PathMustFromJSON("/a/-/0"), nil)
+	assert.ErrorIs(err, ErrInvalidIndex)
+	_, err = node.ResolvePath(PathMustFromJSON("/b/c"), nil)
+	assert.ErrorIs(err, ErrMissing)
+}
+
+func TestNodeIntrospection(t *testing.T) {
+	assert := assert.New(t)
+
+	node := NewNode(MustFromJSON(`{ "b": [1, 2, 3], "a": "x" }`))
+	assert.Equal(CBORTypeMap, node.Kind())
+	assert.Equal(2, node.Len())
+	assert.Equal([]RawKey{RawKey(MustMarshal("a")), RawKey(MustMarshal("b"))}, node.MapKeys())
+
+	child, err := node.GetChild(PathMustFrom("b"), nil)
+	assert.NoError(err)
+	assert.Equal(CBORTypeArray, child.Kind())
+	assert.Equal(3, child.Len())
+	assert.Nil(child.MapKeys())
+
+	child, err = node.GetChild(PathMustFrom("a"), nil)
+	assert.NoError(err)
+	assert.Equal(CBORTypeTextString, child.Kind())
+	assert.Equal(0, child.Len())
+	assert.Nil(child.MapKeys())
+
+	var nilNode *Node
+	assert.Equal(CBORTypePrimitives, nilNode.Kind())
+	assert.Equal(0, nilNode.Len())
+	assert.Equal(CBORTypeNegativeInt, NewNode(MustMarshal(-1)).Kind())
+	assert.Equal(0, NewNode(MustFromJSON(`{}`)).Len())
+	assert.Equal([]RawKey{}, NewNode(MustFromJSON(`{}`)).MapKeys())
+}
+
+func TestNodeGetValues(t *testing.T) {
+	assert := assert.New(t)
+
+	node := NewNode(MustFromJSON(`{"users": [{"id": 1, "name": "a"}, {"id": 2, "name": "b", "friends": [{"id": 3}]}], "id": 0}`))
+	pvsToJSON := func(pvs []*PV) []string {
+		rt := make([]string, 0, len(pvs))
+		for _, pv := range pvs {
+			rt = append(rt, PathToJSON(pv.Path)+"="+MustToJSON(pv.Value))
+		}
+		return rt
+	}
+
+	pvs, err := node.GetValues(PathMustFrom("users", "*", "name"), nil)
+	assert.NoError(err)
+	assert.Equal([]string{`/users/0/name="a"`, `/users/1/name="b"`}, pvsToJSON(pvs))
+
+	pvs, err = node.GetValues(PathMustFrom("**", "id"), nil)
+	assert.NoError(err)
+	assert.Equal([]string{`/id=0`, `/users/0/id=1`, `/users/1/id=2`, `/users/1/friends/0/id=3`}, pvsToJSON(pvs))
+
+	pvs, err = node.GetValues(PathMustFrom("**", "*", "id"), nil)
+	assert.NoError(err)
+	assert.Equal([]string{`/users/0/id=1`, `/users/1/id=2`, `/users/1/friends/0/id=3`}, pvsToJSON(pvs))
+
+	pvs, err = node.GetValues(PathMustFrom("users", -1, "id"), nil)
+	assert.NoError(err)
+	assert.Equal([]string{`/users/1/id=2`}, pvsToJSON(pvs))
+
+	options := NewOptions()
+	options.SupportNegativeIndices = false
+	pvs, err = node.GetValues(PathMustFrom("users", -1, "id"), options)
+	assert.NoError(err)
+	assert.Empty(pvs)
+
+	pvs, err = node.GetValues(PathMustFrom("*", "*", "x"), nil)
+	assert.NoError(err)
+	assert.Empty(pvs)
+
+	pvs, err = node.GetValues(Path{}, nil)
+	assert.NoError(err)
+	assert.Len(pvs, 1)
+	assert.Equal(Path{}, pvs[0].Path)
+	data, err := node.MarshalCBOR()
+	assert.NoError(err)
+	assert.True(Equal(data, pvs[0].Value))
+}
@@ -0,0 +1,109 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrQueueClosed is returned when submitting to a closed PatchQueue.
+var ErrQueueClosed = errors.New("patch queue closed")
+
+// PatchQueue holds a Node and applies patches submitted from multiple goroutines
+// strictly in arrival order with a single writer goroutine.
+// Each patch is applied atomically: the document is unchanged if the patch fails to apply.
+// It is safe for concurrent use.
+type PatchQueue struct {
+	mu      sync.RWMutex
+	closed  bool
+	node    *Node
+	options *Options
+	jobs    chan *queueJob
+	done    chan struct{}
+}
+
+type queueJob struct {
+	patch  Patch
+	read   func(node *Node) error
+	result chan error
+}
+
+// NewPatchQueue returns a PatchQueue holding a Node of the given document,
+// buffering up to size pending patches before Submit blocks.
+// The options is used to apply patches, NewOptions() is used if it is nil.
+func NewPatchQueue(doc []byte, size int, options *Options) *PatchQueue {
+	if options == nil {
+		options = NewOptions()
+	}
+
+	q := &PatchQueue{
+		node:    NewNode(doc),
+		options: options,
+		jobs:    make(chan *queueJob, size),
+		done:    make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+// Submit enqueues the patch, and returns a channel that receives the result of applying it.
+func (q *PatchQueue) Submit(p Patch) <-chan error {
+	return q.enqueue(&queueJob{patch: p, result: make(chan error, 1)})
+}
+
+// Apply enqueues the patch and waits for the result of applying it.
+func (q *PatchQueue) Apply(p Patch) error {
+	return <-q.Submit(p)
+}
+
+// Document returns the document after all patches submitted before it are applied.
+func (q *PatchQueue) Document() ([]byte, error) {
+	var doc []byte
+	err := <-q.enqueue(&queueJob{read: func(node *Node) (err error) {
+		doc, err = node.MarshalCBOR()
+		return
+	}, result: make(chan error, 1)})
+	return doc, err
+}
+
+// Close stops accepting patches, and waits for the pending patches to be applied.
+func (q *PatchQueue) Close() {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.jobs)
+	}
+	q.mu.Unlock()
+	<-q.done
+}
+
+func (q *PatchQueue) enqueue(job *queueJob) <-chan error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.closed {
+		job.result <- ErrQueueClosed
+	} else {
+		q.jobs <- job
+	}
+	return job.result
+}
+
+func (q *PatchQueue) run() {
+	defer close(q.done)
+
+	for job := range q.jobs {
+		if job.read != nil {
+			job.result <- job.read(q.node)
+			continue
+		}
+
+		node, err := q.node.Patched(job.patch, q.options)
+		if err == nil {
+			q.node = node
+		}
+		job.result <- err
+	}
+}
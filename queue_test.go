@@ -0,0 +1,63 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPatchQueue(t *testing.T) {
+	assert := assert.New(t)
+
+	q := NewPatchQueue(MustFromJSON(`{"list": []}`), 4, nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 25; j++ {
+				p := Patch{{Op: OpAdd, Path: PathMustFrom("list", "-"), Value: MustMarshal(i)}}
+				assert.NoError(q.Apply(p))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	doc, err := q.Document()
+	assert.NoError(err)
+	var v struct {
+		List []int `cbor:"list"`
+	}
+	assert.NoError(cborUnmarshal(doc, &v))
+	assert.Equal(200, len(v.List))
+
+	// patches from the same goroutine are applied in order
+	results := []<-chan error{
+		q.Submit(Patch{{Op: OpReplace, Path: PathMustFrom("list"), Value: MustFromJSON(`[]`)}}),
+		q.Submit(Patch{{Op: OpAdd, Path: PathMustFrom("list", "-"), Value: MustMarshal(1)}}),
+		q.Submit(Patch{
+			{Op: OpAdd, Path: PathMustFrom("list", "-"), Value: MustMarshal(2)},
+			{Op: OpTest, Path: PathMustFrom("list", 0), Value: MustMarshal(2)},
+		}),
+		q.Submit(Patch{{Op: OpAdd, Path: PathMustFrom("list", "-"), Value: MustMarshal(3)}}),
+	}
+	assert.NoError(<-results[0])
+	assert.NoError(<-results[1])
+	assert.ErrorIs(<-results[2], ErrTestFailed)
+	assert.NoError(<-results[3])
+
+	doc, err = q.Document()
+	assert.NoError(err)
+	assert.Equal(`{"list":[1,3]}`, MustToJSON(doc))
+
+	q.Close()
+	q.Close()
+	assert.ErrorIs(q.Apply(Patch{}), ErrQueueClosed)
+	_, err = q.Document()
+	assert.ErrorIs(err, ErrQueueClosed)
+}
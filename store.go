@@ -0,0 +1,153 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrVersionConflict is returned by DocStore.Put when the stored version does not match.
+var ErrVersionConflict = errors.New("version conflict")
+
+// DocStore is a key-value store of versioned CBOR documents.
+type DocStore interface {
+	// Get returns the document and its version.
+	// It returns an error that matches ErrMissing if the document does not exist.
+	Get(key string) (doc []byte, version uint64, err error)
+	// Put stores the document if its current version equals version, and returns the new version.
+	// Version 0 means that the document must not exist.
+	// It returns an error that matches ErrVersionConflict if the version does not match.
+	Put(key string, doc []byte, version uint64) (newVersion uint64, err error)
+}
+
+// DefaultStoreMaxRetries is the default number of retries on version conflicts for ApplyToStore.
+const DefaultStoreMaxRetries = 10
+
+// StoreOptions specifies options for ApplyToStore.
+type StoreOptions struct {
+	// Options is used to apply patches, NewOptions() is used if it is nil.
+	Options *Options
+	// MaxRetries is the max number of retries on version conflicts.
+	// DefaultStoreMaxRetries is used if it is zero, negative means no retry.
+	MaxRetries int
+	// Backoff returns the delay before the given retry attempt (starts from 1). No delay if it is nil.
+	Backoff func(attempt int) time.Duration
+	// CreateIfMissing applies the patch to a CBOR null document if the key does not exist.
+	// Note that the patch root must be a map or array to apply, so a patch should replace the root first.
+	CreateIfMissing bool
+}
+
+// ApplyToStore reads the document by key from the store, applies the patch to it,
+// and writes it back with a compare-and-swap on the version.
+// It re-reads and re-applies the patch on version conflicts, up to StoreOptions.MaxRetries times.
+// Errors from applying the patch are returned immediately without retry.
+// It returns the new document and its version.
+func ApplyToStore(store DocStore, key string, patch Patch, opts *StoreOptions) ([]byte, uint64, error) {
+	if opts == nil {
+		opts = &StoreOptions{}
+	}
+	if err := patch.Valid(); err != nil {
+		return nil, 0, err
+	}
+
+	options := opts.Options
+	if options == nil {
+		options = NewOptions()
+	}
+
+	retries := opts.MaxRetries
+	if retries == 0 {
+		retries = DefaultStoreMaxRetries
+	}
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && opts.Backoff != nil {
+			time.Sleep(opts.Backoff(attempt))
+		}
+
+		doc, version, err := store.Get(key)
+		switch {
+		case err == nil:
+		case errors.Is(err, ErrMissing) && opts.CreateIfMissing:
+			doc, version = nil, 0
+		default:
+			return nil, 0, err
+		}
+
+		if doc, err = applyToNullable(doc, patch, options); err != nil {
+			return nil, 0, err
+		}
+
+		newVersion, err := store.Put(key, doc, version)
+		switch {
+		case err == nil:
+			return doc, newVersion, nil
+		case !errors.Is(err, ErrVersionConflict):
+			return nil, 0, err
+		case attempt >= retries:
+			return nil, 0, fmt.Errorf("unable to apply patch to %q after %d attempts, %w", key, attempt+1, err)
+		}
+	}
+}
+
+// applyToNullable applies the patch to the doc, a nil doc can only be replaced at the root.
+func applyToNullable(doc []byte, patch Patch, options *Options) ([]byte, error) {
+	if doc == nil && len(patch) > 0 && patch[0].Op == OpReplace && len(patch[0].Path) == 0 {
+		doc = patch[0].Value
+		patch = patch[1:]
+	}
+	return patch.ApplyWithOptions(doc, options)
+}
+
+// MemoryStore is an in-memory DocStore. It is safe for concurrent use.
+// A DocStore on a bbolt database is in the separate module github.com/ldclabs/cbor-patch/store/bbolt.
+type MemoryStore struct {
+	mu   sync.RWMutex
+	docs map[string]*memoryDoc
+}
+
+type memoryDoc struct {
+	doc     []byte
+	version uint64
+}
+
+var _ DocStore = (*MemoryStore)(nil)
+
+// NewMemoryStore returns a new MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{docs: make(map[string]*memoryDoc)}
+}
+
+// Get implements the DocStore interface.
+func (s *MemoryStore) Get(key string) ([]byte, uint64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	d, ok := s.docs[key]
+	if !ok {
+		return nil, 0, fmt.Errorf("unable to get nonexistent document %q, %w", key, ErrMissing)
+	}
+	return copyBytes(d.doc), d.version, nil
+}
+
+// Put implements the DocStore interface.
+func (s *MemoryStore) Put(key string, doc []byte, version uint64) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var cur uint64
+	if d, ok := s.docs[key]; ok {
+		cur = d.version
+	}
+	if cur != version {
+		return 0, fmt.Errorf("unable to put document %q with version %d, current version %d, %w",
+			key, version, cur, ErrVersionConflict)
+	}
+
+	s.docs[key] = &memoryDoc{doc: copyBytes(doc), version: cur + 1}
+	return cur + 1, nil
+}
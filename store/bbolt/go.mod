@@ -0,0 +1,23 @@
+module github.com/ldclabs/cbor-patch/store/bbolt
+
+go 1.18
+
+require (
+	github.com/ldclabs/cbor-patch v0.0.0
+	github.com/stretchr/testify v1.8.1
+	go.etcd.io/bbolt v1.3.7
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/fxamacker/cbor/v2 v2.5.0-beta // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
+	golang.org/x/sys v0.4.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+replace (
+	github.com/fxamacker/cbor/v2 v2.5.0-beta => github.com/ldclabs/cbor/v2 v2.5.0-stg3
+	github.com/ldclabs/cbor-patch => ../../
+)
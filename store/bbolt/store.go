@@ -0,0 +1,115 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+// Package bbolt implements cborpatch.DocStore on a bbolt database.
+// It is a separate module, so that the cborpatch module does not depend on bbolt.
+package bbolt
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	cborpatch "github.com/ldclabs/cbor-patch"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Store is a cborpatch.DocStore that keeps the documents in a bucket of a bbolt database.
+// A document is stored with its version in the first 8 bytes in big-endian.
+// It is safe for concurrent use, and the versions are checked and updated in a bbolt transaction.
+type Store struct {
+	db     *bolt.DB
+	bucket []byte
+}
+
+var _ cborpatch.DocStore = (*Store)(nil)
+
+// New returns a Store of the documents in the bucket of the database,
+// the bucket is created if it does not exist.
+func New(db *bolt.DB, bucket string) (*Store, error) {
+	s := &Store{db: db, bucket: []byte(bucket)}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(s.bucket)
+		return err
+	}); err != nil {
+		return nil, fmt.Errorf("unable to create bucket %q, %w", bucket, err)
+	}
+	return s, nil
+}
+
+// Get implements the cborpatch.DocStore interface.
+func (s *Store) Get(key string) ([]byte, uint64, error) {
+	var doc []byte
+	var version uint64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b, err := s.getBucket(tx)
+		if err != nil {
+			return err
+		}
+
+		val := b.Get([]byte(key))
+		if val == nil {
+			return fmt.Errorf("unable to get nonexistent document %q, %w", key, cborpatch.ErrMissing)
+		}
+		if version, doc, err = decodeValue(val); err != nil {
+			return fmt.Errorf("unable to get document %q, %w", key, err)
+		}
+		// the value is only valid in the transaction.
+		doc = append([]byte(nil), doc...)
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return doc, version, nil
+}
+
+// Put implements the cborpatch.DocStore interface.
+func (s *Store) Put(key string, doc []byte, version uint64) (uint64, error) {
+	var newVersion uint64
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b, err := s.getBucket(tx)
+		if err != nil {
+			return err
+		}
+
+		var cur uint64
+		if val := b.Get([]byte(key)); val != nil {
+			if cur, _, err = decodeValue(val); err != nil {
+				return fmt.Errorf("unable to put document %q, %w", key, err)
+			}
+		}
+		if cur != version {
+			return fmt.Errorf("unable to put document %q with version %d, current version %d, %w",
+				key, version, cur, cborpatch.ErrVersionConflict)
+		}
+
+		newVersion = cur + 1
+		return b.Put([]byte(key), encodeValue(newVersion, doc))
+	})
+	if err != nil {
+		return 0, err
+	}
+	return newVersion, nil
+}
+
+func (s *Store) getBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
+	b := tx.Bucket(s.bucket)
+	if b == nil {
+		return nil, fmt.Errorf("bucket %q does not exist", s.bucket)
+	}
+	return b, nil
+}
+
+func encodeValue(version uint64, doc []byte) []byte {
+	val := make([]byte, 8+len(doc))
+	binary.BigEndian.PutUint64(val, version)
+	copy(val[8:], doc)
+	return val
+}
+
+func decodeValue(val []byte) (uint64, []byte, error) {
+	if len(val) < 8 {
+		return 0, nil, fmt.Errorf("invalid value of %d bytes, %w", len(val), cborpatch.ErrInvalid)
+	}
+	return binary.BigEndian.Uint64(val), val[8:], nil
+}
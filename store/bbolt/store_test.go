@@ -0,0 +1,105 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package bbolt
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	cborpatch "github.com/ldclabs/cbor-patch"
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+)
+
+func openStore(t *testing.T) *Store {
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "docs.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	s, err := New(db, "docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestStore(t *testing.T) {
+	assert := assert.New(t)
+
+	s := openStore(t)
+	_, _, err := s.Get("doc")
+	assert.True(errors.Is(err, cborpatch.ErrMissing))
+
+	_, err = s.Put("doc", cborpatch.MustFromJSON(`{"a": 1}`), 1)
+	assert.True(errors.Is(err, cborpatch.ErrVersionConflict))
+
+	version, err := s.Put("doc", cborpatch.MustFromJSON(`{"a": 1}`), 0)
+	assert.NoError(err)
+	assert.Equal(uint64(1), version)
+
+	doc, version, err := s.Get("doc")
+	assert.NoError(err)
+	assert.Equal(uint64(1), version)
+	assert.Equal(`{"a":1}`, cborpatch.MustToJSON(doc))
+
+	_, err = s.Put("doc", cborpatch.MustFromJSON(`{"a": 2}`), 0)
+	assert.True(errors.Is(err, cborpatch.ErrVersionConflict))
+	version, err = s.Put("doc", cborpatch.MustFromJSON(`{"a": 2}`), 1)
+	assert.NoError(err)
+	assert.Equal(uint64(2), version)
+
+	// the documents are kept in the database.
+	s2, err := New(s.db, "docs")
+	assert.NoError(err)
+	doc, version, err = s2.Get("doc")
+	assert.NoError(err)
+	assert.Equal(uint64(2), version)
+	assert.Equal(`{"a":2}`, cborpatch.MustToJSON(doc))
+
+	assert.NoError(s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(s.bucket).Put([]byte("bad"), []byte{1, 2})
+	}))
+	_, _, err = s.Get("bad")
+	assert.True(errors.Is(err, cborpatch.ErrInvalid))
+	_, err = s.Put("bad", nil, 0)
+	assert.True(errors.Is(err, cborpatch.ErrInvalid))
+}
+
+func TestApplyToStore(t *testing.T) {
+	assert := assert.New(t)
+
+	s := openStore(t)
+	create, err := cborpatch.PatchFromJSON(`[{"op": "replace", "path": "", "value": {"count": 0}}]`)
+	assert.NoError(err)
+	_, version, err := cborpatch.ApplyToStore(s, "doc", create, &cborpatch.StoreOptions{CreateIfMissing: true})
+	assert.NoError(err)
+	assert.Equal(uint64(1), version)
+
+	// concurrent writers retry on version conflicts, every patch is applied once.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			p, err := cborpatch.PatchFromJSON(fmt.Sprintf(`[{"op": "add", "path": "/k%d", "value": %d}]`, i, i))
+			if err == nil {
+				_, _, err = cborpatch.ApplyToStore(s, "doc", p, &cborpatch.StoreOptions{MaxRetries: 100})
+			}
+			assert.NoError(err)
+		}(i)
+	}
+	wg.Wait()
+
+	doc, version, err := s.Get("doc")
+	assert.NoError(err)
+	assert.Equal(uint64(11), version)
+	for i := 0; i < 10; i++ {
+		assert.True(cborpatch.NewNode(doc).Exists(cborpatch.PathMustFrom(fmt.Sprintf("k%d", i)), nil))
+	}
+}
@@ -0,0 +1,109 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// conflictStore injects version conflicts before delegating to a MemoryStore.
+type conflictStore struct {
+	*MemoryStore
+	conflicts int
+	puts      int
+}
+
+func (s *conflictStore) Put(key string, doc []byte, version uint64) (uint64, error) {
+	s.puts++
+	if s.conflicts > 0 {
+		s.conflicts--
+		// a concurrent writer wins
+		cur, v, _ := s.MemoryStore.Get(key)
+		if _, err := s.MemoryStore.Put(key, cur, v); err != nil {
+			return 0, err
+		}
+	}
+	return s.MemoryStore.Put(key, doc, version)
+}
+
+func TestApplyToStore(t *testing.T) {
+	assert := assert.New(t)
+
+	store := NewMemoryStore()
+	_, _, err := ApplyToStore(store, "doc", Patch{}, nil)
+	assert.True(errors.Is(err, ErrMissing))
+
+	create, _ := PatchFromJSON(`[{"op": "replace", "path": "", "value": {"count": 0}}]`)
+	doc, version, err := ApplyToStore(store, "doc", create, &StoreOptions{CreateIfMissing: true})
+	assert.NoError(err)
+	assert.Equal(uint64(1), version)
+	assert.Equal(`{"count":0}`, MustToJSON(doc))
+
+	_, err = store.Put("doc", doc, 0)
+	assert.True(errors.Is(err, ErrVersionConflict))
+
+	cs := &conflictStore{MemoryStore: store, conflicts: 2}
+	incr, _ := PatchFromJSON(`[{"op": "replace", "path": "/count", "value": 1}]`)
+	var delays []int
+	doc, version, err = ApplyToStore(cs, "doc", incr, &StoreOptions{
+		Backoff: func(attempt int) time.Duration {
+			delays = append(delays, attempt)
+			return 0
+		},
+	})
+	assert.NoError(err)
+	assert.Equal(uint64(4), version)
+	assert.Equal(3, cs.puts)
+	assert.Equal([]int{1, 2}, delays)
+	assert.Equal(`{"count":1}`, MustToJSON(doc))
+
+	cs = &conflictStore{MemoryStore: store, conflicts: 5}
+	_, _, err = ApplyToStore(cs, "doc", incr, &StoreOptions{MaxRetries: 2})
+	assert.True(errors.Is(err, ErrVersionConflict))
+	assert.Equal(3, cs.puts)
+
+	cs = &conflictStore{MemoryStore: store, conflicts: 1}
+	_, _, err = ApplyToStore(cs, "doc", incr, &StoreOptions{MaxRetries: -1})
+	assert.True(errors.Is(err, ErrVersionConflict))
+	assert.Equal(1, cs.puts)
+
+	test, _ := PatchFromJSON(`[{"op": "test", "path": "/count", "value": 0}]`)
+	_, _, err = ApplyToStore(store, "doc", test, nil)
+	assert.True(errors.Is(err, ErrTestFailed))
+}
+
+func TestApplyToStoreConcurrently(t *testing.T) {
+	assert := assert.New(t)
+
+	store := NewMemoryStore()
+	_, err := store.Put("doc", MustFromJSON(`{"items": []}`), 0)
+	assert.NoError(err)
+
+	add, _ := PatchFromJSON(`[{"op": "add", "path": "/items/-", "value": 1}]`)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := ApplyToStore(store, "doc", add, &StoreOptions{MaxRetries: 100})
+			assert.NoError(err)
+		}()
+	}
+	wg.Wait()
+
+	doc, version, err := store.Get("doc")
+	assert.NoError(err)
+	assert.Equal(uint64(21), version)
+
+	var res struct {
+		Items []int `cbor:"items"`
+	}
+	assert.NoError(cborUnmarshal(doc, &res))
+	assert.Equal(20, len(res.Items))
+}
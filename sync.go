@@ -0,0 +1,212 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// DefaultSyncHistorySize is the default number of patches kept by a SyncSession.
+const DefaultSyncHistorySize = 1024
+
+var (
+	ErrRevisionConflict    = errors.New("conflicting revision")
+	ErrRevisionOutOfOrder  = errors.New("out-of-order revision")
+	ErrRevisionUnavailable = errors.New("revision unavailable")
+)
+
+// SyncMessage is a patch with the revision that it produces.
+type SyncMessage struct {
+	Revision uint64 `cbor:"1,keyasint"`
+	Patch    Patch  `cbor:"2,keyasint"`
+}
+
+// SyncSession holds a document with a monotonically increasing revision,
+// and applies a stream of SyncMessages to it in order.
+// Applied messages are kept in a bounded history and emitted to subscribers for fan-out.
+// It is safe for concurrent use.
+type SyncSession struct {
+	mu       sync.RWMutex
+	node     *Node
+	rev      uint64
+	options  *Options
+	history  []*syncEntry
+	size     int
+	subs     map[int]func(*SyncMessage)
+	nextSubs int
+}
+
+type syncEntry struct {
+	msg  *SyncMessage
+	data []byte
+}
+
+// NewSyncSession returns a SyncSession with the given document at the given revision.
+// The options is used to apply patches, NewOptions() is used if it is nil.
+func NewSyncSession(doc []byte, rev uint64, options *Options) *SyncSession {
+	if options == nil {
+		options = NewOptions()
+	}
+	return &SyncSession{
+		node:    NewNode(doc),
+		rev:     rev,
+		options: options,
+		size:    DefaultSyncHistorySize,
+		subs:    make(map[int]func(*SyncMessage)),
+	}
+}
+
+// SetHistorySize sets the number of patches kept for Since.
+func (s *SyncSession) SetHistorySize(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.size = n
+	s.trimHistory()
+}
+
+// Revision returns the current revision.
+func (s *SyncSession) Revision() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.rev
+}
+
+// Document returns the current document and its revision.
+func (s *SyncSession) Document() ([]byte, uint64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	doc, err := s.node.MarshalCBOR()
+	return doc, s.rev, err
+}
+
+// Apply applies an incoming message. The message revision must be the next revision.
+// A redelivered message that equals an applied one in the history is ignored.
+// It returns ErrRevisionConflict if a different patch was applied at the revision,
+// and ErrRevisionOutOfOrder if some revisions are missing.
+// The document is unchanged if the patch fails to apply.
+func (s *SyncSession) Apply(msg *SyncMessage) error {
+	if msg == nil {
+		return errors.New("nil sync message")
+	}
+
+	data, err := cborMarshal(msg.Patch)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case msg.Revision <= s.rev:
+		if e := s.entry(msg.Revision); e != nil && bytes.Equal(e.data, data) {
+			return nil
+		}
+		return fmt.Errorf("unable to apply revision %d at revision %d, %w", msg.Revision, s.rev, ErrRevisionConflict)
+
+	case msg.Revision > s.rev+1:
+		return fmt.Errorf("unable to apply revision %d at revision %d, %w", msg.Revision, s.rev, ErrRevisionOutOfOrder)
+	}
+
+	return s.apply(msg, data)
+}
+
+// Submit applies a local patch at the next revision, and returns the message to fan-out.
+func (s *SyncSession) Submit(p Patch) (*SyncMessage, error) {
+	data, err := cborMarshal(p)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msg := &SyncMessage{Revision: s.rev + 1, Patch: p}
+	if err = s.apply(msg, data); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// Since returns the applied messages after the given revision, in order.
+// It returns ErrRevisionUnavailable if some of them are no longer in the history,
+// the caller should resynchronize with Document then.
+func (s *SyncSession) Since(rev uint64) ([]*SyncMessage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if rev >= s.rev {
+		return []*SyncMessage{}, nil
+	}
+
+	if s.entry(rev+1) == nil {
+		return nil, fmt.Errorf("unable to get messages since revision %d, %w", rev, ErrRevisionUnavailable)
+	}
+
+	start := len(s.history) - int(s.rev-rev)
+	res := make([]*SyncMessage, 0, len(s.history)-start)
+	for _, e := range s.history[start:] {
+		res = append(res, e.msg)
+	}
+	return res, nil
+}
+
+// Subscribe registers fn to be called with every applied message, in order.
+// fn is called synchronously with the session locked, so it must not call methods of the session.
+// It returns a function to unsubscribe.
+func (s *SyncSession) Subscribe(fn func(*SyncMessage)) (unsubscribe func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := s.nextSubs
+	s.nextSubs++
+	s.subs[id] = fn
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.subs, id)
+	}
+}
+
+func (s *SyncSession) apply(msg *SyncMessage, data []byte) error {
+	doc, err := s.node.MarshalCBOR()
+	if err != nil {
+		return err
+	}
+
+	node := NewNode(doc)
+	if err = node.Patch(msg.Patch, s.options); err != nil {
+		return err
+	}
+
+	s.node = node
+	s.rev = msg.Revision
+	s.history = append(s.history, &syncEntry{msg: msg, data: data})
+	s.trimHistory()
+	for _, fn := range s.subs {
+		fn(msg)
+	}
+	return nil
+}
+
+func (s *SyncSession) entry(rev uint64) *syncEntry {
+	if rev > s.rev || s.rev-rev >= uint64(len(s.history)) {
+		return nil
+	}
+	return s.history[len(s.history)-1-int(s.rev-rev)]
+}
+
+func (s *SyncSession) trimHistory() {
+	if s.size < 0 {
+		s.size = 0
+	}
+	if n := len(s.history) - s.size; n > 0 {
+		s.history = append(s.history[:0:0], s.history[n:]...)
+	}
+}
@@ -0,0 +1,83 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyncSession(t *testing.T) {
+	assert := assert.New(t)
+
+	s := NewSyncSession(MustFromJSON(`{"count": 0}`), 10, nil)
+	assert.Equal(uint64(10), s.Revision())
+
+	var fanout []*SyncMessage
+	unsubscribe := s.Subscribe(func(msg *SyncMessage) {
+		fanout = append(fanout, msg)
+	})
+
+	p1, _ := PatchFromJSON(`[{"op": "replace", "path": "/count", "value": 1}]`)
+	p2, _ := PatchFromJSON(`[{"op": "replace", "path": "/count", "value": 2}]`)
+	p3, _ := PatchFromJSON(`[{"op": "add", "path": "/name", "value": "x"}]`)
+
+	assert.NoError(s.Apply(&SyncMessage{Revision: 11, Patch: p1}))
+	assert.NoError(s.Apply(&SyncMessage{Revision: 11, Patch: p1}), "redelivery is ignored")
+
+	err := s.Apply(&SyncMessage{Revision: 11, Patch: p2})
+	assert.True(errors.Is(err, ErrRevisionConflict))
+
+	err = s.Apply(&SyncMessage{Revision: 13, Patch: p2})
+	assert.True(errors.Is(err, ErrRevisionOutOfOrder))
+
+	msg, err := s.Submit(p2)
+	assert.NoError(err)
+	assert.Equal(uint64(12), msg.Revision)
+
+	bad, _ := PatchFromJSON(`[
+		{"op": "replace", "path": "/count", "value": 3},
+		{"op": "remove", "path": "/missing"}
+	]`)
+	assert.Error(s.Apply(&SyncMessage{Revision: 13, Patch: bad}))
+
+	doc, rev, err := s.Document()
+	assert.NoError(err)
+	assert.Equal(uint64(12), rev)
+	assert.Equal(`{"count":2}`, MustToJSON(doc), "failed patch should not change the document")
+
+	unsubscribe()
+	assert.NoError(s.Apply(&SyncMessage{Revision: 13, Patch: p3}))
+	assert.Equal(2, len(fanout))
+	assert.Equal(uint64(11), fanout[0].Revision)
+	assert.Equal(uint64(12), fanout[1].Revision)
+
+	msgs, err := s.Since(11)
+	assert.NoError(err)
+	assert.Equal(2, len(msgs))
+	assert.Equal(uint64(12), msgs[0].Revision)
+	assert.Equal(uint64(13), msgs[1].Revision)
+
+	msgs, err = s.Since(13)
+	assert.NoError(err)
+	assert.Equal(0, len(msgs))
+
+	s.SetHistorySize(1)
+	_, err = s.Since(11)
+	assert.True(errors.Is(err, ErrRevisionUnavailable))
+	msgs, err = s.Since(12)
+	assert.NoError(err)
+	assert.Equal(1, len(msgs))
+
+	_, err = s.Since(9)
+	assert.True(errors.Is(err, ErrRevisionUnavailable))
+
+	err = s.Apply(&SyncMessage{Revision: 11, Patch: p1})
+	assert.True(errors.Is(err, ErrRevisionConflict), "unknown old revision")
+
+	doc, _, _ = s.Document()
+	assert.Equal(`{"count":2,"name":"x"}`, MustToJSON(doc))
+}
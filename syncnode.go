@@ -0,0 +1,85 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"fmt"
+	"sync"
+)
+
+// SyncNode is a Node wrapper that is safe for concurrent use by multiple goroutines.
+// Reads run concurrently, and patches are serialized and applied atomically:
+// readers observe either the document before or after a patch, never a partially patched one.
+type SyncNode struct {
+	mu   sync.RWMutex
+	raw  RawMessage
+	node *Node
+}
+
+// NewSyncNode returns a new SyncNode with the given raw encoded CBOR document.
+// A nil or empty raw document is equal to CBOR null.
+func NewSyncNode(doc RawMessage) (*SyncNode, error) {
+	if len(doc) > 0 {
+		if err := cborValid(doc); err != nil {
+			return nil, err
+		}
+	}
+
+	node := NewNode(doc)
+	if err := node.Materialize(); err != nil {
+		return nil, fmt.Errorf("unexpected node %s, %w", node, err)
+	}
+	return &SyncNode{raw: *node.raw, node: node}, nil
+}
+
+// String returns the document as CBOR diagnostic notation.
+func (s *SyncNode) String() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return Diagify(s.raw)
+}
+
+// MarshalCBOR implements the cbor.Marshaler interface.
+func (s *SyncNode) MarshalCBOR() ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return copyBytes(s.raw), nil
+}
+
+// GetValue returns the value of a given path in the document.
+func (s *SyncNode) GetValue(path Path, options *Options) (RawMessage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.node.GetValue(path, options)
+}
+
+// FindChildren returns the children nodes that pass the given tests in the document.
+func (s *SyncNode) FindChildren(tests []*PV, options *Options) ([]*PV, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.node.FindChildren(tests, options)
+}
+
+// Patch applies the patch to the document. The document is left unchanged if the patch fails.
+func (s *SyncNode) Patch(p Patch, options *Options) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	node := NewNode(s.raw)
+	if err := node.Patch(p, options); err != nil {
+		return err
+	}
+
+	data, err := node.MarshalCBOR()
+	if err != nil {
+		return err
+	}
+
+	node = NewNode(data)
+	if err = node.Materialize(); err != nil {
+		return err
+	}
+	s.raw, s.node = data, node
+	return nil
+}
@@ -0,0 +1,69 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyncNode(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := NewSyncNode([]byte{0xa1, 0x01})
+	assert.Error(err)
+
+	sn, err := NewSyncNode(MustFromJSON(`{"counter": 0, "items": [{"name": "a"}]}`))
+	assert.NoError(err)
+	assert.Equal(`{"items": [{"name": "a"}], "counter": 0}`, sn.String())
+
+	tests := PVs{{PathMustFromJSON("/name"), MustFromJSON(`"a"`)}}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				v, err := sn.GetValue(PathMustFromJSON("/items/0/name"), nil)
+				assert.NoError(err)
+				assert.Equal(`"a"`, MustToJSON(v))
+
+				res, err := sn.FindChildren(tests, nil)
+				assert.NoError(err)
+				assert.Equal(1, len(res))
+			}
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for j := 1; j <= 100; j++ {
+			p := Patch{
+				{Op: OpReplace, Path: PathMustFromJSON("/counter"), Value: MustMarshal(j)},
+				{Op: OpAdd, Path: PathMustFromJSON("/items/-"), Value: MustFromJSON(`{"name": "b"}`)},
+			}
+			assert.NoError(sn.Patch(p, nil))
+		}
+	}()
+	wg.Wait()
+
+	v, err := sn.GetValue(PathMustFromJSON("/counter"), nil)
+	assert.NoError(err)
+	assert.Equal(MustMarshal(100), []byte(v))
+
+	data, err := sn.MarshalCBOR()
+	assert.NoError(err)
+	p := Patch{
+		{Op: OpReplace, Path: PathMustFromJSON("/counter"), Value: MustMarshal(0)},
+		{Op: OpTest, Path: PathMustFromJSON("/counter"), Value: MustMarshal(1)},
+	}
+	assert.ErrorIs(sn.Patch(p, nil), ErrTestFailed)
+	res, err := sn.MarshalCBOR()
+	assert.NoError(err)
+	assert.Equal(data, res)
+}
@@ -0,0 +1,79 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"fmt"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+const (
+	// PatchTag is the default CBOR tag number to wrap a Patch, mnemonic for RFC 6902.
+	// It is not registered with IANA, protocols can choose another number with WrapPatch and UnwrapPatch.
+	PatchTag uint64 = 6902
+	// PlaceholderTag is the CBOR tag number of a Template placeholder, its content is the variable name.
+	// It is not registered with IANA.
+	PlaceholderTag uint64 = 6903
+	// RelativePathTag is the CBOR tag number of the first key of a relative path, mnemonic for RFC 6901,
+	// its content is the number of levels to go up, see RelativePath. It is not registered with IANA.
+	RelativePathTag uint64 = 6901
+	// SelfDescribedTag is the self-described CBOR tag number.
+	// Refer to https://www.rfc-editor.org/rfc/rfc8949.html#name-self-described-cbor.
+	SelfDescribedTag uint64 = 55799
+)
+
+// WrapPatch encodes the patch wrapped in a CBOR tag with the given tag number.
+func WrapPatch(p Patch, tag uint64) ([]byte, error) {
+	if err := p.Valid(); err != nil {
+		return nil, err
+	}
+
+	data, err := cborMarshal(p)
+	if err != nil {
+		return nil, err
+	}
+	return cborMarshal(cbor.RawTag{Number: tag, Content: data})
+}
+
+// UnwrapPatch decodes a patch wrapped in a CBOR tag with the given tag number.
+// Untagged patches are also accepted, and the self-described CBOR tag is ignored.
+func UnwrapPatch(data []byte, tag uint64) (Patch, error) {
+	data, err := untagPatch(data, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	var p Patch
+	if err = cborUnmarshal(data, &p); err != nil {
+		return nil, err
+	}
+	if err = p.Valid(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// untagPatch removes the self-described CBOR tag and the given tag from the data.
+func untagPatch(data []byte, tag uint64) ([]byte, error) {
+	for ReadCBORType(data) == CBORTypeTag {
+		var rt cbor.RawTag
+		if err := cborUnmarshal(data, &rt); err != nil {
+			return nil, err
+		}
+
+		switch rt.Number {
+		case SelfDescribedTag:
+			data = rt.Content
+		case tag:
+			if ReadCBORType(rt.Content) != CBORTypeArray {
+				return nil, fmt.Errorf("unexpected content %s in patch tag %d", Diagify(rt.Content), tag)
+			}
+			return rt.Content, nil
+		default:
+			return nil, fmt.Errorf("unexpected tag %d for patch, expected %d", rt.Number, tag)
+		}
+	}
+	return data, nil
+}
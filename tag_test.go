@@ -0,0 +1,54 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWrapPatch(t *testing.T) {
+	assert := assert.New(t)
+
+	p, err := PatchFromJSON(`[{"op": "add", "path": "/a", "value": 1}]`)
+	assert.NoError(err)
+
+	data, err := WrapPatch(p, PatchTag)
+	assert.NoError(err)
+	assert.Equal(`6902([{1: 1, 3: ["a"], 4: 1}])`, Diagify(data))
+
+	res, err := NewPatch(data)
+	assert.NoError(err)
+	assert.Equal(p, res)
+
+	res, err = UnwrapPatch(data, PatchTag)
+	assert.NoError(err)
+	assert.Equal(p, res)
+
+	res, err = UnwrapPatch(MustMarshal(p), PatchTag)
+	assert.NoError(err)
+	assert.Equal(p, res)
+
+	selfDescribed := MustMarshal(cbor.RawTag{Number: SelfDescribedTag, Content: data})
+	res, err = NewPatch(selfDescribed)
+	assert.NoError(err)
+	assert.Equal(p, res)
+
+	data, err = WrapPatch(p, 1000)
+	assert.NoError(err)
+	_, err = NewPatch(data)
+	assert.ErrorContains(err, "unexpected tag 1000 for patch")
+
+	res, err = UnwrapPatch(data, 1000)
+	assert.NoError(err)
+	assert.Equal(p, res)
+
+	_, err = UnwrapPatch(MustMarshal(cbor.Tag{Number: 1000, Content: "x"}), 1000)
+	assert.ErrorContains(err, "unexpected content")
+
+	_, err = WrapPatch(Patch{{Op: OpMove}}, PatchTag)
+	assert.Error(err)
+}
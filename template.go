@@ -0,0 +1,274 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// Template is a patch whose operation values and paths may contain placeholders, which are
+// CBOR tags of PlaceholderTag with the variable names as text strings, such as 6903("device").
+// Placeholders are bound to values by Bind, a placeholder in a value is replaced by the bound value,
+// and a placeholder as a key of "path" or "from" is replaced by the bound value as the key.
+//
+// The template is parsed once, so binding it only copies the bytes around the placeholders,
+// and the operations without placeholders are shared by the bound patches.
+type Template struct {
+	ops  []*templateOp
+	vars []string
+}
+
+type templateOp struct {
+	// op is the operation if it has no placeholder.
+	op *Operation
+
+	raw   templateOperation
+	from  []templateSegment
+	path  []templateSegment
+	value []templateSegment
+}
+
+// templateOperation is an Operation of which the keys of "path" and "from" may be placeholders.
+type templateOperation struct {
+	Op          Op           `cbor:"1,keyasint"`
+	From        []RawMessage `cbor:"2,keyasint,omitempty"`
+	Path        []RawMessage `cbor:"3,keyasint"`
+	Value       RawMessage   `cbor:"4,keyasint,omitempty"`
+	Index       int          `cbor:"5,keyasint,omitempty"`
+	RemoveCount int          `cbor:"6,keyasint,omitempty"`
+}
+
+// templateSegment is a literal part of raw encoded CBOR, or a placeholder if name is not empty.
+type templateSegment struct {
+	raw  []byte
+	name string
+}
+
+// Placeholder returns the encoded placeholder of the variable name, it can be embedded
+// in the values of a patch encoded as a Template.
+func Placeholder(name string) RawMessage {
+	return MustMarshal(cbor.Tag{Number: PlaceholderTag, Content: name})
+}
+
+// NewTemplate decodes the passed CBOR document as a Template.
+// The document can be wrapped in PatchTag or the self-described CBOR tag like NewPatch.
+func NewTemplate(doc []byte) (*Template, error) {
+	doc, err := untagPatch(doc, PatchTag)
+	if err != nil {
+		return nil, err
+	}
+
+	var ops []templateOperation
+	if err = cborUnmarshal(doc, &ops); err != nil {
+		return nil, err
+	}
+
+	t := &Template{ops: make([]*templateOp, 0, len(ops))}
+	vars := make(map[string]struct{})
+	for i, raw := range ops {
+		top := &templateOp{raw: raw}
+		if top.from, err = splitKeys(raw.From); err == nil {
+			if top.path, err = splitKeys(raw.Path); err == nil {
+				top.value, err = splitPlaceholders(raw.Value)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid operation %d, %w", i, err)
+		}
+
+		n := 0
+		for _, segs := range [][]templateSegment{top.from, top.path, top.value} {
+			for _, seg := range segs {
+				if seg.name != "" {
+					vars[seg.name] = struct{}{}
+					n++
+				}
+			}
+		}
+		if n == 0 {
+			if top.op, err = top.bind(nil); err != nil {
+				return nil, fmt.Errorf("invalid operation %d, %w", i, err)
+			}
+		}
+		t.ops = append(t.ops, top)
+	}
+
+	t.vars = make([]string, 0, len(vars))
+	for name := range vars {
+		t.vars = append(t.vars, name)
+	}
+	sort.Strings(t.vars)
+	return t, nil
+}
+
+// Vars returns the sorted names of the variables in the template.
+func (t *Template) Vars() []string {
+	return append([]string{}, t.vars...)
+}
+
+// Bind returns a patch of the template with the placeholders replaced by the values of the variables.
+// It returns an error if a variable is missing, or a bound operation is invalid.
+func (t *Template) Bind(vars map[string]RawMessage) (Patch, error) {
+	for _, name := range t.vars {
+		v, ok := vars[name]
+		if !ok {
+			return nil, fmt.Errorf("missing variable %q", name)
+		}
+		if err := cborValid(v); err != nil {
+			return nil, fmt.Errorf("invalid variable %q, %w", name, err)
+		}
+	}
+
+	p := make(Patch, 0, len(t.ops))
+	for i, top := range t.ops {
+		op := top.op
+		if op == nil {
+			var err error
+			if op, err = top.bind(vars); err != nil {
+				return nil, fmt.Errorf("invalid operation %d, %w", i, err)
+			}
+		}
+		p = append(p, op)
+	}
+	return p, nil
+}
+
+func (top *templateOp) bind(vars map[string]RawMessage) (*Operation, error) {
+	o := &Operation{Op: top.raw.Op, Index: top.raw.Index, RemoveCount: top.raw.RemoveCount}
+	if top.raw.From != nil {
+		o.From = bindKeys(top.from, vars)
+	}
+	if top.raw.Path != nil {
+		o.Path = bindKeys(top.path, vars)
+	}
+	for _, path := range []Path{o.From, o.Path} {
+		for _, k := range path {
+			if err := k.Valid(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if top.raw.Value != nil {
+		n := 0
+		for _, seg := range top.value {
+			n += len(seg.raw) + len(vars[seg.name])
+		}
+		o.Value = make(RawMessage, 0, n)
+		for _, seg := range top.value {
+			if seg.name != "" {
+				o.Value = append(o.Value, vars[seg.name]...)
+			} else {
+				o.Value = append(o.Value, seg.raw...)
+			}
+		}
+		if err := cborValid(o.Value); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := o.Valid(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// splitKeys splits the keys of a path into one segment for each key.
+func splitKeys(keys []RawMessage) ([]templateSegment, error) {
+	segs := make([]templateSegment, 0, len(keys))
+	for _, key := range keys {
+		ks, err := splitPlaceholders(key)
+		if err != nil {
+			return nil, err
+		}
+		if len(ks) != 1 {
+			return nil, errors.New("placeholder can only be used as a whole key")
+		}
+		segs = append(segs, ks[0])
+	}
+	return segs, nil
+}
+
+func bindKeys(segs []templateSegment, vars map[string]RawMessage) Path {
+	path := make(Path, 0, len(segs))
+	for _, seg := range segs {
+		if seg.name != "" {
+			path = append(path, RawKey(vars[seg.name]))
+		} else {
+			path = append(path, RawKey(seg.raw))
+		}
+	}
+	return path
+}
+
+// splitPlaceholders splits the raw encoded CBOR value into the literal segments and the placeholders.
+// Since the lengths of CBOR arrays and maps are the numbers of their items, a placeholder can be
+// replaced by any CBOR value without changing the other segments.
+func splitPlaceholders(data []byte) ([]templateSegment, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	if err := cborValid(data); err != nil {
+		return nil, err
+	}
+
+	var segs []templateSegment
+	start := 0
+	var walk func(off int) (int, error)
+	walk = func(off int) (int, error) {
+		major, arg, next, err := cborHead(data, off)
+		if err != nil {
+			return 0, err
+		}
+
+		switch major {
+		case 2, 3:
+			return next + int(arg), nil
+
+		case 4, 5:
+			if major == 5 {
+				arg *= 2
+			}
+			for i := uint64(0); i < arg; i++ {
+				if next, err = walk(next); err != nil {
+					return 0, err
+				}
+			}
+			return next, nil
+
+		case 6:
+			if arg != PlaceholderTag {
+				return walk(next)
+			}
+
+			ty, n, name, err := cborHead(data, next)
+			if err != nil {
+				return 0, err
+			}
+			if ty != 3 || n == 0 {
+				return 0, fmt.Errorf("invalid placeholder %s, expected a variable name", Diagify(data[off:]))
+			}
+			end := name + int(n)
+			if off > start {
+				segs = append(segs, templateSegment{raw: data[start:off]})
+			}
+			segs = append(segs, templateSegment{name: string(data[name:end])})
+			start = end
+			return end, nil
+		}
+		return next, nil
+	}
+
+	if _, err := walk(0); err != nil {
+		return nil, err
+	}
+	if start < len(data) {
+		segs = append(segs, templateSegment{raw: data[start:]})
+	}
+	return segs, nil
+}
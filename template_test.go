@@ -0,0 +1,70 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplate(t *testing.T) {
+	assert := assert.New(t)
+
+	type op struct {
+		Op    Op    `cbor:"1,keyasint"`
+		From  []any `cbor:"2,keyasint,omitempty"`
+		Path  []any `cbor:"3,keyasint"`
+		Value any   `cbor:"4,keyasint,omitempty"`
+	}
+	data := MustMarshal([]op{
+		{Op: OpTest, Path: []any{"rev"}, Value: 1},
+		{Op: OpReplace, Path: []any{"devices", Placeholder("device"), "name"}, Value: Placeholder("name")},
+		{Op: OpAdd, Path: []any{"tags", "-"},
+			Value: map[string]any{"id": Placeholder("device"), "labels": []any{"x", Placeholder("name")}}},
+		{Op: OpCopy, From: []any{"devices", Placeholder("device")}, Path: []any{"last"}},
+	})
+
+	tpl, err := NewTemplate(data)
+	assert.NoError(err)
+	assert.Equal([]string{"device", "name"}, tpl.Vars())
+
+	doc := MustFromJSON(`{"rev":1,"devices":{"d1":{"name":"a"},"d2":{"name":"b"}},"tags":[]}`)
+	p1, err := tpl.Bind(map[string]RawMessage{"device": MustMarshal("d1"), "name": MustMarshal("x1")})
+	assert.NoError(err)
+	p2, err := tpl.Bind(map[string]RawMessage{"device": MustMarshal("d2"), "name": MustMarshal([]int{2})})
+	assert.NoError(err)
+	assert.Same(p1[0], p2[0])
+
+	res, err := p1.Apply(doc)
+	assert.NoError(err)
+	assert.Equal(`{"devices":{"d1":{"name":"x1"},"d2":{"name":"b"}},"last":{"name":"x1"},"rev":1,"tags":[{"id":"d1","labels":["x","x1"]}]}`,
+		MustToJSON(res))
+
+	res, err = p2.Apply(doc)
+	assert.NoError(err)
+	assert.Equal(`{"devices":{"d1":{"name":"a"},"d2":{"name":[2]}},"last":{"name":[2]},"rev":1,"tags":[{"id":"d2","labels":["x",[2]]}]}`,
+		MustToJSON(res))
+
+	_, err = tpl.Bind(map[string]RawMessage{"device": MustMarshal("d1")})
+	assert.ErrorContains(err, `missing variable "name"`)
+	_, err = tpl.Bind(map[string]RawMessage{"device": MustMarshal([]int{1}), "name": MustMarshal("x")})
+	assert.ErrorContains(err, "invalid operation 1")
+	_, err = tpl.Bind(map[string]RawMessage{"device": MustMarshal("d1"), "name": RawMessage{0xff}})
+	assert.ErrorContains(err, `invalid variable "name"`)
+
+	tagged, err := cbor.Marshal(cbor.RawTag{Number: PatchTag, Content: data})
+	assert.NoError(err)
+	_, err = NewTemplate(tagged)
+	assert.NoError(err)
+
+	_, err = NewTemplate(MustMarshal([]op{{Op: OpAdd, Path: []any{[]any{Placeholder("a")}}, Value: 1}}))
+	assert.Error(err)
+	_, err = NewTemplate(MustMarshal([]op{{Op: OpAdd, Path: []any{"a"},
+		Value: cbor.Tag{Number: PlaceholderTag, Content: 1}}}))
+	assert.ErrorContains(err, "invalid placeholder")
+	_, err = NewTemplate(MustMarshal([]op{{Op: OpMove, Path: []any{"a"}}}))
+	assert.ErrorContains(err, "invalid operation 0")
+}
@@ -0,0 +1,82 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TextDiff returns an edit script that changes the text from into to, it is the value
+// of a "text-diff" operation. The script is an array of steps applied to the runes
+// (Unicode code points) of the text in order:
+//
+//	a positive integer n keeps the next n runes,
+//	a negative integer -n deletes the next n runes,
+//	a text string is inserted.
+//
+// The runes left after the last step are kept. TextDiff keeps the common prefix and suffix
+// of the texts and replaces the runes between them, which is compact for local edits.
+func TextDiff(from, to string) []any {
+	a, b := []rune(from), []rune(to)
+	p := 0
+	for p < len(a) && p < len(b) && a[p] == b[p] {
+		p++
+	}
+	s := 0
+	for s < len(a)-p && s < len(b)-p && a[len(a)-1-s] == b[len(b)-1-s] {
+		s++
+	}
+
+	script := make([]any, 0, 3)
+	if p > 0 {
+		script = append(script, p)
+	}
+	if n := len(a) - p - s; n > 0 {
+		script = append(script, -n)
+	}
+	if p+s < len(b) {
+		script = append(script, string(b[p:len(b)-s]))
+	}
+	return script
+}
+
+// NewTextDiff returns a "text-diff" operation that changes the text string at path from from into to,
+// see TextDiff.
+func NewTextDiff(path Path, from, to string) (*Operation, error) {
+	return newOperation(OpTextDiff, nil, path, TextDiff(from, to))
+}
+
+// applyTextDiff applies the edit script to the text, see TextDiff.
+func applyTextDiff(text string, script []any) (string, error) {
+	src := []rune(text)
+	var b strings.Builder
+	b.Grow(len(text))
+
+	i := 0
+	for _, step := range script {
+		switch s := step.(type) {
+		case uint64:
+			if s > uint64(len(src)-i) {
+				return "", fmt.Errorf("unable to keep %d runes at %d of %d runes, %w", s, i, len(src), ErrInvalidIndex)
+			}
+			b.WriteString(string(src[i : i+int(s)]))
+			i += int(s)
+
+		case int64:
+			if s < -int64(len(src)-i) {
+				return "", fmt.Errorf("unable to delete %d runes at %d of %d runes, %w", -s, i, len(src), ErrInvalidIndex)
+			}
+			i -= int(s)
+
+		case string:
+			b.WriteString(s)
+
+		default:
+			return "", fmt.Errorf("invalid text-diff step %v, %w", step, ErrInvalid)
+		}
+	}
+	b.WriteString(string(src[i:]))
+	return b.String(), nil
+}
@@ -0,0 +1,67 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTextDiff(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, c := range []struct {
+		from, to string
+		script   []any
+	}{
+		{"", "", []any{}},
+		{"hello", "hello", []any{5}},
+		{"", "hello", []any{"hello"}},
+		{"hello", "", []any{-5}},
+		{"hello world", "hello, world", []any{5, ","}},
+		{"hello world", "help world", []any{3, -2, "p"}},
+		{"你好，世界", "你好，Go", []any{3, -2, "Go"}},
+		{"aaa", "aa", []any{2, -1}},
+	} {
+		script := TextDiff(c.from, c.to)
+		assert.Equal(c.script, script, "%q -> %q", c.from, c.to)
+
+		var decoded []any
+		assert.NoError(cborUnmarshal(MustMarshal(script), &decoded))
+		res, err := applyTextDiff(c.from, decoded)
+		assert.NoError(err)
+		assert.Equal(c.to, res)
+	}
+
+	_, err := applyTextDiff("abc", []any{uint64(4)})
+	assert.ErrorContains(err, ErrInvalidIndex.Error())
+	_, err = applyTextDiff("abc", []any{uint64(1), int64(-3)})
+	assert.ErrorContains(err, ErrInvalidIndex.Error())
+	_, err = applyTextDiff("abc", []any{true})
+	assert.ErrorContains(err, ErrInvalid.Error())
+}
+
+func TestNewTextDiff(t *testing.T) {
+	assert := assert.New(t)
+
+	op, err := NewTextDiff(PathMustFrom("body"), "The quick brown fox", "The quick red fox")
+	assert.NoError(err)
+	data, err := PatchToJSON(Patch{op})
+	assert.NoError(err)
+	assert.Equal(`[{"op":"text-diff","path":"/body","value":[10,-5,"red"]}]`, string(data))
+
+	doc, err := Patch{op}.Apply(MustFromJSON(`{"body": "The quick brown fox"}`))
+	assert.NoError(err)
+	assert.Equal(`{"body":"The quick red fox"}`, MustToJSON(doc))
+
+	tree, err := ApplyToTree(map[string]any{"body": "The quick brown fox"}, Patch{op}, nil)
+	assert.NoError(err)
+	assert.Equal(map[string]any{"body": "The quick red fox"}, tree)
+
+	_, err = Patch{op}.Apply(MustFromJSON(`{"body": 1}`))
+	assert.ErrorContains(err, ErrInvalid.Error())
+	_, err = Patch{op}.Apply(MustFromJSON(`{"body": "short"}`))
+	assert.ErrorContains(err, ErrInvalidIndex.Error())
+}
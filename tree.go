@@ -0,0 +1,851 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ApplyToTree applies the patch to a decoded Go value tree of map[string]any, map[any]any
+// and []any containers (as produced by cbor.Unmarshal into any), without encoding it to CBOR.
+// Maps are mutated in place, so the tree should not be used after the call,
+// use the returned tree instead. The tree may be partially mutated when an error is returned.
+//
+// Operation values are decoded by cbor.Unmarshal, and maps in them are converted to
+// map[string]any if the tree root is a map[string]any and all their keys are text strings.
+func ApplyToTree(tree any, p Patch, options *Options) (any, error) {
+	if options == nil {
+		options = NewOptions()
+	}
+	if c := newCodec(options); c != nil {
+		opts := *options
+		opts.codec = c
+		options = &opts
+	}
+	if options.AllowIndefiniteLength {
+		p = definitePatch(p)
+	}
+	if options.AllowRelativeFrom {
+		var err error
+		if p, err = absolutePatch(p); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := checkPatchOps(p, options); err != nil {
+		return nil, err
+	}
+
+	_, stringKeys := tree.(map[string]any)
+	t := &treeApplier{options: options, stringKeys: stringKeys}
+	var err error
+	for i, op := range p {
+		if err = validOp(op, options); err != nil {
+			return nil, newPatchError(i, op, err)
+		}
+		if options.AllowWildcard && op.Path.hasWildcard() {
+			return nil, newPatchError(i, op, fmt.Errorf("wildcard path %s is not supported, %w", op.Path, ErrInvalid))
+		}
+		if err = checkDepth(op, options, func() ([]byte, error) {
+			val, err := treeGetPath(tree, op.From, options)
+			if err != nil {
+				return nil, err
+			}
+			return options.codec.Marshal(val)
+		}); err != nil {
+			return nil, newPatchError(i, op, err)
+		}
+		if options.OnBeforeOp != nil {
+			if err = options.OnBeforeOp(i, op); err != nil {
+				return nil, newPatchError(i, op, err)
+			}
+		}
+		var old RawMessage
+		audit := options.AuditWriter != nil && !op.Op.IsTest()
+		if audit {
+			old = treeAuditValue(tree, op.Path, options)
+		}
+
+		switch op.Op {
+		case OpAdd:
+			tree, err = t.add(tree, op)
+		case OpRemove:
+			tree, err = t.remove(tree, op)
+		case OpReplace:
+			tree, err = t.replace(tree, op)
+		case OpMove:
+			tree, err = t.move(tree, op)
+		case OpTest:
+			err = t.test(tree, op)
+		case OpTestContains:
+			err = t.testContains(tree, op)
+		case OpTestType:
+			err = t.testType(tree, op)
+		case OpTestMatch:
+			err = t.testMatch(tree, op)
+		case OpTestLess, OpTestLessEqual, OpTestGreater, OpTestGreaterEqual:
+			err = t.testCompare(tree, op)
+		case OpTestLength:
+			err = t.testLength(tree, op)
+		case OpTestSubset:
+			err = t.testSubset(tree, op)
+		case OpTestDefined:
+			if _, err = treeGetPath(tree, op.Path, t.options); err != nil {
+				err = testFailedf("test-defined operation for path %s failed, %w", op.Path, err)
+			}
+		case OpTestUndefined:
+			if _, e := treeGetPath(tree, op.Path, t.options); e == nil {
+				err = testFailedf("test-undefined operation for path %s failed, the path exists", op.Path)
+			}
+		case OpCopy:
+			tree, err = t.copy(tree, op)
+		case OpMerge:
+			tree, err = t.transform(tree, op, func(cur, val any) (any, error) {
+				return treeMerge(cur, val), nil
+			})
+		case OpAppend:
+			tree, err = t.transform(tree, op, treeAppend)
+		case OpSplice:
+			tree, err = t.transform(tree, op, func(cur, val any) (any, error) {
+				elems, _ := val.([]any)
+				return t.splice(cur, op, elems)
+			})
+		case OpIncr, OpDecr:
+			tree, err = t.transform(tree, op, func(cur, val any) (any, error) {
+				return addNumber(cur, val, op.Op == OpDecr)
+			})
+		case OpTextDiff:
+			tree, err = t.transform(tree, op, func(cur, val any) (any, error) {
+				text, ok := cur.(string)
+				if !ok {
+					return nil, fmt.Errorf("unable to apply text-diff to %T, %w", cur, ErrInvalid)
+				}
+				script, _ := val.([]any)
+				return applyTextDiff(text, script)
+			})
+		case OpBinaryDiff:
+			tree, err = t.transform(tree, op, func(cur, val any) (any, error) {
+				data, ok := cur.([]byte)
+				if !ok {
+					return nil, fmt.Errorf("unable to apply binary-diff to %T, %w", cur, ErrInvalid)
+				}
+				delta, _ := val.([]any)
+				return applyBinaryDiff(data, delta)
+			})
+		case OpToggle:
+			tree, err = t.transform(tree, op, func(cur, _ any) (any, error) {
+				b, ok := cur.(bool)
+				if !ok {
+					return nil, fmt.Errorf("unable to toggle %T, %w", cur, ErrInvalid)
+				}
+				return !b, nil
+			})
+		}
+
+		if audit && err == nil {
+			err = writeTreeAudit(tree, op, old, options)
+		}
+		if options.OnAfterOp != nil {
+			options.OnAfterOp(i, op, err)
+		}
+		if err != nil {
+			return nil, newPatchError(i, op, err)
+		}
+	}
+	return tree, nil
+}
+
+type treeApplier struct {
+	options             *Options
+	stringKeys          bool
+	accumulatedCopySize int64
+}
+
+func (t *treeApplier) decode(data RawMessage) (any, error) {
+	var v any
+	if len(data) == 0 {
+		return v, nil
+	}
+	if err := t.options.codec.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	if t.stringKeys {
+		v = stringKeysTree(v)
+	}
+	return v, nil
+}
+
+func (t *treeApplier) add(tree any, op *Operation) (any, error) {
+	val, err := t.decode(op.Value)
+	if err != nil {
+		return nil, fmt.Errorf("add operation does not apply for %s, %w", op.Path, err)
+	}
+
+	if len(op.Path) == 0 {
+		return nil, fmt.Errorf("add operation does not apply for %s, %w", op.Path, ErrMissing)
+	}
+
+	tree, err = t.update(tree, op.Path, t.options.EnsurePathExistsOnAdd, func(con any, key RawKey) (any, error) {
+		return treeAdd(con, key, val, t.options)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("add operation does not apply for %s, %w", op.Path, err)
+	}
+	return tree, nil
+}
+
+func (t *treeApplier) remove(tree any, op *Operation) (any, error) {
+	if len(op.Path) == 0 {
+		return nil, fmt.Errorf("remove operation does not apply for %s, %w", op.Path, ErrMissing)
+	}
+
+	res, err := t.update(tree, op.Path, false, func(con any, key RawKey) (any, error) {
+		return treeRemove(con, key, t.options)
+	})
+	if err != nil {
+		if t.options.AllowMissingPathOnRemove && errors.Is(err, ErrMissing) {
+			return tree, nil
+		}
+		return nil, fmt.Errorf("remove operation does not apply for %s, %w", op.Path, err)
+	}
+	return res, nil
+}
+
+func (t *treeApplier) replace(tree any, op *Operation) (any, error) {
+	val, err := t.decode(op.Value)
+	if err != nil {
+		return nil, fmt.Errorf("replace operation does not apply for %s, %w", op.Path, err)
+	}
+
+	if len(op.Path) == 0 {
+		return val, nil
+	}
+
+	tree, err = t.update(tree, op.Path, false, func(con any, key RawKey) (any, error) {
+		if _, err := treeGet(con, key, t.options); err != nil {
+			return nil, err
+		}
+		return treeSet(con, key, val, t.options)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("replace operation does not apply for %s, %w", op.Path, err)
+	}
+	return tree, nil
+}
+
+func (t *treeApplier) move(tree any, op *Operation) (any, error) {
+	if len(op.From) == 0 {
+		return nil, fmt.Errorf("move operation does not apply for from %s, %w", op.From, ErrMissing)
+	}
+
+	val, err := treeGetPath(tree, op.From, t.options)
+	if err != nil {
+		return nil, fmt.Errorf("move operation does not apply for from %s, %w", op.From, err)
+	}
+
+	tree, err = t.update(tree, op.From, false, func(con any, key RawKey) (any, error) {
+		return treeRemove(con, key, t.options)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("move operation does not apply for from %s, %w", op.From, err)
+	}
+
+	if len(op.Path) == 0 {
+		return nil, fmt.Errorf("move operation does not apply for path %s, %w", op.Path, ErrMissing)
+	}
+
+	tree, err = t.update(tree, op.Path, false, func(con any, key RawKey) (any, error) {
+		return treeAdd(con, key, val, t.options)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("move operation does not apply for path %s, %w", op.Path, err)
+	}
+	return tree, nil
+}
+
+func (t *treeApplier) test(tree any, op *Operation) error {
+	val, err := treeGetPath(tree, op.Path, t.options)
+	if err != nil {
+		if errors.Is(err, ErrMissing) && isNull(op.Value) && !t.options.FailOnMissingTestPath {
+			return nil
+		}
+		return testFailedf("test operation for path %s failed, %w", op.Path, err)
+	}
+
+	data, err := t.options.codec.Marshal(val)
+	if err != nil {
+		return testFailedf("test operation for path %s failed, %w", op.Path, err)
+	}
+
+	if !NewNode(data).equal(NewNode(op.Value), &t.options.Equal) {
+		return testFailedf("test operation for path %s failed, expected %s, got %s",
+			op.Path, NewNode(op.Value), NewNode(data))
+	}
+	return nil
+}
+
+func (t *treeApplier) testContains(tree any, op *Operation) error {
+	val, err := treeGetPath(tree, op.Path, t.options)
+	if err != nil {
+		return testFailedf("test-contains operation for path %s failed, %w", op.Path, err)
+	}
+
+	data, err := t.options.codec.Marshal(val)
+	if err != nil {
+		return testFailedf("test-contains operation for path %s failed, %w", op.Path, err)
+	}
+
+	if !containsNode(NewNode(data), op.Value, &t.options.Equal) {
+		return testFailedf("test-contains operation for path %s failed, %s does not contain %s",
+			op.Path, NewNode(data), NewNode(op.Value))
+	}
+	return nil
+}
+
+func (t *treeApplier) testType(tree any, op *Operation) error {
+	match, err := readTestType(op.Value)
+	if err != nil {
+		return fmt.Errorf("test-type operation does not apply for %s, %w", op.Path, err)
+	}
+
+	val, err := treeGetPath(tree, op.Path, t.options)
+	if err != nil {
+		return testFailedf("test-type operation for path %s failed, %w", op.Path, err)
+	}
+
+	data, err := t.options.codec.Marshal(val)
+	if err != nil {
+		return testFailedf("test-type operation for path %s failed, %w", op.Path, err)
+	}
+
+	if !match(data) {
+		return testFailedf("test-type operation for path %s failed, %s is not of type %s",
+			op.Path, NewNode(data), NewNode(op.Value))
+	}
+	return nil
+}
+
+func (t *treeApplier) testMatch(tree any, op *Operation) error {
+	re, err := readTestMatch(op.Value)
+	if err != nil {
+		return fmt.Errorf("test-match operation does not apply for %s, %w", op.Path, err)
+	}
+
+	val, err := treeGetPath(tree, op.Path, t.options)
+	if err != nil {
+		return testFailedf("test-match operation for path %s failed, %w", op.Path, err)
+	}
+
+	data, err := t.options.codec.Marshal(val)
+	if err != nil {
+		return testFailedf("test-match operation for path %s failed, %w", op.Path, err)
+	}
+	return matchText(re, op.Path, data)
+}
+
+func (t *treeApplier) testCompare(tree any, op *Operation) error {
+	val, err := treeGetPath(tree, op.Path, t.options)
+	if err != nil {
+		return testFailedf("%s operation for path %s failed, %w", op.Op, op.Path, err)
+	}
+
+	data, err := t.options.codec.Marshal(val)
+	if err != nil {
+		return testFailedf("%s operation for path %s failed, %w", op.Op, op.Path, err)
+	}
+	return compareValue(op, data)
+}
+
+func (t *treeApplier) testLength(tree any, op *Operation) error {
+	min, max, err := readTestLength(op.Value)
+	if err != nil {
+		return fmt.Errorf("test-length operation does not apply for %s, %w", op.Path, err)
+	}
+
+	val, err := treeGetPath(tree, op.Path, t.options)
+	if err != nil {
+		return testFailedf("test-length operation for path %s failed, %w", op.Path, err)
+	}
+
+	data, err := t.options.codec.Marshal(val)
+	if err != nil {
+		return testFailedf("test-length operation for path %s failed, %w", op.Path, err)
+	}
+
+	n := -1
+	switch v := val.(type) {
+	case []any:
+		n = len(v)
+	case map[any]any:
+		n = len(v)
+	case map[string]any:
+		n = len(v)
+	default:
+		n = rawLength(data)
+	}
+	return checkLength(op.Path, n, min, max, NewNode(data))
+}
+
+func (t *treeApplier) testSubset(tree any, op *Operation) error {
+	val, err := treeGetPath(tree, op.Path, t.options)
+	if err != nil {
+		return testFailedf("test-subset operation for path %s failed, %w", op.Path, err)
+	}
+
+	data, err := t.options.codec.Marshal(val)
+	if err != nil {
+		return testFailedf("test-subset operation for path %s failed, %w", op.Path, err)
+	}
+
+	if !subsetNode(NewNode(data), NewNode(op.Value), &t.options.Equal) {
+		return testFailedf("test-subset operation for path %s failed, expected a superset of %s, got %s",
+			op.Path, NewNode(op.Value), NewNode(data))
+	}
+	return nil
+}
+
+func (t *treeApplier) copy(tree any, op *Operation) (any, error) {
+	if len(op.From) == 0 {
+		return nil, fmt.Errorf("copy operation does not apply for from path %s, %w", op.From, ErrMissing)
+	}
+
+	val, err := treeGetPath(tree, op.From, t.options)
+	if err != nil {
+		return nil, fmt.Errorf("copy operation does not apply for from path %s, %w", op.From, err)
+	}
+
+	data, err := t.options.codec.Marshal(val)
+	if err != nil {
+		return nil, fmt.Errorf("copy operation does not apply for path %s while performing deep copy, %w", op.Path, err)
+	}
+
+	t.accumulatedCopySize += int64(len(data))
+	if t.options.AccumulatedCopySizeLimit > 0 && t.accumulatedCopySize > t.options.AccumulatedCopySizeLimit {
+		return nil, NewAccumulatedCopySizeError(t.options.AccumulatedCopySizeLimit, t.accumulatedCopySize)
+	}
+
+	if val, err = t.decode(data); err != nil {
+		return nil, fmt.Errorf("copy operation does not apply for path %s while performing deep copy, %w", op.Path, err)
+	}
+
+	if len(op.Path) == 0 {
+		return nil, fmt.Errorf("copy operation does not apply for path %s, %w", op.Path, ErrMissing)
+	}
+
+	tree, err = t.update(tree, op.Path, false, func(con any, key RawKey) (any, error) {
+		return treeAdd(con, key, val, t.options)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("copy operation does not apply for path %s while adding value during copy, %w",
+			op.Path, err)
+	}
+	return tree, nil
+}
+
+// transform replaces the target of the operation with the value returned by fn.
+func (t *treeApplier) transform(tree any, op *Operation, fn func(cur, val any) (any, error)) (any, error) {
+	val, err := t.decode(op.Value)
+	if err != nil {
+		return nil, fmt.Errorf("%s operation does not apply for %s, %w", op.Op, op.Path, err)
+	}
+
+	if len(op.Path) == 0 {
+		if tree, err = fn(tree, val); err != nil {
+			return nil, fmt.Errorf("%s operation does not apply for %s, %w", op.Op, op.Path, err)
+		}
+		return tree, nil
+	}
+
+	tree, err = t.update(tree, op.Path, false, func(con any, key RawKey) (any, error) {
+		cur, err := treeGet(con, key, t.options)
+		if err != nil {
+			return nil, err
+		}
+		if cur, err = fn(cur, val); err != nil {
+			return nil, err
+		}
+		return treeSet(con, key, cur, t.options)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s operation does not apply for %s, %w", op.Op, op.Path, err)
+	}
+	return tree, nil
+}
+
+func (t *treeApplier) splice(cur any, op *Operation, elems []any) (any, error) {
+	ary, ok := cur.([]any)
+	if !ok {
+		return nil, fmt.Errorf("unable to splice %T, %w", cur, ErrInvalid)
+	}
+	idx, err := spliceIndex(op.Index, op.RemoveCount, len(ary), t.options)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]any, 0, len(ary)-op.RemoveCount+len(elems))
+	res = append(res, ary[:idx]...)
+	res = append(res, elems...)
+	return append(res, ary[idx+op.RemoveCount:]...), nil
+}
+
+// update calls fn with the container at path[:len(path)-1] and the last key of the path,
+// and stores the container returned by fn back into its parent. It returns the updated tree.
+// If ensure is true, the missing containers in the path are created.
+func (t *treeApplier) update(tree any, path Path, ensure bool, fn func(con any, key RawKey) (any, error)) (any, error) {
+	if len(path) == 1 {
+		return fn(tree, path[0])
+	}
+
+	child, err := treeGet(tree, path[0], t.options)
+	if err != nil || child == nil {
+		if !ensure {
+			if err == nil {
+				err = fmt.Errorf("unable to get nil value at %s, %w", path[0], ErrMissing)
+			}
+			return nil, err
+		}
+
+		child = t.newContainer(path[1])
+		if err == nil {
+			tree, err = treeSet(tree, path[0], child, t.options)
+		} else if tree, err = treePad(tree, path[0], t.options); err == nil {
+			tree, err = treeAdd(tree, path[0], child, t.options)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if path[1].isIndex() && !path[1].isMinus() {
+			if child, err = treePad(child, path[1], t.options); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if child, err = t.update(child, path[1:], ensure, fn); err != nil {
+		return nil, err
+	}
+	return treeSet(tree, path[0], child, t.options)
+}
+
+func (t *treeApplier) newContainer(key RawKey) any {
+	switch {
+	case key.isIndex():
+		return []any{}
+	case t.stringKeys:
+		return map[string]any{}
+	default:
+		return map[any]any{}
+	}
+}
+
+// treePad pads an array with nulls so that the index is the next element to add.
+func treePad(con any, key RawKey, options *Options) (any, error) {
+	ary, ok := con.([]any)
+	if !ok || key.isMinus() {
+		return con, nil
+	}
+
+	idx, err := key.toInt()
+	if err != nil {
+		return nil, err
+	}
+	if idx < 0 {
+		if !options.SupportNegativeIndices || idx < -1 {
+			return nil, fmt.Errorf("unable to ensure path for invalid index %d, %w", idx, ErrInvalidIndex)
+		}
+		return ary, nil
+	}
+	if idx > len(ary) {
+		if err = checkPadding(idx-len(ary), options); err != nil {
+			return nil, err
+		}
+	}
+	for len(ary) < idx {
+		ary = append(ary, nil)
+	}
+	return ary, nil
+}
+
+func treeGetPath(tree any, path Path, options *Options) (any, error) {
+	var err error
+	for _, key := range path {
+		if tree, err = treeGet(tree, key, options); err != nil {
+			return nil, err
+		}
+	}
+	return tree, nil
+}
+
+func treeGet(con any, key RawKey, options *Options) (any, error) {
+	switch c := con.(type) {
+	case map[string]any:
+		k, err := treeStringKey(key)
+		if err != nil {
+			return nil, err
+		}
+		v, ok := c[k]
+		if !ok {
+			return nil, fmt.Errorf("unable to get nonexistent key %s, %w", key, ErrMissing)
+		}
+		return v, nil
+
+	case map[any]any:
+		k, ok := treeMapKey(c, key)
+		if !ok {
+			return nil, fmt.Errorf("unable to get nonexistent key %s, %w", key, ErrMissing)
+		}
+		return c[k], nil
+
+	case []any:
+		idx, err := treeIndex(len(c), key, options)
+		if err != nil {
+			return nil, err
+		}
+		return c[idx], nil
+
+	default:
+		return nil, fmt.Errorf("unable to get key %s in %T, %w", key, con, ErrMissing)
+	}
+}
+
+func treeSet(con any, key RawKey, val any, options *Options) (any, error) {
+	switch c := con.(type) {
+	case map[string]any:
+		k, err := treeStringKey(key)
+		if err != nil {
+			return nil, err
+		}
+		c[k] = val
+		return c, nil
+
+	case map[any]any:
+		k, ok := treeMapKey(c, key)
+		if !ok {
+			var err error
+			if k, err = treeAnyKey(key); err != nil {
+				return nil, err
+			}
+		}
+		c[k] = val
+		return c, nil
+
+	case []any:
+		idx, err := treeIndex(len(c), key, options)
+		if err != nil {
+			return nil, err
+		}
+		c[idx] = val
+		return c, nil
+
+	default:
+		return nil, fmt.Errorf("unable to set key %s in %T, %w", key, con, ErrInvalid)
+	}
+}
+
+func treeAdd(con any, key RawKey, val any, options *Options) (any, error) {
+	c, ok := con.([]any)
+	if !ok {
+		return treeSet(con, key, val, options)
+	}
+
+	if key.isMinus() {
+		return append(c, val), nil
+	}
+
+	idx, err := treeIndex(len(c)+1, key, options)
+	if err != nil {
+		return nil, err
+	}
+
+	ary := make([]any, len(c)+1)
+	copy(ary[0:idx], c[0:idx])
+	ary[idx] = val
+	copy(ary[idx+1:], c[idx:])
+	return ary, nil
+}
+
+func treeRemove(con any, key RawKey, options *Options) (any, error) {
+	switch c := con.(type) {
+	case map[string]any:
+		k, err := treeStringKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := c[k]; !ok {
+			return nil, fmt.Errorf("unable to remove nonexistent key %s, %w", key, ErrMissing)
+		}
+		delete(c, k)
+		return c, nil
+
+	case map[any]any:
+		k, ok := treeMapKey(c, key)
+		if !ok {
+			return nil, fmt.Errorf("unable to remove nonexistent key %s, %w", key, ErrMissing)
+		}
+		delete(c, k)
+		return c, nil
+
+	case []any:
+		idx, err := treeIndex(len(c), key, options)
+		if err != nil {
+			return nil, err
+		}
+		ary := make([]any, len(c)-1)
+		copy(ary[0:idx], c[0:idx])
+		copy(ary[idx:], c[idx+1:])
+		return ary, nil
+
+	default:
+		return nil, fmt.Errorf("unable to remove key %s in %T, %w", key, con, ErrMissing)
+	}
+}
+
+func treeIndex(sz int, key RawKey, options *Options) (int, error) {
+	idx, err := key.toInt()
+	if err != nil {
+		return 0, err
+	}
+
+	if idx < 0 {
+		if !options.SupportNegativeIndices || idx < -sz {
+			return 0, fmt.Errorf("unable to access invalid index %d, %w", idx, ErrMissing)
+		}
+		idx += sz
+	}
+	if idx >= sz {
+		return 0, fmt.Errorf("unable to access invalid index %d, %w", idx, ErrMissing)
+	}
+	return idx, nil
+}
+
+func treeStringKey(key RawKey) (string, error) {
+	var k string
+	if ReadCBORType([]byte(key)) != CBORTypeTextString {
+		return k, fmt.Errorf("key %s can not be used in map[string]any, %w", key, ErrInvalid)
+	}
+	return k, cborUnmarshal([]byte(key), &k)
+}
+
+func treeAnyKey(key RawKey) (any, error) {
+	var k any
+	if err := cborUnmarshal([]byte(key), &k); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// treeMapKey returns the key in the map that encodes to the raw key.
+func treeMapKey(m map[any]any, key RawKey) (any, bool) {
+	if k, err := treeAnyKey(key); err == nil {
+		if _, ok := m[k]; ok {
+			return k, true
+		}
+	}
+
+	for k := range m {
+		if data, err := cborMarshal(k); err == nil && RawKey(data) == key {
+			return k, true
+		}
+	}
+	return nil, false
+}
+
+// stringKeysTree converts maps with text string keys in the tree to map[string]any.
+func stringKeysTree(v any) any {
+	switch c := v.(type) {
+	case map[any]any:
+		m := make(map[string]any, len(c))
+		for k, val := range c {
+			s, ok := k.(string)
+			if !ok {
+				for k, val := range c {
+					c[k] = stringKeysTree(val)
+				}
+				return c
+			}
+			m[s] = stringKeysTree(val)
+		}
+		return m
+
+	case []any:
+		for i, val := range c {
+			c[i] = stringKeysTree(val)
+		}
+		return c
+
+	default:
+		return v
+	}
+}
+
+// treeMerge merges the patch into the target recursively, see MergePatch.
+func treeMerge(target, patch any) any {
+	switch pm := patch.(type) {
+	case map[string]any:
+		if tm, ok := target.(map[any]any); ok {
+			for k, v := range pm {
+				if v == nil {
+					delete(tm, k)
+				} else {
+					tm[k] = treeMerge(tm[k], v)
+				}
+			}
+			return tm
+		}
+
+		tm, ok := target.(map[string]any)
+		if !ok {
+			tm = make(map[string]any, len(pm))
+		}
+		for k, v := range pm {
+			if v == nil {
+				delete(tm, k)
+			} else {
+				tm[k] = treeMerge(tm[k], v)
+			}
+		}
+		return tm
+
+	case map[any]any:
+		tm, ok := target.(map[any]any)
+		if !ok {
+			tm = make(map[any]any, len(pm))
+			if sm, ok := target.(map[string]any); ok {
+				for k, v := range sm {
+					tm[k] = v
+				}
+			}
+		}
+		for k, v := range pm {
+			if v == nil {
+				delete(tm, k)
+			} else {
+				tm[k] = treeMerge(tm[k], v)
+			}
+		}
+		return tm
+	}
+	return patch
+}
+
+// treeAppend appends the elements of the val array to the cur array,
+// or concatenates the val text string or byte string onto cur.
+func treeAppend(cur, val any) (any, error) {
+	switch c := cur.(type) {
+	case []any:
+		if v, ok := val.([]any); ok {
+			return append(c, v...), nil
+		}
+	case string:
+		if v, ok := val.(string); ok {
+			return c + v, nil
+		}
+	case []byte:
+		if v, ok := val.([]byte); ok {
+			return append(c[:len(c):len(c)], v...), nil
+		}
+	}
+	return nil, fmt.Errorf("unable to append %T to %T, %w", val, cur, ErrInvalid)
+}
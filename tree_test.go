@@ -0,0 +1,96 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func applyTreePatch(doc, patch string, options *Options) (string, error) {
+	obj, err := PatchFromJSON(patch)
+	if err != nil {
+		return "", err
+	}
+
+	var tree any
+	if err = cborUnmarshal(MustFromJSON(doc), &tree); err != nil {
+		return "", err
+	}
+	if tree, err = ApplyToTree(tree, obj, options); err != nil {
+		return "", err
+	}
+	return MustToJSON(MustMarshal(tree)), nil
+}
+
+func TestApplyToTreeCases(t *testing.T) {
+	for i, c := range Cases {
+		t.Run(fmt.Sprintf("Case %d", i), func(t *testing.T) {
+			options := DefaultOptions()
+			options.AccumulatedCopySizeLimit = 100
+			options.AllowMissingPathOnRemove = c.allowMissingPathOnRemove
+			options.EnsurePathExistsOnAdd = c.ensurePathExistsOnAdd
+
+			out, err := applyTreePatch(c.doc, c.patch, options)
+			if err != nil {
+				t.Errorf("Unable to apply patch: %s", err)
+			}
+
+			if !compareJSON(out, c.result) {
+				t.Errorf("Patch did not apply. Expected:\n%s\n\nActual:\n%s",
+					reformatJSON(c.result), reformatJSON(out))
+			}
+		})
+	}
+
+	limited := DefaultOptions()
+	limited.AccumulatedCopySizeLimit = 100
+	for _, c := range BadCases {
+		_, err := applyTreePatch(c.doc, c.patch, limited)
+		if err == nil {
+			t.Errorf("Patch %q should have failed to apply but it did not", c.patch)
+		}
+	}
+}
+
+func TestApplyToTree(t *testing.T) {
+	assert := assert.New(t)
+
+	tree := map[string]any{"a": []any{1, 2}, "b": map[string]any{"c": "d"}}
+	p, err := PatchFromJSON(`[
+		{"op": "add", "path": "/a/-", "value": {"x": [1]}},
+		{"op": "replace", "path": "/b/c", "value": "e"},
+		{"op": "test", "path": "/a/2/x/0", "value": 1}
+	]`)
+	assert.NoError(err)
+
+	res, err := ApplyToTree(tree, p, nil)
+	assert.NoError(err)
+	assert.Equal(map[string]any{
+		"a": []any{1, 2, map[string]any{"x": []any{uint64(1)}}},
+		"b": map[string]any{"c": "e"},
+	}, res)
+
+	itree := map[any]any{uint64(1): "a", "b": []any{}}
+	p = Patch{
+		{Op: OpReplace, Path: PathMustFrom(1), Value: MustMarshal("x")},
+		{Op: OpAdd, Path: PathMustFrom("b", 0), Value: MustMarshal(map[int]int{1: 2})},
+		{Op: OpCopy, From: PathMustFrom("b", 0), Path: PathMustFrom(-1)},
+	}
+	ires, err := ApplyToTree(itree, p, nil)
+	assert.NoError(err)
+	assert.Equal(map[any]any{
+		uint64(1): "x",
+		"b":       []any{map[any]any{uint64(1): uint64(2)}},
+		int64(-1): map[any]any{uint64(1): uint64(2)},
+	}, ires)
+
+	_, err = ApplyToTree(map[string]any{}, Patch{{Op: OpAdd, Path: PathMustFrom(1), Value: MustMarshal(1)}}, nil)
+	assert.ErrorContains(err, "can not be used in map[string]any")
+
+	_, err = ApplyToTree(tree, Patch{{Op: OpTest, Path: PathMustFrom("b", "c"), Value: MustMarshal("x")}}, nil)
+	assert.ErrorIs(err, ErrTestFailed)
+}
@@ -0,0 +1,50 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"fmt"
+	"sort"
+)
+
+// ApplyTxn applies the patches to the documents with the same keys, all or nothing.
+// It returns all the documents, patched or not, if every patch applies,
+// otherwise it returns the first error in the order of keys and no document.
+// A patch for a key that does not exist in docs fails with ErrMissing.
+// The passed in docs are not modified.
+func ApplyTxn(docs map[string][]byte, patches map[string]Patch, opts *Options) (map[string][]byte, error) {
+	if opts == nil {
+		opts = NewOptions()
+	}
+
+	keys := make([]string, 0, len(patches))
+	for k := range patches {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	patched := make(map[string][]byte, len(keys))
+	for _, k := range keys {
+		doc, ok := docs[k]
+		if !ok {
+			return nil, fmt.Errorf("unable to apply patch to document %q, %w", k, ErrMissing)
+		}
+
+		data, err := patches[k].ApplyWithOptions(doc, opts)
+		if err != nil {
+			return nil, fmt.Errorf("unable to apply patch to document %q, %w", k, err)
+		}
+		patched[k] = data
+	}
+
+	result := make(map[string][]byte, len(docs))
+	for k, doc := range docs {
+		if data, ok := patched[k]; ok {
+			result[k] = data
+		} else {
+			result[k] = doc
+		}
+	}
+	return result, nil
+}
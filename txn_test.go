@@ -0,0 +1,48 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyTxn(t *testing.T) {
+	assert := assert.New(t)
+
+	docs := map[string][]byte{
+		"alice": MustFromJSON(`{"balance": 100}`),
+		"bob":   MustFromJSON(`{"balance": 50}`),
+		"carol": MustFromJSON(`{"balance": 0}`),
+	}
+
+	transfer := map[string]Patch{
+		"alice": {
+			{Op: OpTest, Path: PathMustFrom("balance"), Value: MustMarshal(100)},
+			{Op: OpReplace, Path: PathMustFrom("balance"), Value: MustMarshal(70)},
+		},
+		"bob": {
+			{Op: OpTest, Path: PathMustFrom("balance"), Value: MustMarshal(50)},
+			{Op: OpReplace, Path: PathMustFrom("balance"), Value: MustMarshal(80)},
+		},
+	}
+
+	res, err := ApplyTxn(docs, transfer, nil)
+	assert.NoError(err)
+	assert.Equal(3, len(res))
+	assert.Equal(`{"balance":70}`, MustToJSON(res["alice"]))
+	assert.Equal(`{"balance":80}`, MustToJSON(res["bob"]))
+	assert.Equal(`{"balance":0}`, MustToJSON(res["carol"]))
+	assert.Equal(`{"balance":100}`, MustToJSON(docs["alice"]))
+
+	_, err = ApplyTxn(res, transfer, nil)
+	assert.ErrorIs(err, ErrTestFailed)
+	assert.ErrorContains(err, `unable to apply patch to document "alice"`)
+
+	transfer["dave"] = Patch{}
+	_, err = ApplyTxn(docs, transfer, nil)
+	assert.ErrorIs(err, ErrMissing)
+	assert.ErrorContains(err, `"dave"`)
+}
@@ -0,0 +1,65 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+// ResultValidator validates a patched CBOR document before it is returned,
+// so that a patch can never produce an invalid document.
+type ResultValidator interface {
+	ValidateResult(doc []byte) error
+}
+
+// ResultValidatorFunc is an adapter to allow the use of ordinary functions as ResultValidator.
+type ResultValidatorFunc func(doc []byte) error
+
+// ValidateResult implements the ResultValidator interface.
+func (f ResultValidatorFunc) ValidateResult(doc []byte) error {
+	return f(doc)
+}
+
+// JSONResultValidator is an adapter to validate the patched document as JSON, such as with a JSON Schema.
+// The document is converted by ToJSON before it is passed to the function.
+//
+//	schema := jsonschema.MustCompile("schema.json")
+//	options.ResultValidator = cborpatch.JSONResultValidator(func(doc []byte) error {
+//		var v any
+//		if err := json.Unmarshal(doc, &v); err != nil {
+//			return err
+//		}
+//		return schema.Validate(v)
+//	})
+type JSONResultValidator func(doc []byte) error
+
+// ValidateResult implements the ResultValidator interface.
+func (f JSONResultValidator) ValidateResult(doc []byte) error {
+	data, err := ToJSON(doc, nil)
+	if err != nil {
+		return err
+	}
+	return f(data)
+}
+
+// ResultValidators is a list of ResultValidator that are invoked in order.
+type ResultValidators []ResultValidator
+
+// ValidateResult implements the ResultValidator interface.
+func (vs ResultValidators) ValidateResult(doc []byte) error {
+	for _, v := range vs {
+		if err := v.ValidateResult(doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TypedResultValidator returns a ResultValidator that decodes the patched document into a T value
+// and validates it with the function.
+func TypedResultValidator[T any](fn func(v *T) error) ResultValidator {
+	return ResultValidatorFunc(func(doc []byte) error {
+		v := new(T)
+		if err := cborUnmarshal(doc, v); err != nil {
+			return err
+		}
+		return fn(v)
+	})
+}
@@ -0,0 +1,66 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResultValidator(t *testing.T) {
+	assert := assert.New(t)
+
+	type person struct {
+		Name string `cbor:"name"`
+		Age  int    `cbor:"age"`
+	}
+
+	errAge := errors.New("age must be non-negative")
+	doc := MustFromJSON(`{"name": "John", "age": 24}`)
+	options := NewOptions()
+	options.ResultValidator = ResultValidators{
+		JSONResultValidator(func(doc []byte) error {
+			var v map[string]any
+			if err := json.Unmarshal(doc, &v); err != nil {
+				return err
+			}
+			if _, ok := v["name"].(string); !ok {
+				return errors.New("name is required")
+			}
+			return nil
+		}),
+		TypedResultValidator(func(p *person) error {
+			if p.Age < 0 {
+				return errAge
+			}
+			return nil
+		}),
+	}
+
+	p, err := PatchFromJSON(`[{"op": "replace", "path": "/age", "value": 25}]`)
+	assert.NoError(err)
+	res, err := p.ApplyWithOptions(doc, options)
+	assert.NoError(err)
+	assert.Equal(`{"age":25,"name":"John"}`, MustToJSON(res))
+
+	p, err = PatchFromJSON(`[{"op": "remove", "path": "/name"}]`)
+	assert.NoError(err)
+	_, err = p.ApplyWithOptions(doc, options)
+	assert.ErrorContains(err, "invalid patched document, name is required")
+
+	p, err = PatchFromJSON(`[{"op": "replace", "path": "/age", "value": -1}]`)
+	assert.NoError(err)
+	_, err = p.ApplyWithOptions(doc, options)
+	assert.ErrorIs(err, errAge)
+
+	node := NewNode(doc)
+	err = node.Patch(p, &Options{ResultValidator: ResultValidatorFunc(func(doc []byte) error {
+		return nil
+	})})
+	assert.NoError(err)
+	assert.Equal(`{"age":-1,"name":"John"}`, MustToJSON(MustMarshal(node)))
+}
@@ -0,0 +1,207 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ApplyToValue marshals v to CBOR, applies the patch and unmarshals the result into a new T value.
+// The patch paths can use Go struct field names, they are resolved to the CBOR keys
+// by the field's "cbor" (or "json") tag, including "keyasint" and "toarray" options.
+// The v is encoded and the result is decoded with opts.EncMode and opts.DecMode if they are set.
+// The v is not modified.
+func ApplyToValue[T any](v T, p Patch, opts *Options) (T, error) {
+	var res T
+
+	c := newCodec(opts)
+	doc, err := c.Marshal(v)
+	if err != nil {
+		return res, err
+	}
+
+	data, err := resolvePatch(p, reflect.TypeOf(v)).ApplyWithOptions(doc, opts)
+	if err != nil {
+		return res, err
+	}
+
+	if err = c.Unmarshal(data, &res); err != nil {
+		return res, err
+	}
+	return res, nil
+}
+
+func resolvePatch(p Patch, t reflect.Type) Patch {
+	if t == nil {
+		return p
+	}
+
+	rp := make(Patch, len(p))
+	for i, op := range p {
+		if op == nil {
+			continue
+		}
+
+		o := op.clone()
+		o.Path = resolvePath(op.Path, t)
+		if op.From != nil {
+			o.From = resolvePath(op.From, t)
+		}
+		rp[i] = o
+	}
+	return rp
+}
+
+// resolvePath resolves the Go struct field names in the path to CBOR keys of the type.
+func resolvePath(path Path, t reflect.Type) Path {
+	var res Path
+	for i, key := range path {
+		for t != nil && t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t == nil {
+			break
+		}
+
+		switch t.Kind() {
+		case reflect.Struct:
+			f := structFieldsOf(t).find(key)
+			if f == nil {
+				t = nil
+				continue
+			}
+
+			if f.key != key {
+				if res == nil {
+					res = make(Path, len(path))
+					copy(res, path)
+				}
+				res[i] = f.key
+			}
+			t = f.typ
+
+		case reflect.Slice, reflect.Array, reflect.Map:
+			t = t.Elem()
+
+		default:
+			t = nil
+		}
+	}
+
+	if res == nil {
+		return path
+	}
+	return res
+}
+
+type structField struct {
+	name string
+	key  RawKey
+	typ  reflect.Type
+}
+
+type structFields []*structField
+
+func (fs structFields) find(key RawKey) *structField {
+	for _, f := range fs {
+		if f.key == key {
+			return f
+		}
+	}
+
+	if ReadCBORType([]byte(key)) == CBORTypeTextString {
+		var name string
+		if err := cborUnmarshal([]byte(key), &name); err == nil {
+			for _, f := range fs {
+				if f.name == name {
+					return f
+				}
+			}
+		}
+	}
+	return nil
+}
+
+var structFieldsCache sync.Map // map[reflect.Type]structFields
+
+func structFieldsOf(t reflect.Type) structFields {
+	if v, ok := structFieldsCache.Load(t); ok {
+		return v.(structFields)
+	}
+
+	toArray := false
+	if f, ok := t.FieldByName("_"); ok {
+		toArray = hasTagOption(f.Tag.Get("cbor"), "toarray")
+	}
+
+	fs := collectStructFields(t, nil)
+	if toArray {
+		for i, f := range fs {
+			f.key = RawKey(MustMarshal(i))
+		}
+	}
+
+	structFieldsCache.Store(t, fs)
+	return fs
+}
+
+func collectStructFields(t reflect.Type, fs structFields) structFields {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag, ok := f.Tag.Lookup("cbor")
+		if !ok {
+			tag = f.Tag.Get("json")
+		}
+		if tag == "-" {
+			continue
+		}
+
+		name := tag
+		if idx := strings.IndexByte(tag, ','); idx >= 0 {
+			name = tag[:idx]
+		}
+
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				fs = collectStructFields(ft, fs)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+
+		sf := &structField{name: f.Name, typ: f.Type}
+		if name == "" {
+			name = f.Name
+		}
+		if hasTagOption(tag, "keyasint") {
+			if n, err := strconv.Atoi(name); err == nil {
+				sf.key = RawKey(MustMarshal(n))
+			}
+		}
+		if sf.key == "" {
+			sf.key = RawKey(MustMarshal(name))
+		}
+		fs = append(fs, sf)
+	}
+	return fs
+}
+
+func hasTagOption(tag, option string) bool {
+	parts := strings.Split(tag, ",")
+	for _, p := range parts[1:] {
+		if p == option {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type valuePoint struct {
+	_ struct{} `cbor:",toarray"`
+	X int
+	Y int
+}
+
+type valueBase struct {
+	ID string `cbor:"id"`
+}
+
+type valueModel struct {
+	valueBase
+	Name    string                `cbor:"name"`
+	Age     int                   `cbor:"1,keyasint,omitempty"`
+	Tags    []string              `json:"tags"`
+	Points  []valuePoint          `cbor:"points"`
+	Meta    map[string]*valueBase `cbor:"meta"`
+	Ignored string                `cbor:"-"`
+}
+
+func TestApplyToValue(t *testing.T) {
+	assert := assert.New(t)
+
+	v := valueModel{
+		valueBase: valueBase{ID: "1"},
+		Name:      "John",
+		Age:       24,
+		Points:    []valuePoint{{X: 1, Y: 2}},
+		Meta:      map[string]*valueBase{"a": {ID: "a"}},
+	}
+
+	p, err := PatchFromJSON(`[
+		{"op": "replace", "path": "/Name", "value": "Jane"},
+		{"op": "replace", "path": "/Age", "value": 25},
+		{"op": "add", "path": "/tags", "value": ["x"]},
+		{"op": "add", "path": "/Tags/-", "value": "y"},
+		{"op": "replace", "path": "/Points/0/Y", "value": 3},
+		{"op": "copy", "from": "/ID", "path": "/Meta/a/ID"},
+		{"op": "test", "path": "/points/0/0", "value": 1}
+	]`)
+	assert.NoError(err)
+
+	res, err := ApplyToValue(v, p, nil)
+	assert.NoError(err)
+	assert.Equal("John", v.Name)
+	assert.Equal(valueModel{
+		valueBase: valueBase{ID: "1"},
+		Name:      "Jane",
+		Age:       25,
+		Tags:      []string{"x", "y"},
+		Points:    []valuePoint{{X: 1, Y: 3}},
+		Meta:      map[string]*valueBase{"a": {ID: "1"}},
+	}, res)
+
+	pres, err := ApplyToValue(&v, p, nil)
+	assert.NoError(err)
+	assert.Equal(res, *pres)
+
+	p, err = PatchFromJSON(`[{"op": "replace", "path": "/Unknown", "value": 1}]`)
+	assert.NoError(err)
+	_, err = ApplyToValue(v, p, nil)
+	assert.ErrorContains(err, "replace operation does not apply")
+
+	m, err := ApplyToValue(map[string]int{"Name": 1}, Patch{
+		{Op: OpReplace, Path: PathMustFrom("Name"), Value: MustMarshal(2)},
+	}, nil)
+	assert.NoError(err)
+	assert.Equal(map[string]int{"Name": 2}, m)
+}
@@ -0,0 +1,106 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// DefaultRevisionPath is the default path of the revision field in a VersionedDoc.
+var DefaultRevisionPath = PathMustFrom("_rev")
+
+// VersionedDoc holds a CBOR document with a revision counter stored in the document,
+// and applies patches with optimistic concurrency control on the revision.
+// It is safe for concurrent use.
+type VersionedDoc struct {
+	mu      sync.RWMutex
+	node    *Node
+	rev     uint64
+	revPath Path
+	options *Options
+}
+
+// NewVersionedDoc returns a VersionedDoc with the given document.
+// The revision is read from the revPath field, DefaultRevisionPath is used if revPath is empty.
+// The revision starts from 0 if the field does not exist.
+// The options is used to apply patches, NewOptions() is used if it is nil.
+func NewVersionedDoc(doc []byte, revPath Path, options *Options) (*VersionedDoc, error) {
+	if len(revPath) == 0 {
+		revPath = DefaultRevisionPath
+	}
+	if options == nil {
+		options = NewOptions()
+	}
+
+	d := &VersionedDoc{node: NewNode(doc), revPath: revPath, options: options}
+	val, err := d.node.GetValue(revPath, options)
+	switch {
+	case err == nil:
+		if err = cborUnmarshal(val, &d.rev); err != nil {
+			return nil, fmt.Errorf("invalid revision %s, %w", Diagify(val), err)
+		}
+	case errors.Is(err, ErrMissing):
+		opts := *options
+		opts.EnsurePathExistsOnAdd = true
+		op := &Operation{Op: OpAdd, Path: revPath, Value: MustMarshal(uint64(0))}
+		if err = d.node.Patch(Patch{op}, &opts); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	d.node.seal()
+	return d, nil
+}
+
+// Revision returns the current revision.
+func (d *VersionedDoc) Revision() uint64 {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.rev
+}
+
+// Document returns the current document and its revision.
+func (d *VersionedDoc) Document() ([]byte, uint64, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	doc, err := d.node.MarshalCBOR()
+	return doc, d.rev, err
+}
+
+// ApplyIfVersion applies the patch if the current revision equals expectedRev,
+// and bumps the revision on success. It returns the new revision.
+// It returns ErrRevisionConflict if the revision does not match.
+// The document is unchanged if the patch fails to apply.
+func (d *VersionedDoc) ApplyIfVersion(p Patch, expectedRev uint64) (uint64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	node, err := d.node.Patched(VersionedPatch(p, d.revPath, expectedRev), d.options)
+	if err != nil {
+		if errors.Is(err, ErrTestFailed) && d.rev != expectedRev {
+			return d.rev, fmt.Errorf("unable to apply patch at revision %d, expected %d, %w",
+				d.rev, expectedRev, ErrRevisionConflict)
+		}
+		return d.rev, err
+	}
+
+	d.node = node
+	d.rev = expectedRev + 1
+	return d.rev, nil
+}
+
+// VersionedPatch returns a patch that tests the revision field at revPath equals rev,
+// applies the patch and then replaces the revision field with rev + 1.
+func VersionedPatch(p Patch, revPath Path, rev uint64) Patch {
+	vp := make(Patch, 0, len(p)+2)
+	vp = append(vp, &Operation{Op: OpTest, Path: revPath, Value: MustMarshal(rev)})
+	vp = append(vp, p...)
+	vp = append(vp, &Operation{Op: OpReplace, Path: revPath, Value: MustMarshal(rev + 1)})
+	return vp
+}
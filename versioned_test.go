@@ -0,0 +1,74 @@
+// (c) 2022-2022, LDC Labs, Inc. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package cborpatch
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVersionedDoc(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := NewVersionedDoc(MustFromJSON(`{"_rev": "x"}`), nil, nil)
+	assert.ErrorContains(err, "invalid revision")
+
+	vd, err := NewVersionedDoc(MustFromJSON(`{"name": "a"}`), nil, nil)
+	assert.NoError(err)
+	assert.Equal(uint64(0), vd.Revision())
+	doc, rev, err := vd.Document()
+	assert.NoError(err)
+	assert.Equal(uint64(0), rev)
+	assert.Equal(`{"_rev":0,"name":"a"}`, MustToJSON(doc))
+
+	p, err := PatchFromJSON(`[{"op": "replace", "path": "/name", "value": "b"}]`)
+	assert.NoError(err)
+	rev, err = vd.ApplyIfVersion(p, 0)
+	assert.NoError(err)
+	assert.Equal(uint64(1), rev)
+
+	rev, err = vd.ApplyIfVersion(p, 0)
+	assert.ErrorIs(err, ErrRevisionConflict)
+	assert.Equal(uint64(1), rev)
+
+	p, err = PatchFromJSON(`[{"op": "test", "path": "/name", "value": "a"}]`)
+	assert.NoError(err)
+	_, err = vd.ApplyIfVersion(p, 1)
+	assert.ErrorIs(err, ErrTestFailed)
+	assert.NotErrorIs(err, ErrRevisionConflict)
+
+	doc, rev, err = vd.Document()
+	assert.NoError(err)
+	assert.Equal(uint64(1), rev)
+	assert.Equal(`{"_rev":1,"name":"b"}`, MustToJSON(doc))
+
+	vd, err = NewVersionedDoc(MustFromJSON(`{"meta": {"v": 5}, "n": 0}`), PathMustFrom("meta", "v"), nil)
+	assert.NoError(err)
+	assert.Equal(uint64(5), vd.Revision())
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	applied := 0
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for {
+				rev := vd.Revision()
+				p := Patch{{Op: OpReplace, Path: PathMustFrom("n"), Value: MustMarshal(i)}}
+				if _, err := vd.ApplyIfVersion(p, rev); err == nil {
+					mu.Lock()
+					applied++
+					mu.Unlock()
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(8, applied)
+	assert.Equal(uint64(13), vd.Revision())
+}